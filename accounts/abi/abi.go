@@ -19,8 +19,12 @@ package abi
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
 )
 
 // The ABI holds information about a contract's context and available
@@ -144,3 +148,52 @@ func (abi *ABI) MethodById(sigdata []byte) (*Method, error) {
 	}
 	return nil, fmt.Errorf("no method with id: %#x", sigdata[:4])
 }
+
+// revertSelector is the method id of Error(string), which solidity uses to encode
+// the reason of a revert.
+var revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+// UnpackRevert resolves the abi-encoded revert reason. Solidity encodes the reason
+// given to revert and require as if it were a call to a method `Error(string)`.
+func UnpackRevert(data []byte) (string, error) {
+	if len(data) < 4 || !bytes.Equal(data[:4], revertSelector) {
+		return "", errors.New("abi: invalid data for unpacking revert reason")
+	}
+	typ, _ := NewType("string", nil)
+	unpacked, err := (Arguments{{Type: typ}}).UnpackValues(data[4:])
+	if err != nil {
+		return "", err
+	}
+	return unpacked[0].(string), nil
+}
+
+// RevertError is the error of a call which reverted. Its message holds the revert
+// reason if the revert data is an Error(string), and it implements the rpc error
+// interfaces so the raw revert data is returned to API callers too.
+type RevertError struct {
+	error
+	reason string // revert reason hex encoded
+}
+
+// NewRevertError creates a RevertError instance with the provided revert data.
+func NewRevertError(data []byte) *RevertError {
+	reason, errUnpack := UnpackRevert(data)
+	err := errors.New("execution reverted")
+	if errUnpack == nil {
+		err = fmt.Errorf("execution reverted: %v", reason)
+	}
+	return &RevertError{
+		error:  err,
+		reason: hexutil.Encode(data),
+	}
+}
+
+// ErrorCode returns the JSON-RPC error code of reverted executions.
+func (e *RevertError) ErrorCode() int {
+	return 3
+}
+
+// ErrorData returns the hex encoded revert data.
+func (e *RevertError) ErrorData() interface{} {
+	return e.reason
+}
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go"
+	"github.com/LemoFoundationLtd/lemochain-go/accounts/abi"
 	"github.com/LemoFoundationLtd/lemochain-go/accounts/abi/bind"
 	"github.com/LemoFoundationLtd/lemochain-go/common"
 	"github.com/LemoFoundationLtd/lemochain-go/common/math"
@@ -183,8 +184,14 @@ func (b *SimulatedBackend) CallContract(ctx context.Context, call lemochain.Call
 	if err != nil {
 		return nil, err
 	}
-	rval, _, _, err := b.callContract(ctx, call, b.blockchain.CurrentBlock(), state)
-	return rval, err
+	res, err := b.callContract(ctx, call, b.blockchain.CurrentBlock(), state)
+	if err != nil {
+		return nil, err
+	}
+	if res.Err == vm.ErrExecutionReverted {
+		return nil, abi.NewRevertError(res.Revert())
+	}
+	return res.ReturnData, nil
 }
 
 // PendingCallContract executes a contract call on the pending state.
@@ -193,8 +200,14 @@ func (b *SimulatedBackend) PendingCallContract(ctx context.Context, call lemocha
 	defer b.mu.Unlock()
 	defer b.pendingState.RevertToSnapshot(b.pendingState.Snapshot())
 
-	rval, _, _, err := b.callContract(ctx, call, b.pendingBlock, b.pendingState)
-	return rval, err
+	res, err := b.callContract(ctx, call, b.pendingBlock, b.pendingState)
+	if err != nil {
+		return nil, err
+	}
+	if res.Err == vm.ErrExecutionReverted {
+		return nil, abi.NewRevertError(res.Revert())
+	}
+	return res.ReturnData, nil
 }
 
 // PendingNonceAt implements PendingStateReader.PendingNonceAt, retrieving
@@ -232,22 +245,22 @@ func (b *SimulatedBackend) EstimateGas(ctx context.Context, call lemochain.CallM
 	cap = hi
 
 	// Create a helper to check if a gas allowance results in an executable transaction
-	executable := func(gas uint64) bool {
+	executable := func(gas uint64) (bool, *core.ExecutionResult) {
 		call.Gas = gas
 
 		snapshot := b.pendingState.Snapshot()
-		_, _, failed, err := b.callContract(ctx, call, b.pendingBlock, b.pendingState)
+		res, err := b.callContract(ctx, call, b.pendingBlock, b.pendingState)
 		b.pendingState.RevertToSnapshot(snapshot)
 
-		if err != nil || failed {
-			return false
+		if err != nil || res.Failed() {
+			return false, res
 		}
-		return true
+		return true, res
 	}
 	// Execute the binary search and hone in on an executable gas limit
 	for lo+1 < hi {
 		mid := (hi + lo) / 2
-		if !executable(mid) {
+		if ok, _ := executable(mid); !ok {
 			lo = mid
 		} else {
 			hi = mid
@@ -255,7 +268,11 @@ func (b *SimulatedBackend) EstimateGas(ctx context.Context, call lemochain.CallM
 	}
 	// Reject the transaction as invalid if it still fails at the highest allowance
 	if hi == cap {
-		if !executable(hi) {
+		if ok, res := executable(hi); !ok {
+			// If the transaction reverted, report the reason instead of the gas
+			if res != nil && res.Err == vm.ErrExecutionReverted {
+				return 0, abi.NewRevertError(res.Revert())
+			}
 			return 0, errGasEstimationFailed
 		}
 	}
@@ -264,7 +281,7 @@ func (b *SimulatedBackend) EstimateGas(ctx context.Context, call lemochain.CallM
 
 // callContract implements common code between normal and pending contract calls.
 // state is modified during execution, make sure to copy it if necessary.
-func (b *SimulatedBackend) callContract(ctx context.Context, call lemochain.CallMsg, block *types.Block, statedb *state.StateDB) ([]byte, uint64, bool, error) {
+func (b *SimulatedBackend) callContract(ctx context.Context, call lemochain.CallMsg, block *types.Block, statedb *state.StateDB) (*core.ExecutionResult, error) {
 	// Ensure message is initialized properly.
 	if call.GasPrice == nil {
 		call.GasPrice = big.NewInt(1)
@@ -454,6 +471,9 @@ func (fb *filterBackend) SubscribeTxPreEvent(ch chan<- core.TxPreEvent) event.Su
 func (fb *filterBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
 	return fb.bc.SubscribeChainEvent(ch)
 }
+func (fb *filterBackend) SubscribeChainReorgEvent(ch chan<- core.ChainReorgEvent) event.Subscription {
+	return fb.bc.SubscribeChainReorgEvent(ch)
+}
 func (fb *filterBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
 	return fb.bc.SubscribeRemovedLogsEvent(ch)
 }
@@ -0,0 +1,68 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package backends
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go"
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/rpc"
+)
+
+// revertCode is a contract that always reverts with the reason "boom".
+var revertCode = common.FromHex("7f08c379a000000000000000000000000000000000000000000000000000000000600052602060045260046024527f626f6f6d0000000000000000000000000000000000000000000000000000000060445260646000fd")
+
+// Tests that reverting calls and gas estimations report the revert reason and
+// expose the revert data.
+func TestSimulatedBackendRevert(t *testing.T) {
+	contract := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	sim := NewSimulatedBackend(core.GenesisAlloc{
+		contract: {Code: revertCode, Balance: new(big.Int)},
+	})
+	msg := lemochain.CallMsg{To: &contract}
+
+	revertData := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"626f6f6d00000000000000000000000000000000000000000000000000000000"
+
+	_, callErr := sim.CallContract(context.Background(), msg, nil)
+	_, pendingErr := sim.PendingCallContract(context.Background(), msg)
+	_, estimateErr := sim.EstimateGas(context.Background(), msg)
+
+	for name, err := range map[string]error{"call": callErr, "pending call": pendingErr, "estimate": estimateErr} {
+		if err == nil {
+			t.Errorf("%s: expected revert error", name)
+			continue
+		}
+		if err.Error() != "execution reverted: boom" {
+			t.Errorf("%s: error message mismatch: have %q", name, err.Error())
+		}
+		derr, ok := err.(rpc.DataError)
+		if !ok {
+			t.Errorf("%s: error has no data: %T", name, err)
+			continue
+		}
+		if data := derr.ErrorData(); data != revertData {
+			t.Errorf("%s: error data mismatch: have %v, want %v", name, data, revertData)
+		}
+	}
+}
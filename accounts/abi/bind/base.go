@@ -113,6 +113,24 @@ func DeployContract(opts *TransactOpts, abi abi.ABI, bytecode []byte, backend Co
 	return c.address, tx, c, nil
 }
 
+// Create2Address computes the address a contract is deployed at by the CREATE2
+// opcode executed by the deployer contract, for the given salt and init code.
+func Create2Address(deployer common.Address, salt [32]byte, initcode []byte) common.Address {
+	return crypto.CreateAddress2(deployer, salt, crypto.Keccak256(initcode))
+}
+
+// Create2ContractAddress computes the address a contract is deployed at by the
+// CREATE2 opcode executed by the deployer contract, for the given salt, where the
+// init code is the contract bytecode followed by the packed constructor params.
+func Create2ContractAddress(deployer common.Address, salt [32]byte, abi abi.ABI, bytecode []byte, params ...interface{}) (common.Address, error) {
+	input, err := abi.Pack("", params...)
+	if err != nil {
+		return common.Address{}, err
+	}
+	initcode := append(common.CopyBytes(bytecode), input...)
+	return Create2Address(deployer, salt, initcode), nil
+}
+
 // Call invokes the (constant) contract method with params as input values and
 // sets the output to result. The result type might be a single field for simple
 // returns, a slice of interfaces for anonymous returns and a struct for named
@@ -0,0 +1,61 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package bind_test
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/accounts/abi"
+	"github.com/LemoFoundationLtd/lemochain-go/accounts/abi/bind"
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+)
+
+func TestCreate2Address(t *testing.T) {
+	var (
+		deployer = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		salt     = common.HexToHash("0x00000000000000000000000000000000000000000000000000000000cafebabe")
+		bytecode = common.FromHex("0xdeadbeef")
+	)
+	// Example 4 of EIP-1014
+	want := common.HexToAddress("0x60f3f640a8508fC6a86d45DF051962668E1e8AC7")
+	if addr := bind.Create2Address(deployer, salt, bytecode); addr != want {
+		t.Errorf("address mismatch: have %x, want %x", addr, want)
+	}
+	// A contract without constructor params is deployed by its plain bytecode
+	noArgs, _ := abi.JSON(strings.NewReader(`[]`))
+	addr, err := bind.Create2ContractAddress(deployer, salt, noArgs, bytecode)
+	if err != nil {
+		t.Fatalf("failed to compute address without params: %v", err)
+	}
+	if addr != want {
+		t.Errorf("address without params mismatch: have %x, want %x", addr, want)
+	}
+	// Constructor params are appended to the bytecode
+	withArgs, _ := abi.JSON(strings.NewReader(`[{"type":"constructor","inputs":[{"name":"value","type":"uint256"}]}]`))
+	if addr, err = bind.Create2ContractAddress(deployer, salt, withArgs, bytecode, big.NewInt(42)); err != nil {
+		t.Fatalf("failed to compute address with params: %v", err)
+	}
+	initcode := append(common.CopyBytes(bytecode), common.LeftPadBytes([]byte{42}, 32)...)
+	if want := bind.Create2Address(deployer, salt, initcode); addr != want {
+		t.Errorf("address with params mismatch: have %x, want %x", addr, want)
+	}
+	if _, err := bind.Create2ContractAddress(deployer, salt, withArgs, bytecode); err == nil {
+		t.Errorf("missing constructor param accepted")
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 	"unicode"
@@ -47,7 +48,10 @@ const (
 // manually maintain hard coded strings that break on runtime.
 func Bind(types []string, abis []string, bytecodes []string, pkg string, lang Lang) (string, error) {
 	// Process each individual contract requested binding
-	contracts := make(map[string]*tmplContract)
+	var (
+		contracts = make(map[string]*tmplContract)
+		structs   = make(map[string]*tmplStruct)
+	)
 
 	for i := 0; i < len(types); i++ {
 		// Parse the actual ABI to generate the binding for
@@ -117,6 +121,11 @@ func Bind(types []string, abis []string, bytecodes []string, pkg string, lang La
 			// Append the event to the accumulator list
 			events[original.Name] = &tmplEvent{Original: original, Normalized: normalized}
 		}
+		// Collect the struct types of all tuple parameters, in a deterministic
+		// order so that the generated struct names are stable
+		if err := bindStructs(evmABI, structs, lang); err != nil {
+			return "", err
+		}
 		contracts[types[i]] = &tmplContract{
 			Type:        capitalise(types[i]),
 			InputABI:    strings.Replace(strippedABI, "\"", "\\\"", -1),
@@ -131,15 +140,20 @@ func Bind(types []string, abis []string, bytecodes []string, pkg string, lang La
 	data := &tmplData{
 		Package:   pkg,
 		Contracts: contracts,
+		Structs:   structs,
 	}
 	buffer := new(bytes.Buffer)
 
 	funcs := map[string]interface{}{
-		"bindtype":      bindType[lang],
-		"bindtopictype": bindTopicType[lang],
-		"namedtype":     namedType[lang],
-		"capitalise":    capitalise,
-		"decapitalise":  decapitalise,
+		"bindtype": func(kind abi.Type) string {
+			return bindType[lang](kind, structs)
+		},
+		"bindtopictype": func(kind abi.Type) string {
+			return bindTopicType[lang](kind, structs)
+		},
+		"namedtype":    namedType[lang],
+		"capitalise":   capitalise,
+		"decapitalise": decapitalise,
 	}
 	tmpl := template.Must(template.New("").Funcs(funcs).Parse(tmplSource[lang]))
 	if err := tmpl.Execute(buffer, data); err != nil {
@@ -157,9 +171,104 @@ func Bind(types []string, abis []string, bytecodes []string, pkg string, lang La
 	return buffer.String(), nil
 }
 
+// bindStructs creates the struct types of all the tuple parameters of a contract
+// that don't have one yet.
+func bindStructs(evmABI abi.ABI, structs map[string]*tmplStruct, lang Lang) error {
+	args := []abi.Arguments{evmABI.Constructor.Inputs}
+
+	methods := make([]string, 0, len(evmABI.Methods))
+	for name := range evmABI.Methods {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	for _, name := range methods {
+		args = append(args, evmABI.Methods[name].Inputs, evmABI.Methods[name].Outputs)
+	}
+	events := make([]string, 0, len(evmABI.Events))
+	for name := range evmABI.Events {
+		events = append(events, name)
+	}
+	sort.Strings(events)
+	for _, name := range events {
+		args = append(args, evmABI.Events[name].Inputs)
+	}
+	for _, list := range args {
+		for _, arg := range list {
+			if !hasTuple(arg.Type) {
+				continue
+			}
+			if lang != LangGo {
+				return fmt.Errorf("tuple parameter %s is not supported for language %d", arg.Name, lang)
+			}
+			bindStructTypeGo(arg.Type, structs)
+		}
+	}
+	return nil
+}
+
+// hasTuple reports whether the type is a tuple or an array or slice of tuples.
+func hasTuple(kind abi.Type) bool {
+	switch kind.T {
+	case abi.TupleTy:
+		return true
+	case abi.ArrayTy, abi.SliceTy:
+		return hasTuple(*kind.Elem)
+	}
+	return false
+}
+
+// tupleID returns a unique identifier of a type containing tuples, consisting of
+// both the types and the names of the tuple fields, as tuples only differing in
+// field names need distinct Go structs.
+func tupleID(kind abi.Type) string {
+	switch kind.T {
+	case abi.TupleTy:
+		fields := make([]string, len(kind.TupleElems))
+		for i, elem := range kind.TupleElems {
+			fields[i] = tupleID(*elem) + " " + kind.TupleRawNames[i]
+		}
+		return "(" + strings.Join(fields, ",") + ")"
+	case abi.ArrayTy:
+		return fmt.Sprintf("%s[%d]", tupleID(*kind.Elem), kind.Size)
+	case abi.SliceTy:
+		return tupleID(*kind.Elem) + "[]"
+	default:
+		return kind.String()
+	}
+}
+
+// bindStructTypeGo converts a tuple type, or an array or slice of them, to the
+// Go struct representing it, creating the struct type if it doesn't exist yet.
+func bindStructTypeGo(kind abi.Type, structs map[string]*tmplStruct) string {
+	switch kind.T {
+	case abi.TupleTy:
+		id := tupleID(kind)
+		if s, exist := structs[id]; exist {
+			return s.Name
+		}
+		var fields []*tmplField
+		for i, elem := range kind.TupleElems {
+			fields = append(fields, &tmplField{
+				Type:    bindTypeGo(*elem, structs),
+				Name:    abi.ToCamelCase(kind.TupleRawNames[i]),
+				SolKind: *elem,
+			})
+		}
+		name := fmt.Sprintf("Struct%d", len(structs))
+		structs[id] = &tmplStruct{Name: name, Fields: fields}
+		return name
+	case abi.ArrayTy:
+		return fmt.Sprintf("[%d]", kind.Size) + bindStructTypeGo(*kind.Elem, structs)
+	case abi.SliceTy:
+		return "[]" + bindStructTypeGo(*kind.Elem, structs)
+	default:
+		return bindTypeGo(kind, structs)
+	}
+}
+
 // bindType is a set of type binders that convert Solidity types to some supported
 // programming language types.
-var bindType = map[Lang]func(kind abi.Type) string{
+var bindType = map[Lang]func(kind abi.Type, structs map[string]*tmplStruct) string{
 	LangGo:   bindTypeGo,
 	LangJava: bindTypeJava,
 }
@@ -198,8 +307,12 @@ func arrayBindingGo(inner string, arraySizes []string) string {
 
 // bindTypeGo converts a Solidity type to a Go one. Since there is no clear mapping
 // from all Solidity types to Go ones (e.g. uint17), those that cannot be exactly
-// mapped will use an upscaled type (e.g. *big.Int).
-func bindTypeGo(kind abi.Type) string {
+// mapped will use an upscaled type (e.g. *big.Int). Tuples are mapped to the
+// generated structs.
+func bindTypeGo(kind abi.Type, structs map[string]*tmplStruct) string {
+	if hasTuple(kind) {
+		return bindStructTypeGo(kind, structs)
+	}
 	stringKind := kind.String()
 	innerLen, innerMapping := bindUnnestedTypeGo(stringKind)
 	return arrayBindingGo(wrapArray(stringKind, innerLen, innerMapping))
@@ -247,7 +360,7 @@ func arrayBindingJava(inner string, arraySizes []string) string {
 // bindTypeJava converts a Solidity type to a Java one. Since there is no clear mapping
 // from all Solidity types to Java ones (e.g. uint17), those that cannot be exactly
 // mapped will use an upscaled type (e.g. BigDecimal).
-func bindTypeJava(kind abi.Type) string {
+func bindTypeJava(kind abi.Type, structs map[string]*tmplStruct) string {
 	stringKind := kind.String()
 	innerLen, innerMapping := bindUnnestedTypeJava(stringKind)
 	return arrayBindingJava(wrapArray(stringKind, innerLen, innerMapping))
@@ -310,16 +423,17 @@ func bindUnnestedTypeJava(stringKind string) (int, string) {
 
 // bindTopicType is a set of type binders that convert Solidity types to some
 // supported programming language topic types.
-var bindTopicType = map[Lang]func(kind abi.Type) string{
+var bindTopicType = map[Lang]func(kind abi.Type, structs map[string]*tmplStruct) string{
 	LangGo:   bindTopicTypeGo,
 	LangJava: bindTopicTypeJava,
 }
 
 // bindTypeGo converts a Solidity topic type to a Go one. It is almost the same
-// funcionality as for simple types, but dynamic types get converted to hashes.
-func bindTopicTypeGo(kind abi.Type) string {
-	bound := bindTypeGo(kind)
-	if bound == "string" || bound == "[]byte" {
+// funcionality as for simple types, but dynamic types and tuples get converted
+// to hashes.
+func bindTopicTypeGo(kind abi.Type, structs map[string]*tmplStruct) string {
+	bound := bindTypeGo(kind, structs)
+	if bound == "string" || bound == "[]byte" || kind.T == abi.TupleTy {
 		bound = "common.Hash"
 	}
 	return bound
@@ -327,8 +441,8 @@ func bindTopicTypeGo(kind abi.Type) string {
 
 // bindTypeGo converts a Solidity topic type to a Java one. It is almost the same
 // funcionality as for simple types, but dynamic types get converted to hashes.
-func bindTopicTypeJava(kind abi.Type) string {
-	bound := bindTypeJava(kind)
+func bindTopicTypeJava(kind abi.Type, structs map[string]*tmplStruct) string {
+	bound := bindTypeJava(kind, structs)
 	if bound == "String" || bound == "Bytes" {
 		bound = "Hash"
 	}
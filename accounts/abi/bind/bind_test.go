@@ -809,6 +809,68 @@ var bindTests = []struct {
 			}
 		`,
 	},
+	// Tests that tuple parameters are bound to generated structs that encode
+	// according to the ABI spec
+	{
+		`Structs`,
+		`
+			contract Structs {
+				struct Account { address wallet; uint8[] scores; }
+				struct Person { string name; uint256 age; Account[] accounts; }
+
+				Person person;
+
+				function getPerson() public view returns (Person) { return person; }
+				function setPerson(Person _person) public { person = _person; }
+			}
+		`,
+		``,
+		`[{"constant":true,"inputs":[],"name":"getPerson","outputs":[{"name":"person","type":"tuple","components":[{"name":"name","type":"string"},{"name":"age","type":"uint256"},{"name":"accounts","type":"tuple[]","components":[{"name":"wallet","type":"address"},{"name":"scores","type":"uint8[]"}]}]}],"type":"function"},{"constant":false,"inputs":[{"name":"person","type":"tuple","components":[{"name":"name","type":"string"},{"name":"age","type":"uint256"},{"name":"accounts","type":"tuple[]","components":[{"name":"wallet","type":"address"},{"name":"scores","type":"uint8[]"}]}]}],"name":"setPerson","outputs":[],"type":"function"}]`,
+		`
+			// The structs must be usable by the generated methods
+			var (
+				_ func(*bind.CallOpts) (Struct1, error) = (&StructsCaller{}).GetPerson
+				_ func(*bind.TransactOpts, Struct1) (*types.Transaction, error) = (&StructsTransactor{}).SetPerson
+			)
+			parsed, err := abi.JSON(strings.NewReader(StructsABI))
+			if err != nil {
+				t.Fatalf("Failed to parse ABI: %v", err)
+			}
+			person := Struct1{
+				Name:     "alice",
+				Age:      big.NewInt(30),
+				Accounts: []Struct0{{Wallet: common.Address{1}, Scores: []uint8{1, 2}}},
+			}
+			packed, err := parsed.Pack("setPerson", person)
+			if err != nil {
+				t.Fatalf("Failed to pack tuple: %v", err)
+			}
+			want := common.Hex2Bytes(
+				"0000000000000000000000000000000000000000000000000000000000000020" +
+				"0000000000000000000000000000000000000000000000000000000000000060" +
+				"000000000000000000000000000000000000000000000000000000000000001e" +
+				"00000000000000000000000000000000000000000000000000000000000000a0" +
+				"0000000000000000000000000000000000000000000000000000000000000005" +
+				"616c696365000000000000000000000000000000000000000000000000000000" +
+				"0000000000000000000000000000000000000000000000000000000000000001" +
+				"0000000000000000000000000000000000000000000000000000000000000020" +
+				"0000000000000000000000000100000000000000000000000000000000000000" +
+				"0000000000000000000000000000000000000000000000000000000000000040" +
+				"0000000000000000000000000000000000000000000000000000000000000002" +
+				"0000000000000000000000000000000000000000000000000000000000000001" +
+				"0000000000000000000000000000000000000000000000000000000000000002")
+			if !bytes.Equal(packed[4:], want) {
+				t.Fatalf("Packed tuple mismatch: have %x, want %x", packed[4:], want)
+			}
+			var out Struct1
+			if err := parsed.Unpack(&out, "getPerson", want); err != nil {
+				t.Fatalf("Failed to unpack tuple: %v", err)
+			}
+			if !reflect.DeepEqual(out, person) {
+				t.Fatalf("Unpacked tuple mismatch: have %+v, want %+v", out, person)
+			}
+		`,
+	},
 }
 
 // Tests that packages generated by the binder can be successfully compiled and
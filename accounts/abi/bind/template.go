@@ -22,6 +22,7 @@ import "github.com/LemoFoundationLtd/lemochain-go/accounts/abi"
 type tmplData struct {
 	Package   string                   // Name of the package to place the generated file in
 	Contracts map[string]*tmplContract // List of contracts to generate into this file
+	Structs   map[string]*tmplStruct   // Struct types of tuple parameters, keyed by tuple identifier
 }
 
 // tmplContract contains the data needed to generate an individual contract binding.
@@ -49,6 +50,21 @@ type tmplEvent struct {
 	Normalized abi.Event // Normalized version of the parsed fields
 }
 
+// tmplField is a wrapper around a tuple field, containing the meta information
+// needed to generate its Go struct field.
+type tmplField struct {
+	Type    string   // Field type representation depends on target binding language
+	Name    string   // Field name converted from the raw user-defined field name
+	SolKind abi.Type // Raw abi type information
+}
+
+// tmplStruct is a wrapper around a tuple type, containing the meta information
+// needed to generate the Go struct it's bound to.
+type tmplStruct struct {
+	Name   string       // Auto-generated struct name
+	Fields []*tmplField // Struct fields definition depends on the binding language
+}
+
 // tmplSource is language to template mapping containing all the supported
 // programming languages the package can generate to.
 var tmplSource = map[Lang]string{
@@ -64,6 +80,14 @@ const tmplSourceGo = `
 
 package {{.Package}}
 
+{{range $structs := .Structs}}
+	// {{.Name}} is an auto generated low-level Go binding around a user-defined struct.
+	type {{.Name}} struct {
+	{{range $field := .Fields}}
+	{{$field.Name}} {{$field.Type}}{{end}}
+	}
+{{end}}
+
 {{range $contract := .Contracts}}
 	// {{.Type}}ABI is the input ABI used to generate the binding from.
 	const {{.Type}}ABI = "{{.InputABI}}"
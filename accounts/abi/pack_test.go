@@ -18,6 +18,7 @@ package abi
 
 import (
 	"bytes"
+	"encoding/json"
 	"math"
 	"math/big"
 	"reflect"
@@ -325,7 +326,7 @@ func TestPack(t *testing.T) {
 			common.Hex2Bytes("0000000000000000000000000000000000000000000000000000000000000006666f6f6261720000000000000000000000000000000000000000000000000000"),
 		},
 	} {
-		typ, err := NewType(test.typ)
+		typ, err := NewType(test.typ, nil)
 		if err != nil {
 			t.Fatalf("%v failed. Unexpected parse error: %v", i, err)
 		}
@@ -408,6 +409,132 @@ func TestMethodPack(t *testing.T) {
 	}
 }
 
+// Test types for the tuple encoding tests, laid out like the structs abigen
+// generates for the tuple parameters.
+type tupleStatic struct {
+	A *big.Int
+	B bool
+}
+
+type tupleDynamic struct {
+	A *big.Int
+	B string
+}
+
+type tupleInner struct {
+	X *big.Int
+	Y []byte
+}
+
+type tupleOuter struct {
+	Inner tupleInner
+	List  []*big.Int
+}
+
+type tupleName struct {
+	Name string
+}
+
+// Tests that tuples are packed according to the ABI spec, and that they are
+// unpacked back into the same values.
+func TestPackTuple(t *testing.T) {
+	for i, test := range []struct {
+		def   string      // ABI definition JSON of a single argument
+		input interface{} // Go value of the argument
+		enc   string      // expected encoding of the argument
+	}{
+		{
+			// static tuple
+			`[{"name":"s","type":"tuple","components":[{"name":"a","type":"uint256"},{"name":"b","type":"bool"}]}]`,
+			tupleStatic{A: big.NewInt(1), B: true},
+			"0000000000000000000000000000000000000000000000000000000000000001" + // s.a
+				"0000000000000000000000000000000000000000000000000000000000000001", // s.b
+		},
+		{
+			// dynamic tuple
+			`[{"name":"s","type":"tuple","components":[{"name":"a","type":"uint256"},{"name":"b","type":"string"}]}]`,
+			tupleDynamic{A: big.NewInt(7), B: "hi"},
+			"0000000000000000000000000000000000000000000000000000000000000020" + // offset of s
+				"0000000000000000000000000000000000000000000000000000000000000007" + // s.a
+				"0000000000000000000000000000000000000000000000000000000000000040" + // offset of s.b
+				"0000000000000000000000000000000000000000000000000000000000000002" + // length of s.b
+				"6869000000000000000000000000000000000000000000000000000000000000", // s.b
+		},
+		{
+			// nested dynamic tuple
+			`[{"name":"s","type":"tuple","components":[{"name":"inner","type":"tuple","components":[{"name":"x","type":"uint256"},{"name":"y","type":"bytes"}]},{"name":"list","type":"uint256[]"}]}]`,
+			tupleOuter{Inner: tupleInner{X: big.NewInt(1), Y: []byte{0xde, 0xad}}, List: []*big.Int{big.NewInt(2), big.NewInt(3)}},
+			"0000000000000000000000000000000000000000000000000000000000000020" + // offset of s
+				"0000000000000000000000000000000000000000000000000000000000000040" + // offset of s.inner
+				"00000000000000000000000000000000000000000000000000000000000000c0" + // offset of s.list
+				"0000000000000000000000000000000000000000000000000000000000000001" + // s.inner.x
+				"0000000000000000000000000000000000000000000000000000000000000040" + // offset of s.inner.y
+				"0000000000000000000000000000000000000000000000000000000000000002" + // length of s.inner.y
+				"dead000000000000000000000000000000000000000000000000000000000000" + // s.inner.y
+				"0000000000000000000000000000000000000000000000000000000000000002" + // length of s.list
+				"0000000000000000000000000000000000000000000000000000000000000002" + // s.list[0]
+				"0000000000000000000000000000000000000000000000000000000000000003", // s.list[1]
+		},
+		{
+			// slice of dynamic tuples
+			`[{"name":"s","type":"tuple[]","components":[{"name":"name","type":"string"}]}]`,
+			[]tupleName{{Name: "a"}, {Name: "bc"}},
+			"0000000000000000000000000000000000000000000000000000000000000020" + // offset of s
+				"0000000000000000000000000000000000000000000000000000000000000002" + // length of s
+				"0000000000000000000000000000000000000000000000000000000000000040" + // offset of s[0]
+				"00000000000000000000000000000000000000000000000000000000000000a0" + // offset of s[1]
+				"0000000000000000000000000000000000000000000000000000000000000020" + // offset of s[0].name
+				"0000000000000000000000000000000000000000000000000000000000000001" + // length of s[0].name
+				"6100000000000000000000000000000000000000000000000000000000000000" + // s[0].name
+				"0000000000000000000000000000000000000000000000000000000000000020" + // offset of s[1].name
+				"0000000000000000000000000000000000000000000000000000000000000002" + // length of s[1].name
+				"6263000000000000000000000000000000000000000000000000000000000000", // s[1].name
+		},
+		{
+			// array of static tuples
+			`[{"name":"s","type":"tuple[2]","components":[{"name":"a","type":"uint256"},{"name":"b","type":"bool"}]}]`,
+			[2]tupleStatic{{A: big.NewInt(1), B: true}, {A: big.NewInt(2), B: false}},
+			"0000000000000000000000000000000000000000000000000000000000000001" + // s[0].a
+				"0000000000000000000000000000000000000000000000000000000000000001" + // s[0].b
+				"0000000000000000000000000000000000000000000000000000000000000002" + // s[1].a
+				"0000000000000000000000000000000000000000000000000000000000000000", // s[1].b
+		},
+		{
+			// array of dynamic tuples
+			`[{"name":"s","type":"tuple[2]","components":[{"name":"name","type":"string"}]}]`,
+			[2]tupleName{{Name: "a"}, {Name: "bc"}},
+			"0000000000000000000000000000000000000000000000000000000000000020" + // offset of s
+				"0000000000000000000000000000000000000000000000000000000000000040" + // offset of s[0]
+				"00000000000000000000000000000000000000000000000000000000000000a0" + // offset of s[1]
+				"0000000000000000000000000000000000000000000000000000000000000020" + // offset of s[0].name
+				"0000000000000000000000000000000000000000000000000000000000000001" + // length of s[0].name
+				"6100000000000000000000000000000000000000000000000000000000000000" + // s[0].name
+				"0000000000000000000000000000000000000000000000000000000000000020" + // offset of s[1].name
+				"0000000000000000000000000000000000000000000000000000000000000002" + // length of s[1].name
+				"6263000000000000000000000000000000000000000000000000000000000000", // s[1].name
+		},
+	} {
+		var args Arguments
+		if err := json.Unmarshal([]byte(test.def), &args); err != nil {
+			t.Fatalf("test %d: invalid ABI definition %s: %v", i, test.def, err)
+		}
+		packed, err := args.Pack(test.input)
+		if err != nil {
+			t.Fatalf("test %d: failed to pack: %v", i, err)
+		}
+		if enc := common.Hex2Bytes(test.enc); !bytes.Equal(packed, enc) {
+			t.Errorf("test %d: pack mismatch:\nhave %x\nwant %x", i, packed, enc)
+		}
+		out := reflect.New(reflect.TypeOf(test.input))
+		if err := args.Unpack(out.Interface(), packed); err != nil {
+			t.Fatalf("test %d: failed to unpack: %v", i, err)
+		}
+		if !reflect.DeepEqual(out.Elem().Interface(), test.input) {
+			t.Errorf("test %d: unpack mismatch: have %+v, want %+v", i, out.Elem().Interface(), test.input)
+		}
+	}
+}
+
 func TestPackNumber(t *testing.T) {
 	tests := []struct {
 		value  reflect.Value
@@ -80,12 +80,49 @@ func set(dst, src reflect.Value, output Argument) error {
 		dst.Set(src)
 	case dstType.Kind() == reflect.Ptr:
 		return set(dst.Elem(), src, output)
+	case dstType.Kind() == reflect.Struct && srcType.Kind() == reflect.Struct:
+		return setStruct(dst, src, output)
+	case dstType.Kind() == reflect.Slice && srcType.Kind() == reflect.Slice:
+		slice := reflect.MakeSlice(dstType, src.Len(), src.Len())
+		if err := setElements(slice, src, output); err != nil {
+			return err
+		}
+		dst.Set(slice)
+	case dstType.Kind() == reflect.Array && srcType.Kind() == reflect.Array && dst.Len() == src.Len():
+		return setElements(dst, src, output)
 	default:
 		return fmt.Errorf("abi: cannot unmarshal %v in to %v", src.Type(), dst.Type())
 	}
 	return nil
 }
 
+// setStruct assigns the fields of an unpacked tuple to the equally named fields
+// of a user defined struct, converting nested tuples as needed.
+func setStruct(dst, src reflect.Value, output Argument) error {
+	for i := 0; i < src.NumField(); i++ {
+		name := src.Type().Field(i).Name
+		field := dst.FieldByName(name)
+		if !field.IsValid() {
+			return fmt.Errorf("abi: field %s can't be found in the given value %v", name, dst.Type())
+		}
+		if err := set(field, src.Field(i), output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setElements assigns the elements of an unpacked slice or array one by one,
+// used when the element types differ, e.g. for slices of tuples.
+func setElements(dst, src reflect.Value, output Argument) error {
+	for i := 0; i < src.Len(); i++ {
+		if err := set(dst.Index(i), src.Index(i), output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // requireAssignable assures that `dest` is a pointer and it's not an interface.
 func requireAssignable(dst, src reflect.Value) error {
 	if dst.Kind() != reflect.Ptr && dst.Kind() != reflect.Interface {
@@ -126,3 +163,15 @@ func requireUniqueStructFieldNames(args Arguments) error {
 	}
 	return nil
 }
+
+// requireUniqueFieldNames makes sure the Go field names of a tuple don't collide.
+func requireUniqueFieldNames(fields []reflect.StructField) error {
+	exists := make(map[string]bool)
+	for _, field := range fields {
+		if exists[field.Name] {
+			return fmt.Errorf("abi: multiple tuple fields mapping to the same struct field '%s'", field.Name)
+		}
+		exists[field.Name] = true
+	}
+	return nil
+}
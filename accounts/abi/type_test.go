@@ -97,7 +97,7 @@ func TestTypeRegexp(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		typ, err := NewType(tt.blob)
+		typ, err := NewType(tt.blob, nil)
 		if err != nil {
 			t.Errorf("type %q: failed to parse type string: %v", tt.blob, err)
 		}
@@ -253,7 +253,7 @@ func TestTypeCheck(t *testing.T) {
 		{"address", [20]byte{}, ""},
 		{"address", common.Address{}, ""},
 	} {
-		typ, err := NewType(test.typ)
+		typ, err := NewType(test.typ, nil)
 		if err != nil && len(test.err) == 0 {
 			t.Fatal("unexpected parse error:", err)
 		} else if err != nil && len(test.err) != 0 {
@@ -278,3 +278,42 @@ func TestTypeCheck(t *testing.T) {
 		}
 	}
 }
+
+// Tests that tuple types are parsed into Go structs with their canonical
+// signature representation.
+func TestTupleType(t *testing.T) {
+	components := []ArgumentMarshaling{
+		{Name: "a", Type: "uint256"},
+		{Name: "inner_value", Type: "tuple[2]", Components: []ArgumentMarshaling{
+			{Name: "x", Type: "address"},
+			{Name: "y", Type: "bytes"},
+		}},
+	}
+	typ, err := NewType("tuple[]", components)
+	if err != nil {
+		t.Fatalf("failed to parse tuple type: %v", err)
+	}
+	if sig := typ.String(); sig != "(uint256,(address,bytes)[2])[]" {
+		t.Errorf("signature mismatch: have %s, want %s", sig, "(uint256,(address,bytes)[2])[]")
+	}
+	want := reflect.TypeOf([]struct {
+		A          *big.Int
+		InnerValue [2]struct {
+			X common.Address
+			Y []byte
+		}
+	}{})
+	if typ.Type != want {
+		t.Errorf("Go type mismatch: have %v, want %v", typ.Type, want)
+	}
+	if tuple := typ.Elem; !reflect.DeepEqual(tuple.TupleRawNames, []string{"a", "inner_value"}) {
+		t.Errorf("raw field names mismatch: have %v", tuple.TupleRawNames)
+	}
+	// Tuple fields must map to distinct exported struct fields
+	if _, err := NewType("tuple", []ArgumentMarshaling{{Name: "_", Type: "uint256"}}); err == nil {
+		t.Errorf("anonymous tuple field accepted")
+	}
+	if _, err := NewType("tuple", []ArgumentMarshaling{{Name: "a_b", Type: "uint256"}, {Name: "aB", Type: "bool"}}); err == nil {
+		t.Errorf("colliding tuple fields accepted")
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of lemochain-go.
+//
+// lemochain-go is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// lemochain-go is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with lemochain-go. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/cmd/utils"
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/lemo"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/rpc"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	dbMigrateDryRunFlag = cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Only list the pending migrations without running them",
+	}
+	dbCommand = cli.Command{
+		Name:      "db",
+		Usage:     "Low level database operations",
+		ArgsUsage: "",
+		Category:  "DATABASE COMMANDS",
+		Description: `
+Commands for inspecting, debugging and backing up the chain database.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "inspect",
+				Usage:     "Inspect the storage size for each type of data in the database",
+				ArgsUsage: "",
+				Action:    utils.MigrateFlags(inspectDatabase),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.DBReadOnlyFlag,
+					utils.LightModeFlag,
+				},
+				Description: `
+    glemo db inspect
+
+walks the entire chain database and reports the number of entries and their
+total size for every kind of data stored: headers, bodies, receipts, lookup
+entries, bloombits, trie nodes, preimages, etc.`,
+			},
+			{
+				Name:      "migrate",
+				Usage:     "Upgrade the database schema to the latest version",
+				ArgsUsage: "",
+				Action:    utils.MigrateFlags(dbMigrate),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.LightModeFlag,
+					dbMigrateDryRunFlag,
+				},
+				Description: `
+    glemo db migrate [--dry-run]
+
+runs all the pending schema migrations of the chain database in order. The node
+runs them in the background on startup too, this command allows upgrading the
+database offline. Interrupted migrations resume where they left off.
+
+With --dry-run the current schema version and the pending migrations are only
+listed, leaving the database untouched.`,
+			},
+			{
+				Name:      "get",
+				Usage:     "Show the value of a database key",
+				ArgsUsage: "<key>",
+				Action:    utils.MigrateFlags(dbGet),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.DBReadOnlyFlag,
+					utils.LightModeFlag,
+				},
+				Description: `
+    glemo db get <key>
+
+prints the value stored under the given key. The key is interpreted as hex if
+it has a 0x prefix, or as a plain string otherwise.`,
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete a database key (WARNING: may corrupt your database)",
+				ArgsUsage: "<key>",
+				Action:    utils.MigrateFlags(dbDelete),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.LightModeFlag,
+				},
+				Description: `
+    glemo db delete <key>
+
+deletes the value stored under the given key. The key is interpreted as hex if
+it has a 0x prefix, or as a plain string otherwise.`,
+			},
+			{
+				Name:      "dumptrie",
+				Usage:     "Show the storage key/values of a given trie",
+				ArgsUsage: "<root> [<start>] [<max>]",
+				Action:    utils.MigrateFlags(dbDumpTrie),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.DBReadOnlyFlag,
+					utils.LightModeFlag,
+				},
+				Description: `
+    glemo db dumptrie <root> [<start>] [<max>]
+
+prints the hashed keys and raw values of the trie with the given root hash, be
+it the state trie or the storage trie of a contract. The dump can be started at
+a given hex key and limited to a maximum number of entries. If the whole trie
+is dumped, the root is recomputed from the dumped entries and checked against
+the given one.`,
+			},
+			{
+				Name:      "backup",
+				Usage:     "Back up the chain database, online if the node is running",
+				ArgsUsage: "<target>",
+				Action:    utils.MigrateFlags(dbBackup),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.IPCPathFlag,
+				},
+				Description: `
+    glemo db backup <target>
+
+takes a consistent snapshot of the chain database, along with its ancient store
+and head markers, and streams it into the target directory. Targets ending in
+.tar, .tar.gz or .tgz are written as a (gzipped) tar archive instead.
+
+If the node is running, the backup is taken through its IPC endpoint while it
+keeps processing blocks. Otherwise the database is opened directly. Only the
+leveldb engine supports consistent snapshots.`,
+			},
+			{
+				Name:      "restore",
+				Usage:     "Restore the chain database from a backup",
+				ArgsUsage: "<source>",
+				Action:    utils.MigrateFlags(dbRestore),
+				Category:  "DATABASE COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.CacheFlag,
+				},
+				Description: `
+    glemo db restore <source>
+
+restores a backup created by 'glemo db backup' into the data directory of a
+stopped node without a chain database. The backup is written next to the final
+location first, and only moved into place once its head block and state root
+have been verified.`,
+			},
+		},
+	}
+)
+
+// inspectDatabase reports the counts and sizes of all the key schemas in the
+// chain database.
+func inspectDatabase(ctx *cli.Context) error {
+	if ctx.NArg() > 0 {
+		utils.Fatalf("This command doesn't take any arguments.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	stats, err := core.InspectDatabase(db)
+	if err != nil {
+		utils.Fatalf("Failed to inspect database: %v", err)
+	}
+	var (
+		rows  [][]string
+		count uint64
+		size  common.StorageSize
+	)
+	for _, stat := range stats {
+		rows = append(rows, []string{stat.Category, strconv.FormatUint(stat.Count, 10), stat.Size.String()})
+		count += stat.Count
+		size += stat.Size
+	}
+	rows = append(rows, []string{"Total", strconv.FormatUint(count, 10), size.String()})
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Category", "Items", "Size"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.AppendBulk(rows)
+	table.Render()
+
+	if frozen := core.GetAncientCount(db); frozen > 0 {
+		fmt.Printf("Ancient blocks: %d\n", frozen)
+	}
+	return nil
+}
+
+// dbMigrate lists or runs the pending schema migrations of the chain database.
+func dbMigrate(ctx *cli.Context) error {
+	if ctx.NArg() > 0 {
+		utils.Fatalf("This command doesn't take any arguments.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	version, pending, err := lemo.PendingMigrations(db)
+	if err != nil {
+		utils.Fatalf("Failed to check database schema: %v", err)
+	}
+	fmt.Printf("Schema version: %d (latest %d)\n", version, lemo.LatestSchemaVersion())
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+	}
+	for _, migration := range pending {
+		fmt.Printf("  pending %d: %s\n", migration.Version, migration.Name)
+	}
+	if ctx.Bool(dbMigrateDryRunFlag.Name) {
+		return nil
+	}
+	start := time.Now()
+	if err := lemo.MigrateDatabase(db, nil); err != nil {
+		utils.Fatalf("Failed to migrate database: %v", err)
+	}
+	fmt.Printf("Migration done in %v\n", time.Since(start))
+	return nil
+}
+
+// parseDatabaseKey interprets a command line argument as a database key: hex if
+// it has a 0x prefix, a plain string otherwise.
+func parseDatabaseKey(arg string) []byte {
+	if !strings.HasPrefix(arg, "0x") && !strings.HasPrefix(arg, "0X") {
+		return []byte(arg)
+	}
+	key, err := hexutil.Decode(arg)
+	if err != nil {
+		utils.Fatalf("Invalid hex key %q: %v", arg, err)
+	}
+	return key
+}
+
+// dbGet prints the value of a single database key.
+func dbGet(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		utils.Fatalf("This command requires a key argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	key := parseDatabaseKey(ctx.Args().First())
+	data, err := db.Get(key)
+	if err != nil {
+		utils.Fatalf("Failed to retrieve key %#x: %v", key, err)
+	}
+	fmt.Printf("key %#x: %#x\n", key, data)
+	return nil
+}
+
+// dbDelete removes a single database key.
+func dbDelete(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		utils.Fatalf("This command requires a key argument.")
+	}
+	stack, _ := makeConfigNode(ctx)
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	key := parseDatabaseKey(ctx.Args().First())
+	data, err := db.Get(key)
+	if err == nil {
+		fmt.Printf("Previous value: %#x\n", data)
+	}
+	if err := db.Delete(key); err != nil {
+		utils.Fatalf("Failed to delete key %#x: %v", key, err)
+	}
+	return nil
+}
+
+// dbDumpTrie prints the key/value pairs of a trie.
+func dbDumpTrie(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 3 {
+		utils.Fatalf("This command requires a root argument, and optionally a start key and a limit.")
+	}
+	root, err := hexutil.Decode(ctx.Args().Get(0))
+	if err != nil || len(root) != common.HashLength {
+		utils.Fatalf("Invalid trie root %q", ctx.Args().Get(0))
+	}
+	var (
+		start []byte
+		max   = int64(-1)
+	)
+	if ctx.NArg() > 1 {
+		if start, err = hexutil.Decode(ctx.Args().Get(1)); err != nil {
+			utils.Fatalf("Invalid start key %q: %v", ctx.Args().Get(1), err)
+		}
+	}
+	if ctx.NArg() > 2 {
+		if max, err = strconv.ParseInt(ctx.Args().Get(2), 10, 64); err != nil {
+			utils.Fatalf("Invalid limit %q: %v", ctx.Args().Get(2), err)
+		}
+	}
+	stack, _ := makeConfigNode(ctx)
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	tr, err := trie.New(common.BytesToHash(root), trie.NewDatabase(db))
+	if err != nil {
+		utils.Fatalf("Failed to open trie: %v", err)
+	}
+	// If the whole trie is dumped, recompute its root from the dumped entries
+	var rehash *trie.StackTrie
+	if start == nil && max < 0 {
+		rehash = trie.NewStackTrie(nil)
+	}
+	var count int64
+	it := trie.NewIterator(tr.NodeIterator(start))
+	for it.Next() {
+		if max >= 0 && count >= max {
+			break
+		}
+		fmt.Printf("  %d. key %#x: %#x\n", count, it.Key, it.Value)
+		if rehash != nil {
+			rehash.Update(it.Key, it.Value)
+		}
+		count++
+	}
+	if it.Err != nil {
+		utils.Fatalf("Failed to iterate trie: %v", it.Err)
+	}
+	if rehash != nil {
+		if hash := rehash.Hash(); hash != common.BytesToHash(root) {
+			utils.Fatalf("Trie root mismatch: dumped entries hash to %x", hash)
+		}
+	}
+	return nil
+}
+
+// dbBackup backs up the chain database, through the IPC endpoint of the running
+// node if there's one, or by opening the database directly otherwise.
+func dbBackup(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		utils.Fatalf("This command requires a target argument.")
+	}
+	target, err := filepath.Abs(ctx.Args().First())
+	if err != nil {
+		utils.Fatalf("Invalid backup target: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+
+	meta := new(core.BackupMeta)
+	if endpoint := stack.IPCEndpoint(); endpoint != "" {
+		if client, err := rpc.Dial(endpoint); err == nil {
+			defer client.Close()
+
+			fmt.Printf("Backing up the running node through %s\n", endpoint)
+			if err := client.Call(meta, "admin_backupDatabase", target); err != nil {
+				utils.Fatalf("Backup failed: %v", err)
+			}
+			printBackupMeta(target, meta)
+			return nil
+		}
+	}
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	sink, err := lemodb.CreateBackup(target)
+	if err != nil {
+		utils.Fatalf("Failed to create backup: %v", err)
+	}
+	meta, err = core.BackupDatabase(db, sink)
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		utils.Fatalf("Backup failed: %v", err)
+	}
+	printBackupMeta(target, meta)
+	return nil
+}
+
+// dbRestore restores the chain database from a backup, activating it only after
+// it has been verified.
+func dbRestore(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		utils.Fatalf("This command requires a source argument.")
+	}
+	source, err := lemodb.OpenBackup(ctx.Args().First())
+	if err != nil {
+		utils.Fatalf("Failed to open backup: %v", err)
+	}
+	defer source.Close()
+
+	stack, _ := makeConfigNode(ctx)
+	name := utils.ChainDatabaseName(ctx)
+	chaindata := stack.ResolvePath(name)
+	if chaindata == "" {
+		utils.Fatalf("Cannot restore into an ephemeral data directory")
+	}
+	if _, err := os.Stat(chaindata); err == nil {
+		utils.Fatalf("Chain database %s already exists, remove it first", chaindata)
+	}
+	// Restore next to the final location, the ancient store either inside the
+	// restored database or next to its own final location if configured. Light
+	// databases have no ancient store at all.
+	var (
+		light          = ctx.GlobalBool(utils.LightModeFlag.Name)
+		restore        = name + ".restore"
+		freezer        = ctx.GlobalString(utils.AncientFlag.Name)
+		restoreFreezer = filepath.Join(restore, "ancient")
+	)
+	if light && freezer != "" {
+		utils.Fatalf("Light databases have no ancient store")
+	}
+	if freezer != "" {
+		restoreFreezer = freezer + ".restore"
+		if _, err := os.Stat(stack.ResolvePath(freezer)); err == nil {
+			utils.Fatalf("Ancient store %s already exists, remove it first", stack.ResolvePath(freezer))
+		}
+	}
+	cleanup := func() {
+		os.RemoveAll(stack.ResolvePath(restore))
+		os.RemoveAll(stack.ResolvePath(restoreFreezer))
+	}
+	cleanup()
+
+	var db lemodb.Database
+	if light {
+		db, err = stack.OpenDatabase(restore, ctx.GlobalInt(utils.CacheFlag.Name), 256)
+	} else {
+		db, err = stack.OpenDatabaseWithFreezer(restore, ctx.GlobalInt(utils.CacheFlag.Name), 256, restoreFreezer, "")
+	}
+	if err != nil {
+		utils.Fatalf("Failed to create database: %v", err)
+	}
+	start := time.Now()
+	meta, err := core.RestoreDatabase(source, db)
+	db.Close()
+	if err != nil {
+		cleanup()
+		utils.Fatalf("Restore failed: %v", err)
+	}
+	// Verified, move the restored database into place
+	if freezer != "" {
+		if err := os.Rename(stack.ResolvePath(restoreFreezer), stack.ResolvePath(freezer)); err != nil {
+			utils.Fatalf("Failed to activate ancient store: %v", err)
+		}
+	}
+	if err := os.Rename(stack.ResolvePath(restore), chaindata); err != nil {
+		utils.Fatalf("Failed to activate database: %v", err)
+	}
+	fmt.Printf("Restored head block #%d [%x] with state root %x in %v\n", meta.Number, meta.Head, meta.Root, common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// printBackupMeta prints the summary of a finished backup.
+func printBackupMeta(target string, meta *core.BackupMeta) {
+	fmt.Printf("Backed up head block #%d [%x] with state root %x into %s\n", meta.Number, meta.Head, meta.Root, target)
+	fmt.Printf("  %d entries, %d ancient blocks, %v\n", meta.Entries, meta.Ancients, common.StorageSize(meta.Size))
+}
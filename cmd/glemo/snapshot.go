@@ -0,0 +1,95 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of lemochain-go.
+//
+// lemochain-go is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// lemochain-go is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with lemochain-go. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/cmd/utils"
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state/pruner"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	snapshotCommand = cli.Command{
+		Name:      "snapshot",
+		Usage:     "A set of commands operating on the state of a single block",
+		ArgsUsage: "",
+		Category:  "BLOCKCHAIN COMMANDS",
+		Description: `
+Commands for maintaining the state stored in the chain database.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "prune-state",
+				Usage:     "Prune stale lemochain state data based on a target state root",
+				ArgsUsage: "[<root>]",
+				Action:    utils.MigrateFlags(pruneState),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.DBEngineFlag,
+					utils.CacheFlag,
+					utils.BloomFilterSizeFlag,
+				},
+				Description: `
+    glemo snapshot prune-state <state-root>
+
+will delete every trie node and contract code not reachable from the given
+state root, the state of the current head block or the state of the genesis
+block. If no root is given, the state of the current head block is the target.
+
+Reachable state is marked in a bloom filter of --bloomfilter.size megabytes,
+which keeps memory use bounded at the cost of retaining a small fraction of
+stale data. Use --bloomfilter.size=0 to mark the state exactly in memory.
+
+The pruning is resumable: if it is interrupted, running the command again
+(optionally without arguments) finishes it towards the original target. The
+node must not be running while pruning.`,
+			},
+		},
+	}
+)
+
+// pruneState deletes all the state not reachable from the target state root.
+func pruneState(ctx *cli.Context) error {
+	var root common.Hash
+	if ctx.NArg() > 1 {
+		utils.Fatalf("This command requires at most one argument.")
+	}
+	if ctx.NArg() == 1 {
+		blob, err := hexutil.Decode(ctx.Args().First())
+		if err != nil || len(blob) != common.HashLength {
+			utils.Fatalf("Invalid state root %q", ctx.Args().First())
+		}
+		root = common.BytesToHash(blob)
+	}
+	stack, _ := makeConfigNode(ctx)
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	start := time.Now()
+	p := pruner.NewPruner(chaindb, stack.InstanceDir(), ctx.GlobalUint64(utils.BloomFilterSizeFlag.Name))
+	if err := p.Prune(root); err != nil {
+		utils.Fatalf("Failed to prune state: %v", err)
+	}
+	log.Info("State pruning successful", "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
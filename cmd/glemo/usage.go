@@ -69,6 +69,13 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			configFileFlag,
 			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.AncientThresholdFlag,
+			utils.HistoryRetainFlag,
+			utils.DBEngineFlag,
+			utils.DBReadOnlyFlag,
+			utils.DBCompressionFlag,
+			utils.DBRecompressFlag,
 			utils.KeyStoreDirFlag,
 			utils.NoUSBFlag,
 			utils.NetworkIdFlag,
@@ -76,6 +83,9 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.RinkebyFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.NoSnapshotFlag,
+			utils.AddressIndexFlag,
+			utils.AddressIndexLogsFlag,
 			utils.LemoStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightServFlag,
@@ -130,7 +140,9 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			utils.CacheFlag,
 			utils.CacheDatabaseFlag,
+			utils.CacheTrieFlag,
 			utils.CacheGCFlag,
+			utils.CacheNoPrefetchFlag,
 			utils.TrieCacheGenFlag,
 		},
 	},
@@ -148,6 +160,7 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.RPCListenAddrFlag,
 			utils.RPCPortFlag,
 			utils.RPCApiFlag,
+			utils.RPCEVMTimeoutFlag,
 			utils.WSEnabledFlag,
 			utils.WSListenAddrFlag,
 			utils.WSPortFlag,
@@ -201,6 +214,7 @@ var AppHelpFlagGroups = []flagGroup{
 		Name: "VIRTUAL MACHINE",
 		Flags: []cli.Flag{
 			utils.VMEnableDebugFlag,
+			utils.VMParallelFlag,
 		},
 	},
 	{
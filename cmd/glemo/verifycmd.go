@@ -0,0 +1,272 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of lemochain-go.
+//
+// lemochain-go is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// lemochain-go is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with lemochain-go. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/cmd/utils"
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/lemo"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	verifyThreadsFlag = cli.IntFlag{
+		Name:  "threads",
+		Usage: "Number of blocks verified concurrently",
+		Value: runtime.NumCPU(),
+	}
+	verifyRepairFlag = cli.BoolFlag{
+		Name:  "repair",
+		Usage: "Download the damaged state entries from the network",
+	}
+	verifyRangeCommand = cli.Command{
+		Action:    utils.MigrateFlags(verifyRange),
+		Name:      "verify-range",
+		Usage:     "Re-execute a range of blocks and validate them against their headers",
+		ArgsUsage: "<from> <to>",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
+			utils.DBCompressionFlag,
+			utils.CacheFlag,
+			verifyThreadsFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+    glemo verify-range <from> <to> [--threads=<n>]
+
+re-executes every canonical block between the two given numbers (both inclusive)
+on top of the state of its parent stored in the database, and compares the
+resulting gas used, receipt root and state root against the stored header. The
+blocks are independent of each other, so the range is split up across --threads
+workers.
+
+For every diverging block the first mismatch is reported, along with the first
+diverging transaction and the accounts differing between the re-executed and
+the stored state. The command fails if any block diverges, or if a block or its
+parent state is missing, so the range must be covered by an archive database.`,
+	}
+	verifyStateCommand = cli.Command{
+		Action:    utils.MigrateFlags(verifyState),
+		Name:      "verify-state",
+		Usage:     "Check the integrity of a state, optionally repairing it from the network",
+		ArgsUsage: "[<root>]",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.DBEngineFlag,
+			utils.CacheFlag,
+			utils.NetworkIdFlag,
+			utils.BootnodesFlag,
+			utils.MaxPeersFlag,
+			verifyRepairFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+    glemo verify-state [<root>] [--repair]
+
+walks the account trie with the given state root (the state of the head block
+by default), along with every storage trie and contract code referenced from
+it, checking that all trie nodes and codes are present and match their hashes.
+The walk doesn't stop at the first damaged entry, all of them are reported.
+
+With --repair the node is started and the damaged entries, along with anything
+missing underneath them, are downloaded from the connected peers the same way
+fast sync retrieves state. The state is verified again once done.`,
+	}
+)
+
+// verifyRange re-executes a range of blocks and reports the ones diverging from
+// their stored headers.
+func verifyRange(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		utils.Fatalf("This command requires two arguments.")
+	}
+	from, err := strconv.ParseUint(ctx.Args()[0], 10, 64)
+	if err != nil {
+		utils.Fatalf("Invalid start block %q: %v", ctx.Args()[0], err)
+	}
+	to, err := strconv.ParseUint(ctx.Args()[1], 10, 64)
+	if err != nil {
+		utils.Fatalf("Invalid end block %q: %v", ctx.Args()[1], err)
+	}
+	stack := makeFullNode(ctx)
+	chain, chainDb := utils.MakeChain(ctx, stack)
+	defer chainDb.Close()
+	defer chain.Stop()
+
+	// Abort the verification on Ctrl-C
+	interrupt := make(chan os.Signal, 1)
+	quit := make(chan struct{})
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	defer close(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during verification, stopping")
+			close(quit)
+		}
+	}()
+	start := time.Now()
+	failures, err := core.VerifyChainRange(chain, from, to, ctx.Int(verifyThreadsFlag.Name), quit)
+	for _, failure := range failures {
+		printVerifyFailure(failure)
+	}
+	if err != nil {
+		utils.Fatalf("Verification failed: %v", err)
+	}
+	if len(failures) > 0 {
+		utils.Fatalf("%d blocks failed verification", len(failures))
+	}
+	log.Info("Verification successful", "from", from, "to", to, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// printVerifyFailure prints the details of a block which failed verification.
+func printVerifyFailure(failure *core.VerifyFailure) {
+	fmt.Printf("Block #%d [%x]: %v\n", failure.Number, failure.Hash, failure.Err)
+	if failure.TxIndex >= 0 {
+		fmt.Printf("  first diverging transaction: %d [%x]\n", failure.TxIndex, failure.TxHash)
+	}
+	for _, diff := range failure.Diff {
+		if diff.Address != nil {
+			fmt.Printf("  account %x (hash %x)\n", *diff.Address, diff.Hash)
+		} else {
+			fmt.Printf("  account hash %x\n", diff.Hash)
+		}
+		fmt.Printf("    local:  %s\n", formatAccount(diff.Have))
+		fmt.Printf("    stored: %s\n", formatAccount(diff.Want))
+	}
+}
+
+// formatAccount returns a single line description of an account.
+func formatAccount(account *state.Account) string {
+	if account == nil {
+		return "<missing>"
+	}
+	return fmt.Sprintf("nonce=%d balance=%v root=%x codehash=%x", account.Nonce, account.Balance, account.Root, account.CodeHash)
+}
+
+// verifyState checks the integrity of a state and optionally downloads the
+// damaged entries from the network.
+func verifyState(ctx *cli.Context) error {
+	var root common.Hash
+	if ctx.NArg() > 1 {
+		utils.Fatalf("This command requires at most one argument.")
+	}
+	if ctx.NArg() == 1 {
+		blob, err := hexutil.Decode(ctx.Args().First())
+		if err != nil || len(blob) != common.HashLength {
+			utils.Fatalf("Invalid state root %q", ctx.Args().First())
+		}
+		root = common.BytesToHash(blob)
+	}
+	stack := makeFullNode(ctx)
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+
+	if root == (common.Hash{}) {
+		hash := core.GetHeadBlockHash(chaindb)
+		header := core.GetHeader(chaindb, hash, core.GetBlockNumber(chaindb, hash))
+		if header == nil {
+			utils.Fatalf("Failed to load the head block")
+		}
+		root = header.Root
+	}
+	// Abort the verification on Ctrl-C
+	interrupt := make(chan os.Signal, 1)
+	quit := make(chan struct{})
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during verification, stopping")
+			close(quit)
+		}
+	}()
+	start := time.Now()
+	report, err := state.VerifyState(chaindb, root, quit)
+	signal.Stop(interrupt)
+	close(interrupt)
+	chaindb.Close()
+
+	if err != nil {
+		utils.Fatalf("Verification failed: %v", err)
+	}
+	for _, fault := range report.Faults {
+		printIntegrityFault(fault)
+	}
+	log.Info("State verified", "root", root, "accounts", report.Accounts, "slots", report.Slots, "nodes", report.Nodes,
+		"codes", report.Codes, "faults", len(report.Faults), "elapsed", common.PrettyDuration(time.Since(start)))
+
+	if len(report.Faults) == 0 {
+		return nil
+	}
+	if !ctx.Bool(verifyRepairFlag.Name) {
+		utils.Fatalf("%d damaged state entries found, use --repair to download them", len(report.Faults))
+	}
+	// Start the node and retrieve the damaged entries from the peers
+	utils.StartNode(stack)
+	defer stack.Stop()
+
+	var lemochain *lemo.Lemochain
+	if err := stack.Service(&lemochain); err != nil {
+		utils.Fatalf("Lemochain service not running: %v", err)
+	}
+	sched := state.NewStateRepair(report.Faults, lemochain.ChainDb())
+	log.Info("Repairing state", "root", root, "entries", sched.Pending())
+
+	start = time.Now()
+	if err := lemochain.Downloader().RepairState(sched); err != nil {
+		utils.Fatalf("Repair failed: %v", err)
+	}
+	if report, err = state.VerifyState(lemochain.ChainDb(), root, nil); err != nil {
+		utils.Fatalf("Verification failed: %v", err)
+	}
+	if len(report.Faults) > 0 {
+		utils.Fatalf("%d damaged state entries left after repair", len(report.Faults))
+	}
+	log.Info("State repaired", "root", root, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// printIntegrityFault prints the details of a damaged state entry.
+func printIntegrityFault(fault *state.IntegrityFault) {
+	damage := "Corrupted"
+	if fault.Missing {
+		damage = "Missing"
+	}
+	switch {
+	case fault.Code:
+		fmt.Printf("%s contract code %x (account hash %x)\n", damage, fault.Hash, fault.Owner)
+	case fault.Owner == (common.Hash{}):
+		fmt.Printf("%s trie node %x (account trie, path %x)\n", damage, fault.Hash, fault.Path)
+	default:
+		fmt.Printf("%s trie node %x (storage trie of account hash %x, path %x)\n", damage, fault.Hash, fault.Owner, fault.Path)
+	}
+}
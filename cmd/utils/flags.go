@@ -34,6 +34,7 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/common/fdlimit"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus/clique"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/dpovp"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
 	"github.com/LemoFoundationLtd/lemochain-go/core"
 	"github.com/LemoFoundationLtd/lemochain-go/core/state"
@@ -118,6 +119,36 @@ var (
 		Usage: "Data directory for the databases and keystore",
 		Value: DirectoryString{node.DefaultDataDir()},
 	}
+	AncientFlag = DirectoryFlag{
+		Name:  "datadir.ancient",
+		Usage: "Data directory for ancient chain segments (default = inside chaindata)",
+	}
+	AncientThresholdFlag = cli.Uint64Flag{
+		Name:  "ancient.threshold",
+		Usage: "Number of blocks behind the head after which chain data is moved into the ancient store",
+		Value: core.DefaultFreezerThreshold,
+	}
+	HistoryRetainFlag = cli.Uint64Flag{
+		Name:  "history.retain",
+		Usage: "Number of recent blocks to keep the bodies, receipts and transaction lookups of (0 = keep all)",
+	}
+	DBEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: "Key-value store backing the databases (\"leveldb\" or \"bolt\", default = engine of the existing database or leveldb)",
+	}
+	DBReadOnlyFlag = cli.BoolFlag{
+		Name:  "db.readonly",
+		Usage: "Open the existing databases in read-only mode (e.g. for analytics replicas)",
+	}
+	DBCompressionFlag = cli.StringFlag{
+		Name:  "db.compression",
+		Usage: `Compression of the stored block bodies and receipts ("none", "rle" or "snappy")`,
+		Value: "none",
+	}
+	DBRecompressFlag = cli.BoolFlag{
+		Name:  "db.recompress",
+		Usage: "Recompress the existing block bodies and receipts in the background with the configured compression",
+	}
 	KeyStoreDirFlag = DirectoryFlag{
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
@@ -175,6 +206,18 @@ var (
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
 		Value: "full",
 	}
+	NoSnapshotFlag = cli.BoolFlag{
+		Name:  "nosnapshot",
+		Usage: "Disables the flat state snapshot used to accelerate state access",
+	}
+	AddressIndexFlag = cli.BoolFlag{
+		Name:  "index.address",
+		Usage: "Maintain an index of the transactions sent from or to every address",
+	}
+	AddressIndexLogsFlag = cli.BoolFlag{
+		Name:  "index.address.logs",
+		Usage: "Index the transactions by the addresses of their emitted logs too",
+	}
 	LightServFlag = cli.IntFlag{
 		Name:  "lightserv",
 		Usage: "Maximum percentage of time allowed for serving LES requests (0-90)",
@@ -300,16 +343,30 @@ var (
 		Usage: "Percentage of cache memory allowance to use for database io",
 		Value: 75,
 	}
+	CacheTrieFlag = cli.IntFlag{
+		Name:  "cache.trie",
+		Usage: "Percentage of cache memory allowance to use for trie caching (on top of the database and pruning allowances)",
+		Value: 10,
+	}
 	CacheGCFlag = cli.IntFlag{
 		Name:  "cache.gc",
 		Usage: "Percentage of cache memory allowance to use for trie pruning",
 		Value: 25,
 	}
+	CacheNoPrefetchFlag = cli.BoolFlag{
+		Name:  "cache.noprefetch",
+		Usage: "Disable heuristic state prefetch during block import (less CPU and disk IO, more time waiting for data)",
+	}
 	TrieCacheGenFlag = cli.IntFlag{
 		Name:  "trie-cache-gens",
 		Usage: "Number of trie node generations to keep in memory",
 		Value: int(state.MaxTrieCacheGen),
 	}
+	BloomFilterSizeFlag = cli.Uint64Flag{
+		Name:  "bloomfilter.size",
+		Usage: "Megabytes of memory allocated to the bloom filter marking live state during pruning (0 = exact marking)",
+		Value: 2048,
+	}
 	// Miner settings
 	MiningEnabledFlag = cli.BoolFlag{
 		Name:  "mine",
@@ -355,6 +412,10 @@ var (
 		Name:  "vmdebug",
 		Usage: "Record information useful for VM and contract debugging",
 	}
+	VMParallelFlag = cli.BoolFlag{
+		Name:  "vmparallel",
+		Usage: "Execute the transactions of imported blocks optimistically in parallel",
+	}
 	// Logging and debug settings
 	LemoStatsURLFlag = cli.StringFlag{
 		Name:  "lemostats",
@@ -402,6 +463,11 @@ var (
 		Usage: "API's offered over the HTTP-RPC interface",
 		Value: "",
 	}
+	RPCEVMTimeoutFlag = cli.DurationFlag{
+		Name:  "rpc.evmtimeout",
+		Usage: "Sets a timeout used for lemo_call and lemo_callBundle (0=infinite)",
+		Value: lemo.DefaultConfig.RPCEVMTimeout,
+	}
 	IPCDisabledFlag = cli.BoolFlag{
 		Name:  "ipcdisable",
 		Usage: "Disable the IPC-RPC server",
@@ -887,6 +953,12 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 		cfg.DataDir = filepath.Join(node.DefaultDataDir(), "rinkeby")
 	}
 
+	if ctx.GlobalIsSet(DBEngineFlag.Name) {
+		cfg.DBEngine = ctx.GlobalString(DBEngineFlag.Name)
+	}
+	if ctx.GlobalIsSet(DBReadOnlyFlag.Name) {
+		cfg.DBReadOnly = ctx.GlobalBool(DBReadOnlyFlag.Name)
+	}
 	if ctx.GlobalIsSet(KeyStoreDirFlag.Name) {
 		cfg.KeyStoreDir = ctx.GlobalString(KeyStoreDirFlag.Name)
 	}
@@ -1045,15 +1117,41 @@ func SetLemoConfig(ctx *cli.Context, stack *node.Node, cfg *lemo.Config) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 	}
 	cfg.DatabaseHandles = makeDatabaseHandles()
+	if ctx.GlobalIsSet(AncientFlag.Name) {
+		cfg.DatabaseFreezer = ctx.GlobalString(AncientFlag.Name)
+	}
+	if ctx.GlobalIsSet(AncientThresholdFlag.Name) {
+		cfg.FreezerThreshold = ctx.GlobalUint64(AncientThresholdFlag.Name)
+	}
+	if ctx.GlobalIsSet(HistoryRetainFlag.Name) {
+		cfg.HistoryRetain = ctx.GlobalUint64(HistoryRetainFlag.Name)
+	}
+	if ctx.GlobalIsSet(DBCompressionFlag.Name) {
+		cfg.DatabaseCompression = ctx.GlobalString(DBCompressionFlag.Name)
+	}
+	if ctx.GlobalIsSet(DBRecompressFlag.Name) {
+		cfg.DatabaseRecompress = ctx.GlobalBool(DBRecompressFlag.Name)
+	}
 
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
 	}
+	if _, err := core.ParseStorageCodec(ctx.GlobalString(DBCompressionFlag.Name)); err != nil {
+		Fatalf("--%s: %v", DBCompressionFlag.Name, err)
+	}
+
 	cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
+	cfg.NoSnapshot = ctx.GlobalBool(NoSnapshotFlag.Name)
+	cfg.AddressIndex = ctx.GlobalBool(AddressIndexFlag.Name)
+	cfg.AddressIndexLogs = ctx.GlobalBool(AddressIndexLogsFlag.Name)
 
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cfg.TrieCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
 	}
+	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
+		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
+	}
+	cfg.NoPrefetch = ctx.GlobalBool(CacheNoPrefetchFlag.Name)
 	if ctx.GlobalIsSet(MinerThreadsFlag.Name) {
 		cfg.MinerThreads = ctx.GlobalInt(MinerThreadsFlag.Name)
 	}
@@ -1070,6 +1168,12 @@ func SetLemoConfig(ctx *cli.Context, stack *node.Node, cfg *lemo.Config) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
 	}
+	if ctx.GlobalIsSet(VMParallelFlag.Name) {
+		cfg.ParallelExecution = ctx.GlobalBool(VMParallelFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCEVMTimeoutFlag.Name) {
+		cfg.RPCEVMTimeout = ctx.GlobalDuration(RPCEVMTimeoutFlag.Name)
+	}
 
 	// Override any default configs for hard coded networks.
 	switch {
@@ -1182,16 +1286,34 @@ func SetupNetwork(ctx *cli.Context) {
 }
 
 // MakeChainDatabase open an LevelDB using the flags passed to the client and will hard crash if it fails.
+// ChainDatabaseName returns the name of the chain database within the data
+// directory, which depends on whether the node runs in light mode.
+func ChainDatabaseName(ctx *cli.Context) string {
+	if ctx.GlobalBool(LightModeFlag.Name) {
+		return "lightchaindata"
+	}
+	return "chaindata"
+}
+
 func MakeChainDatabase(ctx *cli.Context, stack *node.Node) lemodb.Database {
 	var (
 		cache   = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 		handles = makeDatabaseHandles()
 	)
-	name := "chaindata"
+	var (
+		chainDb lemodb.Database
+		err     error
+	)
+	name := ChainDatabaseName(ctx)
 	if ctx.GlobalBool(LightModeFlag.Name) {
-		name = "lightchaindata"
+		chainDb, err = stack.OpenDatabase(name, cache, handles)
+	} else {
+		freezer := ctx.GlobalString(AncientFlag.Name)
+		if freezer == "" {
+			freezer = filepath.Join(name, "ancient")
+		}
+		chainDb, err = stack.OpenDatabaseWithFreezer(name, cache, handles, freezer, "")
 	}
-	chainDb, err := stack.OpenDatabase(name, cache, handles)
 	if err != nil {
 		Fatalf("Could not open database: %v", err)
 	}
@@ -1223,6 +1345,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	var engine consensus.Engine
 	if config.Clique != nil {
 		engine = clique.New(config.Clique, chainDb)
+	} else if config.Dpovp != nil {
+		engine = dpovp.New(config.Dpovp, chainDb)
 	} else {
 		engine = lemohash.NewFaker()
 		if !ctx.GlobalBool(FakePoWFlag.Name) {
@@ -1239,15 +1363,32 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
 	}
+	codec, err := core.ParseStorageCodec(ctx.GlobalString(DBCompressionFlag.Name))
+	if err != nil {
+		Fatalf("--%s: %v", DBCompressionFlag.Name, err)
+	}
 	cache := &core.CacheConfig{
-		Disabled:      ctx.GlobalString(GCModeFlag.Name) == "archive",
-		TrieNodeLimit: lemo.DefaultConfig.TrieCache,
-		TrieTimeLimit: lemo.DefaultConfig.TrieTimeout,
+		Disabled:         ctx.GlobalString(GCModeFlag.Name) == "archive",
+		TrieNodeLimit:    lemo.DefaultConfig.TrieCache,
+		TrieTimeLimit:    lemo.DefaultConfig.TrieTimeout,
+		FreezerThreshold: ctx.GlobalUint64(AncientThresholdFlag.Name),
+		HistoryRetain:    ctx.GlobalUint64(HistoryRetainFlag.Name),
+		NoSnapshot:       ctx.GlobalBool(NoSnapshotFlag.Name),
+		TrieCleanLimit:   lemo.DefaultConfig.TrieCleanCache,
+		NoPrefetch:       ctx.GlobalBool(CacheNoPrefetchFlag.Name),
+		StorageCodec:     codec,
+		ReadOnly:         ctx.GlobalBool(DBReadOnlyFlag.Name),
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cache.TrieNodeLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
 	}
-	vmcfg := vm.Config{EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name)}
+	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
+		cache.TrieCleanLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
+	}
+	vmcfg := vm.Config{
+		EnablePreimageRecording: ctx.GlobalBool(VMEnableDebugFlag.Name),
+		ParallelExecution:       ctx.GlobalBool(VMParallelFlag.Name),
+	}
 	chain, err = core.NewBlockChain(chainDb, cache, config, engine, vmcfg)
 	if err != nil {
 		Fatalf("Can't create BlockChain: %v", err)
@@ -0,0 +1,90 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package rle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+)
+
+// Fuzz implements a go-fuzz fuzzer method to test the equivalence of the buffer
+// and streaming encoders and decoders.
+func Fuzz(data []byte) int {
+	if len(data) == 0 {
+		return -1
+	}
+	if data[0]%2 == 0 {
+		return fuzzCompress(data[1:])
+	}
+	return fuzzDecompress(data[1:])
+}
+
+// fuzzCompress implements a go-fuzz fuzzer method to test that the streaming
+// writer produces the same output as Compress, and that the output decompresses
+// to the original data.
+func fuzzCompress(data []byte) int {
+	want := Compress(data)
+
+	// Write the data in uneven pieces sized by the data itself
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for rest := data; len(rest) > 0; {
+		n := 1 + int(rest[0])%(len(rest))
+		w.Write(rest[:n])
+		rest = rest[n:]
+	}
+	w.Close()
+	if !bytes.Equal(buf.Bytes(), want) {
+		panic("streaming compression mismatch")
+	}
+	blob, err := Decompress(want)
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(blob, data) {
+		panic("round trip mismatch")
+	}
+	stream, err := ioutil.ReadAll(NewReader(bytes.NewReader(want)))
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(stream, data) {
+		panic("streaming round trip mismatch")
+	}
+	return 1
+}
+
+// fuzzDecompress implements a go-fuzz fuzzer method to test that the streaming
+// reader produces the same output and errors as Decompress on arbitrary input.
+func fuzzDecompress(data []byte) int {
+	want, wantErr := Decompress(data)
+	have, haveErr := ioutil.ReadAll(NewReader(bytes.NewReader(data)))
+
+	if !reflect.DeepEqual(haveErr, wantErr) {
+		panic("streaming decompression error mismatch")
+	}
+	if wantErr != nil {
+		return 0
+	}
+	if !bytes.Equal(have, want) {
+		panic("streaming decompression mismatch")
+	}
+	return 1
+}
@@ -20,6 +20,7 @@ package rle
 import (
 	"bytes"
 	"errors"
+	"fmt"
 
 	"github.com/LemoFoundationLtd/lemochain-go/crypto"
 )
@@ -31,28 +32,52 @@ const (
 	tokenToken             = 0xff
 )
 
+// maxZeroRun is the longest run of zero bytes encoded by a single token. Runs of
+// 251-253 zeros would encode into the special tokens, so they are never emitted.
+const maxZeroRun = 255
+
 var empty = crypto.Keccak256([]byte(""))
 var emptyList = crypto.Keccak256([]byte{0x80})
 
+// ErrDanglingToken is returned if the compressed data ends right after a token.
+var ErrDanglingToken = errors.New("token without a following byte")
+
+// DecodeError is returned when decompressing malformed data, reporting the offset
+// of the faulty token within the compressed input.
+type DecodeError struct {
+	Offset int64 // Offset of the malformed token in the compressed data
+	Err    error // Reason the token couldn't be decoded
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("rle: %v at offset %d", e.Err, e.Offset)
+}
+
+// expandToken returns the decompressed content of the byte following a token.
+func expandToken(b byte) []byte {
+	switch b {
+	case emptyShaToken:
+		return empty
+	case emptyListShaToken:
+		return emptyList
+	case tokenToken:
+		return []byte{token}
+	default:
+		return make([]byte, int(b-2))
+	}
+}
+
+// Decompress expands a run-length encoded buffer.
 func Decompress(dat []byte) ([]byte, error) {
 	buf := new(bytes.Buffer)
 
 	for i := 0; i < len(dat); i++ {
 		if dat[i] == token {
 			if i+1 < len(dat) {
-				switch dat[i+1] {
-				case emptyShaToken:
-					buf.Write(empty)
-				case emptyListShaToken:
-					buf.Write(emptyList)
-				case tokenToken:
-					buf.WriteByte(token)
-				default:
-					buf.Write(make([]byte, int(dat[i+1]-2)))
-				}
+				buf.Write(expandToken(dat[i+1]))
 				i++
 			} else {
-				return nil, errors.New("error reading bytes. token encountered without proceeding bytes")
+				return nil, &DecodeError{Offset: int64(i), Err: ErrDanglingToken}
 			}
 		} else {
 			buf.WriteByte(dat[i])
@@ -68,12 +93,15 @@ func compressChunk(dat []byte) (ret []byte, n int) {
 		return []byte{token, tokenToken}, 1
 	case len(dat) > 1 && dat[0] == 0x0 && dat[1] == 0x0:
 		j := 0
-		for j <= 254 && j < len(dat) {
+		for j < maxZeroRun && j < len(dat) {
 			if dat[j] != 0 {
 				break
 			}
 			j++
 		}
+		if j > 250 && j < 254 {
+			j = 250
+		}
 		return []byte{token, byte(j + 2)}, j
 	case len(dat) >= 32:
 		if dat[0] == empty[0] && bytes.Equal(dat[:32], empty) {
@@ -87,6 +115,7 @@ func compressChunk(dat []byte) (ret []byte, n int) {
 	}
 }
 
+// Compress run-length encodes a buffer.
 func Compress(dat []byte) []byte {
 	buf := new(bytes.Buffer)
 
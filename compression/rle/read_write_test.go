@@ -48,3 +48,17 @@ func (s *CompressionRleSuite) TestDecompressSimple(c *checker.C) {
 	c.Assert(res, checker.DeepEquals, make([]byte, 10))
 
 }
+
+func (s *CompressionRleSuite) TestCompressZeroRuns(c *checker.C) {
+	// Runs of 251-253 zeros must not be encoded into the special tokens
+	for n := 251; n <= 253; n++ {
+		res := Compress(append(make([]byte, n), 0x01))
+		c.Assert(res[:2], checker.DeepEquals, []byte{token, 252})
+	}
+	for n := 0; n < 1024; n++ {
+		data := append(make([]byte, n), 0x01)
+		res, err := Decompress(Compress(data))
+		c.Assert(err, checker.IsNil)
+		c.Assert(res, checker.DeepEquals, data)
+	}
+}
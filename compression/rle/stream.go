@@ -0,0 +1,145 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package rle
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// errWriterClosed is returned if data is written into an already closed writer.
+var errWriterClosed = errors.New("rle: write to closed writer")
+
+// Reader decompresses a run-length encoded stream, producing the exact same
+// output as Decompress would on the whole stream.
+type Reader struct {
+	src    *bufio.Reader
+	offset int64  // Offset of the next byte within the compressed stream
+	buf    []byte // Decompressed data not yet consumed
+	err    error  // Sticky error of the compressed stream
+}
+
+// NewReader creates a reader decompressing the data read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{src: bufio.NewReader(r)}
+}
+
+// Read implements io.Reader, filling p with decompressed data.
+func (r *Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) > 0 {
+			copied := copy(p[n:], r.buf)
+			r.buf = r.buf[copied:]
+			n += copied
+			continue
+		}
+		// Avoid blocking on the source if some data is already available
+		if r.err != nil || (n > 0 && r.src.Buffered() == 0) {
+			break
+		}
+		r.err = r.fill()
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, r.err
+}
+
+// fill decodes the next byte or token of the compressed stream into the buffer.
+func (r *Reader) fill() error {
+	b, err := r.src.ReadByte()
+	if err != nil {
+		return err
+	}
+	r.offset++
+	if b != token {
+		r.buf = append(r.buf[:0], b)
+		return nil
+	}
+	next, err := r.src.ReadByte()
+	if err == io.EOF {
+		return &DecodeError{Offset: r.offset - 1, Err: ErrDanglingToken}
+	} else if err != nil {
+		return err
+	}
+	r.offset++
+	r.buf = expandToken(next)
+	return nil
+}
+
+// Writer compresses a stream with run-length encoding, producing the exact same
+// output as Compress would on the concatenation of all the written data. As the
+// encoder needs to look ahead, the tail of the data is buffered until Close is
+// called.
+type Writer struct {
+	dst     io.Writer
+	pending []byte // Data written but not yet compressed
+	closed  bool
+}
+
+// NewWriter creates a writer compressing the data into w. The caller must call
+// Close to flush the buffered data, which doesn't close w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{dst: w}
+}
+
+// Write implements io.Writer, compressing all the data which doesn't depend on
+// future writes any more.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	w.pending = append(w.pending, p...)
+
+	// A chunk never looks further ahead than the longest zero run
+	if err := w.compress(maxZeroRun); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close flushes all the buffered data into the underlying writer. It doesn't
+// close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.compress(1)
+}
+
+// compress encodes the pending data as long as at least lookahead bytes remain.
+func (w *Writer) compress(lookahead int) error {
+	var (
+		out []byte
+		i   int
+	)
+	for len(w.pending)-i >= lookahead && i < len(w.pending) {
+		chunk, n := compressChunk(w.pending[i:])
+		out = append(out, chunk...)
+		i += n
+	}
+	w.pending = append(w.pending[:0], w.pending[i:]...)
+
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := w.dst.Write(out)
+	return err
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package rle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+// makeStreamTestData creates a random blob mixing literal bytes, zero runs of all
+// lengths, tokens and the special hashes.
+func makeStreamTestData(rnd *rand.Rand, pieces int) []byte {
+	var data []byte
+	for i := 0; i < pieces; i++ {
+		switch rnd.Intn(5) {
+		case 0:
+			data = append(data, make([]byte, rnd.Intn(600))...)
+		case 1:
+			data = append(data, token)
+		case 2:
+			data = append(data, empty...)
+		case 3:
+			data = append(data, emptyList...)
+		default:
+			literal := make([]byte, rnd.Intn(64))
+			rnd.Read(literal)
+			data = append(data, literal...)
+		}
+	}
+	return data
+}
+
+// Tests that the streaming writer produces the same output as Compress, no matter
+// how the data is split into writes.
+func TestWriterEquivalence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		data := makeStreamTestData(rnd, rnd.Intn(32))
+		want := Compress(data)
+
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		for rest := data; len(rest) > 0; {
+			n := 1 + rnd.Intn(len(rest))
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatalf("test %d: failed to write: %v", i, err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("test %d: failed to close: %v", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("test %d: compression mismatch: have %x, want %x", i, buf.Bytes(), want)
+		}
+		if _, err := w.Write([]byte{0x01}); err != errWriterClosed {
+			t.Fatalf("test %d: write after close error mismatch: have %v, want %v", i, err, errWriterClosed)
+		}
+	}
+}
+
+// Tests that the streaming reader produces the same output as Decompress, even
+// when the source returns the data byte by byte.
+func TestReaderEquivalence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		data := makeStreamTestData(rnd, rnd.Intn(32))
+		blob := Compress(data)
+		want, _ := Decompress(blob)
+
+		have, err := ioutil.ReadAll(NewReader(iotest.OneByteReader(bytes.NewReader(blob))))
+		if err != nil {
+			t.Fatalf("test %d: failed to decompress: %v", i, err)
+		}
+		if !bytes.Equal(have, want) {
+			t.Fatalf("test %d: decompression mismatch: have %x, want %x", i, have, want)
+		}
+	}
+}
+
+// Tests that malformed input is rejected by both decoders with the offset of the
+// faulty token.
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input  []byte
+		output []byte
+		offset int64
+	}{
+		{[]byte{token}, nil, 0},
+		{[]byte{0x01, 0x02, token}, []byte{0x01, 0x02}, 2},
+		{[]byte{token, 12, 0x01, token}, append(make([]byte, 10), 0x01), 3},
+	}
+	for i, tt := range tests {
+		want := &DecodeError{Offset: tt.offset, Err: ErrDanglingToken}
+
+		if _, err := Decompress(tt.input); !reflect.DeepEqual(err, want) {
+			t.Errorf("test %d: buffer error mismatch: have %v, want %v", i, err, want)
+		}
+		have, err := ioutil.ReadAll(NewReader(bytes.NewReader(tt.input)))
+		if !reflect.DeepEqual(err, want) {
+			t.Errorf("test %d: stream error mismatch: have %v, want %v", i, err, want)
+		}
+		if !bytes.Equal(have, tt.output) {
+			t.Errorf("test %d: stream output mismatch: have %x, want %x", i, have, tt.output)
+		}
+	}
+}
@@ -104,3 +104,13 @@ type PoW interface {
 	// Hashrate returns the current mining hashrate of a PoW consensus engine.
 	Hashrate() float64
 }
+
+// Finality is a consensus engine providing deterministic finality, after which
+// blocks may never be reverted by a chain reorganisation.
+type Finality interface {
+	Engine
+
+	// Finalized returns the number of the last finalized block on the chain
+	// ending with the given header.
+	Finalized(chain ChainReader, header *types.Header) (uint64, error)
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/rpc"
+)
+
+// API is a user facing RPC API to query the deputies and the block confirmations
+// and to control the voting of the delegated deputy node scheme.
+type API struct {
+	chain consensus.ChainReader
+	dpovp *Dpovp
+}
+
+// Finality is the last finalized block of a chain.
+type Finality struct {
+	Number hexutil.Uint64 `json:"number"`
+	Hash   common.Hash    `json:"hash"`
+}
+
+// header retrieves the requested header by number (or the current if none).
+func (api *API) header(number *rpc.BlockNumber) (*types.Header, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return header, nil
+}
+
+// GetSnapshot retrieves the deputy snapshot at a given block.
+func (api *API) GetSnapshot(number *rpc.BlockNumber) (*Snapshot, error) {
+	header, err := api.header(number)
+	if err != nil {
+		return nil, err
+	}
+	return api.dpovp.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+}
+
+// GetSnapshotAtHash retrieves the deputy snapshot at a given block.
+func (api *API) GetSnapshotAtHash(hash common.Hash) (*Snapshot, error) {
+	header := api.chain.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return api.dpovp.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+}
+
+// GetDeputies retrieves the list of deputies at the specified block.
+func (api *API) GetDeputies(number *rpc.BlockNumber) ([]common.Address, error) {
+	snap, err := api.GetSnapshot(number)
+	if err != nil {
+		return nil, err
+	}
+	return snap.deputies(), nil
+}
+
+// GetDeputiesAtHash retrieves the list of deputies at the specified block.
+func (api *API) GetDeputiesAtHash(hash common.Hash) ([]common.Address, error) {
+	snap, err := api.GetSnapshotAtHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	return snap.deputies(), nil
+}
+
+// GetConfirmations retrieves the highest block confirmed by each deputy on the
+// chain ending with the specified block.
+func (api *API) GetConfirmations(number *rpc.BlockNumber) (map[common.Address]hexutil.Uint64, error) {
+	snap, err := api.GetSnapshot(number)
+	if err != nil {
+		return nil, err
+	}
+	confirms := make(map[common.Address]hexutil.Uint64, len(snap.Confirms))
+	for deputy, number := range snap.Confirms {
+		confirms[deputy] = hexutil.Uint64(number)
+	}
+	return confirms, nil
+}
+
+// GetFinalized retrieves the last block confirmed by two thirds of the deputies
+// on the chain ending with the specified block.
+func (api *API) GetFinalized(number *rpc.BlockNumber) (*Finality, error) {
+	header, err := api.header(number)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := api.dpovp.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Walk back to the finalized block, it's an ancestor of the requested one
+	finalized := api.dpovp.finalized(snap)
+	for header != nil && header.Number.Uint64() > finalized {
+		header = api.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return &Finality{Number: hexutil.Uint64(finalized), Hash: header.Hash()}, nil
+}
+
+// Proposals returns the current proposals the node tries to uphold and vote on.
+func (api *API) Proposals() map[common.Address]bool {
+	api.dpovp.lock.RLock()
+	defer api.dpovp.lock.RUnlock()
+
+	proposals := make(map[common.Address]bool)
+	for address, auth := range api.dpovp.proposals {
+		proposals[address] = auth
+	}
+	return proposals
+}
+
+// Propose injects a new deputy election proposal that the node will attempt to
+// push through at the next epoch boundary.
+func (api *API) Propose(address common.Address, auth bool) {
+	api.dpovp.lock.Lock()
+	defer api.dpovp.lock.Unlock()
+
+	api.dpovp.proposals[address] = auth
+}
+
+// Discard drops a currently running proposal, stopping the node from casting
+// further votes (either for or against).
+func (api *API) Discard(address common.Address) {
+	api.dpovp.lock.Lock()
+	defer api.dpovp.lock.Unlock()
+
+	delete(api.dpovp.proposals, address)
+}
@@ -0,0 +1,824 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dpovp implements the delegated deputy node consensus engine.
+//
+// A set of deputy nodes takes turns in producing blocks. Every block sealed by a
+// deputy is its signed confirmation of the block and all of its ancestors, and a
+// block becomes final as soon as two thirds of the deputies confirmed it. Final
+// blocks can never be reverted by a chain reorganisation. Deputies caught sealing
+// conflicting blocks lose their say on the finality above the conflict.
+//
+// The deputies may vote on electing or dismissing accounts while producing the
+// blocks of an epoch. The proposals passed by a majority of the deputies come in
+// effect at the next epoch boundary, whose checkpoint block lists the new set.
+package dpovp
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/accounts"
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/misc"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto/sha3"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+	"github.com/LemoFoundationLtd/lemochain-go/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	checkpointInterval = 1024 // Number of blocks after which to save the deputy snapshot to the database
+	inmemorySnapshots  = 128  // Number of recent deputy snapshots to keep in memory
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+
+	wiggleTime = 500 * time.Millisecond // Random delay (per deputy) to allow concurrent producers
+)
+
+// Dpovp protocol constants.
+var (
+	epochLength = uint64(30000) // Default number of blocks after which to checkpoint and rotate the deputies
+
+	extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for deputy vanity
+	extraSeal   = 65 // Fixed number of extra-data suffix bytes reserved for deputy seal
+
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on electing a new deputy
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on dismissing a deputy
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	diffInTurn = big.NewInt(2) // Block difficulty for in-turn productions
+	diffNoTurn = big.NewInt(1) // Block difficulty for out-of-turn productions
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of deputies is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidCheckpointBeneficiary is returned if a checkpoint/epoch transition
+	// block has a beneficiary set to non-zeroes.
+	errInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+
+	// errInvalidVote is returned if a nonce value is something else that the two
+	// allowed constants of 0x00..0 or 0xff..f.
+	errInvalidVote = errors.New("vote nonce not 0x00..0 or 0xff..f")
+
+	// errInvalidCheckpointVote is returned if a checkpoint/epoch transition block
+	// has a vote nonce set to non-zeroes.
+	errInvalidCheckpointVote = errors.New("vote nonce in checkpoint block non-zero")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the deputy vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errMissingSignature is returned if a block's extra-data section doesn't seem
+	// to contain a 65 byte secp256k1 signature.
+	errMissingSignature = errors.New("extra-data 65 byte suffix signature missing")
+
+	// errExtraDeputies is returned if non-checkpoint block contain deputy data in
+	// their extra-data fields.
+	errExtraDeputies = errors.New("non-checkpoint block contains extra deputy list")
+
+	// errInvalidCheckpointDeputies is returned if a checkpoint block contains an
+	// invalid list of deputies (i.e. non divisible by 20 bytes, or not the correct
+	// ones).
+	errInvalidCheckpointDeputies = errors.New("invalid deputy list on checkpoint block")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not either
+	// of 1 or 2, or if the value does not match the turn of the deputy.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// ErrInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidVotingChain is returned if a deputy list is attempted to be
+	// modified via out-of-range or non-contiguous headers.
+	errInvalidVotingChain = errors.New("invalid voting chain")
+
+	// errUnauthorized is returned if a header is signed by a non-deputy entity.
+	errUnauthorized = errors.New("unauthorized")
+
+	// errWaitTransactions is returned if an empty block is attempted to be sealed
+	// on an instant chain (0 second period).
+	errWaitTransactions = errors.New("waiting for transactions")
+)
+
+// SignerFn is a signer callback function to request a hash to be signed by a
+// backing account.
+type SignerFn func(accounts.Account, []byte) ([]byte, error)
+
+// sigHash returns the hash which is used as input for the deputy signature. It
+// is the hash of the entire header apart from the 65 byte signature contained
+// at the end of the extra data.
+//
+// Note, the method requires the extra data to be at least 65 bytes, otherwise it
+// panics. This is done to avoid accidentally using both forms (signature present
+// or not), which could be abused to produce different hashes for the same header.
+func sigHash(header *types.Header) (hash common.Hash) {
+	hasher := sha3.NewKeccak256()
+
+	rlp.Encode(hasher, []interface{}{
+		header.ParentHash,
+		header.UncleHash,
+		header.Coinbase,
+		header.Root,
+		header.TxHash,
+		header.ReceiptHash,
+		header.Bloom,
+		header.Difficulty,
+		header.Number,
+		header.GasLimit,
+		header.GasUsed,
+		header.Time,
+		header.Extra[:len(header.Extra)-65], // Yes, this will panic if extra is too short
+		header.MixDigest,
+		header.Nonce,
+	})
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// ecrecover extracts the Lemochain account address from a signed header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	// Retrieve the signature from the header extra-data
+	if len(header.Extra) < extraSeal {
+		return common.Address{}, errMissingSignature
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+
+	// Recover the public key and the Lemochain address
+	pubkey, err := crypto.Ecrecover(sigHash(header).Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var deputy common.Address
+	copy(deputy[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	sigcache.Add(hash, deputy)
+	return deputy, nil
+}
+
+// Dpovp is the delegated deputy node consensus engine.
+type Dpovp struct {
+	config *params.DpovpConfig // Consensus engine configuration parameters
+	db     lemodb.Database     // Database to store and retrieve snapshot checkpoints
+
+	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+
+	proposals map[common.Address]bool // Current list of proposals we are pushing
+
+	signer common.Address // Lemochain address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer fields
+
+	evidence *evidence  // Seals of the deputies across all forks, persisted on every change
+	sealLock sync.Mutex // Protects the seal evidence
+}
+
+// New creates a Dpovp consensus engine with the initial deputies set to the ones
+// provided in the genesis block.
+func New(config *params.DpovpConfig, db lemodb.Database) *Dpovp {
+	// Set any missing consensus parameters to their defaults
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = epochLength
+	}
+	// Allocate the snapshot caches and create the engine
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+
+	// Resume judging the deputies by the seals seen before the last shutdown
+	evidence, err := loadEvidence(db)
+	if err != nil {
+		log.Error("Failed to load deputy seal evidence, discarding", "err", err)
+		evidence = newEvidence()
+	}
+	return &Dpovp{
+		config:     &conf,
+		db:         db,
+		recents:    recents,
+		signatures: signatures,
+		proposals:  make(map[common.Address]bool),
+		evidence:   evidence,
+	}
+}
+
+// Author implements consensus.Engine, returning the Lemochain address recovered
+// from the signature in the header's extra-data section.
+func (d *Dpovp) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, d.signatures)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (d *Dpovp) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return d.verifyHeader(chain, header, nil)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (d *Dpovp) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for i, header := range headers {
+			err := d.verifyHeader(chain, header, headers[:i])
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database. This is useful for concurrently verifying
+// a batch of new headers.
+func (d *Dpovp) verifyHeader(chain consensus.ChainReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time.Cmp(big.NewInt(time.Now().Unix())) > 0 {
+		return consensus.ErrFutureBlock
+	}
+	// Checkpoint blocks need to enforce zero beneficiary
+	checkpoint := (number % d.config.Epoch) == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return errInvalidCheckpointBeneficiary
+	}
+	// Nonces must be 0x00..0 or 0xff..f, zeroes enforced on checkpoints
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidCheckpointVote
+	}
+	// Check that the extra-data contains both the vanity and signature
+	if len(header.Extra) < extraVanity {
+		return errMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return errMissingSignature
+	}
+	// Ensure that the extra-data contains a deputy list on checkpoint, but none otherwise
+	deputiesBytes := len(header.Extra) - extraVanity - extraSeal
+	if !checkpoint && deputiesBytes != 0 {
+		return errExtraDeputies
+	}
+	if checkpoint && deputiesBytes%common.AddressLength != 0 {
+		return errInvalidCheckpointDeputies
+	}
+	// Ensure that the mix digest is zero as we don't have fork protection currently
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	// Ensure that the block doesn't contain any uncles which are meaningless here
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	// Ensure that the block's difficulty is meaningful (may not be correct at this point)
+	if number > 0 {
+		if header.Difficulty == nil || (header.Difficulty.Cmp(diffInTurn) != 0 && header.Difficulty.Cmp(diffNoTurn) != 0) {
+			return errInvalidDifficulty
+		}
+	}
+	// If all checks passed, validate any special fields for hard forks
+	if err := misc.VerifyForkHashes(chain.Config(), header, false); err != nil {
+		return err
+	}
+	// All basic checks passed, verify cascading fields
+	return d.verifyCascadingFields(chain, header, parents)
+}
+
+// verifyCascadingFields verifies all the header fields that are not standalone,
+// rather depend on a batch of previous headers. The caller may optionally pass
+// in a batch of parents (ascending order) to avoid looking those up from the
+// database. This is useful for concurrently verifying a batch of new headers.
+func (d *Dpovp) verifyCascadingFields(chain consensus.ChainReader, header *types.Header, parents []*types.Header) error {
+	// The genesis block is the always valid dead-end
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	// Ensure that the block's timestamp isn't too close to it's parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time.Uint64()+d.config.Period > header.Time.Uint64() {
+		return ErrInvalidTimestamp
+	}
+	// Retrieve the snapshot needed to verify this header and cache it
+	snap, err := d.snapshot(chain, number-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	// If the block is a checkpoint block, verify the elected deputy list
+	if number%d.config.Epoch == 0 {
+		next := snap.nextDeputies()
+		deputies := make([]byte, len(next)*common.AddressLength)
+		for i, deputy := range next {
+			copy(deputies[i*common.AddressLength:], deputy[:])
+		}
+		extraSuffix := len(header.Extra) - extraSeal
+		if !bytes.Equal(header.Extra[extraVanity:extraSuffix], deputies) {
+			return errInvalidCheckpointDeputies
+		}
+	}
+	// All basic checks passed, verify the seal and return
+	return d.verifySeal(chain, header, parents)
+}
+
+// snapshot retrieves the deputy snapshot at a given point in time.
+func (d *Dpovp) snapshot(chain consensus.ChainReader, number uint64, hash common.Hash, parents []*types.Header) (*Snapshot, error) {
+	// Search for a snapshot in memory or on disk for checkpoints
+	var (
+		headers []*types.Header
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// If an in-memory snapshot was found, use that
+		if s, ok := d.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// If an on-disk checkpoint snapshot can be found, use that
+		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(d.config, d.signatures, d.db, hash); err == nil {
+				log.Trace("Loaded deputy snapshot form disk", "number", number, "hash", hash)
+				snap = s
+				break
+			}
+		}
+		// If we're at block zero, make a snapshot
+		if number == 0 {
+			genesis := chain.GetHeaderByNumber(0)
+			if err := d.VerifyHeader(chain, genesis, false); err != nil {
+				return nil, err
+			}
+			deputies := make([]common.Address, (len(genesis.Extra)-extraVanity-extraSeal)/common.AddressLength)
+			for i := 0; i < len(deputies); i++ {
+				copy(deputies[i][:], genesis.Extra[extraVanity+i*common.AddressLength:])
+			}
+			snap = newSnapshot(d.config, d.signatures, 0, genesis.Hash(), deputies)
+			if err := snap.store(d.db); err != nil {
+				return nil, err
+			}
+			log.Trace("Stored genesis deputy snapshot to disk")
+			break
+		}
+		// No snapshot for this header, gather the header and move backward
+		var header *types.Header
+		if len(parents) > 0 {
+			// If we have explicit parents, pick from there (enforced)
+			header = parents[len(parents)-1]
+			if header.Hash() != hash || header.Number.Uint64() != number {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			parents = parents[:len(parents)-1]
+		} else {
+			// No explicit parents (or no more left), reach out to the database
+			header = chain.GetHeader(hash, number)
+			if header == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+		}
+		headers = append(headers, header)
+		number, hash = number-1, header.ParentHash
+	}
+	// Previous snapshot found, apply any pending headers on top of it
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers)
+	if err != nil {
+		return nil, err
+	}
+	d.recents.Add(snap.Hash, snap)
+
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(d.db); err != nil {
+			return nil, err
+		}
+		log.Trace("Stored deputy snapshot to disk", "number", snap.Number, "hash", snap.Hash)
+	}
+	return snap, err
+}
+
+// Finalized implements consensus.Finality, returning the number of the last
+// block confirmed by two thirds of the deputies on the chain ending with the
+// given header.
+func (d *Dpovp) Finalized(chain consensus.ChainReader, header *types.Header) (uint64, error) {
+	snap, err := d.snapshot(chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return 0, err
+	}
+	return d.finalized(snap), nil
+}
+
+// finalized returns the last block finalized on the chain of the snapshot. The
+// confirmations of deputies caught sealing conflicting blocks only count below
+// the height they equivocated from, otherwise they would finalize both forks.
+func (d *Dpovp) finalized(snap *Snapshot) uint64 {
+	d.sealLock.Lock()
+	defer d.sealLock.Unlock()
+
+	limits := make(map[common.Address]uint64)
+	for deputy, number := range snap.Confirms {
+		if first, ok := d.evidence.Equivocated[deputy]; ok && number >= first {
+			limits[deputy] = first - 1
+		}
+	}
+	if len(limits) == 0 {
+		return snap.Finalized
+	}
+	if final := snap.finalized(limits); final < snap.Finalized {
+		return final
+	}
+	return snap.Finalized
+}
+
+// recordSeal tracks the blocks sealed by a deputy across all forks. A seal is the
+// confirmation of the block and all its ancestors, so a deputy sealing two blocks
+// that aren't ancestors of one another equivocated from the height they diverge.
+// The evidence is persisted on every change, as the finality of the chains must
+// not be reverted by a restart.
+func (d *Dpovp) recordSeal(chain consensus.ChainReader, header *types.Header, parents []*types.Header, deputy common.Address) {
+	d.sealLock.Lock()
+	defer d.sealLock.Unlock()
+
+	seal := sealRef{Number: header.Number.Uint64(), Hash: header.Hash()}
+
+	prev, known := d.evidence.Seals[deputy]
+	if known && prev.Hash == seal.Hash {
+		return
+	}
+	changed := false
+	if !known || prev.Number < seal.Number {
+		d.evidence.Seals[deputy] = seal
+		changed = true
+	}
+	// Judge the two seals if the previous block is still around
+	var other *types.Header
+	if known {
+		other = lookupHeader(chain, parents, prev.Hash, prev.Number)
+	}
+	if other != nil {
+		low, high := other, header
+		if low.Number.Uint64() > high.Number.Uint64() {
+			low, high = high, low
+		}
+		// Seals are consistent if the lower block is an ancestor of the higher one
+		if fork, ok := forkPoint(chain, parents, low, high); ok && fork != low.Number.Uint64() {
+			if first, ok := d.evidence.Equivocated[deputy]; !ok || fork+1 < first {
+				d.evidence.Equivocated[deputy] = fork + 1
+				changed = true
+
+				log.Warn("Deputy sealed conflicting blocks", "deputy", deputy, "height", fork+1, "first", prev.Hash, "second", seal.Hash)
+			}
+		}
+	}
+	if changed {
+		if err := d.evidence.store(d.db); err != nil {
+			log.Error("Failed to store deputy seal evidence", "err", err)
+		}
+	}
+}
+
+// forkPoint returns the number of the common ancestor of two headers, or false
+// if their ancestry can't be retrieved.
+func forkPoint(chain consensus.ChainReader, parents []*types.Header, a, b *types.Header) (uint64, bool) {
+	for a != nil && b != nil && a.Number.Uint64() > b.Number.Uint64() {
+		a = parentHeader(chain, parents, a)
+	}
+	for a != nil && b != nil && b.Number.Uint64() > a.Number.Uint64() {
+		b = parentHeader(chain, parents, b)
+	}
+	for a != nil && b != nil && a.Hash() != b.Hash() {
+		a, b = parentHeader(chain, parents, a), parentHeader(chain, parents, b)
+	}
+	if a == nil || b == nil {
+		return 0, false
+	}
+	return a.Number.Uint64(), true
+}
+
+// parentHeader retrieves the parent of a header, preferring the batch of headers
+// being verified over the chain as they may not be imported yet.
+func parentHeader(chain consensus.ChainReader, parents []*types.Header, header *types.Header) *types.Header {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	return lookupHeader(chain, parents, header.ParentHash, number-1)
+}
+
+// lookupHeader retrieves a header by hash and number, preferring the batch of
+// headers being verified over the chain as they may not be imported yet.
+func lookupHeader(chain consensus.ChainReader, parents []*types.Header, hash common.Hash, number uint64) *types.Header {
+	if len(parents) > 0 {
+		if first := parents[0].Number.Uint64(); number >= first && number-first < uint64(len(parents)) {
+			if header := parents[number-first]; header.Hash() == hash {
+				return header
+			}
+		}
+	}
+	return chain.GetHeader(hash, number)
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (d *Dpovp) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// VerifySeal implements consensus.Engine, checking whether the signature contained
+// in the header satisfies the consensus protocol requirements.
+func (d *Dpovp) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	return d.verifySeal(chain, header, nil)
+}
+
+// verifySeal checks whether the signature contained in the header satisfies the
+// consensus protocol requirements. The method accepts an optional list of parent
+// headers that aren't yet part of the local blockchain to generate the snapshots
+// from.
+func (d *Dpovp) verifySeal(chain consensus.ChainReader, header *types.Header, parents []*types.Header) error {
+	// Verifying the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	// Retrieve the snapshot needed to verify this header and cache it
+	snap, err := d.snapshot(chain, number-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	// Resolve the authorization key and check against the deputies
+	deputy, err := ecrecover(header, d.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Deputies[deputy]; !ok {
+		return errUnauthorized
+	}
+	for seen, recent := range snap.Recents {
+		if recent == deputy {
+			// Deputy is among recents, only fail if the current block doesn't shift it out
+			if limit := uint64(len(snap.Deputies)/2 + 1); seen > number-limit {
+				return errUnauthorized
+			}
+		}
+	}
+	// Ensure that the difficulty corresponds to the turn-ness of the deputy
+	inturn := snap.inturn(header.Number.Uint64(), deputy)
+	if inturn && header.Difficulty.Cmp(diffInTurn) != 0 {
+		return errInvalidDifficulty
+	}
+	if !inturn && header.Difficulty.Cmp(diffNoTurn) != 0 {
+		return errInvalidDifficulty
+	}
+	d.recordSeal(chain, header, parents, deputy)
+	return nil
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (d *Dpovp) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	// If the block isn't a checkpoint, cast a random vote (good enough for now)
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+
+	number := header.Number.Uint64()
+	// Assemble the deputy snapshot to check which votes make sense
+	snap, err := d.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if number%d.config.Epoch != 0 {
+		d.lock.RLock()
+
+		// Gather all the proposals that make sense voting on
+		addresses := make([]common.Address, 0, len(d.proposals))
+		for address, authorize := range d.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		// If there's pending proposals, cast a vote on them
+		if len(addresses) > 0 {
+			header.Coinbase = addresses[rand.Intn(len(addresses))]
+			if d.proposals[header.Coinbase] {
+				copy(header.Nonce[:], nonceAuthVote)
+			} else {
+				copy(header.Nonce[:], nonceDropVote)
+			}
+		}
+		d.lock.RUnlock()
+	}
+	// Set the correct difficulty
+	header.Difficulty = CalcDifficulty(snap, d.signer)
+
+	// Ensure the extra data has all it's components
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	header.Extra = header.Extra[:extraVanity]
+
+	if number%d.config.Epoch == 0 {
+		for _, deputy := range snap.nextDeputies() {
+			header.Extra = append(header.Extra, deputy[:]...)
+		}
+	}
+	header.Extra = append(header.Extra, make([]byte, extraSeal)...)
+
+	// Mix digest is reserved for now, set to empty
+	header.MixDigest = common.Hash{}
+
+	// Ensure the timestamp has the correct delay
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(d.config.Period))
+	if header.Time.Int64() < time.Now().Unix() {
+		header.Time = big.NewInt(time.Now().Unix())
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given, and returns the final block.
+func (d *Dpovp) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// No block rewards for deputies, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts), nil
+}
+
+// Authorize injects a private key into the consensus engine to produce new
+// blocks with.
+func (d *Dpovp) Authorize(signer common.Address, signFn SignerFn) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.signer = signer
+	d.signFn = signFn
+}
+
+// Seal implements consensus.Engine, attempting to create a sealed block using
+// the local signing credentials.
+func (d *Dpovp) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil, errUnknownBlock
+	}
+	// For 0-period chains, refuse to seal empty blocks (no reward but would spin sealing)
+	if d.config.Period == 0 && len(block.Transactions()) == 0 {
+		return nil, errWaitTransactions
+	}
+	// Don't hold the signer fields for the entire sealing procedure
+	d.lock.RLock()
+	signer, signFn := d.signer, d.signFn
+	d.lock.RUnlock()
+
+	// Bail out if we're not a deputy of the current epoch
+	snap, err := d.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, authorized := snap.Deputies[signer]; !authorized {
+		return nil, errUnauthorized
+	}
+	// If we're amongst the recent producers, wait for the next block
+	for seen, recent := range snap.Recents {
+		if recent == signer {
+			// Deputy is among recents, only wait if the current block doesn't shift it out
+			if limit := uint64(len(snap.Deputies)/2 + 1); number < limit || seen > number-limit {
+				log.Info("Produced recently, must wait for other deputies")
+				<-stop
+				return nil, nil
+			}
+		}
+	}
+	// Sweet, the protocol permits us to sign the block, wait for our time
+	delay := time.Unix(header.Time.Int64(), 0).Sub(time.Now()) // nolint: gosimple
+	if header.Difficulty.Cmp(diffNoTurn) == 0 {
+		// It's not our turn explicitly to sign, delay it a bit
+		wiggle := time.Duration(len(snap.Deputies)/2+1) * wiggleTime
+		delay += time.Duration(rand.Int63n(int64(wiggle)))
+
+		log.Trace("Out-of-turn production requested", "wiggle", common.PrettyDuration(wiggle))
+	}
+	log.Trace("Waiting for slot to sign and propagate", "delay", common.PrettyDuration(delay))
+
+	select {
+	case <-stop:
+		return nil, nil
+	case <-time.After(delay):
+	}
+	// Sign all the things!
+	sighash, err := signFn(accounts.Account{Address: signer}, sigHash(header).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sighash)
+
+	return block.WithSeal(header), nil
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
+// that a new block should have based on the previous blocks in the chain and the
+// current deputy.
+func (d *Dpovp) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	snap, err := d.snapshot(chain, parent.Number.Uint64(), parent.Hash(), nil)
+	if err != nil {
+		return nil
+	}
+	return CalcDifficulty(snap, d.signer)
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
+// that a new block should have based on the previous blocks in the chain and the
+// current deputy.
+func CalcDifficulty(snap *Snapshot, deputy common.Address) *big.Int {
+	if snap.inturn(snap.Number+1, deputy) {
+		return new(big.Int).Set(diffInTurn)
+	}
+	return new(big.Int).Set(diffNoTurn)
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to query
+// the deputies and block confirmations.
+func (d *Dpovp) APIs(chain consensus.ChainReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "dpovp",
+		Version:   "1.0",
+		Service:   &API{chain: chain, dpovp: d},
+		Public:    false,
+	}}
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+// makeDeputyChain generates a chain of blocks on top of parent, each of them
+// sealed by the given deputy with the difficulty matching its turn.
+func makeDeputyChain(accounts *testerAccountPool, deputies []common.Address, parent *types.Block, db lemodb.Database, sealers []string) []*types.Block {
+	engine := New(params.AllDpovpProtocolChanges.Dpovp, db)
+	blocks, _ := core.GenerateChain(params.AllDpovpProtocolChanges, parent, engine, db, len(sealers), func(i int, b *core.BlockGen) {})
+
+	snap := &Snapshot{Deputies: make(map[common.Address]struct{})}
+	for _, deputy := range deputies {
+		snap.Deputies[deputy] = struct{}{}
+	}
+	for i, block := range blocks {
+		header := block.Header()
+		if i > 0 {
+			header.ParentHash = blocks[i-1].Hash()
+		}
+		header.Extra = make([]byte, extraVanity+extraSeal)
+		header.Difficulty = diffNoTurn
+		if snap.inturn(header.Number.Uint64(), accounts.address(sealers[i])) {
+			header.Difficulty = diffInTurn
+		}
+		accounts.sign(header, sealers[i])
+		blocks[i] = block.WithSeal(header)
+	}
+	return blocks
+}
+
+// Tests that a deputy sealing blocks on two forks doesn't get its confirmations
+// counted on either of them, so that equivocating deputies can't finalize both
+// sides of a fork.
+func TestEquivocationFinality(t *testing.T) {
+	accounts := newTesterAccountPool()
+	deputies := accounts.addresses([]string{"A", "B", "C"})
+
+	genesis := &core.Genesis{
+		Config:    params.AllDpovpProtocolChanges,
+		ExtraData: make([]byte, extraVanity+common.AddressLength*len(deputies)+extraSeal),
+	}
+	for i, deputy := range deputies {
+		copy(genesis.ExtraData[extraVanity+i*common.AddressLength:], deputy[:])
+	}
+	db, _ := lemodb.NewMemDatabase()
+	genesis.MustCommit(db)
+
+	gendb, _ := lemodb.NewMemDatabase()
+	parent := genesis.MustCommit(gendb)
+
+	engine := New(params.AllDpovpProtocolChanges.Dpovp, db)
+	chain, _ := core.NewBlockChain(db, nil, params.AllDpovpProtocolChanges, engine, vm.Config{})
+	defer chain.Stop()
+
+	// Import a chain confirmed by all the deputies
+	honest := makeDeputyChain(accounts, deputies, parent, gendb, []string{"A", "B", "C"})
+	if _, err := chain.InsertChain(honest); err != nil {
+		t.Fatalf("failed to import honest chain: %v", err)
+	}
+	if finalized, _ := engine.Finalized(chain, honest[2].Header()); finalized != 2 {
+		t.Fatalf("honest chain finality mismatch: have %d, want %d", finalized, 2)
+	}
+	if len(engine.evidence.Equivocated) != 0 {
+		t.Fatalf("equivocating deputies on a single chain: %v", engine.evidence.Equivocated)
+	}
+	// Import a heavier fork sealed by two of the deputies from below the finality
+	fork := makeDeputyChain(accounts, deputies, parent, gendb, []string{"B", "C", "B", "C", "B", "C", "B"})
+	if _, err := chain.InsertChain(fork); err != nil {
+		t.Fatalf("failed to import equivocating fork: %v", err)
+	}
+	for _, deputy := range []string{"B", "C"} {
+		if first, ok := engine.evidence.Equivocated[accounts.address(deputy)]; !ok || first != 1 {
+			t.Errorf("deputy %s equivocation mismatch: have %d (%v), want %d", deputy, first, ok, 1)
+		}
+	}
+	if _, ok := engine.evidence.Equivocated[accounts.address("A")]; ok {
+		t.Errorf("honest deputy reported as equivocating")
+	}
+	if finalized, _ := engine.Finalized(chain, honest[2].Header()); finalized != 0 {
+		t.Errorf("honest chain finality mismatch: have %d, want %d", finalized, 0)
+	}
+	if finalized, _ := engine.Finalized(chain, fork[len(fork)-1].Header()); finalized != 0 {
+		t.Errorf("fork finality mismatch: have %d, want %d", finalized, 0)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != fork[len(fork)-1].Hash() {
+		t.Errorf("head block mismatch: have #%d [%x…], want #%d [%x…]", head.NumberU64(), head.Hash().Bytes()[:4], len(fork), fork[len(fork)-1].Hash().Bytes()[:4])
+	}
+	// Restart the engine, the equivocations must still be accounted for
+	restarted := New(params.AllDpovpProtocolChanges.Dpovp, db)
+	if finalized, _ := restarted.Finalized(chain, honest[2].Header()); finalized != 0 {
+		t.Errorf("honest chain finality mismatch after restart: have %d, want %d", finalized, 0)
+	}
+}
+
+// Tests that the seals of a deputy on a single chain, even if verified out of
+// order, are never reported as conflicting.
+func TestSealTracking(t *testing.T) {
+	accounts := newTesterAccountPool()
+	db, _ := lemodb.NewMemDatabase()
+	engine := New(params.AllDpovpProtocolChanges.Dpovp, db)
+
+	headers := make([]*types.Header, 5)
+	for i := range headers {
+		headers[i] = &types.Header{Number: big.NewInt(int64(i) + 1), Extra: make([]byte, extraVanity+extraSeal)}
+		if i > 0 {
+			headers[i].ParentHash = headers[i-1].Hash()
+		}
+		accounts.sign(headers[i], "A")
+	}
+	deputy := accounts.address("A")
+	for _, i := range []int{2, 4, 0, 3, 4} {
+		engine.recordSeal(nil, headers[i], headers, deputy)
+	}
+	if len(engine.evidence.Equivocated) != 0 {
+		t.Fatalf("consistent seals reported as equivocation: %v", engine.evidence.Equivocated)
+	}
+	if seal := engine.evidence.Seals[deputy]; seal.Hash != headers[4].Hash() {
+		t.Fatalf("highest seal mismatch: have #%d, want #%d", seal.Number, 5)
+	}
+}
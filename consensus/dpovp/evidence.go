@@ -0,0 +1,72 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"encoding/json"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+)
+
+// evidenceKey is the database key of the seals tracked across all forks.
+var evidenceKey = []byte("dpovp-evidence")
+
+// sealRef identifies the highest block sealed by a deputy.
+type sealRef struct {
+	Number uint64      `json:"number"` // Number of the sealed block
+	Hash   common.Hash `json:"hash"`   // Hash of the sealed block
+}
+
+// evidence is the record of the blocks sealed by the deputies across all forks,
+// which any chain's finality is judged by. It's persisted on every change, so
+// the finality of a chain doesn't depend on the blocks seen since the last start.
+type evidence struct {
+	Seals       map[common.Address]sealRef `json:"seals"`       // Highest block sealed by each deputy across all forks
+	Equivocated map[common.Address]uint64  `json:"equivocated"` // First height at which each deputy sealed conflicting blocks
+}
+
+// newEvidence creates an empty record of deputy seals.
+func newEvidence() *evidence {
+	return &evidence{
+		Seals:       make(map[common.Address]sealRef),
+		Equivocated: make(map[common.Address]uint64),
+	}
+}
+
+// loadEvidence loads the record of deputy seals from the database, or creates an
+// empty one if none was stored yet.
+func loadEvidence(db lemodb.Database) (*evidence, error) {
+	blob, err := db.Get(evidenceKey)
+	if err != nil {
+		return newEvidence(), nil
+	}
+	e := newEvidence()
+	if err := json.Unmarshal(blob, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// store inserts the record of deputy seals into the database.
+func (e *evidence) store(db lemodb.Database) error {
+	blob, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return db.Put(evidenceKey, blob)
+}
@@ -0,0 +1,362 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Vote represents a single vote that a deputy made to modify the deputy set at
+// the next epoch boundary.
+type Vote struct {
+	Deputy    common.Address `json:"deputy"`    // Deputy that cast this vote
+	Block     uint64         `json:"block"`     // Block number the vote was cast in
+	Address   common.Address `json:"address"`   // Account being voted on to change its deputy status
+	Authorize bool           `json:"authorize"` // Whether to elect or dismiss the voted account
+}
+
+// Tally is a simple vote tally to keep the current score of votes. Votes that
+// go against the proposal aren't counted since it's equivalent to not voting.
+type Tally struct {
+	Authorize bool `json:"authorize"` // Whether the vote is about electing or dismissing someone
+	Votes     int  `json:"votes"`     // Number of votes until now wanting to pass the proposal
+}
+
+// Snapshot is the state of the deputy set, the pending votes and the block
+// confirmations at a given point in time.
+type Snapshot struct {
+	config   *params.DpovpConfig // Consensus engine parameters to fine tune behavior
+	sigcache *lru.ARCCache       // Cache of recent block signatures to speed up ecrecover
+
+	Number    uint64                      `json:"number"`    // Block number where the snapshot was created
+	Hash      common.Hash                 `json:"hash"`      // Block hash where the snapshot was created
+	Deputies  map[common.Address]struct{} `json:"deputies"`  // Set of deputies of the current epoch
+	Recents   map[uint64]common.Address   `json:"recents"`   // Set of recent block producers for spam protections
+	Votes     []*Vote                     `json:"votes"`     // List of votes cast in chronological order
+	Tally     map[common.Address]Tally    `json:"tally"`     // Current vote tally to avoid recalculating
+	Confirms  map[common.Address]uint64   `json:"confirms"`  // Highest block confirmed by each deputy
+	Finalized uint64                      `json:"finalized"` // Number of the last block confirmed by 2/3 of the deputies
+}
+
+// newSnapshot creates a new snapshot with the specified startup parameters. This
+// method does not initialize the set of recent producers, so only ever use if
+// for the genesis block.
+func newSnapshot(config *params.DpovpConfig, sigcache *lru.ARCCache, number uint64, hash common.Hash, deputies []common.Address) *Snapshot {
+	snap := &Snapshot{
+		config:   config,
+		sigcache: sigcache,
+		Number:   number,
+		Hash:     hash,
+		Deputies: make(map[common.Address]struct{}),
+		Recents:  make(map[uint64]common.Address),
+		Tally:    make(map[common.Address]Tally),
+		Confirms: make(map[common.Address]uint64),
+	}
+	for _, deputy := range deputies {
+		snap.Deputies[deputy] = struct{}{}
+	}
+	return snap
+}
+
+// loadSnapshot loads an existing snapshot from the database.
+func loadSnapshot(config *params.DpovpConfig, sigcache *lru.ARCCache, db lemodb.Database, hash common.Hash) (*Snapshot, error) {
+	blob, err := db.Get(append([]byte("dpovp-"), hash[:]...))
+	if err != nil {
+		return nil, err
+	}
+	snap := new(Snapshot)
+	if err := json.Unmarshal(blob, snap); err != nil {
+		return nil, err
+	}
+	snap.config = config
+	snap.sigcache = sigcache
+
+	return snap, nil
+}
+
+// store inserts the snapshot into the database.
+func (s *Snapshot) store(db lemodb.Database) error {
+	blob, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return db.Put(append([]byte("dpovp-"), s.Hash[:]...), blob)
+}
+
+// copy creates a deep copy of the snapshot, though not the individual votes.
+func (s *Snapshot) copy() *Snapshot {
+	cpy := &Snapshot{
+		config:    s.config,
+		sigcache:  s.sigcache,
+		Number:    s.Number,
+		Hash:      s.Hash,
+		Deputies:  make(map[common.Address]struct{}),
+		Recents:   make(map[uint64]common.Address),
+		Votes:     make([]*Vote, len(s.Votes)),
+		Tally:     make(map[common.Address]Tally),
+		Confirms:  make(map[common.Address]uint64),
+		Finalized: s.Finalized,
+	}
+	for deputy := range s.Deputies {
+		cpy.Deputies[deputy] = struct{}{}
+	}
+	for block, deputy := range s.Recents {
+		cpy.Recents[block] = deputy
+	}
+	for address, tally := range s.Tally {
+		cpy.Tally[address] = tally
+	}
+	for deputy, number := range s.Confirms {
+		cpy.Confirms[deputy] = number
+	}
+	copy(cpy.Votes, s.Votes)
+
+	return cpy
+}
+
+// validVote returns whether it makes sense to cast the specified vote in the
+// given snapshot context (e.g. don't try to elect an already active deputy).
+func (s *Snapshot) validVote(address common.Address, authorize bool) bool {
+	_, deputy := s.Deputies[address]
+	return (deputy && !authorize) || (!deputy && authorize)
+}
+
+// cast adds a new vote into the tally.
+func (s *Snapshot) cast(address common.Address, authorize bool) bool {
+	// Ensure the vote is meaningful
+	if !s.validVote(address, authorize) {
+		return false
+	}
+	// Cast the vote into an existing or new tally
+	if old, ok := s.Tally[address]; ok {
+		old.Votes++
+		s.Tally[address] = old
+	} else {
+		s.Tally[address] = Tally{Authorize: authorize, Votes: 1}
+	}
+	return true
+}
+
+// uncast removes a previously cast vote from the tally.
+func (s *Snapshot) uncast(address common.Address, authorize bool) bool {
+	// If there's no tally, it's a dangling vote, just drop
+	tally, ok := s.Tally[address]
+	if !ok {
+		return false
+	}
+	// Ensure we only revert counted votes
+	if tally.Authorize != authorize {
+		return false
+	}
+	// Otherwise revert the vote
+	if tally.Votes > 1 {
+		tally.Votes--
+		s.Tally[address] = tally
+	} else {
+		delete(s.Tally, address)
+	}
+	return true
+}
+
+// nextDeputies retrieves the deputy set of the next epoch in ascending order,
+// which is the current one with all the proposals passed by a majority of the
+// deputies applied.
+func (s *Snapshot) nextDeputies() []common.Address {
+	next := make(map[common.Address]struct{}, len(s.Deputies))
+	for deputy := range s.Deputies {
+		next[deputy] = struct{}{}
+	}
+	for address, tally := range s.Tally {
+		if tally.Votes <= len(s.Deputies)/2 {
+			continue
+		}
+		if tally.Authorize {
+			next[address] = struct{}{}
+		} else {
+			delete(next, address)
+		}
+	}
+	return sortAddresses(next)
+}
+
+// apply creates a new deputy snapshot by applying the given headers to the
+// original one.
+func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
+	// Allow passing in no headers for cleaner code
+	if len(headers) == 0 {
+		return s, nil
+	}
+	// Sanity check that the headers can be applied
+	for i := 0; i < len(headers)-1; i++ {
+		if headers[i+1].Number.Uint64() != headers[i].Number.Uint64()+1 {
+			return nil, errInvalidVotingChain
+		}
+	}
+	if headers[0].Number.Uint64() != s.Number+1 {
+		return nil, errInvalidVotingChain
+	}
+	// Iterate through the headers and create a new snapshot
+	snap := s.copy()
+
+	for _, header := range headers {
+		number := header.Number.Uint64()
+
+		// Delete the oldest producer from the recent list to allow it sealing again
+		if limit := uint64(len(snap.Deputies)/2 + 1); number >= limit {
+			delete(snap.Recents, number-limit)
+		}
+		// Resolve the producer and check against the deputies of the epoch
+		deputy, err := ecrecover(header, s.sigcache)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := snap.Deputies[deputy]; !ok {
+			return nil, errUnauthorized
+		}
+		for _, recent := range snap.Recents {
+			if recent == deputy {
+				return nil, errUnauthorized
+			}
+		}
+		snap.Recents[number] = deputy
+
+		// The seal confirms the block and all its ancestors, update the finality
+		snap.Confirms[deputy] = number
+		if final := snap.finalized(nil); final > snap.Finalized {
+			snap.Finalized = final
+		}
+		// Checkpoint blocks switch to the deputy set elected during the epoch
+		if number%s.config.Epoch == 0 {
+			snap.rotate(snap.nextDeputies())
+			continue
+		}
+		// Header authorized, discard any previous votes from the deputy
+		for i, vote := range snap.Votes {
+			if vote.Deputy == deputy && vote.Address == header.Coinbase {
+				// Uncast the vote from the cached tally
+				snap.uncast(vote.Address, vote.Authorize)
+
+				// Uncast the vote from the chronological list
+				snap.Votes = append(snap.Votes[:i], snap.Votes[i+1:]...)
+				break // only one vote allowed
+			}
+		}
+		// Tally up the new vote from the deputy
+		var authorize bool
+		switch {
+		case bytes.Equal(header.Nonce[:], nonceAuthVote):
+			authorize = true
+		case bytes.Equal(header.Nonce[:], nonceDropVote):
+			authorize = false
+		default:
+			return nil, errInvalidVote
+		}
+		if snap.cast(header.Coinbase, authorize) {
+			snap.Votes = append(snap.Votes, &Vote{
+				Deputy:    deputy,
+				Block:     number,
+				Address:   header.Coinbase,
+				Authorize: authorize,
+			})
+		}
+	}
+	snap.Number += uint64(len(headers))
+	snap.Hash = headers[len(headers)-1].Hash()
+
+	return snap, nil
+}
+
+// rotate replaces the deputy set with the given one, discarding all the votes
+// and the spam protection and confirmations of dismissed deputies.
+func (s *Snapshot) rotate(deputies []common.Address) {
+	s.Deputies = make(map[common.Address]struct{}, len(deputies))
+	for _, deputy := range deputies {
+		s.Deputies[deputy] = struct{}{}
+	}
+	s.Votes = nil
+	s.Tally = make(map[common.Address]Tally)
+
+	for number, deputy := range s.Recents {
+		if _, ok := s.Deputies[deputy]; !ok {
+			delete(s.Recents, number)
+		}
+	}
+	for deputy := range s.Confirms {
+		if _, ok := s.Deputies[deputy]; !ok {
+			delete(s.Confirms, deputy)
+		}
+	}
+}
+
+// quorum returns the number of deputy confirmations needed to finalize a block,
+// being at least two thirds of the deputies.
+func (s *Snapshot) quorum() int {
+	return (2*len(s.Deputies) + 2) / 3
+}
+
+// finalized calculates the highest block number confirmed by a quorum of the
+// current deputies. The confirmations of the deputies present in limits are not
+// counted above their limit.
+func (s *Snapshot) finalized(limits map[common.Address]uint64) uint64 {
+	confirms := make([]uint64, 0, len(s.Confirms))
+	for deputy, number := range s.Confirms {
+		if limit, ok := limits[deputy]; ok && number > limit {
+			number = limit
+		}
+		confirms = append(confirms, number)
+	}
+	quorum := s.quorum()
+	if quorum == 0 || len(confirms) < quorum {
+		return 0
+	}
+	sort.Slice(confirms, func(i, j int) bool { return confirms[i] > confirms[j] })
+	return confirms[quorum-1]
+}
+
+// deputies retrieves the list of deputies in ascending order.
+func (s *Snapshot) deputies() []common.Address {
+	return sortAddresses(s.Deputies)
+}
+
+// inturn returns if a deputy at a given block height is in-turn or not.
+func (s *Snapshot) inturn(number uint64, deputy common.Address) bool {
+	deputies, offset := s.deputies(), 0
+	for offset < len(deputies) && deputies[offset] != deputy {
+		offset++
+	}
+	return (number % uint64(len(deputies))) == uint64(offset)
+}
+
+// sortAddresses flattens a set of addresses into a list in ascending order.
+func sortAddresses(set map[common.Address]struct{}) []common.Address {
+	addresses := make([]common.Address, 0, len(set))
+	for address := range set {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i][:], addresses[j][:]) < 0
+	})
+	return addresses
+}
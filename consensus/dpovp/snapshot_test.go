@@ -0,0 +1,251 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpovp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+type testerBlock struct {
+	deputy string
+	voted  string
+	auth   bool
+}
+
+// testerAccountPool is a pool to maintain currently active tester accounts,
+// mapped from textual names used in the tests below to actual Lemochain private
+// keys capable of signing blocks.
+type testerAccountPool struct {
+	accounts map[string]*ecdsa.PrivateKey
+}
+
+func newTesterAccountPool() *testerAccountPool {
+	return &testerAccountPool{
+		accounts: make(map[string]*ecdsa.PrivateKey),
+	}
+}
+
+func (ap *testerAccountPool) sign(header *types.Header, deputy string) {
+	// Ensure we have a persistent key for the deputy
+	if ap.accounts[deputy] == nil {
+		ap.accounts[deputy], _ = crypto.GenerateKey()
+	}
+	// Sign the header and embed the signature in extra data
+	sig, _ := crypto.Sign(sigHash(header).Bytes(), ap.accounts[deputy])
+	copy(header.Extra[len(header.Extra)-65:], sig)
+}
+
+func (ap *testerAccountPool) address(account string) common.Address {
+	// Return the zero account for non-addresses
+	if account == "" {
+		return common.Address{}
+	}
+	// Ensure we have a persistent key for the account
+	if ap.accounts[account] == nil {
+		ap.accounts[account], _ = crypto.GenerateKey()
+	}
+	// Resolve and return the Lemochain address
+	return crypto.PubkeyToAddress(ap.accounts[account].PublicKey)
+}
+
+// addresses resolves and sorts a list of textual account names.
+func (ap *testerAccountPool) addresses(accounts []string) []common.Address {
+	set := make(map[common.Address]struct{})
+	for _, account := range accounts {
+		set[ap.address(account)] = struct{}{}
+	}
+	return sortAddresses(set)
+}
+
+// testerChainReader implements consensus.ChainReader to access the genesis
+// block. All other methods and requests will panic.
+type testerChainReader struct {
+	db lemodb.Database
+}
+
+func (r *testerChainReader) Config() *params.ChainConfig                 { return params.AllDpovpProtocolChanges }
+func (r *testerChainReader) CurrentHeader() *types.Header                { panic("not supported") }
+func (r *testerChainReader) GetHeader(common.Hash, uint64) *types.Header { panic("not supported") }
+func (r *testerChainReader) GetBlock(common.Hash, uint64) *types.Block   { panic("not supported") }
+func (r *testerChainReader) GetHeaderByHash(common.Hash) *types.Header   { panic("not supported") }
+func (r *testerChainReader) GetHeaderByNumber(number uint64) *types.Header {
+	if number == 0 {
+		return core.GetHeader(r.db, core.GetCanonicalHash(r.db, 0), 0)
+	}
+	panic("not supported")
+}
+
+// Tests that the deputy set is only rotated at epoch boundaries and that blocks
+// are finalized once confirmed by two thirds of the deputies.
+func TestDeputiesAndFinality(t *testing.T) {
+	tests := []struct {
+		epoch     uint64
+		deputies  []string
+		blocks    []testerBlock
+		results   []string
+		finalized uint64
+		failure   error
+	}{
+		{
+			// Single deputy, every block is final right away
+			deputies:  []string{"A"},
+			blocks:    []testerBlock{{deputy: "A"}, {deputy: "A"}},
+			results:   []string{"A"},
+			finalized: 2,
+		}, {
+			// Three deputies, a block is final once two of them built on it
+			deputies:  []string{"A", "B", "C"},
+			blocks:    []testerBlock{{deputy: "A"}, {deputy: "B"}, {deputy: "C"}},
+			results:   []string{"A", "B", "C"},
+			finalized: 2,
+		}, {
+			// Four deputies need three confirmations
+			deputies:  []string{"A", "B", "C", "D"},
+			blocks:    []testerBlock{{deputy: "A"}, {deputy: "B"}, {deputy: "C"}},
+			results:   []string{"A", "B", "C", "D"},
+			finalized: 1,
+		}, {
+			// Four deputies, the same two deputies can't finalize anything
+			deputies:  []string{"A", "B", "C", "D"},
+			blocks:    []testerBlock{{deputy: "A"}, {deputy: "B"}, {deputy: "C"}, {deputy: "A"}, {deputy: "B"}},
+			results:   []string{"A", "B", "C", "D"},
+			finalized: 3,
+		}, {
+			// Passed election doesn't take effect before the epoch ends
+			epoch:    4,
+			deputies: []string{"A", "B"},
+			blocks: []testerBlock{
+				{deputy: "A", voted: "C", auth: true},
+				{deputy: "B", voted: "C", auth: true},
+				{deputy: "A"},
+			},
+			results:   []string{"A", "B"},
+			finalized: 2,
+		}, {
+			// Passed election takes effect at the epoch boundary
+			epoch:    3,
+			deputies: []string{"A", "B"},
+			blocks: []testerBlock{
+				{deputy: "A", voted: "C", auth: true},
+				{deputy: "B", voted: "C", auth: true},
+				{deputy: "A"},
+				{deputy: "C"},
+			},
+			results:   []string{"A", "B", "C"},
+			finalized: 3,
+		}, {
+			// Single vote out of two deputies doesn't pass
+			epoch:    3,
+			deputies: []string{"A", "B"},
+			blocks: []testerBlock{
+				{deputy: "A", voted: "B", auth: false},
+				{deputy: "B"},
+				{deputy: "A"},
+			},
+			results:   []string{"A", "B"},
+			finalized: 2,
+		}, {
+			// Dismissed deputy drops out of the confirmations at the epoch boundary
+			epoch:    3,
+			deputies: []string{"A", "B", "C"},
+			blocks: []testerBlock{
+				{deputy: "A", voted: "C", auth: false},
+				{deputy: "B", voted: "C", auth: false},
+				{deputy: "C"},
+			},
+			results:   []string{"A", "B"},
+			finalized: 2,
+		}, {
+			// Non-deputies can't produce blocks
+			deputies: []string{"A", "B"},
+			blocks:   []testerBlock{{deputy: "A"}, {deputy: "C"}},
+			failure:  errUnauthorized,
+		}, {
+			// Deputies can't produce blocks in a row
+			deputies: []string{"A", "B", "C"},
+			blocks:   []testerBlock{{deputy: "A"}, {deputy: "A"}},
+			failure:  errUnauthorized,
+		},
+	}
+	for i, tt := range tests {
+		// Create the account pool and generate the initial set of deputies
+		accounts := newTesterAccountPool()
+		deputies := accounts.addresses(tt.deputies)
+
+		// Create the genesis block with the initial set of deputies
+		genesis := &core.Genesis{
+			ExtraData: make([]byte, extraVanity+common.AddressLength*len(deputies)+extraSeal),
+		}
+		for j, deputy := range deputies {
+			copy(genesis.ExtraData[extraVanity+j*common.AddressLength:], deputy[:])
+		}
+		db, _ := lemodb.NewMemDatabase()
+		genesis.Commit(db)
+
+		// Assemble a chain of headers from the produced blocks
+		headers := make([]*types.Header, len(tt.blocks))
+		for j, block := range tt.blocks {
+			headers[j] = &types.Header{
+				Number:   big.NewInt(int64(j) + 1),
+				Time:     big.NewInt(int64(j)),
+				Coinbase: accounts.address(block.voted),
+				Extra:    make([]byte, extraVanity+extraSeal),
+			}
+			if j > 0 {
+				headers[j].ParentHash = headers[j-1].Hash()
+			}
+			if block.auth {
+				copy(headers[j].Nonce[:], nonceAuthVote)
+			}
+			accounts.sign(headers[j], block.deputy)
+		}
+		// Pass all the headers through dpovp and ensure the snapshot is correct
+		head := headers[len(headers)-1]
+
+		snap, err := New(&params.DpovpConfig{Epoch: tt.epoch}, db).snapshot(&testerChainReader{db: db}, head.Number.Uint64(), head.Hash(), headers)
+		if err != tt.failure {
+			t.Errorf("test %d: failure mismatch: have %v, want %v", i, err, tt.failure)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		result, want := snap.deputies(), accounts.addresses(tt.results)
+		if len(result) != len(want) {
+			t.Errorf("test %d: deputies mismatch: have %x, want %x", i, result, want)
+			continue
+		}
+		for j := 0; j < len(result); j++ {
+			if !bytes.Equal(result[j][:], want[j][:]) {
+				t.Errorf("test %d, deputy %d: deputy mismatch: have %x, want %x", i, j, result[j], want[j])
+			}
+		}
+		if snap.Finalized != tt.finalized {
+			t.Errorf("test %d: finalized mismatch: have %d, want %d", i, snap.Finalized, tt.finalized)
+		}
+	}
+}
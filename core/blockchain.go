@@ -31,6 +31,7 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/common/mclock"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus"
 	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state/snapshot"
 	"github.com/LemoFoundationLtd/lemochain-go/core/types"
 	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
 	"github.com/LemoFoundationLtd/lemochain-go/crypto"
@@ -48,6 +49,9 @@ import (
 var (
 	blockInsertTimer = metrics.NewRegisteredTimer("chain/inserts", nil)
 
+	blockPrefetchExecuteTimer   = metrics.NewRegisteredTimer("chain/prefetch/executes", nil)
+	blockPrefetchInterruptMeter = metrics.NewRegisteredMeter("chain/prefetch/interrupts", nil)
+
 	ErrNoGenesis = errors.New("Genesis not found in chain")
 )
 
@@ -66,9 +70,16 @@ const (
 // CacheConfig contains the configuration values for the trie caching/pruning
 // that's resident in a blockchain.
 type CacheConfig struct {
-	Disabled      bool          // Whlemo to disable trie write caching (archive node)
-	TrieNodeLimit int           // Memory limit (MB) at which to flush the current in-memory trie to disk
-	TrieTimeLimit time.Duration // Time limit after which to flush the current in-memory trie to disk
+	Disabled         bool          // Whlemo to disable trie write caching (archive node)
+	TrieNodeLimit    int           // Memory limit (MB) at which to flush the current in-memory trie to disk
+	TrieTimeLimit    time.Duration // Time limit after which to flush the current in-memory trie to disk
+	FreezerThreshold uint64        // Distance from the head after which blocks are moved into the ancient store (0 = default)
+	HistoryRetain    uint64        // Number of recent blocks whose bodies and receipts are retained (0 = all)
+	NoSnapshot       bool          // Whether to disable the flat state snapshot acceleration structure
+	TrieCleanLimit   int           // Memory allowance (MB) to use for caching clean trie nodes in memory
+	NoPrefetch       bool          // Whether to disable the heuristic state prefetching of the next block
+	StorageCodec     StorageCodec  // Codec new block bodies and receipts are compressed with
+	ReadOnly         bool          // Whether the database is read-only, disabling freezing and history pruning
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -98,10 +109,15 @@ type BlockChain struct {
 	chainFeed     event.Feed
 	chainSideFeed event.Feed
 	chainHeadFeed event.Feed
+	reorgFeed     event.Feed
 	logsFeed      event.Feed
 	scope         event.SubscriptionScope
 	genesisBlock  *types.Block
 
+	reorgQueue []ChainReorgEvent // Reorg events waiting to be delivered, oldest first
+	reorgLock  sync.Mutex        // Protects the reorg event queue
+	reorgWake  chan struct{}     // Notification channel of newly queued reorg events
+
 	mu      sync.RWMutex // global mutex for locking chain operations
 	chainmu sync.RWMutex // blockchain insertion lock
 	procmu  sync.RWMutex // block processor lock
@@ -109,8 +125,11 @@ type BlockChain struct {
 	checkpoint       int          // checkpoint counts towards the new checkpoint
 	currentBlock     atomic.Value // Current head of the block chain
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
+	historyTail      uint64       // First block whose history is retained, older ones may be pruned (atomic)
 
 	stateCache   state.Database // State database to reuse between imports (contains state cache)
+	snaps        *snapshot.Tree // Flat state snapshot for fast state access (nil if disabled)
+	snapsRebuilt bool           // Whether the snapshot was already regenerated after missing an imported state
 	bodyCache    *lru.Cache     // Cache for the most recent block bodies
 	bodyRLPCache *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
 	blockCache   *lru.Cache     // Cache for the most recent entire blocks
@@ -122,10 +141,11 @@ type BlockChain struct {
 	procInterrupt int32          // interrupt signaler for block processing
 	wg            sync.WaitGroup // chain processing wait group for shutting down
 
-	engine    consensus.Engine
-	processor Processor // block processor interface
-	validator Validator // block and state validator interface
-	vmConfig  vm.Config
+	engine     consensus.Engine
+	processor  Processor  // block processor interface
+	validator  Validator  // block and state validator interface
+	prefetcher Prefetcher // block state prefetcher interface
+	vmConfig   vm.Config
 
 	badBlocks *lru.Cache // Bad block cache
 }
@@ -136,8 +156,9 @@ type BlockChain struct {
 func NewBlockChain(db lemodb.Database, cacheConfig *CacheConfig, chainConfig *params.ChainConfig, engine consensus.Engine, vmConfig vm.Config) (*BlockChain, error) {
 	if cacheConfig == nil {
 		cacheConfig = &CacheConfig{
-			TrieNodeLimit: 256 * 1024 * 1024,
-			TrieTimeLimit: 5 * time.Minute,
+			TrieNodeLimit:  256 * 1024 * 1024,
+			TrieTimeLimit:  5 * time.Minute,
+			TrieCleanLimit: 256,
 		}
 	}
 	bodyCache, _ := lru.New(bodyCacheLimit)
@@ -151,8 +172,9 @@ func NewBlockChain(db lemodb.Database, cacheConfig *CacheConfig, chainConfig *pa
 		cacheConfig:  cacheConfig,
 		db:           db,
 		triegc:       prque.New(),
-		stateCache:   state.NewDatabase(db),
+		stateCache:   state.NewDatabaseWithCache(db, cacheConfig.TrieCleanLimit),
 		quit:         make(chan struct{}),
+		reorgWake:    make(chan struct{}, 1),
 		bodyCache:    bodyCache,
 		bodyRLPCache: bodyRLPCache,
 		blockCache:   blockCache,
@@ -163,6 +185,7 @@ func NewBlockChain(db lemodb.Database, cacheConfig *CacheConfig, chainConfig *pa
 	}
 	bc.SetValidator(NewBlockValidator(chainConfig, bc, engine))
 	bc.SetProcessor(NewStateProcessor(chainConfig, bc, engine))
+	bc.prefetcher = newStatePrefetcher(chainConfig, bc, engine)
 
 	var err error
 	bc.hc, err = NewHeaderChain(db, chainConfig, engine, bc.getProcInterrupt)
@@ -189,8 +212,34 @@ func NewBlockChain(db lemodb.Database, cacheConfig *CacheConfig, chainConfig *pa
 			}
 		}
 	}
+	// Load any existing snapshot, regenerating it if loading failed
+	if !bc.cacheConfig.NoSnapshot {
+		bc.snaps = snapshot.New(bc.db, bc.stateCache.TrieDB(), bc.CurrentBlock().Root())
+	}
+	bc.historyTail = GetHistoryTail(db)
+
+	if cacheConfig.HistoryRetain > 0 && cacheConfig.HistoryRetain < minHistoryRetain {
+		log.Warn("Sanitizing history retention", "provided", cacheConfig.HistoryRetain, "updated", minHistoryRetain)
+		cacheConfig.HistoryRetain = minHistoryRetain
+	}
+	bc.checkHistoryRetain()
+
 	// Take ownership of this particular state
 	go bc.update()
+
+	bc.wg.Add(1)
+	go bc.dispatchReorgs()
+
+	// Move and delete old chain data only if the database may be modified
+	if !cacheConfig.ReadOnly {
+		bc.wg.Add(1)
+		go bc.freeze()
+
+		if cacheConfig.HistoryRetain > 0 {
+			bc.wg.Add(1)
+			go bc.pruneHistory()
+		}
+	}
 	return bc, nil
 }
 
@@ -254,9 +303,18 @@ func (bc *BlockChain) loadLastState() error {
 	log.Info("Loaded most recent local full block", "number", currentBlock.Number(), "hash", currentBlock.Hash(), "td", blockTd)
 	log.Info("Loaded most recent local fast block", "number", currentFastBlock.Number(), "hash", currentFastBlock.Hash(), "td", fastTd)
 
+	bc.ensureSnapshot(currentBlock.Root())
 	return nil
 }
 
+// ensureSnapshot regenerates the state snapshot if it doesn't cover the given
+// state root, e.g. after the head was rewound below the persisted snapshot.
+func (bc *BlockChain) ensureSnapshot(root common.Hash) {
+	if bc.snaps != nil && bc.snaps.Snapshot(root) == nil {
+		bc.snaps.Rebuild(root)
+	}
+}
+
 // SetHead rewinds the local chain to a new head. In the case of headers, everything
 // above the new head will be deleted and the new one set. In the case of blocks
 // though, the head may be further rewound if block bodies are missing (non-archive
@@ -328,6 +386,8 @@ func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
 	bc.currentBlock.Store(block)
 	bc.mu.Unlock()
 
+	bc.ensureSnapshot(block.Root())
+
 	log.Info("Committed new head block", "number", block.Number(), "hash", hash)
 	return nil
 }
@@ -384,7 +444,7 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 
 // StateAt returns a new mutable state based on a particular point in time.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache)
+	return state.NewWithSnapshot(root, bc.stateCache, bc.snaps)
 }
 
 // Reset purges the entire blockchain, restoring it to its genesis state.
@@ -415,6 +475,7 @@ func (bc *BlockChain) ResetWithGenesisBlock(genesis *types.Block) error {
 	bc.hc.SetGenesis(bc.genesisBlock.Header())
 	bc.hc.SetCurrentHeader(bc.genesisBlock.Header())
 	bc.currentFastBlock.Store(bc.genesisBlock)
+	bc.ensureSnapshot(bc.genesisBlock.Root())
 
 	return nil
 }
@@ -539,8 +600,10 @@ func (bc *BlockChain) HasBlock(hash common.Hash, number uint64) bool {
 	if bc.blockCache.Contains(hash) {
 		return true
 	}
-	ok, _ := bc.db.Has(blockBodyKey(hash, number))
-	return ok
+	if ok, _ := bc.db.Has(blockBodyKey(hash, number)); ok {
+		return true
+	}
+	return hasAncientBlock(bc.db, hash, number)
 }
 
 // HasState checks if state trie is fully present in the database or not.
@@ -642,6 +705,12 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	// Journal the snapshot diff layers above the disk layer, suspending generation
+	if bc.snaps != nil {
+		if err := bc.snaps.Journal(bc.CurrentBlock().Root()); err != nil {
+			log.Error("Failed to journal state snapshot", "err", err)
+		}
+	}
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -802,10 +871,10 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 			return i, fmt.Errorf("failed to set receipts data: %v", err)
 		}
 		// Write all the data out into the database
-		if err := WriteBody(batch, block.Hash(), block.NumberU64(), block.Body()); err != nil {
+		if err := writeBody(batch, block.Hash(), block.NumberU64(), block.Body(), bc.cacheConfig.StorageCodec); err != nil {
 			return i, fmt.Errorf("failed to write block body: %v", err)
 		}
-		if err := WriteBlockReceipts(batch, block.Hash(), block.NumberU64(), receipts); err != nil {
+		if err := writeBlockReceipts(batch, block.Hash(), block.NumberU64(), receipts, bc.cacheConfig.StorageCodec); err != nil {
 			return i, fmt.Errorf("failed to write block receipts: %v", err)
 		}
 		if err := WriteTxLookupEntries(batch, block); err != nil {
@@ -864,7 +933,7 @@ func (bc *BlockChain) WriteBlockWithoutState(block *types.Block, td *big.Int) (e
 	if err := bc.hc.WriteTd(block.Hash(), block.NumberU64(), td); err != nil {
 		return err
 	}
-	if err := WriteBlock(bc.db, block); err != nil {
+	if err := writeBlock(bc.db, block, bc.cacheConfig.StorageCodec); err != nil {
 		return err
 	}
 	return nil
@@ -894,7 +963,7 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	}
 	// Write other block data using a batch.
 	batch := bc.db.NewBatch()
-	if err := WriteBlock(batch, block); err != nil {
+	if err := writeBlock(batch, block, bc.cacheConfig.StorageCodec); err != nil {
 		return NonStatTy, err
 	}
 	root, err := state.Commit(bc.chainConfig.IsEIP158(block.Number()))
@@ -953,7 +1022,7 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 			}
 		}
 	}
-	if err := WriteBlockReceipts(batch, block.Hash(), block.NumberU64(), receipts); err != nil {
+	if err := writeBlockReceipts(batch, block.Hash(), block.NumberU64(), receipts, bc.cacheConfig.StorageCodec); err != nil {
 		return NonStatTy, err
 	}
 	// If the total difficulty is higher than our known, add it to the canonical chain
@@ -965,13 +1034,17 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 		// Split same-difficulty blocks by number, then at random
 		reorg = block.NumberU64() < currentBlock.NumberU64() || (block.NumberU64() == currentBlock.NumberU64() && mrand.Float64() < 0.5)
 	}
-	if reorg {
-		// Reorganise the chain if the parent is not the head block
-		if block.ParentHash() != currentBlock.Hash() {
-			if err := bc.reorg(currentBlock, block); err != nil {
+	// Reorganise the chain if the parent is not the head block, keeping the block
+	// as a side chain if that would revert finalized blocks
+	if reorg && block.ParentHash() != currentBlock.Hash() {
+		if err := bc.reorg(currentBlock, block); err != nil {
+			if err != ErrReorgFinalized {
 				return NonStatTy, err
 			}
+			reorg = false
 		}
+	}
+	if reorg {
 		// Write the positional metadata for transaction and receipt lookups
 		if err := WriteTxLookupEntries(batch, block); err != nil {
 			return NonStatTy, err
@@ -991,6 +1064,27 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	// Set new head.
 	if status == CanonStatTy {
 		bc.insert(block)
+
+		// Keep a bounded number of diff layers in the snapshot tree above the disk.
+		// The disk layer is kept within the tries retained in memory, so that an
+		// interrupted snapshot generation can resume on top of it.
+		//
+		// If the new head isn't covered by the snapshot (e.g. a reorg deeper than
+		// the disk layer), it's regenerated once. Any further miss falls back to
+		// the tries until the next restart, instead of repeatedly wiping it.
+		if bc.snaps != nil {
+			switch {
+			case bc.snaps.Snapshot(root) != nil:
+				if err := bc.snaps.Cap(root, triesInMemory-1); err != nil {
+					log.Warn("Failed to cap snapshot tree", "root", root, "err", err)
+				}
+			case !bc.snapsRebuilt:
+				bc.snapsRebuilt = true
+				bc.snaps.Rebuild(root)
+			default:
+				log.Debug("State snapshot missing, falling back to tries", "root", root)
+			}
+		}
 	}
 	bc.futureBlocks.Remove(block.Hash())
 	return status, nil
@@ -1139,22 +1233,42 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 		} else {
 			parent = chain[i-1]
 		}
-		state, err := state.New(parent.Root(), bc.stateCache)
+		state, err := state.NewWithSnapshot(parent.Root(), bc.stateCache, bc.snaps)
 		if err != nil {
 			return i, events, coalescedLogs, err
 		}
+		// If we have a followup block, run that against the current state to pre-cache
+		// transactions and probabilistically some of the account/storage trie nodes.
+		// The tracers of the configured VM must not see the throwaway execution.
+		var followupInterrupt uint32
+
+		if !bc.cacheConfig.NoPrefetch && i+1 < len(chain) && len(chain[i+1].Transactions()) > 0 {
+			throwaway := state.Copy()
+			go func(start time.Time, followup *types.Block, interrupt *uint32) {
+				bc.prefetcher.Prefetch(followup, throwaway, vm.Config{}, interrupt)
+
+				blockPrefetchExecuteTimer.UpdateSince(start)
+				if atomic.LoadUint32(interrupt) == 1 {
+					blockPrefetchInterruptMeter.Mark(1)
+				}
+			}(time.Now(), chain[i+1], &followupInterrupt)
+		}
 		// Process block using the parent state as reference point.
 		receipts, logs, usedGas, err := bc.processor.Process(block, state, bc.vmConfig)
 		if err != nil {
 			bc.reportBlock(block, receipts, err)
+			atomic.StoreUint32(&followupInterrupt, 1)
 			return i, events, coalescedLogs, err
 		}
 		// Validate the state using the default validator
 		err = bc.Validator().ValidateState(block, parent, state, receipts, usedGas)
 		if err != nil {
 			bc.reportBlock(block, receipts, err)
+			atomic.StoreUint32(&followupInterrupt, 1)
 			return i, events, coalescedLogs, err
 		}
+		// The real processing caught up with the prefetcher, stop it
+		atomic.StoreUint32(&followupInterrupt, 1)
 		proctime := time.Since(bstart)
 
 		// Write the block to the chain and get the status.
@@ -1310,6 +1424,17 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 			return fmt.Errorf("Invalid new chain")
 		}
 	}
+	// Never rewind the chain below the last block finalized by the consensus
+	if finality, ok := bc.engine.(consensus.Finality); ok && len(oldChain) > 0 {
+		finalized, err := finality.Finalized(bc, oldChain[0].Header())
+		if err != nil {
+			return err
+		}
+		if commonBlock.NumberU64() < finalized {
+			log.Warn("Rejected reorg below finalized block", "number", commonBlock.Number(), "hash", commonBlock.Hash(), "finalized", finalized)
+			return ErrReorgFinalized
+		}
+	}
 	// Ensure the user sees large reorgs
 	if len(oldChain) > 0 && len(newChain) > 0 {
 		logFn := log.Debug
@@ -1348,11 +1473,62 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 				bc.chainSideFeed.Send(ChainSideEvent{Block: block})
 			}
 		}()
+		// Announce the reorg itself, ordering the hashes from the common ancestor
+		ev := ChainReorgEvent{
+			CommonHash:   commonBlock.Hash(),
+			CommonNumber: commonBlock.NumberU64(),
+			OldChain:     make([]common.Hash, len(oldChain)),
+			NewChain:     make([]common.Hash, len(newChain)),
+			Depth:        uint64(len(oldChain)),
+		}
+		for i, block := range oldChain {
+			ev.OldChain[len(oldChain)-1-i] = block.Hash()
+		}
+		for i, block := range newChain {
+			ev.NewChain[len(newChain)-1-i] = block.Hash()
+		}
+		bc.queueReorg(ev)
 	}
 
 	return nil
 }
 
+// queueReorg schedules a reorg event for delivery. The events are delivered by a
+// single dispatcher in the order of the reorgs, without holding up the chain on
+// slow subscribers.
+func (bc *BlockChain) queueReorg(ev ChainReorgEvent) {
+	bc.reorgLock.Lock()
+	bc.reorgQueue = append(bc.reorgQueue, ev)
+	bc.reorgLock.Unlock()
+
+	select {
+	case bc.reorgWake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchReorgs delivers the queued reorg events to the subscribers, one at a
+// time and in order.
+func (bc *BlockChain) dispatchReorgs() {
+	defer bc.wg.Done()
+
+	for {
+		select {
+		case <-bc.reorgWake:
+			bc.reorgLock.Lock()
+			queue := bc.reorgQueue
+			bc.reorgQueue = nil
+			bc.reorgLock.Unlock()
+
+			for _, ev := range queue {
+				bc.reorgFeed.Send(ev)
+			}
+		case <-bc.quit:
+			return
+		}
+	}
+}
+
 // PostChainEvents iterates over the events generated by a chain insertion and
 // posts them into the event feed.
 // TODO: Should not expose PostChainEvents. The chain events should be posted in WriteBlock.
@@ -1558,6 +1734,11 @@ func (bc *BlockChain) SubscribeChainSideEvent(ch chan<- ChainSideEvent) event.Su
 	return bc.scope.Track(bc.chainSideFeed.Subscribe(ch))
 }
 
+// SubscribeChainReorgEvent registers a subscription of ChainReorgEvent.
+func (bc *BlockChain) SubscribeChainReorgEvent(ch chan<- ChainReorgEvent) event.Subscription {
+	return bc.scope.Track(bc.reorgFeed.Subscribe(ch))
+}
+
 // SubscribeLogsEvent registers a subscription of []*types.Log.
 func (bc *BlockChain) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
 	return bc.scope.Track(bc.logsFeed.Subscribe(ch))
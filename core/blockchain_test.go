@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
 	"github.com/LemoFoundationLtd/lemochain-go/core/state"
 	"github.com/LemoFoundationLtd/lemochain-go/core/types"
@@ -351,6 +352,82 @@ func testReorgShort(t *testing.T, full bool) {
 	testReorg(t, easy, diff, 12615120, full)
 }
 
+// finalizingEngine is a consensus engine reporting a fixed block as finalized.
+type finalizingEngine struct {
+	consensus.Engine
+	finalized uint64
+}
+
+func (e *finalizingEngine) Finalized(chain consensus.ChainReader, header *types.Header) (uint64, error) {
+	return e.finalized, nil
+}
+
+// Tests that a heavier chain doesn't reorganise the chain below the last block
+// finalized by the consensus engine, but is kept as a side chain. The two chains
+// below share their first two blocks and fork at the third, which is finalized
+// on the easy chain.
+func TestReorgBelowFinalizedHeaders(t *testing.T) { testReorgBelowFinalized(t, false) }
+func TestReorgBelowFinalizedBlocks(t *testing.T)  { testReorgBelowFinalized(t, true) }
+
+func testReorgBelowFinalized(t *testing.T, full bool) {
+	engine := &finalizingEngine{Engine: lemohash.NewFaker(), finalized: 3}
+
+	db, _ := lemodb.NewMemDatabase()
+	genesis := new(Genesis).MustCommit(db)
+	blockchain, _ := NewBlockChain(db, nil, params.AllLemohashProtocolChanges, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	easyBlocks, _ := GenerateChain(params.TestChainConfig, genesis, lemohash.NewFaker(), db, 3, func(i int, b *BlockGen) {
+		b.OffsetTime([]int64{0, 0, -9}[i])
+	})
+	diffBlocks, _ := GenerateChain(params.TestChainConfig, genesis, lemohash.NewFaker(), db, 5, func(i int, b *BlockGen) {
+		b.OffsetTime([]int64{0, 0, 0, -9, 0}[i])
+	})
+	insert := func(blocks []*types.Block) error {
+		if full {
+			_, err := blockchain.InsertChain(blocks)
+			return err
+		}
+		headers := make([]*types.Header, len(blocks))
+		for i, block := range blocks {
+			headers[i] = block.Header()
+		}
+		_, err := blockchain.InsertHeaderChain(headers, 1)
+		return err
+	}
+	head := func() common.Hash {
+		if full {
+			return blockchain.CurrentBlock().Hash()
+		}
+		return blockchain.CurrentHeader().Hash()
+	}
+	if err := insert(easyBlocks); err != nil {
+		t.Fatalf("failed to insert easy chain: %v", err)
+	}
+	if err := insert(diffBlocks[:4]); err != nil {
+		t.Fatalf("failed to insert difficult chain: %v", err)
+	}
+	if hash := head(); hash != easyBlocks[len(easyBlocks)-1].Hash() {
+		t.Fatalf("head mismatch: have [%x…], want [%x…]", hash.Bytes()[:4], easyBlocks[len(easyBlocks)-1].Hash().Bytes()[:4])
+	}
+	for _, block := range diffBlocks[:4] {
+		if full && !blockchain.HasBlock(block.Hash(), block.NumberU64()) {
+			t.Fatalf("side block #%d missing", block.NumberU64())
+		}
+		if !full && !blockchain.HasHeader(block.Hash(), block.NumberU64()) {
+			t.Fatalf("side header #%d missing", block.NumberU64())
+		}
+	}
+	// Once the finality allows it, the heavier chain should be adopted
+	engine.finalized = 0
+	if err := insert(diffBlocks[4:]); err != nil {
+		t.Fatalf("failed to extend difficult chain: %v", err)
+	}
+	if hash := head(); hash != diffBlocks[len(diffBlocks)-1].Hash() {
+		t.Fatalf("head mismatch: have [%x…], want [%x…]", hash.Bytes()[:4], diffBlocks[len(diffBlocks)-1].Hash().Bytes()[:4])
+	}
+}
+
 func testReorg(t *testing.T, first, second []int64, td int64, full bool) {
 	// Create a pristine chain and database
 	db, blockchain, err := newCanonical(lemohash.NewFaker(), 0, full)
@@ -978,6 +1055,110 @@ done:
 
 }
 
+// Tests that a reorg fires a single ChainReorgEvent describing the dropped and
+// the newly canonical blocks in ascending order.
+func TestReorgChainReorgEvent(t *testing.T) {
+	var (
+		db, _   = lemodb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), db, 5, func(i int, gen *BlockGen) {})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// Fork off from block #2 with an equally long, but heavier chain
+	fork, _ := GenerateChain(gspec.Config, chain[1], lemohash.NewFaker(), db, 3, func(i int, gen *BlockGen) {
+		gen.OffsetTime(-9)
+	})
+	reorgCh := make(chan ChainReorgEvent, 4)
+	sub := blockchain.SubscribeChainReorgEvent(reorgCh)
+	defer sub.Unsubscribe()
+
+	if _, err := blockchain.InsertChain(fork); err != nil {
+		t.Fatalf("failed to insert fork: %v", err)
+	}
+	select {
+	case ev := <-reorgCh:
+		if ev.CommonHash != chain[1].Hash() || ev.CommonNumber != 2 {
+			t.Errorf("common ancestor mismatch: have #%d [%x], want #2 [%x]", ev.CommonNumber, ev.CommonHash, chain[1].Hash())
+		}
+		if ev.Depth != 3 {
+			t.Errorf("reorg depth mismatch: have %d, want 3", ev.Depth)
+		}
+		if len(ev.OldChain) != 3 {
+			t.Fatalf("dropped block count mismatch: have %d, want 3", len(ev.OldChain))
+		}
+		for i, hash := range ev.OldChain {
+			if want := chain[2+i].Hash(); hash != want {
+				t.Errorf("dropped block %d: hash mismatch: have %x, want %x", i, hash, want)
+			}
+		}
+		if len(ev.NewChain) != 3 {
+			t.Fatalf("new block count mismatch: have %d, want 3", len(ev.NewChain))
+		}
+		for i, hash := range ev.NewChain {
+			if want := fork[i].Hash(); hash != want {
+				t.Errorf("new block %d: hash mismatch: have %x, want %x", i, hash, want)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reorg event not fired")
+	}
+	select {
+	case ev := <-reorgCh:
+		t.Errorf("unexpected reorg event fired: %+v", ev)
+	case <-time.After(250 * time.Millisecond):
+	}
+}
+
+// Tests that back-to-back reorgs are delivered to a slow subscriber in the order
+// they happened.
+func TestChainReorgEventOrder(t *testing.T) {
+	var (
+		db, _   = lemodb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		genesis = gspec.MustCommit(db)
+	)
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	defer blockchain.Stop()
+
+	chain, _ := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), db, 2, func(i int, gen *BlockGen) {})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	reorgCh := make(chan ChainReorgEvent)
+	sub := blockchain.SubscribeChainReorgEvent(reorgCh)
+	defer sub.Unsubscribe()
+
+	// Repeatedly replace the head block with a longer fork, without reading the events
+	var forks []common.Hash
+	for i := 0; i < 16; i++ {
+		head := blockchain.CurrentBlock()
+		parent := blockchain.GetBlock(head.ParentHash(), head.NumberU64()-1)
+		fork, _ := GenerateChain(gspec.Config, parent, lemohash.NewFaker(), db, 2, func(j int, gen *BlockGen) {
+			gen.SetCoinbase(common.Address{byte(i + 1)})
+		})
+		if _, err := blockchain.InsertChain(fork); err != nil {
+			t.Fatalf("fork %d: failed to insert: %v", i, err)
+		}
+		forks = append(forks, fork[0].Hash())
+	}
+	for i, want := range forks {
+		select {
+		case ev := <-reorgCh:
+			if have := ev.NewChain[0]; have != want {
+				t.Fatalf("reorg %d: first new block mismatch: have %x, want %x", i, have, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("reorg %d: event not fired", i)
+		}
+	}
+}
+
 // Tests if the canonical block can be fetched from the database during chain insertion.
 func TestCanonicalBlockRetrieval(t *testing.T) {
 	_, blockchain, err := newCanonical(lemohash.NewFaker(), 0, true)
@@ -1338,3 +1519,70 @@ func TestLargeReorgTrieGC(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the snapshot diff layers are journalled on shutdown and restored on
+// restart, instead of regenerating the snapshot.
+func TestSnapshotJournalRestart(t *testing.T) {
+	engine := lemohash.NewFaker()
+
+	db, _ := lemodb.NewMemDatabase()
+	genesis := new(Genesis).MustCommit(db)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 8, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
+
+	chain, err := NewBlockChain(db, nil, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	chain, err = NewBlockChain(db, nil, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to recreate tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	for i, block := range blocks {
+		if chain.snaps.Snapshot(block.Root()) == nil {
+			t.Errorf("block %d: snapshot layer not restored", i)
+		}
+	}
+}
+
+// Tests that a reorg deeper than the snapshot disk layer regenerates the
+// snapshot only once, covering the new head.
+func TestSnapshotDeepReorg(t *testing.T) {
+	engine := lemohash.NewFaker()
+
+	db, _ := lemodb.NewMemDatabase()
+	genesis := new(Genesis).MustCommit(db)
+	original, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*triesInMemory, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
+	competitor, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*triesInMemory+1, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{2}) })
+
+	diskdb, _ := lemodb.NewMemDatabase()
+	new(Genesis).MustCommit(diskdb)
+
+	chain, err := NewBlockChain(diskdb, nil, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(original); err != nil {
+		t.Fatalf("failed to insert original chain: %v", err)
+	}
+	if chain.snapsRebuilt {
+		t.Fatalf("snapshot regenerated without a reorg")
+	}
+	if _, err := chain.InsertChain(competitor); err != nil {
+		t.Fatalf("failed to insert competitor chain: %v", err)
+	}
+	if !chain.snapsRebuilt {
+		t.Errorf("snapshot not regenerated after deep reorg")
+	}
+	if chain.snaps.Snapshot(chain.CurrentBlock().Root()) == nil {
+		t.Errorf("new head not covered by the snapshot")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+)
+
+const (
+	// DefaultFreezerThreshold is the number of blocks behind the chain head after
+	// which chain data is considered immutable and moved into the ancient store.
+	DefaultFreezerThreshold = 90000
+
+	// freezerRecheckInterval is the frequency to check the key-value database for
+	// chain progression that might permit new blocks to be frozen.
+	freezerRecheckInterval = time.Minute
+
+	// freezerBatchLimit is the maximum number of blocks to freeze in one batch
+	// before yielding the chain lock to block imports.
+	freezerBatchLimit = 2048
+)
+
+// freeze is a background thread that periodically checks the blockchain for any
+// import progress and moves ancient data from the key-value store into the
+// ancient store attached to the chain database.
+func (bc *BlockChain) freeze() {
+	defer bc.wg.Done()
+
+	ancients, ok := bc.db.(lemodb.AncientStore)
+	if !ok {
+		return
+	}
+	if _, err := ancients.Ancients(); err != nil {
+		return // No ancient store attached to the database
+	}
+	for {
+		frozen, err := bc.freezeChain(ancients)
+		if err != nil {
+			log.Error("Failed to freeze chain segment", "err", err)
+		}
+		// If a full batch was moved, continue right away, otherwise wait a bit
+		if err == nil && frozen == freezerBatchLimit {
+			select {
+			case <-bc.quit:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-bc.quit:
+			return
+		case <-time.After(freezerRecheckInterval):
+		}
+	}
+}
+
+// freezeChain moves a batch of canonical blocks older than the freezer threshold
+// out of the key-value store and into the ancient store, returning the number of
+// blocks frozen.
+//
+// If history pruning is enabled, only blocks below the history tail are frozen,
+// without their bodies and receipts, as the append-only ancient store can't have
+// its history pruned afterwards.
+//
+// Note, side chain blocks at frozen heights are left in the key-value store.
+func (bc *BlockChain) freezeChain(ancients lemodb.AncientStore) (int, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	threshold := bc.cacheConfig.FreezerThreshold
+	if threshold == 0 {
+		threshold = DefaultFreezerThreshold
+	}
+	head := bc.CurrentBlock().NumberU64()
+	if head <= threshold {
+		return 0, nil
+	}
+	frozen, err := ancients.Ancients()
+	if err != nil {
+		return 0, err
+	}
+	limit := head - threshold
+	if bc.cacheConfig.HistoryRetain > 0 && limit > bc.HistoryTail() {
+		limit = bc.HistoryTail()
+	}
+	if frozen >= limit {
+		return 0, nil
+	}
+	if limit-frozen > freezerBatchLimit {
+		limit = frozen + freezerBatchLimit
+	}
+	// Append all the canonical blocks in the range to the ancient store
+	var (
+		start  = time.Now()
+		hashes []common.Hash
+	)
+	for number := frozen; number < limit; number++ {
+		if atomic.LoadInt32(&bc.procInterrupt) == 1 {
+			break
+		}
+		hash := GetCanonicalHash(bc.db, number)
+		if hash == (common.Hash{}) {
+			return len(hashes), fmt.Errorf("canonical hash missing, can't freeze block %d", number)
+		}
+		header := GetHeaderRLP(bc.db, hash, number)
+		if len(header) == 0 {
+			return len(hashes), fmt.Errorf("block header missing, can't freeze block %d", number)
+		}
+		// The history of pruned blocks is frozen empty
+		body := GetBodyRLP(bc.db, hash, number)
+		if len(body) == 0 && !bc.HistoryPruned(number) {
+			return len(hashes), fmt.Errorf("block body missing, can't freeze block %d", number)
+		}
+		receipts := GetBlockReceiptsRLP(bc.db, hash, number)
+		if len(receipts) == 0 && !bc.HistoryPruned(number) {
+			return len(hashes), fmt.Errorf("block receipts missing, can't freeze block %d", number)
+		}
+		td, _ := bc.db.Get(headerTdKey(hash, number))
+		if len(td) == 0 {
+			return len(hashes), fmt.Errorf("total difficulty missing, can't freeze block %d", number)
+		}
+		if err := ancients.AppendAncient(number, hash.Bytes(), header, body, receipts, td); err != nil {
+			return len(hashes), err
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) == 0 {
+		return 0, nil
+	}
+	if err := ancients.Sync(); err != nil {
+		return 0, err
+	}
+	// Wipe out all the frozen data from the key-value store, keeping the genesis
+	// block around for tools reading the database directly.
+	for i, hash := range hashes {
+		if number := frozen + uint64(i); number > 0 {
+			DeleteFrozenBlock(bc.db, hash, number)
+		}
+	}
+	log.Info("Moved chain segment into ancient store", "blocks", len(hashes), "number", frozen+uint64(len(hashes))-1,
+		"hash", hashes[len(hashes)-1], "elapsed", common.PrettyDuration(time.Since(start)))
+
+	return len(hashes), nil
+}
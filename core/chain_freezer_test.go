@@ -0,0 +1,123 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+// Tests that canonical blocks older than the freezer threshold are moved into
+// the ancient store and that they can still be retrieved transparently.
+func TestChainFreezer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chain-freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := lemodb.NewLDBDatabaseWithFreezer(filepath.Join(dir, "chaindata"), 0, 0, filepath.Join(dir, "ancient"), "")
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainId)
+	)
+	gendb, _ := lemodb.NewMemDatabase()
+	gspec.MustCommit(gendb)
+	blocks, _ := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), gendb, 64, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x01}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	cacheConfig := &CacheConfig{TrieNodeLimit: 256, TrieTimeLimit: 5 * time.Minute, FreezerThreshold: 16}
+	chain, err := NewBlockChain(db, cacheConfig, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	if _, err := chain.freezeChain(db); err != nil {
+		t.Fatalf("failed to freeze chain: %v", err)
+	}
+	if frozen := GetAncientCount(db); frozen != 64-16 {
+		t.Fatalf("frozen block count mismatch: have %d, want %d", frozen, 64-16)
+	}
+	chain.blockCache.Purge()
+	chain.bodyCache.Purge()
+
+	for _, block := range blocks {
+		number, hash := block.NumberU64(), block.Hash()
+
+		if have := chain.GetBlockByNumber(number); have == nil || have.Hash() != hash {
+			t.Fatalf("block #%d: canonical block mismatch", number)
+		}
+		if receipts := chain.GetReceiptsByHash(hash); len(receipts) != 1 || receipts[0].TxHash != block.Transactions()[0].Hash() {
+			t.Fatalf("block #%d: receipts mismatch: %v", number, receipts)
+		}
+		if td := chain.GetTd(hash, number); td == nil {
+			t.Fatalf("block #%d: total difficulty missing", number)
+		}
+		if !chain.HasBlock(hash, number) {
+			t.Fatalf("block #%d: block reported missing", number)
+		}
+		if tx, _, _, _ := GetTransaction(db, block.Transactions()[0].Hash()); tx == nil {
+			t.Fatalf("block #%d: transaction lookup failed", number)
+		}
+		// Frozen blocks must be gone from the key-value store
+		frozen := number < 64-16
+		if has, _ := db.Has(headerKey(hash, number)); has == frozen {
+			t.Fatalf("block #%d: key-value header presence mismatch: have %v, want %v", number, has, !frozen)
+		}
+	}
+	// Rewinding below the frozen limit must truncate the ancient store
+	if err := chain.SetHead(20); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if frozen := GetAncientCount(db); frozen != 21 {
+		t.Fatalf("frozen block count mismatch after rewind: have %d, want %d", frozen, 21)
+	}
+	if head := chain.CurrentBlock(); head.NumberU64() != 20 || head.Hash() != blocks[19].Hash() {
+		t.Fatalf("head block mismatch after rewind: have #%d [%x]", head.NumberU64(), head.Hash())
+	}
+}
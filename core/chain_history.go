@@ -0,0 +1,161 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+)
+
+const (
+	// minHistoryRetain is the minimum number of recent blocks whose history is
+	// retained, so that chain reorganisations always find the bodies they need.
+	minHistoryRetain = triesInMemory
+
+	// historyRecheckInterval is the frequency to check the chain for progression
+	// that might permit more history to be pruned.
+	historyRecheckInterval = time.Minute
+
+	// historyBatchLimit is the maximum number of blocks to prune in one batch
+	// before yielding the chain lock to block imports.
+	historyBatchLimit = 2048
+)
+
+// HistoryTail returns the number of the first block whose body, receipts and
+// transaction lookup entries are retained. The history of older blocks may have
+// been pruned.
+func (bc *BlockChain) HistoryTail() uint64 {
+	return atomic.LoadUint64(&bc.historyTail)
+}
+
+// HistoryPruned reports whether the history of the block with the given number
+// is older than the retained window and thus might have been deleted.
+func (bc *BlockChain) HistoryPruned(number uint64) bool {
+	return number > 0 && number < bc.HistoryTail()
+}
+
+// checkHistoryRetain disables history pruning if the ancient store contains the
+// history of blocks above the history tail, e.g. if pruning was enabled on a
+// database frozen without it. Such history can't be removed from the append-only
+// ancient store, so the tail couldn't move past it.
+func (bc *BlockChain) checkHistoryRetain() {
+	if bc.cacheConfig.HistoryRetain == 0 {
+		return
+	}
+	tail := bc.HistoryTail()
+	if tail == 0 {
+		tail = 1
+	}
+	if frozen := GetAncientCount(bc.db); frozen > tail {
+		log.Warn("Disabling history pruning, unpruned history already frozen", "frozen", frozen, "tail", tail)
+		bc.cacheConfig.HistoryRetain = 0
+	}
+}
+
+// pruneHistory is a background thread that periodically checks the blockchain
+// for any import progress and deletes the history falling out of the retained
+// window.
+func (bc *BlockChain) pruneHistory() {
+	defer bc.wg.Done()
+
+	for {
+		pruned, err := bc.pruneHistoryChain()
+		if err != nil {
+			log.Error("Failed to prune chain history", "err", err)
+		}
+		// If a full batch was pruned, continue right away, otherwise wait a bit
+		if err == nil && pruned == historyBatchLimit {
+			select {
+			case <-bc.quit:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-bc.quit:
+			return
+		case <-time.After(historyRecheckInterval):
+		}
+	}
+}
+
+// pruneHistoryChain deletes the bodies, receipts and transaction lookup entries
+// of a batch of canonical blocks older than the retained window, returning the
+// number of blocks pruned. Headers, total difficulties and canonical hashes are
+// retained. The genesis block is never pruned.
+//
+// Note, the history of side chain blocks at pruned heights is left in the database.
+func (bc *BlockChain) pruneHistoryChain() (int, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if bc.cacheConfig.HistoryRetain == 0 {
+		return 0, nil
+	}
+	head := bc.CurrentBlock().NumberU64()
+	if head <= bc.cacheConfig.HistoryRetain {
+		return 0, nil
+	}
+	tail := bc.HistoryTail()
+	if tail == 0 {
+		tail = 1
+	}
+	limit := head - bc.cacheConfig.HistoryRetain
+	if tail >= limit {
+		return 0, nil
+	}
+	if limit-tail > historyBatchLimit {
+		limit = tail + historyBatchLimit
+	}
+	var (
+		start = time.Now()
+		batch = bc.db.NewBatch()
+		txs   int
+	)
+	for number := tail; number < limit; number++ {
+		hash := GetCanonicalHash(bc.db, number)
+		if hash == (common.Hash{}) {
+			return 0, fmt.Errorf("canonical hash missing, can't prune block %d", number)
+		}
+		if body := GetBody(bc.db, hash, number); body != nil {
+			for _, tx := range body.Transactions {
+				DeleteTxLookupEntry(batch, tx.Hash())
+			}
+			txs += len(body.Transactions)
+		}
+		DeleteBody(batch, hash, number)
+		DeleteBlockReceipts(batch, hash, number)
+	}
+	WriteHistoryTail(batch, limit)
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	atomic.StoreUint64(&bc.historyTail, limit)
+
+	// Drop the cached blocks, they might contain pruned bodies
+	bc.bodyCache.Purge()
+	bc.bodyRLPCache.Purge()
+	bc.blockCache.Purge()
+
+	log.Info("Pruned chain history", "blocks", limit-tail, "txs", txs, "tail", limit, "elapsed", common.PrettyDuration(time.Since(start)))
+	return int(limit - tail), nil
+}
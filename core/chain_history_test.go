@@ -0,0 +1,194 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+// Tests that the bodies, receipts and transaction lookups of canonical blocks
+// older than the retained window are pruned while their headers are kept, and
+// that pruned blocks can still be moved into the ancient store.
+func TestChainHistoryPruning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chain-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := lemodb.NewLDBDatabaseWithFreezer(filepath.Join(dir, "chaindata"), 0, 0, filepath.Join(dir, "ancient"), "")
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainId)
+	)
+	gendb, _ := lemodb.NewMemDatabase()
+	gspec.MustCommit(gendb)
+	blocks, _ := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), gendb, 200, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x01}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	cacheConfig := &CacheConfig{TrieNodeLimit: 256, TrieTimeLimit: 5 * time.Minute, FreezerThreshold: 16, HistoryRetain: 150}
+	chain, err := NewBlockChain(db, cacheConfig, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	// Blocks must not be frozen before their history is pruned
+	if frozen, err := chain.freezeChain(db); err != nil || frozen != 0 {
+		t.Fatalf("frozen unpruned block count mismatch: have %d, %v, want 0", frozen, err)
+	}
+	if pruned, err := chain.pruneHistoryChain(); err != nil || pruned != 49 {
+		t.Fatalf("pruned block count mismatch: have %d, %v, want 49", pruned, err)
+	}
+	if tail := chain.HistoryTail(); tail != 50 {
+		t.Fatalf("history tail mismatch: have %d, want 50", tail)
+	}
+	// Pruned blocks must also be frozen without their history, but the freezer
+	// must stop at the history tail regardless of its threshold
+	if _, err := chain.freezeChain(db); err != nil {
+		t.Fatalf("failed to freeze chain: %v", err)
+	}
+	if frozen := GetAncientCount(db); frozen != 50 {
+		t.Fatalf("frozen block count mismatch: have %d, want %d", frozen, 50)
+	}
+	for number := uint64(1); number < 50; number++ {
+		if body, _ := db.Ancient("bodies", number); len(body) != 0 {
+			t.Fatalf("block #%d: pruned body frozen", number)
+		}
+	}
+	checkHistory := func(chain *BlockChain) {
+		for _, block := range blocks {
+			number, hash := block.NumberU64(), block.Hash()
+			pruned := number < 50
+
+			if chain.HistoryPruned(number) != pruned {
+				t.Fatalf("block #%d: pruned status mismatch: have %v, want %v", number, !pruned, pruned)
+			}
+			if header := chain.GetHeaderByNumber(number); header == nil || header.Hash() != hash {
+				t.Fatalf("block #%d: canonical header mismatch", number)
+			}
+			if have := chain.GetBlockByNumber(number); (have == nil) != pruned {
+				t.Fatalf("block #%d: block presence mismatch: have %v, want %v", number, have != nil, !pruned)
+			}
+			if receipts := chain.GetReceiptsByHash(hash); (receipts == nil) != pruned {
+				t.Fatalf("block #%d: receipts presence mismatch: have %v, want %v", number, receipts != nil, !pruned)
+			}
+			if tx, _, _, _ := GetTransaction(db, block.Transactions()[0].Hash()); (tx == nil) != pruned {
+				t.Fatalf("block #%d: transaction lookup presence mismatch: have %v, want %v", number, tx != nil, !pruned)
+			}
+		}
+	}
+	checkHistory(chain)
+	chain.Stop()
+
+	// The history tail must survive restarts
+	chain, err = NewBlockChain(db, cacheConfig, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to reopen blockchain: %v", err)
+	}
+	defer chain.Stop()
+
+	if tail := chain.HistoryTail(); tail != 50 {
+		t.Fatalf("history tail mismatch after restart: have %d, want 50", tail)
+	}
+	checkHistory(chain)
+}
+
+// Tests that history pruning is disabled on databases whose ancient store already
+// contains history above the tail, as it can't be removed from there.
+func TestChainHistoryPruningFrozen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chain-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := lemodb.NewLDBDatabaseWithFreezer(filepath.Join(dir, "chaindata"), 0, 0, filepath.Join(dir, "ancient"), "")
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	gspec := &Genesis{Config: params.TestChainConfig}
+	genesis := gspec.MustCommit(db)
+
+	gendb, _ := lemodb.NewMemDatabase()
+	gspec.MustCommit(gendb)
+	blocks, _ := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), gendb, 200, nil)
+
+	// Freeze some blocks with their history before pruning is enabled
+	cacheConfig := &CacheConfig{TrieNodeLimit: 256, TrieTimeLimit: 5 * time.Minute, FreezerThreshold: 16}
+	chain, err := NewBlockChain(db, cacheConfig, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	if _, err := chain.freezeChain(db); err != nil {
+		t.Fatalf("failed to freeze chain: %v", err)
+	}
+	chain.Stop()
+
+	cacheConfig.HistoryRetain = 150
+	chain, err = NewBlockChain(db, cacheConfig, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to reopen blockchain: %v", err)
+	}
+	defer chain.Stop()
+
+	if pruned, err := chain.pruneHistoryChain(); err != nil || pruned != 0 {
+		t.Fatalf("pruned block count mismatch: have %d, %v, want 0", pruned, err)
+	}
+	for _, block := range blocks {
+		if chain.HistoryPruned(block.NumberU64()) {
+			t.Fatalf("block #%d: reported pruned", block.NumberU64())
+		}
+		if chain.GetBlockByNumber(block.NumberU64()) == nil {
+			t.Fatalf("block #%d: block missing", block.NumberU64())
+		}
+	}
+}
@@ -46,6 +46,14 @@ type ChainIndexerBackend interface {
 	Commit() error
 }
 
+// ChainIndexerRollbacker is an optional interface of the ChainIndexerBackends
+// which need to clean up the data of sections invalidated by a reorg.
+type ChainIndexerRollbacker interface {
+	// Rollback removes the index data of a section processed up until the given
+	// head, which is no longer part of the canonical chain.
+	Rollback(section uint64, head common.Hash) error
+}
+
 // ChainIndexerChain interface is used for connecting the indexer to a blockchain
 type ChainIndexerChain interface {
 	// CurrentHeader retrieves the latest locally known header.
@@ -411,6 +419,11 @@ func (c *ChainIndexer) setValidSections(sections uint64) {
 	// Remove any reorged sections, caching the valids in the mean time
 	for c.storedSections > sections {
 		c.storedSections--
+		if rollbacker, ok := c.backend.(ChainIndexerRollbacker); ok {
+			if err := rollbacker.Rollback(c.storedSections, c.SectionHead(c.storedSections)); err != nil {
+				c.log.Error("Section rollback failed", "section", c.storedSections, "err", err)
+			}
+		}
 		c.removeSectionHead(c.storedSections)
 	}
 	c.storedSections = sections // needed if new > old
@@ -0,0 +1,357 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+)
+
+const (
+	// verifySegmentSize is the number of consecutive blocks handed to a single
+	// verification worker at once.
+	verifySegmentSize = 64
+
+	// maxVerifyDiff is the maximum number of diverging accounts reported for a
+	// single block.
+	maxVerifyDiff = 64
+)
+
+// errVerifyInterrupted is returned if a chain verification is aborted.
+var errVerifyInterrupted = errors.New("verification interrupted")
+
+// VerifyFailure describes a block whose re-execution on top of its stored parent
+// state diverged from its stored header.
+type VerifyFailure struct {
+	Number uint64      // Number of the diverging block
+	Hash   common.Hash // Hash of the diverging block
+	Err    error       // First mismatch between the header and the re-execution
+
+	TxIndex int         // Index of the first diverging transaction, -1 if unknown
+	TxHash  common.Hash // Hash of the first diverging transaction
+
+	Diff []AccountDiff // Accounts differing between the re-executed and the stored state
+}
+
+// AccountDiff is an account differing between the re-executed and the stored
+// state of a block.
+type AccountDiff struct {
+	Hash    common.Hash     // Hash of the account address (state trie key)
+	Address *common.Address // Address of the account, nil if its preimage is unknown
+	Have    *state.Account  // Account in the re-executed state, nil if missing
+	Want    *state.Account  // Account in the stored state, nil if missing
+}
+
+// VerifyChainRange re-executes the canonical blocks between from and to (both
+// inclusive) on top of their stored parent states, comparing the gas used, the
+// receipt root and the state root of every block against its stored header.
+//
+// Every block only depends on the state of its parent, so the range is split up
+// into segments verified concurrently by the given number of workers. The blocks
+// failing verification are returned ordered by number. An error is returned if
+// a block or its parent state is missing, or if the quit channel is closed.
+func VerifyChainRange(bc *BlockChain, from, to uint64, threads int, quit <-chan struct{}) ([]*VerifyFailure, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid block range #%d-#%d", from, to)
+	}
+	if from == 0 {
+		from = 1 // The genesis block has nothing to re-execute
+	}
+	if from > to {
+		return nil, nil
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	var (
+		segments = make(chan uint64)
+		abort    = make(chan struct{})
+		done     = make(chan error, threads)
+
+		lock     sync.Mutex
+		failures []*VerifyFailure
+		verified uint64
+	)
+	for i := 0; i < threads; i++ {
+		go func() {
+			verifier := newChainVerifier(bc)
+			for start := range segments {
+				for number := start; number <= to && number-start < verifySegmentSize; number++ {
+					select {
+					case <-abort:
+						done <- nil
+						return
+					default:
+					}
+					failure, err := verifier.verify(number)
+					if err != nil {
+						done <- err
+						return
+					}
+					if failure != nil {
+						lock.Lock()
+						failures = append(failures, failure)
+						lock.Unlock()
+
+						log.Warn("Block failed verification", "number", failure.Number, "hash", failure.Hash, "err", failure.Err)
+					}
+					atomic.AddUint64(&verified, 1)
+				}
+			}
+			done <- nil
+		}()
+	}
+	// Feed the segments to the workers until done, failed or interrupted
+	var (
+		next    = from
+		closed  bool
+		running = threads
+		err     error
+
+		start  = time.Now()
+		report = time.NewTicker(8 * time.Second)
+	)
+	defer report.Stop()
+
+	for running > 0 {
+		var feed chan uint64
+		if next <= to && next >= from && err == nil {
+			feed = segments
+		} else if !closed {
+			close(segments)
+			closed = true
+		}
+		select {
+		case feed <- next:
+			next += verifySegmentSize
+
+		case werr := <-done:
+			running--
+			if werr != nil && err == nil {
+				err = werr
+				close(abort)
+			}
+		case <-quit:
+			if err == nil {
+				err = errVerifyInterrupted
+				close(abort)
+			}
+			quit = nil
+
+		case <-report.C:
+			lock.Lock()
+			failed := len(failures)
+			lock.Unlock()
+
+			log.Info("Verifying blocks", "verified", atomic.LoadUint64(&verified), "total", to-from+1, "failed", failed, "elapsed", common.PrettyDuration(time.Since(start)))
+		}
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Number < failures[j].Number })
+	return failures, err
+}
+
+// chainVerifier re-executes blocks on top of their stored parent states. The
+// verifiers are not safe for concurrent use, every worker needs its own.
+type chainVerifier struct {
+	bc        *BlockChain
+	processor *StateProcessor
+	database  state.Database // Private state database, never flushed to disk
+}
+
+// newChainVerifier creates a block verifier on top of the given chain.
+func newChainVerifier(bc *BlockChain) *chainVerifier {
+	return &chainVerifier{
+		bc:        bc,
+		processor: NewStateProcessor(bc.Config(), bc, bc.Engine()),
+		database:  state.NewDatabase(bc.db),
+	}
+}
+
+// verify re-executes a canonical block, returning the details of the divergence
+// if the results don't match the header, or nil if the block is valid.
+func (v *chainVerifier) verify(number uint64) (*VerifyFailure, error) {
+	block := v.bc.GetBlockByNumber(number)
+	if block == nil {
+		return nil, fmt.Errorf("missing block #%d", number)
+	}
+	parent := v.bc.GetBlock(block.ParentHash(), number-1)
+	if parent == nil {
+		return nil, fmt.Errorf("missing parent of block #%d [%x…]", number, block.Hash().Bytes()[:4])
+	}
+	statedb, err := state.New(parent.Root(), v.database)
+	if err != nil {
+		return nil, fmt.Errorf("missing parent state of block #%d [%x…]: %v", number, block.Hash().Bytes()[:4], err)
+	}
+	failure := &VerifyFailure{Number: number, Hash: block.Hash(), TxIndex: -1}
+
+	receipts, _, usedGas, err := v.processor.Process(block, statedb, vm.Config{})
+	if err != nil {
+		failure.Err = err
+		failure.setTx(block, statedb.TxIndex())
+		return failure, nil
+	}
+	header := block.Header()
+	deleteEmpty := v.bc.Config().IsEIP158(header.Number)
+
+	root := statedb.IntermediateRoot(deleteEmpty)
+	switch {
+	case usedGas != header.GasUsed:
+		failure.Err = fmt.Errorf("gas used mismatch (stored: %d local: %d)", header.GasUsed, usedGas)
+	case types.DeriveSha(receipts) != header.ReceiptHash:
+		failure.Err = fmt.Errorf("receipt root mismatch (stored: %x local: %x)", header.ReceiptHash, types.DeriveSha(receipts))
+	case root != header.Root:
+		failure.Err = fmt.Errorf("state root mismatch (stored: %x local: %x)", header.Root, root)
+	default:
+		return nil, nil
+	}
+	// Pinpoint the first transaction whose receipt diverges from the stored one
+	if stored := v.bc.GetReceiptsByHash(block.Hash()); stored != nil {
+		for i, receipt := range receipts {
+			if i >= len(stored) || !receiptEqual(receipt, stored[i]) {
+				failure.setTx(block, i)
+				break
+			}
+		}
+	}
+	if root != header.Root {
+		if failure.Diff, err = v.diff(statedb, deleteEmpty, header.Root); err != nil {
+			log.Warn("Failed to diff block state", "number", number, "hash", block.Hash(), "err", err)
+		}
+		// If the receipts matched, fall back to the first transaction touching
+		// a diverging account
+		if failure.TxIndex < 0 {
+			failure.setTx(block, firstTouchingTx(block, v.bc.Config(), failure.Diff))
+		}
+	}
+	return failure, nil
+}
+
+// diff commits the re-executed state into the private database and collects the
+// accounts differing from the stored state with the given root.
+func (v *chainVerifier) diff(statedb *state.StateDB, deleteEmpty bool, want common.Hash) ([]AccountDiff, error) {
+	have, err := statedb.Commit(deleteEmpty)
+	if err != nil {
+		return nil, err
+	}
+	haveTrie, err := v.database.OpenTrie(have)
+	if err != nil {
+		return nil, err
+	}
+	wantTrie, err := v.database.OpenTrie(want)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make(map[common.Hash]*AccountDiff)
+
+	// collect gathers the accounts present in b but not in a
+	collect := func(a, b state.Trie, local bool) error {
+		it, _ := trie.NewDifferenceIterator(a.NodeIterator(nil), b.NodeIterator(nil))
+		for it.Next(true) && len(diffs) < maxVerifyDiff {
+			if !it.Leaf() {
+				continue
+			}
+			account := new(state.Account)
+			if err := rlp.DecodeBytes(it.LeafBlob(), account); err != nil {
+				return err
+			}
+			hash := common.BytesToHash(it.LeafKey())
+			diff := diffs[hash]
+			if diff == nil {
+				diff = &AccountDiff{Hash: hash}
+				if preimage := b.GetKey(hash.Bytes()); preimage != nil {
+					address := common.BytesToAddress(preimage)
+					diff.Address = &address
+				}
+				diffs[hash] = diff
+			}
+			if local {
+				diff.Have = account
+			} else {
+				diff.Want = account
+			}
+		}
+		return it.Error()
+	}
+	if err := collect(wantTrie, haveTrie, true); err != nil {
+		return nil, err
+	}
+	if err := collect(haveTrie, wantTrie, false); err != nil {
+		return nil, err
+	}
+	result := make([]AccountDiff, 0, len(diffs))
+	for _, diff := range diffs {
+		result = append(result, *diff)
+	}
+	sort.Slice(result, func(i, j int) bool { return bytes.Compare(result[i].Hash[:], result[j].Hash[:]) < 0 })
+	return result, nil
+}
+
+// setTx records the transaction with the given index as the first diverging one,
+// if it exists.
+func (f *VerifyFailure) setTx(block *types.Block, index int) {
+	if txs := block.Transactions(); index >= 0 && index < len(txs) {
+		f.TxIndex, f.TxHash = index, txs[index].Hash()
+	}
+}
+
+// receiptEqual reports whether two receipts have the same consensus fields.
+func receiptEqual(a, b *types.Receipt) bool {
+	ablob, err := rlp.EncodeToBytes(a)
+	if err != nil {
+		return false
+	}
+	bblob, err := rlp.EncodeToBytes(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ablob, bblob)
+}
+
+// firstTouchingTx returns the index of the first transaction of the block sent
+// from or to one of the diverging accounts, or -1 if there's none.
+func firstTouchingTx(block *types.Block, config *params.ChainConfig, diffs []AccountDiff) int {
+	touched := make(map[common.Address]bool)
+	for _, diff := range diffs {
+		if diff.Address != nil && *diff.Address != block.Coinbase() {
+			touched[*diff.Address] = true
+		}
+	}
+	signer := types.MakeSigner(config, block.Number())
+	for i, tx := range block.Transactions() {
+		if to := tx.To(); to != nil && touched[*to] {
+			return i
+		}
+		if from, err := types.Sender(signer, tx); err == nil && touched[from] {
+			return i
+		}
+	}
+	return -1
+}
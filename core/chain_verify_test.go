@@ -0,0 +1,111 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+// Tests that re-executing a consistent archive chain passes verification, and
+// that a tampered block is reported with its first diverging transaction and
+// the diverging accounts.
+func TestVerifyChainRange(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender  = crypto.PubkeyToAddress(key.PublicKey)
+		target  = common.Address{0x02}
+		signer  = types.HomesteadSigner{}
+		db, _   = lemodb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{sender: {Balance: big.NewInt(1000000000000000000)}}}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), db, 150, func(i int, gen *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(sender), target, big.NewInt(1), params.TxGas, nil, nil), signer, key)
+		gen.AddTx(tx)
+	})
+	chain, _ := NewBlockChain(db, &CacheConfig{Disabled: true}, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	failures, err := VerifyChainRange(chain, 0, 150, 4, nil)
+	if err != nil {
+		t.Fatalf("failed to verify chain: %v", err)
+	}
+	if len(failures) != 0 {
+		t.Fatalf("pristine chain failed verification: %v", failures[0].Err)
+	}
+	chain.Stop()
+
+	// Swap the transaction of a block for one transferring a different amount
+	block := blocks[99]
+	tampered, _ := types.SignTx(types.NewTransaction(block.Transactions()[0].Nonce(), target, big.NewInt(2), params.TxGas, nil, nil), signer, key)
+	WriteBody(db, block.Hash(), block.NumberU64(), &types.Body{Transactions: types.Transactions{tampered}})
+
+	chain, _ = NewBlockChain(db, &CacheConfig{Disabled: true}, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	defer chain.Stop()
+
+	failures, err = VerifyChainRange(chain, 90, 110, 3, nil)
+	if err != nil {
+		t.Fatalf("failed to verify chain: %v", err)
+	}
+	if len(failures) != 1 {
+		t.Fatalf("failure count mismatch: have %d, want 1", len(failures))
+	}
+	failure := failures[0]
+	if failure.Number != 100 || failure.Hash != block.Hash() {
+		t.Errorf("failed block mismatch: have #%d [%x], want #100 [%x]", failure.Number, failure.Hash, block.Hash())
+	}
+	if failure.TxIndex != 0 || failure.TxHash != tampered.Hash() {
+		t.Errorf("failed transaction mismatch: have %d [%x], want 0 [%x]", failure.TxIndex, failure.TxHash, tampered.Hash())
+	}
+	if len(failure.Diff) != 2 {
+		t.Fatalf("diverging account count mismatch: have %d, want 2", len(failure.Diff))
+	}
+	for _, diff := range failure.Diff {
+		if diff.Have == nil || diff.Want == nil {
+			t.Fatalf("account %x: missing from one of the states", diff.Hash)
+		}
+		if diff.Address == nil {
+			t.Fatalf("account %x: missing preimage", diff.Hash)
+		}
+		switch *diff.Address {
+		case target:
+			if delta := new(big.Int).Sub(diff.Have.Balance, diff.Want.Balance); delta.Cmp(common.Big1) != 0 {
+				t.Errorf("target balance difference mismatch: have %v, want 1", delta)
+			}
+		case sender:
+			if delta := new(big.Int).Sub(diff.Want.Balance, diff.Have.Balance); delta.Cmp(common.Big1) != 0 {
+				t.Errorf("sender balance difference mismatch: have %v, want 1", delta)
+			}
+		default:
+			t.Errorf("unexpected diverging account %x", *diff.Address)
+		}
+	}
+	// Verifying a range with missing blocks should fail
+	if _, err := VerifyChainRange(chain, 140, 160, 2, nil); err == nil {
+		t.Errorf("verified range beyond the chain head")
+	}
+}
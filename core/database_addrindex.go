@@ -0,0 +1,117 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+)
+
+// AddressTxEntry is a posting of the address transaction index, referencing a
+// transaction which touched a given address.
+type AddressTxEntry struct {
+	BlockNumber uint64
+	Index       uint64
+	Hash        common.Hash
+}
+
+// addrTxKey = addrTxPrefix + address + section (uint64 big endian) + hash
+func addrTxKey(address common.Address, section uint64, head common.Hash) []byte {
+	return append(append(append(addrTxPrefix, address.Bytes()...), encodeBlockNumber(section)...), head.Bytes()...)
+}
+
+// addrSectionKey = addrSectionPrefix + section (uint64 big endian) + hash
+func addrSectionKey(section uint64, head common.Hash) []byte {
+	return append(append(addrSectionPrefix, encodeBlockNumber(section)...), head.Bytes()...)
+}
+
+// GetAddressTxEntries retrieves the transaction postings of an address within
+// an index section, ordered by block number and transaction index.
+func GetAddressTxEntries(db DatabaseReader, address common.Address, section uint64, head common.Hash) []AddressTxEntry {
+	data, _ := db.Get(addrTxKey(address, section, head))
+	if len(data) == 0 {
+		return nil
+	}
+	var entries []AddressTxEntry
+	if err := rlp.Decode(bytes.NewReader(data), &entries); err != nil {
+		log.Error("Invalid address transaction postings", "address", address, "section", section, "err", err)
+		return nil
+	}
+	return entries
+}
+
+// WriteAddressTxEntries stores the transaction postings of an address within an
+// index section.
+func WriteAddressTxEntries(db lemodb.Putter, address common.Address, section uint64, head common.Hash, entries []AddressTxEntry) error {
+	data, err := rlp.EncodeToBytes(entries)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(addrTxKey(address, section, head), data); err != nil {
+		log.Crit("Failed to store address transaction postings", "err", err)
+	}
+	return nil
+}
+
+// DeleteAddressTxEntries removes the transaction postings of an address within
+// an index section.
+func DeleteAddressTxEntries(db DatabaseDeleter, address common.Address, section uint64, head common.Hash) {
+	db.Delete(addrTxKey(address, section, head))
+}
+
+// GetAddressIndexSection retrieves the list of addresses which have transaction
+// postings within an index section.
+func GetAddressIndexSection(db DatabaseReader, section uint64, head common.Hash) []common.Address {
+	data, _ := db.Get(addrSectionKey(section, head))
+	if len(data) == 0 {
+		return nil
+	}
+	var addresses []common.Address
+	if err := rlp.Decode(bytes.NewReader(data), &addresses); err != nil {
+		log.Error("Invalid address index section", "section", section, "err", err)
+		return nil
+	}
+	return addresses
+}
+
+// WriteAddressIndexSection stores the list of addresses which have transaction
+// postings within an index section, needed to clean them up on reorgs.
+func WriteAddressIndexSection(db lemodb.Putter, section uint64, head common.Hash, addresses []common.Address) error {
+	data, err := rlp.EncodeToBytes(addresses)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(addrSectionKey(section, head), data); err != nil {
+		log.Crit("Failed to store address index section", "err", err)
+	}
+	return nil
+}
+
+// DeleteAddressIndexSection removes an index section, including the transaction
+// postings of all the addresses in it.
+func DeleteAddressIndexSection(db lemodb.Database, section uint64, head common.Hash) error {
+	batch := db.NewBatch()
+	for _, address := range GetAddressIndexSection(db, section, head) {
+		DeleteAddressTxEntries(batch, address, section, head)
+	}
+	batch.Delete(addrSectionKey(section, head))
+	return batch.Write()
+}
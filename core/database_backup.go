@@ -0,0 +1,223 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+)
+
+const (
+	// backupVersion is the version of the backup layout, bumped on every change
+	// that older releases can't restore.
+	backupVersion = 1
+
+	// backupMetaFile is the name of the backup file describing its content. It's
+	// written last, so its presence marks the backup complete.
+	backupMetaFile = "backup.json"
+)
+
+var (
+	// errBackupNotSupported is returned if a backup is requested from a database
+	// unable to take consistent snapshots.
+	errBackupNotSupported = errors.New("database doesn't support consistent snapshots")
+
+	// errBackupIncomplete is returned if a backup is restored without its metadata.
+	errBackupIncomplete = errors.New("incomplete backup: missing " + backupMetaFile)
+)
+
+// BackupMeta describes the chain contained in a database backup.
+type BackupMeta struct {
+	Version  uint64      `json:"version"`
+	Time     int64       `json:"time"`     // Unix time the snapshot was taken at
+	Head     common.Hash `json:"head"`     // Hash of the head block
+	Number   uint64      `json:"number"`   // Number of the head block
+	Root     common.Hash `json:"root"`     // State root of the head block
+	Entries  uint64      `json:"entries"`  // Number of key-value entries
+	Ancients uint64      `json:"ancients"` // Number of blocks in the ancient store
+	Size     uint64      `json:"size"`     // Total size of the backup files
+}
+
+// snapshotReader exposes a database snapshot along with the ancient store of its
+// database, so that frozen blocks can be resolved.
+type snapshotReader struct {
+	lemodb.Snapshot
+	lemodb.AncientReader
+}
+
+// BackupDatabase streams a consistent snapshot of a chain database not used by
+// a running chain, along with its ancient store, into a backup sink. The sink is
+// not closed. Live databases must be backed up through BlockChain.Backup, which
+// persists the head state first.
+func BackupDatabase(db lemodb.Database, sink lemodb.BackupSink) (*BackupMeta, error) {
+	snapper, ok := db.(lemodb.Snapshotter)
+	if !ok {
+		return nil, errBackupNotSupported
+	}
+	snap, err := snapper.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return backupSnapshot(db, snap, sink)
+}
+
+// Backup streams a consistent snapshot of the live chain database, along with
+// its ancient store, into a backup sink. Unless running as an archive node, the
+// state of recent blocks only lives in memory, so the state of the head block is
+// flushed to the database under the chain lock right before taking the snapshot.
+// The backup is thus self-consistent regardless of the blocks imported while it's
+// running. The sink is not closed.
+func (bc *BlockChain) Backup(sink lemodb.BackupSink) (*BackupMeta, error) {
+	snapper, ok := bc.db.(lemodb.Snapshotter)
+	if !ok {
+		return nil, errBackupNotSupported
+	}
+	bc.mu.Lock()
+	err := bc.stateCache.TrieDB().Commit(bc.CurrentBlock().Root(), false)
+	var snap lemodb.Snapshot
+	if err == nil {
+		snap, err = snapper.NewSnapshot()
+	}
+	bc.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	return backupSnapshot(bc.db, snap, sink)
+}
+
+// backupSnapshot streams a snapshot of a chain database, along with the ancient
+// store of the database, into a backup sink, releasing the snapshot afterwards.
+// The head markers are read from the snapshot, whose head state must be present.
+func backupSnapshot(db lemodb.Database, snap lemodb.Snapshot, sink lemodb.BackupSink) (*BackupMeta, error) {
+	defer snap.Release()
+
+	meta := &BackupMeta{Version: backupVersion, Time: time.Now().Unix()}
+
+	// Count the frozen blocks only after taking the snapshot, see WriteBackup
+	var (
+		reader   DatabaseReader = snap
+		ancients lemodb.AncientReader
+	)
+	if store, ok := db.(lemodb.AncientReader); ok {
+		if frozen, err := store.Ancients(); err == nil {
+			ancients, meta.Ancients = store, frozen
+			reader = &snapshotReader{snap, store}
+		}
+	}
+	meta.Head = GetHeadBlockHash(reader)
+	if meta.Head == (common.Hash{}) {
+		return nil, errors.New("no head block to back up")
+	}
+	meta.Number = GetBlockNumber(reader, meta.Head)
+	header := GetHeader(reader, meta.Head, meta.Number)
+	if header == nil {
+		return nil, fmt.Errorf("missing head block #%d [%x…]", meta.Number, meta.Head.Bytes()[:4])
+	}
+	meta.Root = header.Root
+	if has, _ := snap.Has(meta.Root.Bytes()); !has {
+		return nil, fmt.Errorf("missing state of head block #%d [%x…]", meta.Number, meta.Head.Bytes()[:4])
+	}
+
+	log.Info("Backing up chain database", "number", meta.Number, "hash", meta.Head, "ancients", meta.Ancients)
+	stats, err := lemodb.WriteBackup(sink, snap, ancients, meta.Ancients)
+	if err != nil {
+		return nil, err
+	}
+	meta.Entries, meta.Size = stats.Entries, stats.Bytes
+
+	blob, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := sink.WriteFile(backupMetaFile, blob); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// RestoreDatabase writes a backup into an empty chain database and its ancient
+// store, then verifies that the restored head block and its state root match the
+// backup metadata. The database must not be used if an error is returned.
+func RestoreDatabase(source lemodb.BackupSource, db lemodb.Database) (*BackupMeta, error) {
+	ancients, _ := db.(lemodb.AncientWriter)
+
+	extras, stats, err := lemodb.RestoreBackup(source, db, ancients)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := extras[backupMetaFile]
+	if !ok {
+		return nil, errBackupIncomplete
+	}
+	meta := new(BackupMeta)
+	if err := json.Unmarshal(blob, meta); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", backupMetaFile, err)
+	}
+	if meta.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d, want %d", meta.Version, backupVersion)
+	}
+	if stats.Entries != meta.Entries || stats.Ancients != meta.Ancients {
+		return nil, fmt.Errorf("backup content mismatch: have %d entries and %d ancients, want %d and %d",
+			stats.Entries, stats.Ancients, meta.Entries, meta.Ancients)
+	}
+	if err := VerifyBackup(db, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// VerifyBackup checks that the head block of a restored database is the one of
+// the backup, that its body matches its header and that its state root node is
+// present.
+func VerifyBackup(db lemodb.Database, meta *BackupMeta) error {
+	if head := GetHeadBlockHash(db); head != meta.Head {
+		return fmt.Errorf("head block mismatch: have %x, want %x", head, meta.Head)
+	}
+	block := GetBlock(db, meta.Head, meta.Number)
+	if block == nil {
+		return fmt.Errorf("missing head block #%d [%x…]", meta.Number, meta.Head.Bytes()[:4])
+	}
+	if hash := block.Hash(); hash != meta.Head {
+		return fmt.Errorf("head block hash mismatch: have %x, want %x", hash, meta.Head)
+	}
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return fmt.Errorf("head block transaction root mismatch: have %x, want %x", hash, block.TxHash())
+	}
+	if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+		return fmt.Errorf("head block uncle root mismatch: have %x, want %x", hash, block.UncleHash())
+	}
+	if block.Root() != meta.Root {
+		return fmt.Errorf("state root mismatch: have %x, want %x", block.Root(), meta.Root)
+	}
+	node, err := db.Get(meta.Root.Bytes())
+	if err != nil || len(node) == 0 {
+		return fmt.Errorf("missing state root %x", meta.Root)
+	}
+	if hash := crypto.Keccak256Hash(node); hash != meta.Root {
+		return fmt.Errorf("corrupted state root %x: hash %x", meta.Root, hash)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+// Tests that a live chain database can be backed up while blocks are imported,
+// and that the restored database is verified against the backed up head.
+func TestBackupRestoreDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := lemodb.NewLDBDatabase(filepath.Join(dir, "source"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Generate the chain into a separate database, so only the blocks imported by
+	// the (garbage collecting) chain below end up in the source database
+	var (
+		gendb, _ = lemodb.NewMemDatabase()
+		gspec    = &Genesis{Config: params.TestChainConfig}
+		genesis  = gspec.MustCommit(gendb)
+	)
+	gspec.MustCommit(db)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, lemohash.NewFaker(), gendb, 20, func(i int, b *BlockGen) {})
+
+	chain, _ := NewBlockChain(db, nil, params.TestChainConfig, lemohash.NewFaker(), vm.Config{})
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks[:10]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// The head state only lives in memory, so an offline backup must be rejected
+	offline, err := lemodb.CreateBackup(filepath.Join(dir, "offline"))
+	if err != nil {
+		t.Fatalf("failed to create backup: %v", err)
+	}
+	if _, err := BackupDatabase(db, offline); err == nil {
+		t.Fatalf("backed up database with missing head state")
+	}
+	offline.Close()
+	// Back up the database, importing more blocks in the meantime
+	path := filepath.Join(dir, "backup.tar.gz")
+	sink, err := lemodb.CreateBackup(path)
+	if err != nil {
+		t.Fatalf("failed to create backup: %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := chain.InsertChain(blocks[10:])
+		errc <- err
+	}()
+	meta, err := chain.Backup(sink)
+	if err != nil {
+		t.Fatalf("failed to back up database: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close backup: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if block := blocks[meta.Number-1]; meta.Head != block.Hash() || meta.Root != block.Root() {
+		t.Fatalf("backup head mismatch: have #%d [%x] root %x, want [%x] root %x", meta.Number, meta.Head, meta.Root, block.Hash(), block.Root())
+	}
+	// Restore the backup and check that it's verified
+	restored, err := lemodb.NewLDBDatabase(filepath.Join(dir, "restored"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer restored.Close()
+
+	source, err := lemodb.OpenBackup(path)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer source.Close()
+
+	have, err := RestoreDatabase(source, restored)
+	if err != nil {
+		t.Fatalf("failed to restore database: %v", err)
+	}
+	if *have != *meta {
+		t.Fatalf("restored metadata mismatch: have %+v, want %+v", have, meta)
+	}
+	if head := GetHeadBlockHash(restored); head != meta.Head {
+		t.Fatalf("restored head mismatch: have %x, want %x", head, meta.Head)
+	}
+	// Drop the state root, verification must fail
+	restored.Delete(meta.Root.Bytes())
+	if err := VerifyBackup(restored, meta); err == nil {
+		t.Fatalf("verified database with missing state root")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/compression/rle"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/golang/snappy"
+)
+
+// StorageCodec is the compression scheme of the block bodies and receipts stored
+// in the chain database. Compressed records are prefixed with the codec byte,
+// all of which are below the RLP list prefix (0xc0), so uncompressed records of
+// older databases are still read as raw RLP.
+type StorageCodec byte
+
+const (
+	CodecNone   StorageCodec = 0x00 // Records are stored as raw RLP, without prefix
+	CodecRLE    StorageCodec = 0x01 // Records are compressed with compression/rle
+	CodecSnappy StorageCodec = 0x02 // Records are compressed with snappy
+)
+
+// errUnknownCodec is returned if a stored record has an unknown codec prefix.
+var errUnknownCodec = errors.New("unknown storage codec")
+
+// ParseStorageCodec converts a codec name into a storage codec.
+func ParseStorageCodec(name string) (StorageCodec, error) {
+	switch name {
+	case "", "none":
+		return CodecNone, nil
+	case "rle":
+		return CodecRLE, nil
+	case "snappy":
+		return CodecSnappy, nil
+	default:
+		return CodecNone, fmt.Errorf("unknown storage codec %q (available: none, rle, snappy)", name)
+	}
+}
+
+func (c StorageCodec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecRLE:
+		return "rle"
+	case CodecSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+// encodeRecord compresses a raw RLP record with the given codec, prefixing it
+// with the codec byte. If compression doesn't save any space, the raw record is
+// returned instead.
+func encodeRecord(codec StorageCodec, blob []byte) []byte {
+	var compressed []byte
+	switch codec {
+	case CodecRLE:
+		compressed = rle.Compress(blob)
+	case CodecSnappy:
+		compressed = snappy.Encode(nil, blob)
+	default:
+		return blob
+	}
+	if len(compressed)+1 >= len(blob) {
+		return blob
+	}
+	return append([]byte{byte(codec)}, compressed...)
+}
+
+// decodeRecord returns the raw RLP content of a stored record, decompressing it
+// based on its codec prefix.
+func decodeRecord(blob []byte) ([]byte, error) {
+	if len(blob) == 0 || blob[0] >= 0xc0 {
+		return blob, nil
+	}
+	switch StorageCodec(blob[0]) {
+	case CodecRLE:
+		return rle.Decompress(blob[1:])
+	case CodecSnappy:
+		return snappy.Decode(nil, blob[1:])
+	default:
+		return nil, errUnknownCodec
+	}
+}
+
+// recordCodec returns the codec a stored record was written with.
+func recordCodec(blob []byte) StorageCodec {
+	if len(blob) == 0 || blob[0] >= 0xc0 {
+		return CodecNone
+	}
+	return StorageCodec(blob[0])
+}
+
+// RecompressChainData rewrites all the block bodies and receipts in the chain
+// database which were stored with a different codec than the configured one.
+// Records that don't shrink with the codec are stored raw and rewritten on every
+// run. Closing quit aborts the recompression.
+func (bc *BlockChain) RecompressChainData(quit <-chan struct{}) error {
+	return recompressChainData(bc.db, bc.cacheConfig.StorageCodec, &bc.mu, quit)
+}
+
+// recompressedRecord is a chain data record pending to be rewritten.
+type recompressedRecord struct {
+	key  []byte // Database key of the record
+	blob []byte // Stored content the record was recompressed from
+	data []byte // Recompressed content to overwrite the record with
+}
+
+// recompressChainData rewrites all the block bodies and receipts in the database
+// which were stored with a different codec than the given one. The records are
+// written in batches while holding lock, skipping any that were modified or
+// deleted (e.g. moved into the ancient store) since they were read.
+func recompressChainData(db lemodb.Database, codec StorageCodec, lock sync.Locker, quit <-chan struct{}) error {
+	var (
+		start  = time.Now()
+		logged = time.Now()
+
+		count   int
+		before  common.StorageSize
+		after   common.StorageSize
+		skipped int
+
+		pending []recompressedRecord
+		size    int
+	)
+	log.Info("Recompressing chain data", "codec", codec)
+
+	// flush writes out the pending records which are still unchanged in the
+	// database, atomically with regard to the chain data deleters.
+	flush := func() error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		batch := db.NewBatch()
+		for _, record := range pending {
+			if have, _ := db.Get(record.key); !bytes.Equal(have, record.blob) {
+				skipped++
+				continue
+			}
+			if err := batch.Put(record.key, record.data); err != nil {
+				return err
+			}
+			count++
+			before += common.StorageSize(len(record.blob))
+			after += common.StorageSize(len(record.data))
+		}
+		pending, size = pending[:0], 0
+		return batch.Write()
+	}
+	for _, prefix := range [][]byte{bodyPrefix, blockReceiptsPrefix} {
+		var (
+			length = len(prefix) + 8 + common.HashLength
+			it     = db.NewIteratorWithPrefix(prefix)
+		)
+		for it.Next() {
+			key, blob := it.Key(), it.Value()
+			if len(key) != length {
+				continue
+			}
+			if recordCodec(blob) == codec {
+				skipped++
+				continue
+			}
+			raw, err := decodeRecord(blob)
+			if err != nil {
+				log.Warn("Skipping undecodable chain data", "key", common.Bytes2Hex(key), "err", err)
+				continue
+			}
+			recoded := encodeRecord(codec, raw)
+			if recordCodec(recoded) == recordCodec(blob) {
+				skipped++
+				continue
+			}
+			pending = append(pending, recompressedRecord{key: common.CopyBytes(key), blob: common.CopyBytes(blob), data: recoded})
+			size += len(recoded)
+
+			if size >= lemodb.IdealBatchSize {
+				if err := flush(); err != nil {
+					it.Release()
+					return err
+				}
+				select {
+				case <-quit:
+					it.Release()
+					log.Info("Chain data recompression aborted", "records", count, "before", before, "after", after)
+					return nil
+				default:
+				}
+			}
+			if time.Since(logged) > 8*time.Second {
+				log.Info("Recompressing chain data", "records", count, "skipped", skipped, "before", before, "after", after, "elapsed", common.PrettyDuration(time.Since(start)))
+				logged = time.Now()
+			}
+		}
+		err := it.Error()
+		it.Release()
+		if err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	log.Info("Recompressed chain data", "codec", codec, "records", count, "skipped", skipped, "before", before, "after", after, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
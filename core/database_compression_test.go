@@ -0,0 +1,258 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+)
+
+// makeCompressionTestData creates a block body and receipts with enough
+// redundancy to be compressible by all the codecs.
+func makeCompressionTestData() (*types.Body, types.Receipts) {
+	body := new(types.Body)
+	receipts := make(types.Receipts, 0, 16)
+	for i := 0; i < 16; i++ {
+		tx := types.NewTransaction(uint64(i), common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), make([]byte, 64))
+		body.Transactions = append(body.Transactions, tx)
+
+		receipts = append(receipts, &types.Receipt{
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: uint64(21000 * (i + 1)),
+			Logs:              []*types.Log{{Address: common.Address{0x01}, Topics: []common.Hash{{0x02}}, Data: make([]byte, 32)}},
+			TxHash:            tx.Hash(),
+			GasUsed:           21000,
+		})
+	}
+	return body, receipts
+}
+
+// Tests that block bodies and receipts can be stored and retrieved with all the
+// codecs, and that records of different codecs can be mixed in the database.
+func TestCompressedStorage(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+	body, receipts := makeCompressionTestData()
+	wantBody, _ := rlp.EncodeToBytes(body)
+
+	for i, codec := range []StorageCodec{CodecNone, CodecRLE, CodecSnappy} {
+		hash, number := common.Hash{byte(i + 1)}, uint64(i)
+		if err := writeBody(db, hash, number, body, codec); err != nil {
+			t.Fatalf("%v: failed to write body: %v", codec, err)
+		}
+		if err := writeBlockReceipts(db, hash, number, receipts, codec); err != nil {
+			t.Fatalf("%v: failed to write receipts: %v", codec, err)
+		}
+		stored, _ := db.Get(blockBodyKey(hash, number))
+		if have := recordCodec(stored); have != codec {
+			t.Errorf("%v: stored body codec mismatch: have %v", codec, have)
+		}
+		if codec != CodecNone && len(stored) >= len(wantBody) {
+			t.Errorf("%v: body not compressed: have %d bytes, raw %d bytes", codec, len(stored), len(wantBody))
+		}
+	}
+	// Ensure all the records are readable regardless of their codec
+	for i := range []StorageCodec{CodecNone, CodecRLE, CodecSnappy} {
+		hash, number := common.Hash{byte(i + 1)}, uint64(i)
+
+		if blob := GetBodyRLP(db, hash, number); !bytes.Equal(blob, wantBody) {
+			t.Errorf("record %d: body RLP mismatch: have %x, want %x", i, blob, wantBody)
+		}
+		have := GetBlockReceipts(db, hash, number)
+		if len(have) != len(receipts) {
+			t.Fatalf("record %d: receipt count mismatch: have %d, want %d", i, len(have), len(receipts))
+		}
+		for j := range receipts {
+			if have[j].TxHash != receipts[j].TxHash || have[j].CumulativeGasUsed != receipts[j].CumulativeGasUsed {
+				t.Errorf("record %d: receipt %d mismatch: have %v, want %v", i, j, have[j], receipts[j])
+			}
+		}
+	}
+}
+
+// Tests that records with an unknown codec prefix are rejected.
+func TestUnknownStorageCodec(t *testing.T) {
+	if _, err := decodeRecord([]byte{0x7f, 0x01, 0x02}); err != errUnknownCodec {
+		t.Fatalf("unknown codec error mismatch: have %v, want %v", err, errUnknownCodec)
+	}
+	if _, err := ParseStorageCodec("zip"); err == nil {
+		t.Fatalf("unknown codec name accepted")
+	}
+}
+
+// Tests that existing chain data is rewritten with the requested codec and stays
+// readable afterwards.
+func TestRecompressChainData(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+	body, receipts := makeCompressionTestData()
+
+	for i := uint64(0); i < 32; i++ {
+		WriteBody(db, common.Hash{byte(i)}, i, body)
+		WriteBlockReceipts(db, common.Hash{byte(i)}, i, receipts)
+	}
+	if err := recompressChainData(db, CodecRLE, new(sync.Mutex), nil); err != nil {
+		t.Fatalf("failed to recompress chain data: %v", err)
+	}
+	for i := uint64(0); i < 32; i++ {
+		hash := common.Hash{byte(i)}
+		for _, key := range [][]byte{blockBodyKey(hash, i), blockReceiptsKey(hash, i)} {
+			blob, _ := db.Get(key)
+			if codec := recordCodec(blob); codec != CodecRLE {
+				t.Errorf("block %d: record %x codec mismatch: have %v, want %v", i, key, codec, CodecRLE)
+			}
+		}
+		if GetBody(db, hash, i) == nil {
+			t.Errorf("block %d: recompressed body missing", i)
+		}
+		if have := GetBlockReceipts(db, hash, i); len(have) != len(receipts) {
+			t.Errorf("block %d: recompressed receipts mismatch: have %d, want %d", i, len(have), len(receipts))
+		}
+	}
+}
+
+// deletingLocker is a lock which deletes some records whenever it's acquired,
+// simulating chain data being removed right before a recompression write.
+type deletingLocker struct {
+	sync.Mutex
+	db   lemodb.Database
+	keys [][]byte
+}
+
+func (l *deletingLocker) Lock() {
+	l.Mutex.Lock()
+	for _, key := range l.keys {
+		l.db.Delete(key)
+	}
+}
+
+// Tests that records deleted after being read by the recompression are not
+// resurrected when the recompressed data is written.
+func TestRecompressChainDataDeleted(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+	body, receipts := makeCompressionTestData()
+
+	for i := uint64(0); i < 4; i++ {
+		WriteBody(db, common.Hash{byte(i)}, i, body)
+		WriteBlockReceipts(db, common.Hash{byte(i)}, i, receipts)
+	}
+	deleted := [][]byte{blockBodyKey(common.Hash{1}, 1), blockReceiptsKey(common.Hash{1}, 1)}
+	if err := recompressChainData(db, CodecSnappy, &deletingLocker{db: db, keys: deleted}, nil); err != nil {
+		t.Fatalf("failed to recompress chain data: %v", err)
+	}
+	for _, key := range deleted {
+		if ok, _ := db.Has(key); ok {
+			t.Errorf("deleted record %x resurrected", key)
+		}
+	}
+	for _, i := range []uint64{0, 2, 3} {
+		blob, _ := db.Get(blockBodyKey(common.Hash{byte(i)}, i))
+		if codec := recordCodec(blob); codec != CodecSnappy {
+			t.Errorf("block %d: body codec mismatch: have %v, want %v", i, codec, CodecSnappy)
+		}
+	}
+}
+
+// Tests that the block chain writes new bodies and receipts with the codec from
+// its cache config.
+func TestBlockChainStorageCodec(t *testing.T) {
+	var (
+		db, _ = lemodb.NewMemDatabase()
+		gspec = Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{benchRootAddr: {Balance: benchRootFunds}}}
+	)
+	genesis := gspec.MustCommit(db)
+	blocks, _ := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), db, 4, genTxRing(200))
+
+	chain, err := NewBlockChain(db, &CacheConfig{TrieNodeLimit: 256, TrieTimeLimit: 5 * time.Minute, StorageCodec: CodecSnappy}, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	for _, block := range blocks {
+		hash, number := block.Hash(), block.NumberU64()
+		for _, key := range [][]byte{blockBodyKey(hash, number), blockReceiptsKey(hash, number)} {
+			blob, _ := db.Get(key)
+			if codec := recordCodec(blob); codec != CodecSnappy {
+				t.Errorf("block %d: record %x codec mismatch: have %v, want %v", number, key, codec, CodecSnappy)
+			}
+		}
+		if GetBody(db, hash, number) == nil {
+			t.Errorf("block %d: compressed body missing", number)
+		}
+	}
+}
+
+func BenchmarkStorageCodec_none(b *testing.B)   { benchStorageCodec(b, CodecNone) }
+func BenchmarkStorageCodec_rle(b *testing.B)    { benchStorageCodec(b, CodecRLE) }
+func BenchmarkStorageCodec_snappy(b *testing.B) { benchStorageCodec(b, CodecSnappy) }
+
+// benchStorageCodec writes the bodies and receipts of a generated chain full of
+// transactions with the given codec, reporting the stored size compared to the
+// uncompressed data.
+func benchStorageCodec(b *testing.B, codec StorageCodec) {
+	// Generate a chain with many small transactions and their receipts
+	var (
+		db, _ = lemodb.NewMemDatabase()
+		gspec = Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{benchRootAddr: {Balance: benchRootFunds}}}
+	)
+	genesis := gspec.MustCommit(db)
+	blocks, receipts := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), db, 16, genTxRing(200))
+
+	var raw int
+	for i, block := range blocks {
+		body, _ := rlp.EncodeToBytes(block.Body())
+		storage := make([]*types.ReceiptForStorage, len(receipts[i]))
+		for j, receipt := range receipts[i] {
+			storage[j] = (*types.ReceiptForStorage)(receipt)
+		}
+		blob, _ := rlp.EncodeToBytes(storage)
+		raw += len(body) + len(blob)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var stored int
+	for i := 0; i < b.N; i++ {
+		db, _ := lemodb.NewMemDatabase()
+		for j, block := range blocks {
+			writeBody(db, block.Hash(), block.NumberU64(), block.Body(), codec)
+			writeBlockReceipts(db, block.Hash(), block.NumberU64(), receipts[j], codec)
+		}
+		stored = 0
+		for _, key := range db.Keys() {
+			value, _ := db.Get(key)
+			stored += len(value)
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(stored)/float64(len(blocks)), "bytes/block")
+	b.ReportMetric(100*float64(stored)/float64(raw), "%size")
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+)
+
+// DatabaseStat is the number and total size of the entries of a single key
+// schema found in the database.
+type DatabaseStat struct {
+	Category string             // Human readable name of the key schema
+	Count    uint64             // Number of entries matching the schema
+	Size     common.StorageSize // Total size of the keys and values
+}
+
+// add accounts a single database entry to the stat.
+func (s *DatabaseStat) add(size int) {
+	s.Count++
+	s.Size += common.StorageSize(size)
+}
+
+// databaseSchema is a key schema of the chain database, matching the keys with
+// a given prefix and (if non zero) a given total length.
+type databaseSchema struct {
+	category string
+	prefix   []byte
+	length   int
+}
+
+// databaseSchemas lists all the known key schemas stored in the chain database,
+// checked in order. Schemas defined outside of this package (state snapshots,
+// consensus snapshots, light client tries) are matched on their raw prefixes.
+var databaseSchemas = []databaseSchema{
+	{"Headers", headerPrefix, len(headerPrefix) + 8 + common.HashLength},
+	{"Total difficulties", headerPrefix, len(headerPrefix) + 8 + common.HashLength + len(tdSuffix)},
+	{"Canonical hashes", headerPrefix, len(headerPrefix) + 8 + len(numSuffix)},
+	{"Block number lookups", blockHashPrefix, len(blockHashPrefix) + common.HashLength},
+	{"Bodies", bodyPrefix, len(bodyPrefix) + 8 + common.HashLength},
+	{"Receipts", blockReceiptsPrefix, len(blockReceiptsPrefix) + 8 + common.HashLength},
+	{"Transaction lookups", lookupPrefix, len(lookupPrefix) + common.HashLength},
+	{"Bloombits", bloomBitsPrefix, len(bloomBitsPrefix) + 2 + 8 + common.HashLength},
+	{"Bloombits index", BloomBitsIndexPrefix, 0},
+	{"Address transaction index", addrTxPrefix, len(addrTxPrefix) + common.AddressLength + 8 + common.HashLength},
+	{"Address transaction index", addrSectionPrefix, len(addrSectionPrefix) + 8 + common.HashLength},
+	{"Address transaction index", AddressIndexPrefix, 0},
+	{"Preimages", []byte(preimagePrefix), len(preimagePrefix) + common.HashLength},
+	{"Snapshot accounts", []byte("a"), 1 + common.HashLength},
+	{"Snapshot storage", []byte("o"), 1 + 2*common.HashLength},
+	{"Consensus snapshots", []byte("dpovp-"), 0},
+	{"Consensus snapshots", []byte("clique-"), 0},
+	{"Light client tries", []byte("chtRoot-"), 0},
+	{"Light client tries", []byte("bltRoot-"), 0},
+	{"Light client tries", []byte("cht-"), 0},
+	{"Light client tries", []byte("blt-"), 0},
+	{"Chain configs", configPrefix, 0},
+}
+
+// databaseMetadata lists the singleton metadata keys of the chain database.
+var databaseMetadata = [][]byte{
+	headHeaderKey, headBlockKey, headFastKey, trieSyncKey, historyTailKey,
+	[]byte("BlockchainVersion"), []byte("SnapshotRoot"), []byte("SnapshotGenerator"),
+	[]byte("PruneStateRoot"), schemaVersionKey, schemaMigrationKey,
+}
+
+// InspectDatabase walks the entire database and accounts every entry to the key
+// schema it belongs to, returning the counts and sizes of all the schemas. Entries
+// keyed by a plain hash are reported as trie nodes and contract codes, anything
+// else as unaccounted data.
+func InspectDatabase(db lemodb.Iteratee) ([]*DatabaseStat, error) {
+	var (
+		stats   []*DatabaseStat
+		indices = make(map[string]*DatabaseStat)
+	)
+	stat := func(category string) *DatabaseStat {
+		if s, ok := indices[category]; ok {
+			return s
+		}
+		s := &DatabaseStat{Category: category}
+		indices[category] = s
+		stats = append(stats, s)
+		return s
+	}
+	// Register all the categories upfront to keep the report ordering stable
+	for _, schema := range databaseSchemas {
+		stat(schema.category)
+	}
+	var (
+		tries       = stat("Trie nodes and contract codes")
+		metadata    = stat("Chain metadata")
+		unaccounted = stat("Unaccounted data")
+
+		start  = time.Now()
+		logged = time.Now()
+		count  uint64
+	)
+	it := db.NewIterator()
+	defer it.Release()
+
+	for it.Next() {
+		key, size := it.Key(), len(it.Key())+len(it.Value())
+
+		switch {
+		case len(key) == common.HashLength:
+			tries.add(size)
+		case isMetadataKey(key):
+			metadata.add(size)
+		default:
+			matched := false
+			for _, schema := range databaseSchemas {
+				if bytes.HasPrefix(key, schema.prefix) && (schema.length == 0 || len(key) == schema.length) {
+					indices[schema.category].add(size)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				unaccounted.add(size)
+			}
+		}
+		count++
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Inspecting database", "count", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// isMetadataKey reports whether the key is one of the singleton metadata keys.
+func isMetadataKey(key []byte) bool {
+	for _, meta := range databaseMetadata {
+		if bytes.Equal(key, meta) {
+			return true
+		}
+	}
+	return false
+}
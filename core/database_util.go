@@ -44,10 +44,15 @@ type DatabaseDeleter interface {
 }
 
 var (
-	headHeaderKey = []byte("LastHeader")
-	headBlockKey  = []byte("LastBlock")
-	headFastKey   = []byte("LastFast")
-	trieSyncKey   = []byte("TrieSync")
+	headHeaderKey  = []byte("LastHeader")
+	headBlockKey   = []byte("LastBlock")
+	headFastKey    = []byte("LastFast")
+	trieSyncKey    = []byte("TrieSync")
+	historyTailKey = []byte("HistoryTail")
+
+	// Database schema version and the progress of an interrupted schema migration.
+	schemaVersionKey   = []byte("SchemaVersion")
+	schemaMigrationKey = []byte("SchemaMigration")
 
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`).
 	headerPrefix        = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
@@ -58,12 +63,15 @@ var (
 	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
 	lookupPrefix        = []byte("l") // lookupPrefix + hash -> transaction/receipt lookup metadata
 	bloomBitsPrefix     = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
+	addrTxPrefix        = []byte("A") // addrTxPrefix + address + section (uint64 big endian) + hash -> address transaction postings
+	addrSectionPrefix   = []byte("x") // addrSectionPrefix + section (uint64 big endian) + hash -> addresses indexed in the section
 
 	preimagePrefix = "secure-key-"              // preimagePrefix + hash -> preimage
 	configPrefix   = []byte("lemochain-config-") // config prefix for the db
 
 	// Chain index prefixes (use `i` + single byte to avoid mixing data types).
 	BloomBitsIndexPrefix = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
+	AddressIndexPrefix   = []byte("iA") // AddressIndexPrefix is the data table of the address transaction indexer to track its progress
 
 	// used by old db, now only used for conversion
 	oldReceiptsPrefix = []byte("receipts-")
@@ -90,9 +98,51 @@ func encodeBlockNumber(number uint64) []byte {
 	return enc
 }
 
+// readAncient retrieves an item of the given kind from the ancient store, if the
+// database has one attached and the block was already frozen.
+func readAncient(db DatabaseReader, kind string, number uint64) []byte {
+	if ancients, ok := db.(lemodb.AncientReader); ok {
+		data, _ := ancients.Ancient(kind, number)
+		return data
+	}
+	return nil
+}
+
+// readAncientByHash retrieves an item of the given kind from the ancient store,
+// but only if the canonical block frozen at the given number has the requested
+// hash. Side chain data is never moved into the ancient store.
+func readAncientByHash(db DatabaseReader, kind string, hash common.Hash, number uint64) []byte {
+	if !hasAncientBlock(db, hash, number) {
+		return nil
+	}
+	return readAncient(db, kind, number)
+}
+
+// hasAncientBlock checks whether the block with the given hash and number was
+// moved into the ancient store.
+func hasAncientBlock(db DatabaseReader, hash common.Hash, number uint64) bool {
+	data := readAncient(db, lemodb.AncientHashes, number)
+	return len(data) > 0 && common.BytesToHash(data) == hash
+}
+
+// GetAncientCount returns the number of blocks moved into the ancient store, or
+// zero if the database has no ancient store attached.
+func GetAncientCount(db DatabaseReader) uint64 {
+	if ancients, ok := db.(lemodb.AncientReader); ok {
+		frozen, err := ancients.Ancients()
+		if err == nil {
+			return frozen
+		}
+	}
+	return 0
+}
+
 // GetCanonicalHash retrieves a hash assigned to a canonical block number.
 func GetCanonicalHash(db DatabaseReader, number uint64) common.Hash {
 	data, _ := db.Get(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
+	if len(data) == 0 {
+		data = readAncient(db, lemodb.AncientHashes, number)
+	}
 	if len(data) == 0 {
 		return common.Hash{}
 	}
@@ -157,10 +207,23 @@ func GetTrieSyncProgress(db DatabaseReader) uint64 {
 	return new(big.Int).SetBytes(data).Uint64()
 }
 
+// GetHistoryTail retrieves the number of the first block whose body, receipts
+// and transaction lookup entries are retained, all older ones may be pruned.
+func GetHistoryTail(db DatabaseReader) uint64 {
+	data, _ := db.Get(historyTailKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
 // GetHeaderRLP retrieves a block header in its raw RLP database encoding, or nil
 // if the header's not found.
 func GetHeaderRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(headerKey(hash, number))
+	if len(data) == 0 {
+		data = readAncientByHash(db, lemodb.AncientHeaders, hash, number)
+	}
 	return data
 }
 
@@ -181,10 +244,28 @@ func GetHeader(db DatabaseReader, hash common.Hash, number uint64) *types.Header
 
 // GetBodyRLP retrieves the block body (transactions and uncles) in RLP encoding.
 func GetBodyRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
-	data, _ := db.Get(blockBodyKey(hash, number))
+	data := readCompressed(db, blockBodyKey(hash, number))
+	if len(data) == 0 {
+		data = readAncientByHash(db, lemodb.AncientBodies, hash, number)
+	}
 	return data
 }
 
+// readCompressed retrieves a record which may be stored compressed, returning
+// its raw RLP content.
+func readCompressed(db DatabaseReader, key []byte) []byte {
+	data, _ := db.Get(key)
+	if len(data) == 0 {
+		return nil
+	}
+	raw, err := decodeRecord(data)
+	if err != nil {
+		log.Error("Invalid compressed record", "key", common.Bytes2Hex(key), "err", err)
+		return nil
+	}
+	return raw
+}
+
 func headerKey(hash common.Hash, number uint64) []byte {
 	return append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
@@ -193,6 +274,14 @@ func blockBodyKey(hash common.Hash, number uint64) []byte {
 	return append(append(bodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
 
+func headerTdKey(hash common.Hash, number uint64) []byte {
+	return append(headerKey(hash, number), tdSuffix...)
+}
+
+func blockReceiptsKey(hash common.Hash, number uint64) []byte {
+	return append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
 // GetBody retrieves the block body (transactons, uncles) corresponding to the
 // hash, nil if none found.
 func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
@@ -211,7 +300,10 @@ func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
 // GetTd retrieves a block's total difficulty corresponding to the hash, nil if
 // none found.
 func GetTd(db DatabaseReader, hash common.Hash, number uint64) *big.Int {
-	data, _ := db.Get(append(append(append(headerPrefix, encodeBlockNumber(number)...), hash[:]...), tdSuffix...))
+	data, _ := db.Get(headerTdKey(hash, number))
+	if len(data) == 0 {
+		data = readAncientByHash(db, lemodb.AncientDiffs, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -243,10 +335,20 @@ func GetBlock(db DatabaseReader, hash common.Hash, number uint64) *types.Block {
 	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles)
 }
 
+// GetBlockReceiptsRLP retrieves the receipts of a block in their raw RLP
+// storage encoding.
+func GetBlockReceiptsRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
+	data := readCompressed(db, blockReceiptsKey(hash, number))
+	if len(data) == 0 {
+		data = readAncientByHash(db, lemodb.AncientReceipts, hash, number)
+	}
+	return data
+}
+
 // GetBlockReceipts retrieves the receipts generated by the transactions included
 // in a block given by its hash.
 func GetBlockReceipts(db DatabaseReader, hash common.Hash, number uint64) types.Receipts {
-	data, _ := db.Get(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash[:]...))
+	data := GetBlockReceiptsRLP(db, hash, number)
 	if len(data) == 0 {
 		return nil
 	}
@@ -394,6 +496,14 @@ func WriteTrieSyncProgress(db lemodb.Putter, count uint64) error {
 	return nil
 }
 
+// WriteHistoryTail stores the number of the first block whose history is retained.
+func WriteHistoryTail(db lemodb.Putter, number uint64) error {
+	if err := db.Put(historyTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store history tail", "err", err)
+	}
+	return nil
+}
+
 // WriteHeader serializes a block header into the database.
 func WriteHeader(db lemodb.Putter, header *types.Header) error {
 	data, err := rlp.EncodeToBytes(header)
@@ -416,17 +526,29 @@ func WriteHeader(db lemodb.Putter, header *types.Header) error {
 
 // WriteBody serializes the body of a block into the database.
 func WriteBody(db lemodb.Putter, hash common.Hash, number uint64, body *types.Body) error {
+	return writeBody(db, hash, number, body, CodecNone)
+}
+
+// writeBody serializes the body of a block into the database, compressed with
+// the given storage codec.
+func writeBody(db lemodb.Putter, hash common.Hash, number uint64, body *types.Body, codec StorageCodec) error {
 	data, err := rlp.EncodeToBytes(body)
 	if err != nil {
 		return err
 	}
-	return WriteBodyRLP(db, hash, number, data)
+	return writeBodyRLP(db, hash, number, data, codec)
 }
 
 // WriteBodyRLP writes a serialized body of a block into the database.
 func WriteBodyRLP(db lemodb.Putter, hash common.Hash, number uint64, rlp rlp.RawValue) error {
+	return writeBodyRLP(db, hash, number, rlp, CodecNone)
+}
+
+// writeBodyRLP writes a serialized body of a block into the database, compressed
+// with the given storage codec.
+func writeBodyRLP(db lemodb.Putter, hash common.Hash, number uint64, rlp rlp.RawValue, codec StorageCodec) error {
 	key := append(append(bodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
-	if err := db.Put(key, rlp); err != nil {
+	if err := db.Put(key, encodeRecord(codec, rlp)); err != nil {
 		log.Crit("Failed to store block body", "err", err)
 	}
 	return nil
@@ -447,8 +569,14 @@ func WriteTd(db lemodb.Putter, hash common.Hash, number uint64, td *big.Int) err
 
 // WriteBlock serializes a block into the database, header and body separately.
 func WriteBlock(db lemodb.Putter, block *types.Block) error {
+	return writeBlock(db, block, CodecNone)
+}
+
+// writeBlock serializes a block into the database, header and body separately,
+// compressing the body with the given storage codec.
+func writeBlock(db lemodb.Putter, block *types.Block, codec StorageCodec) error {
 	// Store the body first to retain database consistency
-	if err := WriteBody(db, block.Hash(), block.NumberU64(), block.Body()); err != nil {
+	if err := writeBody(db, block.Hash(), block.NumberU64(), block.Body(), codec); err != nil {
 		return err
 	}
 	// Store the header too, signaling full block ownership
@@ -462,6 +590,12 @@ func WriteBlock(db lemodb.Putter, block *types.Block) error {
 // as a single receipt slice. This is used during chain reorganisations for
 // rescheduling dropped transactions.
 func WriteBlockReceipts(db lemodb.Putter, hash common.Hash, number uint64, receipts types.Receipts) error {
+	return writeBlockReceipts(db, hash, number, receipts, CodecNone)
+}
+
+// writeBlockReceipts stores all the transaction receipts belonging to a block,
+// compressed with the given storage codec.
+func writeBlockReceipts(db lemodb.Putter, hash common.Hash, number uint64, receipts types.Receipts, codec StorageCodec) error {
 	// Convert the receipts into their storage form and serialize them
 	storageReceipts := make([]*types.ReceiptForStorage, len(receipts))
 	for i, receipt := range receipts {
@@ -471,9 +605,9 @@ func WriteBlockReceipts(db lemodb.Putter, hash common.Hash, number uint64, recei
 	if err != nil {
 		return err
 	}
-	// Store the flattened receipt slice
+	// Store the flattened receipt slice, compressed with the requested codec
 	key := append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
-	if err := db.Put(key, bytes); err != nil {
+	if err := db.Put(key, encodeRecord(codec, bytes)); err != nil {
 		log.Crit("Failed to store block receipts", "err", err)
 	}
 	return nil
@@ -542,6 +676,17 @@ func DeleteBlock(db DatabaseDeleter, hash common.Hash, number uint64) {
 	DeleteTd(db, hash, number)
 }
 
+// DeleteFrozenBlock removes all the data of a block moved into the ancient store
+// from the key-value store. The hash to number mapping is retained to allow
+// looking up the number of a frozen block.
+func DeleteFrozenBlock(db DatabaseDeleter, hash common.Hash, number uint64) {
+	DeleteCanonicalHash(db, number)
+	db.Delete(headerKey(hash, number))
+	DeleteBody(db, hash, number)
+	DeleteTd(db, hash, number)
+	DeleteBlockReceipts(db, hash, number)
+}
+
 // DeleteBlockReceipts removes all receipt data associated with a block hash.
 func DeleteBlockReceipts(db DatabaseDeleter, hash common.Hash, number uint64) {
 	db.Delete(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...))
@@ -593,6 +738,58 @@ func WriteBlockChainVersion(db lemodb.Putter, vsn int) {
 	db.Put([]byte("BlockchainVersion"), enc)
 }
 
+// GetSchemaVersion reads the version of the database schema and whether any was
+// stored at all.
+func GetSchemaVersion(db DatabaseReader) (uint64, bool) {
+	enc, _ := db.Get(schemaVersionKey)
+	if len(enc) == 0 {
+		return 0, false
+	}
+	var version uint64
+	if err := rlp.DecodeBytes(enc, &version); err != nil {
+		log.Crit("Invalid database schema version", "err", err)
+	}
+	return version, true
+}
+
+// WriteSchemaVersion stores the version of the database schema.
+func WriteSchemaVersion(db lemodb.Putter, version uint64) error {
+	enc, _ := rlp.EncodeToBytes(version)
+	return db.Put(schemaVersionKey, enc)
+}
+
+// schemaMigration is the progress of an interrupted schema migration.
+type schemaMigration struct {
+	Version uint64 // Schema version the migration upgrades to
+	Marker  []byte // Migration specific marker to resume from
+}
+
+// GetSchemaMigration reads the progress of an interrupted schema migration: the
+// version it upgrades to and the marker to resume from. A zero version means no
+// migration was interrupted.
+func GetSchemaMigration(db DatabaseReader) (uint64, []byte) {
+	enc, _ := db.Get(schemaMigrationKey)
+	if len(enc) == 0 {
+		return 0, nil
+	}
+	var progress schemaMigration
+	if err := rlp.DecodeBytes(enc, &progress); err != nil {
+		log.Crit("Invalid database schema migration progress", "err", err)
+	}
+	return progress.Version, progress.Marker
+}
+
+// WriteSchemaMigration stores the progress of a running schema migration.
+func WriteSchemaMigration(db lemodb.Putter, version uint64, marker []byte) error {
+	enc, _ := rlp.EncodeToBytes(&schemaMigration{Version: version, Marker: marker})
+	return db.Put(schemaMigrationKey, enc)
+}
+
+// DeleteSchemaMigration removes the progress of a finished schema migration.
+func DeleteSchemaMigration(db DatabaseDeleter) {
+	db.Delete(schemaMigrationKey)
+}
+
 // WriteChainConfig writes the chain config settings to the database.
 func WriteChainConfig(db lemodb.Putter, hash common.Hash, cfg *params.ChainConfig) error {
 	// short circuit and ignore if nil config. GetChainConfig
@@ -23,6 +23,7 @@ import (
 
 	"github.com/LemoFoundationLtd/lemochain-go/common"
 	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
 	"github.com/LemoFoundationLtd/lemochain-go/crypto/sha3"
 	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
 	"github.com/LemoFoundationLtd/lemochain-go/rlp"
@@ -386,3 +387,44 @@ func TestBlockReceiptStorage(t *testing.T) {
 		t.Fatalf("deleted receipts returned: %v", rs)
 	}
 }
+
+// Tests that database inspection accounts all the entries to their key schemas.
+func TestInspectDatabase(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Extra: []byte("test block")})
+	WriteBlock(db, block)
+	WriteTd(db, block.Hash(), 1, big.NewInt(1))
+	WriteCanonicalHash(db, block.Hash(), 1)
+	WriteHeadBlockHash(db, block.Hash())
+	WriteBlockReceipts(db, block.Hash(), 1, nil)
+	WritePreimages(db, 1, map[common.Hash][]byte{common.HexToHash("0x01"): {0x01}})
+
+	db.Put(crypto.Keccak256([]byte{0x01}), []byte{0x01})
+	db.Put([]byte("unknown"), []byte{0x01})
+
+	stats, err := InspectDatabase(db)
+	if err != nil {
+		t.Fatalf("failed to inspect database: %v", err)
+	}
+	want := map[string]uint64{
+		"Headers":                       1,
+		"Total difficulties":            1,
+		"Canonical hashes":              1,
+		"Block number lookups":          1,
+		"Bodies":                        1,
+		"Receipts":                      1,
+		"Preimages":                     1,
+		"Trie nodes and contract codes": 1,
+		"Chain metadata":                1,
+		"Unaccounted data":              1,
+	}
+	for _, stat := range stats {
+		if stat.Count != want[stat.Category] {
+			t.Errorf("%s: count mismatch: have %d, want %d", stat.Category, stat.Count, want[stat.Category])
+		}
+		if (stat.Count == 0) != (stat.Size == 0) {
+			t.Errorf("%s: size %v mismatch with count %d", stat.Category, stat.Size, stat.Count)
+		}
+	}
+}
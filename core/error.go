@@ -32,4 +32,13 @@ var (
 	// ErrNonceTooHigh is returned if the nonce of a transaction is higher than the
 	// next one expected based on the local chain.
 	ErrNonceTooHigh = errors.New("nonce too high")
+
+	// ErrReorgFinalized is returned if a chain reorganisation would revert blocks
+	// already finalized by the consensus engine. The new branch is kept as a side
+	// chain instead.
+	ErrReorgFinalized = errors.New("reorg below finalized block")
+
+	// ErrHistoryPruned is returned if the body or receipts of a block are requested
+	// which are older than the retained history and were pruned.
+	ErrHistoryPruned = errors.New("history pruned")
 )
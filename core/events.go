@@ -18,9 +18,12 @@ package core
 
 import (
 	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
 	"github.com/LemoFoundationLtd/lemochain-go/core/types"
 )
 
+//go:generate gencodec -type ChainReorgEvent -field-override chainReorgEventMarshaling -out gen_chain_reorg_event.go
+
 // TxPreEvent is posted when a transaction enters the transaction pool.
 type TxPreEvent struct{ Tx *types.Transaction }
 
@@ -52,3 +55,18 @@ type ChainSideEvent struct {
 }
 
 type ChainHeadEvent struct{ Block *types.Block }
+
+// ChainReorgEvent is posted when the canonical chain is reorganised. The dropped
+// and added block hashes are ordered from the common ancestor towards the heads.
+type ChainReorgEvent struct {
+	CommonHash   common.Hash   `json:"commonHash"   gencodec:"required"`
+	CommonNumber uint64        `json:"commonNumber" gencodec:"required"`
+	OldChain     []common.Hash `json:"oldChain"     gencodec:"required"`
+	NewChain     []common.Hash `json:"newChain"     gencodec:"required"`
+	Depth        uint64        `json:"depth"        gencodec:"required"`
+}
+
+type chainReorgEventMarshaling struct {
+	CommonNumber hexutil.Uint64
+	Depth        hexutil.Uint64
+}
@@ -153,7 +153,20 @@ func (hc *HeaderChain) WriteHeader(header *types.Header) (status WriteStatus, er
 	// If the total difficulty is higher than our known, add it to the canonical chain
 	// Second clause in the if statement reduces the vulnerability to selfish mining.
 	// Please refer to http://www.cs.cornell.edu/~ie53/publications/btcProcFC.pdf
-	if externTd.Cmp(localTd) > 0 || (externTd.Cmp(localTd) == 0 && mrand.Float64() < 0.5) {
+	reorg := externTd.Cmp(localTd) > 0 || (externTd.Cmp(localTd) == 0 && mrand.Float64() < 0.5)
+	if reorg && header.ParentHash != hc.currentHeaderHash {
+		// Never rewind the chain below the last header finalized by the consensus,
+		// keep the new branch as a side chain instead
+		finalized, err := hc.revertsFinalized(header)
+		if err != nil {
+			return NonStatTy, err
+		}
+		if finalized {
+			log.Warn("Rejected reorg below finalized header", "number", number, "hash", hash)
+			reorg = false
+		}
+	}
+	if reorg {
 		// Delete any canonical number assignments above the new head
 		for i := number + 1; ; i++ {
 			hash := GetCanonicalHash(hc.chainDb, i)
@@ -196,6 +209,32 @@ func (hc *HeaderChain) WriteHeader(header *types.Header) (status WriteStatus, er
 	return
 }
 
+// revertsFinalized reports whether re-routing the canonical chain to the given
+// header would revert headers finalized by the consensus engine, i.e. whether it
+// forks off the canonical chain below the last finalized header.
+func (hc *HeaderChain) revertsFinalized(header *types.Header) (bool, error) {
+	finality, ok := hc.engine.(consensus.Finality)
+	if !ok {
+		return false, nil
+	}
+	finalized, err := finality.Finalized(hc, hc.CurrentHeader())
+	if err != nil {
+		return false, err
+	}
+	// Walk back the new branch until it joins the canonical chain
+	for ancestor := hc.GetHeader(header.ParentHash, header.Number.Uint64()-1); ancestor != nil; {
+		number := ancestor.Number.Uint64()
+		if number < finalized {
+			return true, nil
+		}
+		if GetCanonicalHash(hc.chainDb, number) == ancestor.Hash() {
+			return false, nil
+		}
+		ancestor = hc.GetHeader(ancestor.ParentHash, number-1)
+	}
+	return false, consensus.ErrUnknownAncestor
+}
+
 // WhCallback is a callback function for inserting individual headers.
 // A callback is used for two reasons: first, in a LightChain, status should be
 // processed and light chain events sent, while in a BlockChain this is not
@@ -368,8 +407,10 @@ func (hc *HeaderChain) HasHeader(hash common.Hash, number uint64) bool {
 	if hc.numberCache.Contains(hash) || hc.headerCache.Contains(hash) {
 		return true
 	}
-	ok, _ := hc.chainDb.Has(headerKey(hash, number))
-	return ok
+	if ok, _ := hc.chainDb.Has(headerKey(hash, number)); ok {
+		return true
+	}
+	return hasAncientBlock(hc.chainDb, hash, number)
 }
 
 // GetHeaderByNumber retrieves a block header from the database by number,
@@ -424,6 +465,12 @@ func (hc *HeaderChain) SetHead(head uint64, delFn DeleteCallback) {
 	for i := height; i > head; i-- {
 		DeleteCanonicalHash(hc.chainDb, i)
 	}
+	// Discard any frozen chain segment above the new head
+	if ancients, ok := hc.chainDb.(lemodb.AncientWriter); ok && GetAncientCount(hc.chainDb) > head+1 {
+		if err := ancients.TruncateAncients(head + 1); err != nil {
+			log.Crit("Failed to truncate ancient store", "head", head, "err", err)
+		}
+	}
 	// Clear out any stale content from the caches
 	hc.headerCache.Purge()
 	hc.tdCache.Purge()
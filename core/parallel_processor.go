@@ -0,0 +1,404 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/metrics"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+var (
+	parallelSpeculatedMeter = metrics.NewRegisteredMeter("chain/parallel/speculated", nil)
+	parallelReexecutedMeter = metrics.NewRegisteredMeter("chain/parallel/reexecuted", nil)
+)
+
+// accessKey identifies a piece of state touched by a transaction: either the
+// account fields (balance, nonce, code, existence) or a single storage slot.
+type accessKey struct {
+	addr    common.Address
+	slot    common.Hash
+	storage bool
+}
+
+// accessTracker is a vm.StateDB wrapping a real state database, recording the
+// read and write sets of the transaction executed on top of it.
+//
+// Balance credits to the block's coinbase are not treated as accesses unless
+// the transaction also touches the coinbase in any other way. Every transaction
+// pays its fee to the coinbase, so tracking these would serialize the entire
+// block, whereas credits commute and can simply be summed up.
+type accessTracker struct {
+	*state.StateDB
+
+	coinbase common.Address
+	credited bool // Whether the coinbase was credited by the transaction
+
+	reads     map[accessKey]struct{}
+	writes    map[accessKey]struct{}
+	created   map[common.Address]struct{} // Accounts (re)created, wiping their storage
+	coded     map[common.Address]struct{} // Accounts which had their code set
+	preimages map[common.Hash][]byte
+}
+
+// newAccessTracker wraps a state database to track the accesses made to it.
+func newAccessTracker(statedb *state.StateDB, coinbase common.Address) *accessTracker {
+	return &accessTracker{
+		StateDB:   statedb,
+		coinbase:  coinbase,
+		reads:     make(map[accessKey]struct{}),
+		writes:    make(map[accessKey]struct{}),
+		created:   make(map[common.Address]struct{}),
+		coded:     make(map[common.Address]struct{}),
+		preimages: make(map[common.Hash][]byte),
+	}
+}
+
+func (t *accessTracker) readAccount(addr common.Address) {
+	t.reads[accessKey{addr: addr}] = struct{}{}
+}
+
+func (t *accessTracker) writeAccount(addr common.Address) {
+	t.writes[accessKey{addr: addr}] = struct{}{}
+}
+
+func (t *accessTracker) CreateAccount(addr common.Address) {
+	t.writeAccount(addr)
+	t.created[addr] = struct{}{}
+	t.StateDB.CreateAccount(addr)
+}
+
+func (t *accessTracker) SubBalance(addr common.Address, amount *big.Int) {
+	t.writeAccount(addr)
+	t.StateDB.SubBalance(addr, amount)
+}
+
+func (t *accessTracker) AddBalance(addr common.Address, amount *big.Int) {
+	if addr == t.coinbase {
+		t.credited = true
+	} else {
+		t.writeAccount(addr)
+	}
+	t.StateDB.AddBalance(addr, amount)
+}
+
+func (t *accessTracker) GetBalance(addr common.Address) *big.Int {
+	t.readAccount(addr)
+	return t.StateDB.GetBalance(addr)
+}
+
+func (t *accessTracker) GetNonce(addr common.Address) uint64 {
+	t.readAccount(addr)
+	return t.StateDB.GetNonce(addr)
+}
+
+func (t *accessTracker) SetNonce(addr common.Address, nonce uint64) {
+	t.writeAccount(addr)
+	t.StateDB.SetNonce(addr, nonce)
+}
+
+func (t *accessTracker) GetCodeHash(addr common.Address) common.Hash {
+	t.readAccount(addr)
+	return t.StateDB.GetCodeHash(addr)
+}
+
+func (t *accessTracker) GetCode(addr common.Address) []byte {
+	t.readAccount(addr)
+	return t.StateDB.GetCode(addr)
+}
+
+func (t *accessTracker) SetCode(addr common.Address, code []byte) {
+	t.writeAccount(addr)
+	t.coded[addr] = struct{}{}
+	t.StateDB.SetCode(addr, code)
+}
+
+func (t *accessTracker) GetCodeSize(addr common.Address) int {
+	t.readAccount(addr)
+	return t.StateDB.GetCodeSize(addr)
+}
+
+// GetState also counts as a read of the account itself, since the storage is
+// wiped if the account is destructed or recreated.
+func (t *accessTracker) GetState(addr common.Address, key common.Hash) common.Hash {
+	t.readAccount(addr)
+	t.reads[accessKey{addr: addr, slot: key, storage: true}] = struct{}{}
+	return t.StateDB.GetState(addr, key)
+}
+
+func (t *accessTracker) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	t.readAccount(addr)
+	t.writes[accessKey{addr: addr, slot: key, storage: true}] = struct{}{}
+	t.StateDB.SetState(addr, key, value)
+}
+
+func (t *accessTracker) Suicide(addr common.Address) bool {
+	t.writeAccount(addr)
+	return t.StateDB.Suicide(addr)
+}
+
+func (t *accessTracker) HasSuicided(addr common.Address) bool {
+	t.readAccount(addr)
+	return t.StateDB.HasSuicided(addr)
+}
+
+func (t *accessTracker) Exist(addr common.Address) bool {
+	t.readAccount(addr)
+	return t.StateDB.Exist(addr)
+}
+
+func (t *accessTracker) Empty(addr common.Address) bool {
+	t.readAccount(addr)
+	return t.StateDB.Empty(addr)
+}
+
+func (t *accessTracker) AddPreimage(hash common.Hash, preimage []byte) {
+	t.preimages[hash] = preimage
+	t.StateDB.AddPreimage(hash, preimage)
+}
+
+// ForEachStorage reads the entire storage of the account, which can't be
+// expressed as a set of slots, so treat it as a read of the account only and
+// rely on storage writes also marking their account as read.
+func (t *accessTracker) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	t.readAccount(addr)
+	t.StateDB.ForEachStorage(addr, cb)
+}
+
+// conflicts reports whether the transaction accessed any state written by the
+// transactions preceding it in the block.
+func (t *accessTracker) conflicts(written map[accessKey]struct{}) bool {
+	for key := range t.reads {
+		if _, ok := written[key]; ok {
+			return true
+		}
+	}
+	for key := range t.writes {
+		if _, ok := written[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// trackedResult is the outcome of a transaction executed on a tracked state.
+type trackedResult struct {
+	msg     types.Message
+	gas     uint64
+	failed  bool
+	revert  []byte // Revert reason of the transaction, if it was reverted
+	tracker *accessTracker
+}
+
+// applyTrackedTransaction executes a transaction on top of the given state the
+// same way ApplyTransaction does, but records the state accessed by it. The
+// state must be in Byzantium mode, no intermediate root is calculated.
+func applyTrackedTransaction(config *params.ChainConfig, bc *BlockChain, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, cfg vm.Config) (*trackedResult, error) {
+	msg, err := tx.AsMessage(types.MakeSigner(config, header.Number))
+	if err != nil {
+		return nil, err
+	}
+	tracker := newAccessTracker(statedb, header.Coinbase)
+
+	vmenv := vm.NewEVM(NewEVMContext(msg, header, bc, nil), tracker, config, cfg)
+	result, err := ApplyMessage(vmenv, msg, gp)
+	if err != nil {
+		return nil, err
+	}
+	statedb.Finalise(true)
+
+	return &trackedResult{msg: msg, gas: result.UsedGas, failed: result.Failed(), revert: result.Revert(), tracker: tracker}, nil
+}
+
+// speculation is a transaction executed on a private copy of the block's
+// pre-state, waiting to be merged into the real state.
+type speculation struct {
+	result   *trackedResult
+	statedb  *state.StateDB // Private state the transaction was executed on
+	coinbase *big.Int       // Balance of the coinbase before the execution
+	err      error
+}
+
+// merge transfers the state changes of a speculatively executed transaction
+// into the given state. It's only valid if none of the state accessed by the
+// transaction was modified by any preceding ones.
+func (s *speculation) merge(statedb *state.StateDB, hash common.Hash) {
+	var (
+		tracker  = s.result.tracker
+		accounts []common.Address
+		slots    []accessKey
+	)
+	for key := range tracker.writes {
+		if key.storage {
+			slots = append(slots, key)
+		} else {
+			accounts = append(accounts, key.addr)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return bytes.Compare(accounts[i][:], accounts[j][:]) < 0 })
+	sort.Slice(slots, func(i, j int) bool {
+		if c := bytes.Compare(slots[i].addr[:], slots[j].addr[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(slots[i].slot[:], slots[j].slot[:]) < 0
+	})
+	// Transfer the account fields, deleting anything the transaction removed
+	for _, addr := range accounts {
+		if !s.statedb.Exist(addr) {
+			if statedb.Exist(addr) {
+				statedb.Suicide(addr)
+			}
+			continue
+		}
+		if _, ok := tracker.created[addr]; ok || !statedb.Exist(addr) {
+			statedb.CreateAccount(addr)
+		}
+		statedb.SetBalance(addr, s.statedb.GetBalance(addr))
+		statedb.SetNonce(addr, s.statedb.GetNonce(addr))
+		if _, ok := tracker.coded[addr]; ok {
+			statedb.SetCode(addr, s.statedb.GetCode(addr))
+		}
+	}
+	for _, key := range slots {
+		if s.statedb.Exist(key.addr) {
+			statedb.SetState(key.addr, key.slot, s.statedb.GetState(key.addr, key.slot))
+		}
+	}
+	// Sum up the coinbase credits if the account was not touched otherwise
+	if _, ok := tracker.writes[accessKey{addr: tracker.coinbase}]; tracker.credited && !ok {
+		statedb.AddBalance(tracker.coinbase, new(big.Int).Sub(s.statedb.GetBalance(tracker.coinbase), s.coinbase))
+	}
+	// Re-emit the logs to assign them their final positions within the block
+	for _, log := range s.statedb.GetLogs(hash) {
+		statedb.AddLog(&types.Log{
+			Address:     log.Address,
+			Topics:      log.Topics,
+			Data:        log.Data,
+			BlockNumber: log.BlockNumber,
+		})
+	}
+	for hash, preimage := range tracker.preimages {
+		statedb.AddPreimage(hash, preimage)
+	}
+	statedb.Finalise(true)
+}
+
+// applyParallel executes the transactions of a block optimistically in parallel.
+// Every transaction is first run against its own copy of the block's pre-state,
+// after which the results are merged in order. A transaction which accessed any
+// state written by a preceding one is discarded and executed again on top of the
+// merged state, so the outcome is always the same as of the sequential execution.
+//
+// Only post-Byzantium blocks can be processed in parallel, as the receipts of
+// earlier ones contain intermediate state roots.
+func (p *StateProcessor) applyParallel(block *types.Block, statedb *state.StateDB, gp *GasPool, usedGas *uint64, cfg vm.Config) (types.Receipts, []*types.Log, error) {
+	var (
+		header = block.Header()
+		txs    = block.Transactions()
+		specs  = make([]*speculation, len(txs))
+	)
+	// Speculatively execute every transaction on top of the pre-state
+	threads := runtime.NumCPU()
+	if threads > len(txs) {
+		threads = len(txs)
+	}
+	tasks := make(chan int, len(txs))
+	for i := range txs {
+		tasks <- i
+	}
+	close(tasks)
+
+	var pend sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+
+			for i := range tasks {
+				spec := &speculation{statedb: statedb.Copy()}
+				spec.statedb.Prepare(txs[i].Hash(), block.Hash(), i)
+				spec.coinbase = spec.statedb.GetBalance(header.Coinbase)
+				spec.result, spec.err = applyTrackedTransaction(p.config, p.bc, new(GasPool).AddGas(block.GasLimit()), spec.statedb, header, txs[i], cfg)
+				specs[i] = spec
+			}
+		}()
+	}
+	pend.Wait()
+
+	// Merge the results in order, executing again anything that conflicts
+	var (
+		receipts types.Receipts
+		allLogs  []*types.Log
+		written  = make(map[accessKey]struct{})
+	)
+	for i, tx := range txs {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		var (
+			spec   = specs[i]
+			result *trackedResult
+		)
+		if spec.err == nil && gp.Gas() >= tx.Gas() && !spec.result.tracker.conflicts(written) {
+			spec.merge(statedb, tx.Hash())
+			if err := gp.SubGas(spec.result.gas); err != nil {
+				return nil, nil, err
+			}
+			result = spec.result
+			parallelSpeculatedMeter.Mark(1)
+		} else {
+			var err error
+			if result, err = applyTrackedTransaction(p.config, p.bc, gp, statedb, header, tx, cfg); err != nil {
+				return nil, nil, err
+			}
+			parallelReexecutedMeter.Mark(1)
+		}
+		specs[i] = nil
+
+		for key := range result.tracker.writes {
+			written[key] = struct{}{}
+		}
+		if result.tracker.credited {
+			written[accessKey{addr: header.Coinbase}] = struct{}{}
+		}
+		*usedGas += result.gas
+
+		// Create the receipt exactly as ApplyTransaction does
+		receipt := types.NewReceipt(nil, result.failed, *usedGas)
+		receipt.TxHash = tx.Hash()
+		receipt.GasUsed = result.gas
+		receipt.RevertReason = result.revert
+		if result.msg.To() == nil {
+			receipt.ContractAddress = crypto.CreateAddress(result.msg.From(), tx.Nonce())
+		}
+		receipt.Logs = statedb.GetLogs(tx.Hash())
+		receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	return receipts, allLogs, nil
+}
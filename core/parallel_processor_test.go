@@ -0,0 +1,184 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+var (
+	// parallelCounter increments the counter in slot 0 and logs its new value
+	parallelCounter = common.Address{0xc0}
+	// parallelPeeker stores the balance of the coinbase in slot 0
+	parallelPeeker = common.Address{0xc1}
+	// parallelBomb self destructs, sending its funds to the caller
+	parallelBomb = common.Address{0xc2}
+	// parallelCoinbase is the coinbase of all the generated blocks
+	parallelCoinbase = common.Address{0xcb}
+)
+
+// parallelTestChain generates a chain with a mix of independent and conflicting
+// transactions: plain transfers, repeated senders, shared contract storage, reads
+// of the coinbase, self destructs, contract creations and touched empty accounts.
+func parallelTestChain(t *testing.T, blocks int) (*Genesis, lemodb.Database, []*types.Block) {
+	keys := make([]*ecdsa.PrivateKey, 16)
+	alloc := GenesisAlloc{
+		parallelCounter: {Balance: big.NewInt(0), Code: common.FromHex("600054600101806000556000526020600060a000")},
+		parallelPeeker:  {Balance: big.NewInt(0), Code: common.FromHex("413160005500")},
+		parallelBomb:    {Balance: big.NewInt(1000000), Code: common.FromHex("33ff")},
+	}
+	for i := range keys {
+		keys[i], _ = crypto.ToECDSA(crypto.Keccak256([]byte{byte(i)}))
+		alloc[crypto.PubkeyToAddress(keys[i].PublicKey)] = GenesisAccount{Balance: big.NewInt(1000000000000000000)}
+	}
+	var (
+		db, _   = lemodb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: alloc}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainId)
+	)
+	chain, _ := GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), db, blocks, func(i int, block *BlockGen) {
+		block.SetCoinbase(parallelCoinbase)
+
+		send := func(key *ecdsa.PrivateKey, to *common.Address, amount int64, gas uint64, data []byte) {
+			var (
+				nonce = block.TxNonce(crypto.PubkeyToAddress(key.PublicKey))
+				tx    *types.Transaction
+			)
+			if to == nil {
+				tx = types.NewContractCreation(nonce, big.NewInt(amount), gas, big.NewInt(1), data)
+			} else {
+				tx = types.NewTransaction(nonce, *to, big.NewInt(amount), gas, big.NewInt(1), data)
+			}
+			tx, err := types.SignTx(tx, signer, key)
+			if err != nil {
+				t.Fatalf("failed to sign transaction: %v", err)
+			}
+			block.AddTx(tx)
+		}
+		// Independent transfers to fresh accounts from half of the senders
+		for j, key := range keys[:8] {
+			to := common.Address{byte(i), byte(j)}
+			send(key, &to, 1000, 21000, nil)
+		}
+		// Chained transactions of the same sender
+		for j := 0; j < 3; j++ {
+			to := common.Address{0xaa, byte(j)}
+			send(keys[0], &to, int64(j), 21000, nil)
+		}
+		// Contract calls sharing the same storage slot and emitting logs
+		send(keys[8], &parallelCounter, 0, 100000, nil)
+		send(keys[9], &parallelCounter, 0, 100000, nil)
+
+		switch i % 4 {
+		case 0:
+			send(keys[10], &parallelPeeker, 0, 100000, nil)
+		case 1:
+			send(keys[11], &parallelBomb, 0, 100000, nil)
+			send(keys[12], &parallelCoinbase, 1000, 21000, nil)
+		case 2:
+			send(keys[13], nil, 0, 100000, common.FromHex("600160005500"))
+		case 3:
+			empty := common.Address{0xee}
+			send(keys[14], &empty, 0, 21000, nil)
+		}
+	})
+	return gspec, db, chain
+}
+
+// Tests that processing blocks in parallel produces exactly the same receipts,
+// logs and state as processing them sequentially.
+func TestParallelProcessing(t *testing.T) {
+	gspec, db, blocks := parallelTestChain(t, 8)
+
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	defer blockchain.Stop()
+
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	for i, block := range blocks {
+		parent := blockchain.GetBlockByHash(block.ParentHash())
+
+		seqdb, _ := state.New(parent.Root(), state.NewDatabase(db))
+		seqReceipts, seqLogs, seqGas, err := blockchain.Processor().Process(block, seqdb, vm.Config{})
+		if err != nil {
+			t.Fatalf("block %d: sequential processing failed: %v", i, err)
+		}
+		pardb, _ := state.New(parent.Root(), state.NewDatabase(db))
+		parReceipts, parLogs, parGas, err := blockchain.Processor().Process(block, pardb, vm.Config{ParallelExecution: true})
+		if err != nil {
+			t.Fatalf("block %d: parallel processing failed: %v", i, err)
+		}
+		if parGas != seqGas {
+			t.Errorf("block %d: gas mismatch: have %d, want %d", i, parGas, seqGas)
+		}
+		if have, want := types.DeriveSha(parReceipts), types.DeriveSha(seqReceipts); have != want {
+			t.Errorf("block %d: receipt root mismatch: have %x, want %x", i, have, want)
+		}
+		for j := range seqReceipts {
+			if have, want := parReceipts[j].ContractAddress, seqReceipts[j].ContractAddress; have != want {
+				t.Errorf("block %d, receipt %d: contract address mismatch: have %x, want %x", i, j, have, want)
+			}
+		}
+		if len(parLogs) != len(seqLogs) {
+			t.Fatalf("block %d: log count mismatch: have %d, want %d", i, len(parLogs), len(seqLogs))
+		}
+		for j := range seqLogs {
+			have, want := parLogs[j], seqLogs[j]
+			if have.TxHash != want.TxHash || have.TxIndex != want.TxIndex || have.Index != want.Index || common.BytesToHash(have.Data) != common.BytesToHash(want.Data) {
+				t.Errorf("block %d, log %d: mismatch: have %+v, want %+v", i, j, have, want)
+			}
+		}
+		if have, want := pardb.IntermediateRoot(true), seqdb.IntermediateRoot(true); have != want {
+			t.Errorf("block %d: state root mismatch: have %x, want %x", i, have, want)
+		}
+		if have, want := pardb.IntermediateRoot(true), block.Root(); have != want {
+			t.Errorf("block %d: state root mismatch with header: have %x, want %x", i, have, want)
+		}
+	}
+}
+
+// Tests that a chain can be imported with parallel processing enabled, passing
+// the full block validation.
+func TestParallelImport(t *testing.T) {
+	gspec, _, blocks := parallelTestChain(t, 8)
+
+	db, _ := lemodb.NewMemDatabase()
+	gspec.MustCommit(db)
+
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, lemohash.NewFaker(), vm.Config{ParallelExecution: true})
+	defer blockchain.Stop()
+
+	if n, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	if have, want := blockchain.CurrentBlock().Hash(), blocks[len(blocks)-1].Hash(); have != want {
+		t.Errorf("head mismatch: have %x, want %x", have, want)
+	}
+}
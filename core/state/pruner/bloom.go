@@ -0,0 +1,165 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+)
+
+// stateBloomHashes is the number of hash functions used by the state bloom.
+// Since all the inserted keys are already cryptographic hashes, the individual
+// hash functions are simply distinct 8 byte slices of the key itself.
+const stateBloomHashes = common.HashLength / 8
+
+// errBloomCorrupted is returned if a persisted state bloom cannot be decoded.
+var errBloomCorrupted = errors.New("corrupted state bloom")
+
+// stateSet is the set of trie nodes and contract codes marked as reachable from
+// the pruning target.
+type stateSet interface {
+	// Put marks the given hash as reachable.
+	Put(hash common.Hash)
+
+	// Contain reports whether the given hash was marked as reachable.
+	Contain(hash common.Hash) bool
+}
+
+// exactSet is a stateSet tracking every marked hash in memory. It never reports
+// false positives, but its memory use grows with the size of the state.
+type exactSet map[common.Hash]struct{}
+
+func (s exactSet) Put(hash common.Hash) { s[hash] = struct{}{} }
+
+func (s exactSet) Contain(hash common.Hash) bool {
+	_, ok := s[hash]
+	return ok
+}
+
+// stateBloom is a stateSet backed by a fixed size bloom filter. Its memory use
+// is bounded, at the cost of occasionally retaining an unreachable entry.
+type stateBloom struct {
+	bits []uint64 // Bit vector of the filter
+}
+
+// newStateBloom creates a state bloom occupying the given number of megabytes.
+func newStateBloom(size uint64) *stateBloom {
+	words := size * 1024 * 1024 / 8
+	if words == 0 {
+		words = 1
+	}
+	return &stateBloom{bits: make([]uint64, words)}
+}
+
+// positions returns the bit indexes the given hash maps to.
+func (b *stateBloom) positions(hash common.Hash) [stateBloomHashes]uint64 {
+	var (
+		pos  [stateBloomHashes]uint64
+		size = uint64(len(b.bits)) * 64
+	)
+	for i := 0; i < stateBloomHashes; i++ {
+		pos[i] = binary.BigEndian.Uint64(hash[i*8:]) % size
+	}
+	return pos
+}
+
+func (b *stateBloom) Put(hash common.Hash) {
+	for _, pos := range b.positions(hash) {
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (b *stateBloom) Contain(hash common.Hash) bool {
+	for _, pos := range b.positions(hash) {
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// commit flushes the bloom filter into the given file. The data is written into
+// a temporary file first and moved into place afterwards, so an interruption
+// never leaves a partial filter behind.
+func (b *stateBloom) commit(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	buf := make([]byte, 8)
+
+	binary.BigEndian.PutUint64(buf, uint64(len(b.bits)))
+	if _, err := w.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	for _, word := range b.bits {
+		binary.BigEndian.PutUint64(buf, word)
+		if _, err := w.Write(buf); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadStateBloom reads back a bloom filter previously flushed by commit.
+func loadStateBloom(path string) (*stateBloom, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, errBloomCorrupted
+	}
+	words := binary.BigEndian.Uint64(buf)
+	if stat, err := f.Stat(); err != nil {
+		return nil, err
+	} else if words == 0 || uint64(stat.Size()) != (words+1)*8 {
+		return nil, errBloomCorrupted
+	}
+	bloom := &stateBloom{bits: make([]uint64, words)}
+	for i := range bloom.bits {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, errBloomCorrupted
+		}
+		bloom.bits[i] = binary.BigEndian.Uint64(buf)
+	}
+	return bloom, nil
+}
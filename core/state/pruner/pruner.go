@@ -0,0 +1,272 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pruner implements offline pruning of the state stored in the
+// chain database.
+package pruner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+)
+
+// pruneRootKey tracks the target state root of an interrupted pruning, so that
+// a restarted pruning can resume towards the same target.
+var pruneRootKey = []byte("PruneStateRoot")
+
+// Pruner deletes every trie node and contract code from the database that is
+// not reachable from a target state root. Pruning is meant to be run offline,
+// while no node is using the database.
+//
+// Pruning happens in two phases: the marking phase walks the target state and
+// records all reachable entries, the sweeping phase iterates over the database
+// and deletes everything that was not marked. The marked set is either held
+// exactly in memory, or in a fixed size bloom filter which is also persisted to
+// disk, so an interrupted sweep can be resumed without repeating the marking.
+type Pruner struct {
+	db        lemodb.Database
+	datadir   string // Directory to persist the state bloom into
+	bloomSize uint64 // Size of the state bloom in megabytes (0 = exact marking)
+}
+
+// NewPruner creates a state pruner operating on the given database. If bloomSize
+// is non-zero, reachable state is tracked in a bloom filter of that many
+// megabytes, persisted into datadir.
+func NewPruner(db lemodb.Database, datadir string, bloomSize uint64) *Pruner {
+	return &Pruner{
+		db:        db,
+		datadir:   datadir,
+		bloomSize: bloomSize,
+	}
+}
+
+// Prune deletes all state not reachable from the given root. If root is the
+// zero hash, a previously interrupted pruning is resumed, or the state of the
+// current head block is targeted if there is none. Besides the target, the
+// states of the current head block and the genesis block are always kept.
+func (p *Pruner) Prune(root common.Hash) error {
+	// Resolve the pruning target, refusing to change it mid-way
+	if stored := p.interrupted(); stored != (common.Hash{}) {
+		if root != (common.Hash{}) && root != stored {
+			return fmt.Errorf("interrupted pruning towards %x must be resumed first", stored)
+		}
+		log.Info("Resuming interrupted state pruning", "root", stored)
+		root = stored
+	}
+	if root == (common.Hash{}) {
+		if root = p.headRoot(); root == (common.Hash{}) {
+			return fmt.Errorf("no pruning target specified and head block unknown")
+		}
+	}
+	if _, err := state.New(root, state.NewDatabase(p.db)); err != nil {
+		return fmt.Errorf("target state %x missing: %v", root, err)
+	}
+	if err := p.db.Put(pruneRootKey, root.Bytes()); err != nil {
+		return err
+	}
+	// Mark all the reachable state, or reload the marks of an interrupted run
+	set, err := p.mark(root)
+	if err != nil {
+		return err
+	}
+	// Sweep all the unmarked entries and clean up after ourselves
+	if err := p.sweep(set); err != nil {
+		return err
+	}
+	if err := p.db.Delete(pruneRootKey); err != nil {
+		return err
+	}
+	if p.bloomSize > 0 {
+		os.Remove(p.bloomPath(root))
+	}
+	start := time.Now()
+	log.Info("Compacting database after pruning")
+	if err := p.db.Compact(nil, nil); err != nil {
+		return err
+	}
+	log.Info("Database compaction finished", "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// interrupted returns the target root of an interrupted pruning, or the zero
+// hash if there is none.
+func (p *Pruner) interrupted() common.Hash {
+	data, _ := p.db.Get(pruneRootKey)
+	if len(data) != common.HashLength {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// bloomPath returns the file the state bloom of the given target is persisted to.
+func (p *Pruner) bloomPath(root common.Hash) string {
+	return filepath.Join(p.datadir, fmt.Sprintf("statebloom.%x.bf", root.Bytes()))
+}
+
+// mark collects all trie nodes and contract codes reachable from the target root,
+// the current head state and the genesis state.
+func (p *Pruner) mark(root common.Hash) (stateSet, error) {
+	if p.bloomSize > 0 {
+		if bloom, err := loadStateBloom(p.bloomPath(root)); err == nil {
+			log.Info("Loaded state bloom of interrupted pruning", "root", root)
+			return bloom, nil
+		}
+	}
+	var set stateSet = make(exactSet)
+	if p.bloomSize > 0 {
+		set = newStateBloom(p.bloomSize)
+	}
+	if err := p.markState(set, root); err != nil {
+		return nil, err
+	}
+	for _, extra := range []common.Hash{p.headRoot(), p.genesisRoot()} {
+		if extra == root || extra == (common.Hash{}) {
+			continue
+		}
+		if _, err := state.New(extra, state.NewDatabase(p.db)); err != nil {
+			log.Warn("Retained state missing, skipping", "root", extra)
+			continue
+		}
+		if err := p.markState(set, extra); err != nil {
+			return nil, err
+		}
+	}
+	if bloom, ok := set.(*stateBloom); ok {
+		if err := bloom.commit(p.bloomPath(root)); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// headRoot returns the state root of the current head block, or the zero hash
+// if the database contains no chain.
+func (p *Pruner) headRoot() common.Hash {
+	hash := core.GetHeadBlockHash(p.db)
+	if hash == (common.Hash{}) {
+		return common.Hash{}
+	}
+	header := core.GetHeader(p.db, hash, core.GetBlockNumber(p.db, hash))
+	if header == nil {
+		return common.Hash{}
+	}
+	return header.Root
+}
+
+// genesisRoot returns the state root of the genesis block, or the zero hash if
+// the database contains no chain.
+func (p *Pruner) genesisRoot() common.Hash {
+	header := core.GetHeader(p.db, core.GetCanonicalHash(p.db, 0), 0)
+	if header == nil {
+		return common.Hash{}
+	}
+	return header.Root
+}
+
+// markState iterates over the state trie with the given root, including all the
+// storage tries and contract codes, and marks every entry in the set.
+func (p *Pruner) markState(set stateSet, root common.Hash) error {
+	statedb, err := state.New(root, state.NewDatabase(p.db))
+	if err != nil {
+		return err
+	}
+	var (
+		start   = time.Now()
+		logged  = time.Now()
+		entries int
+	)
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+		if it.Hash == (common.Hash{}) {
+			continue // Embedded node, not stored on its own
+		}
+		set.Put(it.Hash)
+		entries++
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Marking reachable state", "root", root, "entries", entries, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if it.Error != nil {
+		return it.Error
+	}
+	log.Info("Marked reachable state", "root", root, "entries", entries, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// sweep deletes every trie node and contract code from the database that is not
+// contained in the marked set. Trie nodes and codes are both stored keyed by the
+// hash of their content, which is how they are told apart from other entries.
+func (p *Pruner) sweep(set stateSet) error {
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		count  int
+		size   common.StorageSize
+		batch  = p.db.NewBatch()
+		it     = p.db.NewIterator()
+	)
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) != common.HashLength {
+			continue
+		}
+		if set.Contain(common.BytesToHash(key)) {
+			continue
+		}
+		value := it.Value()
+		if !bytes.Equal(crypto.Keccak256(value), key) {
+			continue
+		}
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+		count++
+		size += common.StorageSize(len(key) + len(value))
+
+		if batch.ValueSize() >= lemodb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning unreachable state", "count", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Pruned unreachable state", "count", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
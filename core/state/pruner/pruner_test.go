@@ -0,0 +1,177 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+)
+
+// makeTestStates creates two consecutive states on disk, the second overwriting
+// most of the first, and returns their roots.
+func makeTestStates(t *testing.T, db lemodb.Database) (common.Hash, common.Hash) {
+	var (
+		sdb   = state.NewDatabase(db)
+		root  common.Hash
+		roots []common.Hash
+		err   error
+	)
+	for round := byte(1); round <= 2; round++ {
+		statedb, _ := state.New(root, sdb)
+		for i := byte(0); i < 64; i++ {
+			addr := common.BytesToAddress([]byte{i})
+			statedb.AddBalance(addr, big.NewInt(int64(round)))
+			statedb.SetState(addr, common.Hash{i}, common.Hash{round, i})
+			if i%8 == 0 {
+				statedb.SetCode(addr, []byte{round, i, 0x60, 0x00})
+			}
+		}
+		root, err = statedb.Commit(false)
+		if err != nil {
+			t.Fatalf("failed to commit state: %v", err)
+		}
+		if err := sdb.TrieDB().Commit(root, false); err != nil {
+			t.Fatalf("failed to flush state: %v", err)
+		}
+		roots = append(roots, root)
+	}
+	return roots[0], roots[1]
+}
+
+// checkStateComplete iterates over the entire state and fails if anything is missing.
+func checkStateComplete(t *testing.T, db lemodb.Database, root common.Hash) {
+	statedb, err := state.New(root, state.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("state %x missing: %v", root, err)
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("state %x incomplete: %v", root, it.Error)
+	}
+}
+
+func testPrune(t *testing.T, bloomSize uint64) {
+	dir, err := ioutil.TempDir("", "pruner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := lemodb.NewLDBDatabase(filepath.Join(dir, "chaindata"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	stale, target := makeTestStates(t, db)
+	if err := NewPruner(db, dir, bloomSize).Prune(target); err != nil {
+		t.Fatalf("failed to prune state: %v", err)
+	}
+	checkStateComplete(t, db, target)
+	if _, err := state.New(stale, state.NewDatabase(db)); err == nil {
+		t.Fatalf("stale state %x not pruned", stale)
+	}
+	if has, _ := db.Has(pruneRootKey); has {
+		t.Fatalf("pruning marker not cleaned up")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "statebloom.*")); len(files) != 0 {
+		t.Fatalf("state bloom not cleaned up: %v", files)
+	}
+}
+
+// Tests that pruning removes unreachable state and keeps the target intact.
+func TestPruneExact(t *testing.T) { testPrune(t, 0) }
+func TestPruneBloom(t *testing.T) { testPrune(t, 1) }
+
+// Tests that an interrupted pruning is resumed towards the original target and
+// that changing the target of an interrupted pruning is refused.
+func TestPruneResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := lemodb.NewLDBDatabase(filepath.Join(dir, "chaindata"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	stale, target := makeTestStates(t, db)
+
+	// Simulate a crash right after the marking phase finished
+	pruner := NewPruner(db, dir, 1)
+	db.Put(pruneRootKey, target.Bytes())
+	set, err := pruner.mark(target)
+	if err != nil {
+		t.Fatalf("failed to mark state: %v", err)
+	}
+	if !set.Contain(target) {
+		t.Fatalf("target root not marked")
+	}
+	if err := pruner.Prune(stale); err == nil {
+		t.Fatalf("retargeted interrupted pruning")
+	}
+	if err := pruner.Prune(common.Hash{}); err != nil {
+		t.Fatalf("failed to resume pruning: %v", err)
+	}
+	checkStateComplete(t, db, target)
+	if _, err := state.New(stale, state.NewDatabase(db)); err == nil {
+		t.Fatalf("stale state %x not pruned", stale)
+	}
+}
+
+// Tests that a state bloom survives a round trip through the disk.
+func TestStateBloomCommitLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statebloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bloom := newStateBloom(1)
+	for i := byte(0); i < 100; i++ {
+		bloom.Put(common.Hash{i, i + 1, i + 2})
+	}
+	path := filepath.Join(dir, "bloom")
+	if err := bloom.commit(path); err != nil {
+		t.Fatalf("failed to commit bloom: %v", err)
+	}
+	loaded, err := loadStateBloom(path)
+	if err != nil {
+		t.Fatalf("failed to load bloom: %v", err)
+	}
+	for i := byte(0); i < 100; i++ {
+		if !loaded.Contain(common.Hash{i, i + 1, i + 2}) {
+			t.Fatalf("item %d missing from loaded bloom", i)
+		}
+	}
+	os.Truncate(path, 16)
+	if _, err := loadStateBloom(path); err != errBloomCorrupted {
+		t.Fatalf("corrupted bloom error mismatch: have %v, want %v", err, errBloomCorrupted)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+)
+
+// diskLayer is a low level persistent snapshot built on top of a key-value store.
+type diskLayer struct {
+	diskdb lemodb.Database // Key-value store containing the base snapshot
+	triedb *trie.Database  // Trie node cache for reconstruction purposes
+	root   common.Hash     // Root hash of the base snapshot
+	stale  bool            // Signals that the layer became stale (state progressed)
+
+	genMarker []byte           // Last account hash covered by the generator (nil = done, empty = nothing yet)
+	genAbort  chan chan []byte // Notification channel to abort generating the snapshot (nil = not generating)
+
+	lock sync.RWMutex
+}
+
+// loadSnapshot loads a pre-existing state snapshot backed by a key-value store
+// and the journalled diff layers on top, resuming its generation if it was
+// interrupted. The given root must be covered by one of the loaded layers.
+func loadSnapshot(diskdb lemodb.Database, triedb *trie.Database, root common.Hash) (map[common.Hash]snapshot, error) {
+	blob, _ := diskdb.Get(snapshotRootKey)
+	if len(blob) != common.HashLength {
+		return nil, errors.New("missing or corrupted snapshot")
+	}
+	base := &diskLayer{
+		diskdb: diskdb,
+		triedb: triedb,
+		root:   common.BytesToHash(blob),
+	}
+	layers := loadJournal(base, root)
+	if _, ok := layers[root]; !ok {
+		return nil, errors.New("head state doesn't match snapshot")
+	}
+	if marker, err := diskdb.Get(snapshotGeneratorKey); err == nil {
+		if marker == nil {
+			marker = []byte{}
+		}
+		log.Info("Resuming state snapshot generation", "root", base.root, "at", common.BytesToHash(marker))
+		base.genMarker = marker
+		base.genAbort = make(chan chan []byte)
+		go base.generate()
+	}
+	return layers, nil
+}
+
+// Root returns root hash for which this snapshot was made.
+func (dl *diskLayer) Root() common.Hash {
+	return dl.root
+}
+
+// Parent always returns nil as there's no layer below the disk.
+func (dl *diskLayer) Parent() snapshot {
+	return nil
+}
+
+// Stale return whether this layer has become stale (was flattened across) or if
+// it's still live.
+func (dl *diskLayer) Stale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+// markStale sets the stale flag, invalidating the layer for any further reads.
+func (dl *diskLayer) markStale() {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.stale = true
+}
+
+// AccountRLP directly retrieves the account RLP associated with a particular
+// hash in the snapshot.
+func (dl *diskLayer) AccountRLP(hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	// If the layer was flattened into, consider it invalid (any live reference to
+	// the original should be marked as unusable).
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	// If the layer is being generated, ensure the requested hash has already been
+	// covered by the generator.
+	if !coveredBy(dl.genMarker, hash) {
+		return nil, ErrNotCoveredYet
+	}
+	blob, _ := dl.diskdb.Get(accountSnapshotKey(hash))
+	if len(blob) == 0 {
+		return nil, nil
+	}
+	return blob, nil
+}
+
+// Storage directly retrieves the storage data associated with a particular hash,
+// within a particular account.
+func (dl *diskLayer) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	if !coveredBy(dl.genMarker, accountHash) {
+		return nil, ErrNotCoveredYet
+	}
+	blob, _ := dl.diskdb.Get(storageSnapshotKey(accountHash, storageHash))
+	if len(blob) == 0 {
+		return nil, nil
+	}
+	return blob, nil
+}
+
+// flatten writes the changes of the given diff layer, built directly on top of
+// this disk layer, into the persistent database and returns a new disk layer for
+// the resulting state. Both the current disk layer and the diff are invalidated.
+//
+// If the snapshot is still being generated, only the changes within the range
+// already covered are written; the rest is picked up by the generator, which is
+// resumed on top of the new disk layer.
+func (dl *diskLayer) flatten(diff *diffLayer) *diskLayer {
+	marker := dl.stopGeneration()
+
+	dl.markStale()
+	diff.markStale()
+
+	batch := dl.diskdb.NewBatch()
+	for hash := range diff.destructSet {
+		if !coveredBy(marker, hash) {
+			continue
+		}
+		batch.Delete(accountSnapshotKey(hash))
+
+		prefix := append(append([]byte{}, snapshotStoragePrefix...), hash.Bytes()...)
+		err := iterateKeys(dl.diskdb, prefix, len(prefix)+common.HashLength, func(key []byte) {
+			batch.Delete(key)
+		})
+		if err != nil {
+			log.Crit("Failed to wipe destructed account storage", "err", err)
+		}
+	}
+	for hash, data := range diff.accountData {
+		if coveredBy(marker, hash) {
+			batch.Put(accountSnapshotKey(hash), data)
+		}
+	}
+	for accountHash, storage := range diff.storageData {
+		if !coveredBy(marker, accountHash) {
+			continue
+		}
+		for storageHash, data := range storage {
+			if len(data) == 0 {
+				batch.Delete(storageSnapshotKey(accountHash, storageHash))
+			} else {
+				batch.Put(storageSnapshotKey(accountHash, storageHash), data)
+			}
+		}
+	}
+	batch.Put(snapshotRootKey, diff.root.Bytes())
+	if marker != nil {
+		batch.Put(snapshotGeneratorKey, marker)
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write flattened snapshot", "err", err)
+	}
+	res := &diskLayer{
+		diskdb:    dl.diskdb,
+		triedb:    dl.triedb,
+		root:      diff.root,
+		genMarker: marker,
+	}
+	if marker != nil {
+		res.genAbort = make(chan chan []byte)
+		go res.generate()
+	}
+	return res
+}
+
+// stopGeneration aborts the snapshot generator running on this layer, if any,
+// and returns the marker of the generation progress (nil if done).
+func (dl *diskLayer) stopGeneration() []byte {
+	if dl.genAbort != nil {
+		abort := make(chan []byte)
+		dl.genAbort <- abort
+		<-abort
+		dl.genAbort = nil
+	}
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.genMarker
+}
@@ -0,0 +1,214 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+)
+
+// emptyRoot is the known root hash of an empty trie.
+var emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+// generateSnapshot wipes any previously persisted snapshot data and starts the
+// regeneration of the snapshot for the given state root on a background thread.
+func generateSnapshot(diskdb lemodb.Database, triedb *trie.Database, root common.Hash) *diskLayer {
+	batch := diskdb.NewBatch()
+	for _, prefix := range []struct {
+		key    []byte
+		keylen int
+	}{
+		{snapshotAccountPrefix, len(snapshotAccountPrefix) + common.HashLength},
+		{snapshotStoragePrefix, len(snapshotStoragePrefix) + 2*common.HashLength},
+	} {
+		err := iterateKeys(diskdb, prefix.key, prefix.keylen, func(key []byte) {
+			batch.Delete(key)
+			if batch.ValueSize() >= lemodb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					log.Crit("Failed to wipe state snapshot", "err", err)
+				}
+				batch.Reset()
+			}
+		})
+		if err != nil {
+			log.Crit("Failed to wipe state snapshot", "err", err)
+		}
+	}
+	batch.Delete(snapshotJournalKey)
+	batch.Put(snapshotRootKey, root.Bytes())
+	batch.Put(snapshotGeneratorKey, []byte{})
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to initialise state snapshot", "err", err)
+	}
+	base := &diskLayer{
+		diskdb:    diskdb,
+		triedb:    triedb,
+		root:      root,
+		genMarker: []byte{},
+		genAbort:  make(chan chan []byte),
+	}
+	go base.generate()
+	return base
+}
+
+// generate is a background thread that iterates over the state and storage tries
+// and constructs the state snapshot, starting after the current progress marker.
+// Since the disk layer is replaced whenever diff layers are flattened into it,
+// the generation is often aborted and restarted on top of the new layer.
+//
+// Accounts are only ever persisted together with their entire storage and the
+// progress marker in the same batch, so the covered range of the snapshot is
+// always complete, even after a crash.
+func (dl *diskLayer) generate() {
+	dl.lock.RLock()
+	marker := dl.genMarker
+	dl.lock.RUnlock()
+
+	var (
+		start    = time.Now()
+		logged   = time.Now()
+		accounts int
+		slots    int
+		batch    = dl.diskdb.NewBatch()
+	)
+	// flush persists all the fully generated accounts and the progress marker
+	flush := func() error {
+		batch.Put(snapshotGeneratorKey, marker)
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+
+		dl.lock.Lock()
+		dl.genMarker = marker
+		dl.lock.Unlock()
+		return nil
+	}
+	// fail logs a generation failure and waits for the layer to be discarded,
+	// the generation is restarted with the next layer on top of the last flush.
+	fail := func(err error) {
+		log.Warn("State snapshot generation failed", "root", dl.root, "err", err)
+
+		abort := <-dl.genAbort
+		abort <- nil
+	}
+	accTrie, err := trie.New(dl.root, dl.triedb)
+	if err != nil {
+		fail(err)
+		return
+	}
+	// If the generation starts from scratch, the account root is recomputed too
+	var accStack *trie.StackTrie
+	if len(marker) == 0 {
+		accStack = trie.NewStackTrie(nil)
+	}
+	it := trie.NewIterator(accTrie.NodeIterator(marker))
+	for it.Next() {
+		accountHash := common.BytesToHash(it.Key)
+		if len(marker) > 0 && accountHash == common.BytesToHash(marker) {
+			continue // Last generated account before a restart
+		}
+		// Check whether we've been asked to stop at every account boundary
+		select {
+		case abort := <-dl.genAbort:
+			if err := flush(); err != nil {
+				log.Error("Failed to write state snapshot", "err", err)
+			}
+			abort <- nil
+			return
+		default:
+		}
+		var acc Account
+		if err := rlp.DecodeBytes(it.Value, &acc); err != nil {
+			fail(err)
+			return
+		}
+		batch.Put(accountSnapshotKey(accountHash), it.Value)
+		if accStack != nil {
+			accStack.Update(it.Key, it.Value)
+		}
+
+		if acc.Root != emptyRoot {
+			storeTrie, err := trie.New(acc.Root, dl.triedb)
+			if err != nil {
+				fail(err)
+				return
+			}
+			// Recompute the storage root from the iterated slots on the fly, so
+			// that a damaged storage trie isn't silently baked into the snapshot
+			storeIt := trie.NewIterator(storeTrie.NodeIterator(nil))
+			stack := trie.NewStackTrie(nil)
+			for storeIt.Next() {
+				batch.Put(storageSnapshotKey(accountHash, common.BytesToHash(storeIt.Key)), storeIt.Value)
+				stack.Update(storeIt.Key, storeIt.Value)
+				slots++
+			}
+			if storeIt.Err != nil {
+				fail(storeIt.Err)
+				return
+			}
+			if root := stack.Hash(); root != acc.Root {
+				fail(fmt.Errorf("storage root mismatch for account %x: have %x, want %x", accountHash, root, acc.Root))
+				return
+			}
+		}
+		accounts++
+		marker = accountHash.Bytes()
+
+		if batch.ValueSize() >= lemodb.IdealBatchSize {
+			if err := flush(); err != nil {
+				fail(err)
+				return
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Generating state snapshot", "root", dl.root, "at", accountHash, "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if it.Err != nil {
+		fail(it.Err)
+		return
+	}
+	if accStack != nil {
+		if root := accStack.Hash(); root != dl.root {
+			fail(fmt.Errorf("account root mismatch: have %x, want %x", root, dl.root))
+			return
+		}
+	}
+	// Snapshot fully generated, persist the remainder and mark it complete
+	batch.Delete(snapshotGeneratorKey)
+	if err := batch.Write(); err != nil {
+		fail(err)
+		return
+	}
+	dl.lock.Lock()
+	dl.genMarker = nil
+	dl.lock.Unlock()
+
+	log.Info("Generated state snapshot", "root", dl.root, "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// Someone will be looking for us, wait it out
+	abort := <-dl.genAbort
+	abort <- nil
+}
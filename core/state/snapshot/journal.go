@@ -0,0 +1,180 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"fmt"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+)
+
+// journal is the persisted form of the diff layers above the disk layer, stored
+// on shutdown so the tree can be restored on the next startup.
+type journal struct {
+	Base   common.Hash    // Root of the disk layer the diffs were built on
+	Layers []journalLayer // Diff layers from the bottom to the top
+}
+
+// journalLayer is the persisted form of a single diff layer.
+type journalLayer struct {
+	Root      common.Hash
+	Destructs []common.Hash
+	Accounts  []journalAccount
+	Storage   []journalStorage
+}
+
+// journalAccount is an account entry of a persisted diff layer.
+type journalAccount struct {
+	Hash common.Hash
+	Blob []byte
+}
+
+// journalStorage is the set of storage slots of an account in a persisted diff
+// layer. Deleted slots are stored with an empty value.
+type journalStorage struct {
+	Hash common.Hash
+	Keys []common.Hash
+	Vals [][]byte
+}
+
+// Journal suspends any running snapshot generation, saving its progress, and
+// persists the diff layers between the given root and the disk layer, so that
+// they can be restored on the next startup instead of regenerating the snapshot.
+// It's meant to be called on shutdown, the tree must not be used afterwards.
+func (t *Tree) Journal(root common.Hash) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, layer := range t.layers {
+		if disk, ok := layer.(*diskLayer); ok {
+			disk.stopGeneration()
+		}
+	}
+	snap, ok := t.layers[root]
+	if !ok {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	// Gather all the diff layers from the requested one down to the disk layer
+	var diffs []*diffLayer
+	for {
+		diff, ok := snap.(*diffLayer)
+		if !ok {
+			break
+		}
+		diffs = append(diffs, diff)
+		snap = diff.Parent()
+	}
+	enc := journal{Base: snap.Root()}
+	for i := len(diffs) - 1; i >= 0; i-- {
+		enc.Layers = append(enc.Layers, diffs[i].journal())
+	}
+	blob, err := rlp.EncodeToBytes(enc)
+	if err != nil {
+		return err
+	}
+	if err := t.diskdb.Put(snapshotJournalKey, blob); err != nil {
+		return err
+	}
+	log.Info("Persisted state snapshot journal", "disk", enc.Base, "diffs", len(enc.Layers))
+	return nil
+}
+
+// journal converts the diff layer into its persisted form.
+func (dl *diffLayer) journal() journalLayer {
+	layer := journalLayer{Root: dl.root}
+	for hash := range dl.destructSet {
+		layer.Destructs = append(layer.Destructs, hash)
+	}
+	for hash, blob := range dl.accountData {
+		layer.Accounts = append(layer.Accounts, journalAccount{Hash: hash, Blob: blob})
+	}
+	for hash, slots := range dl.storageData {
+		storage := journalStorage{Hash: hash}
+		for key, val := range slots {
+			storage.Keys = append(storage.Keys, key)
+			storage.Vals = append(storage.Vals, val)
+		}
+		layer.Storage = append(layer.Storage, storage)
+	}
+	return layer
+}
+
+// loadJournal restores the journalled diff layers on top of the given disk
+// layer, up to the given root. Journals built on a different disk layer (e.g.
+// one left over from a crash) are ignored.
+func loadJournal(base *diskLayer, root common.Hash) map[common.Hash]snapshot {
+	layers := map[common.Hash]snapshot{base.root: base}
+
+	blob, err := base.diskdb.Get(snapshotJournalKey)
+	if err != nil || len(blob) == 0 {
+		return layers
+	}
+	var dec journal
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		log.Warn("Failed to decode state snapshot journal", "err", err)
+		return layers
+	}
+	if dec.Base != base.root {
+		log.Debug("Ignoring stale state snapshot journal", "base", dec.Base, "disk", base.root)
+		return layers
+	}
+	for _, layer := range dec.Layers {
+		for _, entry := range layer.Storage {
+			if len(entry.Keys) != len(entry.Vals) {
+				log.Warn("Ignoring corrupted state snapshot journal", "root", layer.Root)
+				return layers
+			}
+		}
+	}
+	var parent snapshot = base
+	for _, layer := range dec.Layers {
+		if parent.Root() == root {
+			break
+		}
+		destructs := make(map[common.Hash]struct{}, len(layer.Destructs))
+		for _, hash := range layer.Destructs {
+			destructs[hash] = struct{}{}
+		}
+		accounts := make(map[common.Hash][]byte, len(layer.Accounts))
+		for _, account := range layer.Accounts {
+			accounts[account.Hash] = nilIfEmpty(account.Blob)
+		}
+		storage := make(map[common.Hash]map[common.Hash][]byte, len(layer.Storage))
+		for _, entry := range layer.Storage {
+			slots := make(map[common.Hash][]byte, len(entry.Keys))
+			for i, key := range entry.Keys {
+				slots[key] = nilIfEmpty(entry.Vals[i])
+			}
+			storage[entry.Hash] = slots
+		}
+		diff := newDiffLayer(parent, layer.Root, destructs, accounts, storage)
+		layers[diff.root] = diff
+		parent = diff
+	}
+	return layers
+}
+
+// nilIfEmpty converts an empty decoded value back into the nil marker of missing
+// data used by the diff layers.
+func nilIfEmpty(blob []byte) []byte {
+	if len(blob) == 0 {
+		return nil
+	}
+	return blob
+}
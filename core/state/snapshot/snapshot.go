@@ -0,0 +1,294 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package snapshot implements a flat key-value view of the state, accelerating
+// account and storage reads compared to walking the state tries.
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+)
+
+var (
+	// ErrSnapshotStale is returned from data accessors if the underlying snapshot
+	// layer had been invalidated due to the chain progressing forward far enough
+	// to not maintain the layer's original state.
+	ErrSnapshotStale = errors.New("snapshot stale")
+
+	// ErrNotCoveredYet is returned from data accessors if the underlying snapshot
+	// is being generated currently and the requested data item is not yet in the
+	// range of accounts covered.
+	ErrNotCoveredYet = errors.New("not covered yet")
+
+	// errSnapshotCycle is returned if a snapshot is attempted to be inserted
+	// that forms a cycle in the snapshot tree.
+	errSnapshotCycle = errors.New("snapshot cycle")
+)
+
+// The fields below define the low level database schema of the snapshot.
+//
+// Account entries are keyed by the hash of the account address and storage
+// entries by the account hash followed by the hash of the storage slot. Since
+// trie nodes are stored keyed by their plain 32 byte hash, snapshot entries are
+// told apart from them by their lengths.
+var (
+	snapshotRootKey      = []byte("SnapshotRoot")      // Root hash of the state the persisted snapshot represents
+	snapshotGeneratorKey = []byte("SnapshotGenerator") // Progress marker of an unfinished snapshot generation
+	snapshotJournalKey   = []byte("SnapshotJournal")   // Diff layers above the persisted snapshot, stored on shutdown
+
+	snapshotAccountPrefix = []byte("a") // snapshotAccountPrefix + account hash -> account trie value
+	snapshotStoragePrefix = []byte("o") // snapshotStoragePrefix + account hash + storage hash -> storage trie value
+)
+
+// accountSnapshotKey = snapshotAccountPrefix + hash
+func accountSnapshotKey(hash common.Hash) []byte {
+	return append(append([]byte{}, snapshotAccountPrefix...), hash.Bytes()...)
+}
+
+// storageSnapshotKey = snapshotStoragePrefix + account hash + storage hash
+func storageSnapshotKey(accountHash, storageHash common.Hash) []byte {
+	return append(append(append([]byte{}, snapshotStoragePrefix...), accountHash.Bytes()...), storageHash.Bytes()...)
+}
+
+// Account is the consensus representation of accounts as stored in the state
+// trie and in the snapshot. It mirrors state.Account.
+type Account struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash []byte
+}
+
+// Snapshot represents the functionality supported by a snapshot storage layer.
+type Snapshot interface {
+	// Root returns the root hash for which this snapshot was made.
+	Root() common.Hash
+
+	// AccountRLP directly retrieves the account RLP associated with a particular
+	// hash in the snapshot. A nil blob with a nil error means the account does
+	// not exist.
+	AccountRLP(hash common.Hash) ([]byte, error)
+
+	// Storage directly retrieves the RLP encoded storage data associated with a
+	// particular hash, within a particular account. A nil blob with a nil error
+	// means the slot is empty.
+	Storage(accountHash, storageHash common.Hash) ([]byte, error)
+}
+
+// snapshot is the internal version of the snapshot data layer that supports
+// walking the layer hierarchy.
+type snapshot interface {
+	Snapshot
+
+	// Parent returns the subsequent layer of a snapshot, or nil if the base was
+	// reached.
+	Parent() snapshot
+
+	// Stale returns whether this layer has become stale (was flattened across)
+	// or if it's still live.
+	Stale() bool
+}
+
+// Tree is an Lemochain state snapshot tree. It consists of one persistent base
+// layer backed by a key-value store, on top of which arbitrarily many in-memory
+// diff layers are topped. The memory diffs can form a tree with branching, but
+// the disk layer is singleton and common to all. If a reorg goes deeper than the
+// disk layer, everything needs to be regenerated.
+//
+// The goal of a state snapshot is to allow direct access to account and storage
+// data to avoid expensive multi-level trie lookups.
+type Tree struct {
+	diskdb lemodb.Database          // Persistent database to store the snapshot
+	triedb *trie.Database           // In-memory cache to access the trie through
+	layers map[common.Hash]snapshot // Collection of all known layers
+	lock   sync.RWMutex
+}
+
+// New attempts to load an already existing snapshot from a persistent key-value
+// store together with the journal of its diff layers, ensuring that the head of
+// the snapshot matches the expected one.
+//
+// If the snapshot is missing or inconsistent, the entirety is deleted and will
+// be reconstructed from scratch based on the tries in the key-value store, on a
+// background thread.
+func New(diskdb lemodb.Database, triedb *trie.Database, root common.Hash) *Tree {
+	layers, err := loadSnapshot(diskdb, triedb, root)
+	if err != nil {
+		log.Warn("Failed to load state snapshot, regenerating", "err", err)
+		base := generateSnapshot(diskdb, triedb, root)
+		layers = map[common.Hash]snapshot{base.root: base}
+	}
+	return &Tree{
+		diskdb: diskdb,
+		triedb: triedb,
+		layers: layers,
+	}
+}
+
+// Snapshot retrieves a snapshot belonging to the given block root, or nil if no
+// snapshot is maintained for that block.
+func (t *Tree) Snapshot(blockRoot common.Hash) Snapshot {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if layer, ok := t.layers[blockRoot]; ok {
+		return layer
+	}
+	return nil
+}
+
+// Update adds a new snapshot into the tree, if that can be linked to an existing
+// old parent. It is disallowed to insert a disk layer (the origin of all).
+func (t *Tree) Update(blockRoot common.Hash, parentRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) error {
+	// Reject noop updates to avoid self-loops in the snapshot tree
+	if blockRoot == parentRoot {
+		return errSnapshotCycle
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	parent, ok := t.layers[parentRoot]
+	if !ok {
+		return fmt.Errorf("parent [%#x] snapshot missing", parentRoot)
+	}
+	if _, ok := t.layers[blockRoot]; ok {
+		return nil // Same state reached through a different block, keep the old layer
+	}
+	t.layers[blockRoot] = newDiffLayer(parent, blockRoot, destructs, accounts, storage)
+	return nil
+}
+
+// Cap traverses downwards the snapshot tree from a head block hash until the
+// number of allowed layers are crossed. All layers beyond the permitted number
+// are flattened downwards into the disk layer, and all layers not descending
+// from the new disk layer are discarded.
+func (t *Tree) Cap(root common.Hash, layers int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	snap, ok := t.layers[root]
+	if !ok {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	// Gather all the diff layers from the requested one down to the disk layer
+	var (
+		chain []*diffLayer
+		base  *diskLayer
+	)
+	for layer := snap; base == nil; {
+		switch layer := layer.(type) {
+		case *diffLayer:
+			chain = append(chain, layer)
+		case *diskLayer:
+			base = layer
+		}
+		layer = layer.Parent()
+	}
+	if len(chain) <= layers {
+		return nil
+	}
+	// Flatten all the layers beyond the permitted number into the disk, bottom up
+	for i := len(chain) - 1; i >= layers; i-- {
+		base = base.flatten(chain[i])
+	}
+	// Relink any layer built on top of the last flattened one to the new disk
+	// layer, and drop every layer not descending from the new disk layer.
+	top := chain[layers]
+	for _, layer := range t.layers {
+		if diff, ok := layer.(*diffLayer); ok && diff.Parent() == snapshot(top) {
+			diff.setParent(base)
+		}
+	}
+	remaining := map[common.Hash]snapshot{base.root: base}
+	for root, layer := range t.layers {
+		diff, ok := layer.(*diffLayer)
+		if !ok || diff.Stale() {
+			continue
+		}
+		if descendsFrom(diff, base) {
+			remaining[root] = diff
+		} else {
+			diff.markStale()
+		}
+	}
+	t.layers = remaining
+	return nil
+}
+
+// descendsFrom reports whether the given diff layer is built on top of the given
+// disk layer.
+func descendsFrom(diff *diffLayer, base *diskLayer) bool {
+	var layer snapshot = diff
+	for {
+		parent := layer.Parent()
+		if parent == nil {
+			return layer == snapshot(base)
+		}
+		if parent.Stale() {
+			return false
+		}
+		layer = parent
+	}
+}
+
+// Rebuild wipes all available snapshot data from the persistent database and
+// discards all caches and diff layers. Afterwards, it starts a new snapshot
+// generator with the given root hash.
+func (t *Tree) Rebuild(root common.Hash) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, layer := range t.layers {
+		switch layer := layer.(type) {
+		case *diskLayer:
+			layer.stopGeneration()
+			layer.markStale()
+		case *diffLayer:
+			layer.markStale()
+		}
+	}
+	log.Info("Rebuilding state snapshot", "root", root)
+	t.layers = map[common.Hash]snapshot{root: generateSnapshot(t.diskdb, t.triedb, root)}
+}
+
+// coveredBy reports whether an account with the given hash is contained within
+// the part of the snapshot already generated up to the given marker.
+func coveredBy(marker []byte, hash common.Hash) bool {
+	return marker == nil || (len(marker) > 0 && bytes.Compare(hash[:], marker) <= 0)
+}
+
+// iterateKeys invokes the callback for every key of the given length in the
+// database starting with the given prefix.
+func iterateKeys(db lemodb.Database, prefix []byte, keylen int, fn func(key []byte)) error {
+	it := db.NewIteratorWithPrefix(prefix)
+	defer it.Release()
+
+	for it.Next() {
+		if key := it.Key(); len(key) == keylen {
+			fn(common.CopyBytes(key))
+		}
+	}
+	return it.Error()
+}
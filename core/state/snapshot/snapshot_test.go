@@ -0,0 +1,314 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+)
+
+// makeTestState creates a state on disk with a few accounts, every other one
+// having some storage, and returns its root.
+func makeTestState(t *testing.T, triedb *trie.Database) common.Hash {
+	accTrie, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+	for i := byte(0); i < 32; i++ {
+		acc := Account{Nonce: uint64(i), Balance: big.NewInt(int64(i)), Root: emptyRoot, CodeHash: crypto.Keccak256(nil)}
+		if i%2 == 0 {
+			storeTrie, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+			for j := byte(1); j <= 8; j++ {
+				val, _ := rlp.EncodeToBytes([]byte{i, j})
+				storeTrie.Update(common.Hash{j}.Bytes(), val)
+			}
+			root, err := storeTrie.Commit(nil)
+			if err != nil {
+				t.Fatalf("failed to commit storage trie: %v", err)
+			}
+			acc.Root = root
+		}
+		blob, _ := rlp.EncodeToBytes(acc)
+		accTrie.Update(common.BytesToAddress([]byte{i}).Bytes(), blob)
+	}
+	root, err := accTrie.Commit(nil)
+	if err != nil {
+		t.Fatalf("failed to commit account trie: %v", err)
+	}
+	if err := triedb.Commit(root, false); err != nil {
+		t.Fatalf("failed to flush state: %v", err)
+	}
+	return root
+}
+
+// waitGeneration blocks until the disk layer of the tree finished generating.
+func waitGeneration(t *testing.T, snaps *Tree, root common.Hash) *diskLayer {
+	disk := snaps.layers[root].(*diskLayer)
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		disk.lock.RLock()
+		done := disk.genMarker == nil
+		disk.lock.RUnlock()
+		if done {
+			return disk
+		}
+	}
+	t.Fatalf("snapshot generation timed out")
+	return nil
+}
+
+// Tests that a snapshot generated from the state tries contains exactly the
+// same data as the tries.
+func TestGenerateSnapshot(t *testing.T) {
+	var (
+		diskdb, _ = lemodb.NewMemDatabase()
+		triedb    = trie.NewDatabase(diskdb)
+		root      = makeTestState(t, triedb)
+	)
+	snaps := New(diskdb, triedb, root)
+	waitGeneration(t, snaps, root)
+
+	if _, err := diskdb.Get(snapshotGeneratorKey); err == nil {
+		t.Errorf("generator marker not deleted after generation")
+	}
+	snap := snaps.Snapshot(root)
+	accTrie, _ := trie.NewSecure(root, triedb, 0)
+	for i := byte(0); i < 32; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		blob, err := snap.AccountRLP(crypto.Keccak256Hash(addr[:]))
+		if err != nil {
+			t.Fatalf("account %d: failed to read snapshot: %v", i, err)
+		}
+		if want := accTrie.Get(addr[:]); !bytes.Equal(blob, want) {
+			t.Errorf("account %d: snapshot mismatch: have %x, want %x", i, blob, want)
+		}
+		for j := byte(1); j <= 8; j++ {
+			blob, err := snap.Storage(crypto.Keccak256Hash(addr[:]), crypto.Keccak256Hash(common.Hash{j}.Bytes()))
+			if err != nil {
+				t.Fatalf("slot %d/%d: failed to read snapshot: %v", i, j, err)
+			}
+			var want []byte
+			if i%2 == 0 {
+				want, _ = rlp.EncodeToBytes([]byte{i, j})
+			}
+			if !bytes.Equal(blob, want) {
+				t.Errorf("slot %d/%d: snapshot mismatch: have %x, want %x", i, j, blob, want)
+			}
+		}
+	}
+	// Reopening the tree on the same root must load instead of regenerate
+	if _, err := loadSnapshot(diskdb, triedb, root); err != nil {
+		t.Errorf("failed to load generated snapshot: %v", err)
+	}
+	if _, err := loadSnapshot(diskdb, triedb, common.Hash{0x01}); err == nil {
+		t.Errorf("loaded snapshot for mismatching root")
+	}
+}
+
+// newTestTree creates a snapshot tree with a single, fully generated and empty
+// disk layer.
+func newTestTree(root common.Hash) (*Tree, lemodb.Database) {
+	diskdb, _ := lemodb.NewMemDatabase()
+	diskdb.Put(snapshotRootKey, root.Bytes())
+
+	base := &diskLayer{diskdb: diskdb, root: root}
+	return &Tree{diskdb: diskdb, layers: map[common.Hash]snapshot{root: base}}, diskdb
+}
+
+// Tests that diff layers shadow the data of their parents and that destructed
+// accounts hide any storage below them.
+func TestDiffLayerReads(t *testing.T) {
+	var (
+		acc1 = common.Hash{0x01}
+		acc2 = common.Hash{0x02}
+		slot = common.Hash{0xaa}
+	)
+	snaps, diskdb := newTestTree(common.Hash{0x00})
+	diskdb.Put(accountSnapshotKey(acc1), []byte("disk1"))
+	diskdb.Put(accountSnapshotKey(acc2), []byte("disk2"))
+	diskdb.Put(storageSnapshotKey(acc1, slot), []byte("diskslot1"))
+	diskdb.Put(storageSnapshotKey(acc2, slot), []byte("diskslot2"))
+
+	if err := snaps.Update(common.Hash{0x10}, common.Hash{0x00}, nil, map[common.Hash][]byte{acc1: []byte("diff1")}, nil); err != nil {
+		t.Fatalf("failed to create first diff layer: %v", err)
+	}
+	destructs := map[common.Hash]struct{}{acc2: {}}
+	if err := snaps.Update(common.Hash{0x20}, common.Hash{0x10}, destructs, nil, map[common.Hash]map[common.Hash][]byte{acc1: {slot: nil}}); err != nil {
+		t.Fatalf("failed to create second diff layer: %v", err)
+	}
+	if err := snaps.Update(common.Hash{0x30}, common.Hash{0x30}, nil, nil, nil); err != errSnapshotCycle {
+		t.Errorf("self referencing layer error mismatch: have %v, want %v", err, errSnapshotCycle)
+	}
+	if err := snaps.Update(common.Hash{0x30}, common.Hash{0xff}, nil, nil, nil); err == nil {
+		t.Errorf("layer with missing parent accepted")
+	}
+	tests := []struct {
+		root    common.Hash
+		account common.Hash
+		slot    bool
+		want    []byte
+	}{
+		{common.Hash{0x10}, acc1, false, []byte("diff1")},
+		{common.Hash{0x10}, acc2, false, []byte("disk2")},
+		{common.Hash{0x10}, acc1, true, []byte("diskslot1")},
+		{common.Hash{0x20}, acc1, false, []byte("diff1")},
+		{common.Hash{0x20}, acc2, false, nil},
+		{common.Hash{0x20}, acc1, true, nil},
+		{common.Hash{0x20}, acc2, true, nil},
+	}
+	for i, tt := range tests {
+		var (
+			blob []byte
+			err  error
+		)
+		if tt.slot {
+			blob, err = snaps.Snapshot(tt.root).Storage(tt.account, slot)
+		} else {
+			blob, err = snaps.Snapshot(tt.root).AccountRLP(tt.account)
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to read snapshot: %v", i, err)
+		} else if !bytes.Equal(blob, tt.want) {
+			t.Errorf("test %d: value mismatch: have %q, want %q", i, blob, tt.want)
+		}
+	}
+}
+
+// Tests that capping the tree flattens the bottom layers into the disk, marks
+// the flattened layers stale and drops any layer on a different branch.
+func TestCapFlattensLayers(t *testing.T) {
+	var (
+		acc  = common.Hash{0x01}
+		slot = common.Hash{0xaa}
+	)
+	snaps, diskdb := newTestTree(common.Hash{0x00})
+	diskdb.Put(storageSnapshotKey(acc, slot), []byte("old"))
+
+	snaps.Update(common.Hash{0x10}, common.Hash{0x00}, nil, map[common.Hash][]byte{acc: []byte("a1")}, nil)
+	snaps.Update(common.Hash{0x20}, common.Hash{0x10}, nil, map[common.Hash][]byte{acc: []byte("a2")}, map[common.Hash]map[common.Hash][]byte{acc: {slot: nil}})
+	snaps.Update(common.Hash{0x30}, common.Hash{0x20}, nil, map[common.Hash][]byte{acc: []byte("a3")}, nil)
+	snaps.Update(common.Hash{0x11}, common.Hash{0x00}, nil, map[common.Hash][]byte{acc: []byte("side")}, nil)
+
+	bottom, side := snaps.Snapshot(common.Hash{0x10}), snaps.Snapshot(common.Hash{0x11})
+	if err := snaps.Cap(common.Hash{0x30}, 1); err != nil {
+		t.Fatalf("failed to cap snapshot tree: %v", err)
+	}
+	if len(snaps.layers) != 2 {
+		t.Errorf("layer count mismatch: have %d, want %d", len(snaps.layers), 2)
+	}
+	disk, ok := snaps.layers[common.Hash{0x20}].(*diskLayer)
+	if !ok {
+		t.Fatalf("disk layer not flattened to the expected root")
+	}
+	if blob, _ := diskdb.Get(accountSnapshotKey(acc)); !bytes.Equal(blob, []byte("a2")) {
+		t.Errorf("flattened account mismatch: have %q, want %q", blob, "a2")
+	}
+	if _, err := diskdb.Get(storageSnapshotKey(acc, slot)); err == nil {
+		t.Errorf("deleted slot not removed from disk")
+	}
+	if blob, _ := diskdb.Get(snapshotRootKey); common.BytesToHash(blob) != disk.root {
+		t.Errorf("persisted root mismatch: have %x, want %x", blob, disk.root)
+	}
+	if top := snaps.layers[common.Hash{0x30}].(*diffLayer); top.Parent() != snapshot(disk) {
+		t.Errorf("top layer not relinked to the new disk layer")
+	}
+	if blob, err := snaps.Snapshot(common.Hash{0x30}).AccountRLP(acc); err != nil || !bytes.Equal(blob, []byte("a3")) {
+		t.Errorf("top layer read mismatch: have %q/%v, want %q", blob, err, "a3")
+	}
+	for name, layer := range map[string]Snapshot{"flattened": bottom, "side": side} {
+		if _, err := layer.AccountRLP(acc); err != ErrSnapshotStale {
+			t.Errorf("%s layer: error mismatch: have %v, want %v", name, err, ErrSnapshotStale)
+		}
+	}
+}
+
+// Tests that the diff layers are journalled on shutdown and restored on top of
+// the disk layer when the tree is reopened.
+func TestJournal(t *testing.T) {
+	var (
+		acc1 = common.Hash{0x01}
+		acc2 = common.Hash{0x02}
+		slot = common.Hash{0xaa}
+	)
+	snaps, diskdb := newTestTree(common.Hash{0x00})
+	diskdb.Put(accountSnapshotKey(acc2), []byte("disk2"))
+	diskdb.Put(storageSnapshotKey(acc1, slot), []byte("diskslot1"))
+
+	snaps.Update(common.Hash{0x10}, common.Hash{0x00}, nil, map[common.Hash][]byte{acc1: []byte("a1")}, map[common.Hash]map[common.Hash][]byte{acc1: {slot: []byte("s1")}})
+	snaps.Update(common.Hash{0x20}, common.Hash{0x10}, map[common.Hash]struct{}{acc2: {}}, nil, map[common.Hash]map[common.Hash][]byte{acc1: {slot: nil}})
+	snaps.Update(common.Hash{0x30}, common.Hash{0x20}, nil, map[common.Hash][]byte{acc1: []byte("a3")}, nil)
+	snaps.Update(common.Hash{0x11}, common.Hash{0x00}, nil, map[common.Hash][]byte{acc1: []byte("side")}, nil)
+
+	if err := snaps.Journal(common.Hash{0x30}); err != nil {
+		t.Fatalf("failed to journal snapshot tree: %v", err)
+	}
+	layers, err := loadSnapshot(diskdb, nil, common.Hash{0x30})
+	if err != nil {
+		t.Fatalf("failed to load journalled snapshot: %v", err)
+	}
+	if len(layers) != 4 {
+		t.Errorf("layer count mismatch: have %d, want %d", len(layers), 4)
+	}
+	if _, ok := layers[common.Hash{0x11}]; ok {
+		t.Errorf("side layer restored from journal")
+	}
+	tests := []struct {
+		root    common.Hash
+		account common.Hash
+		slot    bool
+		want    []byte
+	}{
+		{common.Hash{0x10}, acc1, false, []byte("a1")},
+		{common.Hash{0x10}, acc1, true, []byte("s1")},
+		{common.Hash{0x10}, acc2, false, []byte("disk2")},
+		{common.Hash{0x20}, acc1, true, nil},
+		{common.Hash{0x20}, acc2, false, nil},
+		{common.Hash{0x30}, acc1, false, []byte("a3")},
+		{common.Hash{0x30}, acc1, true, nil},
+	}
+	for i, tt := range tests {
+		var blob []byte
+		if tt.slot {
+			blob, err = layers[tt.root].Storage(tt.account, slot)
+		} else {
+			blob, err = layers[tt.root].AccountRLP(tt.account)
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to read snapshot: %v", i, err)
+		} else if !bytes.Equal(blob, tt.want) {
+			t.Errorf("test %d: value mismatch: have %q, want %q", i, blob, tt.want)
+		}
+	}
+	// Loading on a rewound head must only restore the layers up to it
+	if layers, err := loadSnapshot(diskdb, nil, common.Hash{0x10}); err != nil {
+		t.Errorf("failed to load journalled snapshot on rewound head: %v", err)
+	} else if len(layers) != 2 {
+		t.Errorf("rewound layer count mismatch: have %d, want %d", len(layers), 2)
+	}
+	if _, err := loadSnapshot(diskdb, nil, common.Hash{0x11}); err == nil {
+		t.Errorf("loaded snapshot for root missing from the journal")
+	}
+	// A journal built on a different disk layer must be ignored
+	diskdb.Put(snapshotRootKey, common.Hash{0x10}.Bytes())
+	if _, err := loadSnapshot(diskdb, nil, common.Hash{0x30}); err == nil {
+		t.Errorf("loaded snapshot from stale journal")
+	}
+}
@@ -117,6 +117,68 @@ func TestIntermediateLeaks(t *testing.T) {
 	}
 }
 
+// Tests that updating and committing many storage tries concurrently results in
+// the same roots and database content as doing it one object at a time.
+func TestParallelStorageUpdates(t *testing.T) {
+	var (
+		seqDb, _ = lemodb.NewMemDatabase()
+		parDb, _ = lemodb.NewMemDatabase()
+		seq, _   = New(common.Hash{}, NewDatabase(seqDb))
+		par, _   = New(common.Hash{}, NewDatabase(parDb))
+	)
+	modify := func(state *StateDB, i int, tweak byte) {
+		addr := common.Address{byte(i), byte(i >> 8)}
+		state.SetBalance(addr, big.NewInt(int64(i)+int64(tweak)))
+		for j := 0; j < 1+i%20; j++ {
+			state.SetState(addr, common.Hash{byte(j), tweak}, common.Hash{byte(i), byte(j), tweak})
+		}
+	}
+	for round, tweak := range []byte{1, 2} {
+		// Only ever finalise objects below the parallel threshold in the sequential state
+		for i := 0; i < 20*parallelStorageThreshold; i++ {
+			modify(seq, i, tweak)
+			modify(par, i, tweak)
+			if i%(parallelStorageThreshold-1) == 0 {
+				seq.IntermediateRoot(false)
+			}
+		}
+		seqRoot := seq.IntermediateRoot(false)
+		if parRoot := par.IntermediateRoot(false); parRoot != seqRoot {
+			t.Fatalf("round %d: intermediate root mismatch: sequential %x, parallel %x", round, seqRoot, parRoot)
+		}
+	}
+	// Modify the storage again and commit without finalising first
+	for i := 0; i < 20*parallelStorageThreshold; i += 2 {
+		modify(seq, i, 3)
+		modify(par, i, 3)
+		if i%(parallelStorageThreshold-1) == 0 {
+			seq.IntermediateRoot(false)
+		}
+	}
+	seqRoot, err := seq.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit sequential state: %v", err)
+	}
+	parRoot, err := par.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit parallel state: %v", err)
+	}
+	if seqRoot != parRoot {
+		t.Fatalf("commit root mismatch: sequential %x, parallel %x", seqRoot, parRoot)
+	}
+	seq.Database().TrieDB().Commit(seqRoot, false)
+	par.Database().TrieDB().Commit(parRoot, false)
+	if seqLen, parLen := len(seqDb.Keys()), len(parDb.Keys()); seqLen != parLen {
+		t.Fatalf("database entry count mismatch: sequential %d, parallel %d", seqLen, parLen)
+	}
+	for _, key := range seqDb.Keys() {
+		want, _ := seqDb.Get(key)
+		if have, _ := parDb.Get(key); !bytes.Equal(have, want) {
+			t.Fatalf("database entry %x mismatch: sequential %x, parallel %x", key, want, have)
+		}
+	}
+}
+
 // TestCopy tests that copying a statedb object indeed makes the original and
 // the copy independent of each other. This test is a regression test against
 // https://github.com/LemoFoundationLtd/lemochain-go/pull/15549.
@@ -421,3 +483,61 @@ func (s *StateSuite) TestTouchDelete(c *check.C) {
 		c.Fatal("expected no dirty state object")
 	}
 }
+
+// Tests that replacing the storage of an account hides all its original slots,
+// only affects the state it was applied on and survives copying.
+func TestSetStorage(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	addr := common.BytesToAddress([]byte{0x01})
+	slot1, slot2 := common.BytesToHash([]byte{0x01}), common.BytesToHash([]byte{0x02})
+	state.SetState(addr, slot1, common.BytesToHash([]byte{0xaa}))
+	root, _ := state.Commit(false)
+
+	state, _ = New(root, state.Database())
+	state.SetStorage(addr, map[common.Hash]common.Hash{slot2: common.BytesToHash([]byte{0xbb})})
+	if value := state.GetState(addr, slot1); value != (common.Hash{}) {
+		t.Errorf("replaced slot still visible: have %x", value)
+	}
+	if value := state.GetState(addr, slot2); value != common.BytesToHash([]byte{0xbb}) {
+		t.Errorf("replacement slot mismatch: have %x", value)
+	}
+	state.SetState(addr, slot1, common.BytesToHash([]byte{0xcc}))
+
+	copy := state.Copy()
+	if value := copy.GetState(addr, slot1); value != common.BytesToHash([]byte{0xcc}) {
+		t.Errorf("copied replacement slot mismatch: have %x", value)
+	}
+	// The original storage must remain untouched in the database
+	fresh, _ := New(root, state.Database())
+	if value := fresh.GetState(addr, slot1); value != common.BytesToHash([]byte{0xaa}) {
+		t.Errorf("original slot mismatch: have %x", value)
+	}
+}
+
+// Tests that storage writes on top of a replaced storage are journalled and can
+// be undone by reverting to a snapshot.
+func TestSetStorageRevert(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	addr := common.BytesToAddress([]byte{0x01})
+	slot := common.BytesToHash([]byte{0x01})
+	state.SetStorage(addr, map[common.Hash]common.Hash{slot: common.BytesToHash([]byte{0xaa})})
+
+	snapshot := state.Snapshot()
+	state.SetState(addr, slot, common.BytesToHash([]byte{0xbb}))
+	state.SetState(addr, common.BytesToHash([]byte{0x02}), common.BytesToHash([]byte{0xcc}))
+	if value := state.GetState(addr, slot); value != common.BytesToHash([]byte{0xbb}) {
+		t.Fatalf("modified slot mismatch: have %x", value)
+	}
+	state.RevertToSnapshot(snapshot)
+
+	if value := state.GetState(addr, slot); value != common.BytesToHash([]byte{0xaa}) {
+		t.Errorf("reverted slot mismatch: have %x, want %x", value, common.BytesToHash([]byte{0xaa}))
+	}
+	if value := state.GetState(addr, common.BytesToHash([]byte{0x02})); value != (common.Hash{}) {
+		t.Errorf("reverted new slot still set: have %x", value)
+	}
+}
@@ -28,14 +28,20 @@ import (
 func NewStateSync(root common.Hash, database trie.DatabaseReader) *trie.TrieSync {
 	var syncer *trie.TrieSync
 	callback := func(leaf []byte, parent common.Hash) error {
-		var obj Account
-		if err := rlp.Decode(bytes.NewReader(leaf), &obj); err != nil {
-			return err
-		}
-		syncer.AddSubTrie(obj.Root, 64, parent, nil)
-		syncer.AddRawEntry(common.BytesToHash(obj.CodeHash), 64, parent)
-		return nil
+		return syncAccount(syncer, leaf, parent)
 	}
 	syncer = trie.NewTrieSync(root, database, callback)
 	return syncer
 }
+
+// syncAccount schedules the storage trie and the contract code of an account
+// leaf retrieved by a state trie download.
+func syncAccount(syncer *trie.TrieSync, leaf []byte, parent common.Hash) error {
+	var obj Account
+	if err := rlp.Decode(bytes.NewReader(leaf), &obj); err != nil {
+		return err
+	}
+	syncer.AddSubTrie(obj.Root, 64, parent, nil)
+	syncer.AddRawEntry(common.BytesToHash(obj.CodeHash), 64, parent)
+	return nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyBranch is the encoding of a branch node without children, substituted
+	// for the damaged nodes so the trie iterators step over them.
+	emptyBranch, _ = rlp.EncodeToBytes(make([][]byte, 17))
+
+	// errVerifyInterrupted is returned if a state verification is aborted.
+	errVerifyInterrupted = errors.New("verification interrupted")
+)
+
+// IntegrityFault is a missing or corrupted entry of the state database.
+type IntegrityFault struct {
+	Hash    common.Hash // Hash of the damaged trie node or contract code
+	Owner   common.Hash // Hash of the account owning the storage trie or code, zero for the account trie
+	Path    []byte      // Hex-encoded path of the node within its trie, nil for contract code
+	Code    bool        // Whether the entry is contract code rather than a trie node
+	Missing bool        // Whether the entry is missing (or present with a mismatching hash otherwise)
+}
+
+// IntegrityReport is the result of a state verification.
+type IntegrityReport struct {
+	Accounts uint64 // Number of accounts verified
+	Slots    uint64 // Number of storage slots verified
+	Nodes    uint64 // Number of trie nodes verified
+	Codes    uint64 // Number of contract codes verified
+
+	Faults []*IntegrityFault // Missing and corrupted entries found
+}
+
+// verifyingDatabase wraps a disk database, checking the hash of every trie node
+// read against its key. Damaged nodes are recorded and substituted with an empty
+// branch, so the iterators can carry on with the rest of the trie.
+type verifyingDatabase struct {
+	lemodb.Database
+	faults map[common.Hash]bool // Damaged nodes read, mapped to whether they are missing
+}
+
+// Get implements lemodb.Database, validating the retrieved trie nodes.
+func (db *verifyingDatabase) Get(key []byte) ([]byte, error) {
+	blob, err := db.Database.Get(key)
+	if len(key) != common.HashLength {
+		return blob, err
+	}
+	hash := common.BytesToHash(key)
+	switch {
+	case err != nil || len(blob) == 0:
+		db.faults[hash] = true
+	case crypto.Keccak256Hash(blob) != hash:
+		db.faults[hash] = false
+	default:
+		return blob, nil
+	}
+	return emptyBranch, nil
+}
+
+// stateVerifier walks a state, checking every trie node and contract code.
+type stateVerifier struct {
+	db     *verifyingDatabase
+	state  Database
+	report *IntegrityReport
+
+	storages map[common.Hash]struct{} // Storage tries already verified
+	codes    map[common.Hash]struct{} // Contract codes already verified
+
+	quit   <-chan struct{}
+	start  time.Time
+	logged time.Time
+}
+
+// VerifyState walks the account trie with the given root, along with all the
+// storage tries and contract codes referenced from it, checking that every trie
+// node and code is present and matches the hash it is referenced by. Damaged
+// entries don't abort the walk, all of them are collected into the report.
+func VerifyState(db lemodb.Database, root common.Hash, quit <-chan struct{}) (*IntegrityReport, error) {
+	vdb := &verifyingDatabase{Database: db, faults: make(map[common.Hash]bool)}
+	v := &stateVerifier{
+		db:       vdb,
+		state:    NewDatabase(vdb),
+		report:   new(IntegrityReport),
+		storages: make(map[common.Hash]struct{}),
+		codes:    make(map[common.Hash]struct{}),
+		quit:     quit,
+		start:    time.Now(),
+		logged:   time.Now(),
+	}
+	tr, err := v.state.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.walk(tr, common.Hash{}, v.verifyAccount); err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+// walk iterates over all the nodes of a trie, recording the damaged ones and
+// invoking the callback for every leaf.
+func (v *stateVerifier) walk(tr Trie, owner common.Hash, onLeaf func(key, blob []byte) error) error {
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+		select {
+		case <-v.quit:
+			return errVerifyInterrupted
+		default:
+		}
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			v.report.Nodes++
+			if missing, ok := v.db.faults[hash]; ok {
+				v.report.Faults = append(v.report.Faults, &IntegrityFault{
+					Hash:    hash,
+					Owner:   owner,
+					Path:    common.CopyBytes(it.Path()),
+					Missing: missing,
+				})
+				log.Warn("Damaged trie node", "owner", owner, "path", common.Bytes2Hex(it.Path()), "hash", hash, "missing", missing)
+			}
+		}
+		if it.Leaf() {
+			if err := onLeaf(it.LeafKey(), it.LeafBlob()); err != nil {
+				return err
+			}
+		}
+		if time.Since(v.logged) > 8*time.Second {
+			log.Info("Verifying state", "accounts", v.report.Accounts, "slots", v.report.Slots, "nodes", v.report.Nodes,
+				"codes", v.report.Codes, "faults", len(v.report.Faults), "elapsed", common.PrettyDuration(time.Since(v.start)))
+			v.logged = time.Now()
+		}
+	}
+	return it.Error()
+}
+
+// verifyAccount checks the storage trie and the contract code of an account.
+func (v *stateVerifier) verifyAccount(key, blob []byte) error {
+	var account Account
+	if err := rlp.DecodeBytes(blob, &account); err != nil {
+		return err
+	}
+	v.report.Accounts++
+
+	owner := common.BytesToHash(key)
+	if _, ok := v.storages[account.Root]; account.Root != emptyRoot && !ok {
+		v.storages[account.Root] = struct{}{}
+
+		tr, err := v.state.OpenStorageTrie(owner, account.Root)
+		if err != nil {
+			return err
+		}
+		if err := v.walk(tr, owner, v.verifySlot); err != nil {
+			return err
+		}
+	}
+	codeHash := common.BytesToHash(account.CodeHash)
+	if _, ok := v.codes[codeHash]; !bytes.Equal(account.CodeHash, emptyCodeHash) && !ok {
+		v.codes[codeHash] = struct{}{}
+		v.report.Codes++
+
+		// Contract code is not a trie node, read it directly from disk
+		code, err := v.db.Database.Get(codeHash.Bytes())
+		if err != nil || len(code) == 0 || crypto.Keccak256Hash(code) != codeHash {
+			missing := err != nil || len(code) == 0
+			v.report.Faults = append(v.report.Faults, &IntegrityFault{Hash: codeHash, Owner: owner, Code: true, Missing: missing})
+			log.Warn("Damaged contract code", "owner", owner, "hash", codeHash, "missing", missing)
+		}
+	}
+	return nil
+}
+
+// verifySlot counts a storage slot, the trie nodes are checked by the walk.
+func (v *stateVerifier) verifySlot(key, blob []byte) error {
+	v.report.Slots++
+	return nil
+}
+
+// NewStateRepair creates a state download scheduler retrieving the damaged state
+// entries found by VerifyState, along with any entries missing underneath them.
+// The damaged entries are scheduled even if present in the database, so that the
+// corrupted ones get overwritten.
+func NewStateRepair(faults []*IntegrityFault, database trie.DatabaseReader) *trie.TrieSync {
+	damaged := make(map[common.Hash]struct{})
+	for _, fault := range faults {
+		damaged[fault.Hash] = struct{}{}
+	}
+	syncer := trie.NewTrieSync(emptyRoot, &repairReader{database, damaged}, nil)
+	callback := func(leaf []byte, parent common.Hash) error {
+		return syncAccount(syncer, leaf, parent)
+	}
+	for _, fault := range faults {
+		switch {
+		case fault.Code:
+			syncer.AddRawEntry(fault.Hash, 64, common.Hash{})
+		case fault.Owner == (common.Hash{}):
+			syncer.AddSubTrie(fault.Hash, len(fault.Path), common.Hash{}, callback)
+		default:
+			syncer.AddSubTrie(fault.Hash, 64+len(fault.Path), common.Hash{}, nil)
+		}
+	}
+	return syncer
+}
+
+// repairReader hides the damaged entries of a database from the state scheduler.
+type repairReader struct {
+	trie.DatabaseReader
+	damaged map[common.Hash]struct{}
+}
+
+// Get implements trie.DatabaseReader, reporting the damaged entries as missing.
+func (r *repairReader) Get(key []byte) ([]byte, error) {
+	if _, ok := r.damaged[common.BytesToHash(key)]; ok && len(key) == common.HashLength {
+		return nil, errors.New("not found")
+	}
+	return r.DatabaseReader.Get(key)
+}
+
+// Has implements trie.DatabaseReader, reporting the damaged entries as missing.
+func (r *repairReader) Has(key []byte) (bool, error) {
+	if _, ok := r.damaged[common.BytesToHash(key)]; ok && len(key) == common.HashLength {
+		return false, nil
+	}
+	return r.DatabaseReader.Has(key)
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+)
+
+// makeVerifyTestState creates a state with accounts, contract codes and storage
+// tries, flushed into the returned disk database.
+func makeVerifyTestState() (*lemodb.MemDatabase, common.Hash) {
+	diskdb, _ := lemodb.NewMemDatabase()
+	db := NewDatabase(diskdb)
+	state, _ := New(common.Hash{}, db)
+
+	for i := byte(0); i < 64; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		state.AddBalance(addr, big.NewInt(int64(i)+1))
+		if i%4 == 0 {
+			state.SetCode(addr, []byte{i, i, i, i})
+			for j := byte(0); j < 32; j++ {
+				state.SetState(addr, common.BytesToHash([]byte{i, j}), common.BytesToHash([]byte{j + 1}))
+			}
+		}
+	}
+	root, _ := state.Commit(false)
+	db.TrieDB().Commit(root, false)
+
+	return diskdb, root
+}
+
+// Tests that the state verifier finds all the missing and corrupted entries of a
+// state without aborting, and that the repair scheduler retrieves them.
+func TestVerifyState(t *testing.T) {
+	diskdb, root := makeVerifyTestState()
+
+	report, err := VerifyState(diskdb, root, nil)
+	if err != nil {
+		t.Fatalf("failed to verify pristine state: %v", err)
+	}
+	if len(report.Faults) != 0 {
+		t.Fatalf("pristine state has faults: %v", report.Faults[0])
+	}
+	if report.Accounts != 64 || report.Slots != 16*32 || report.Codes != 16 {
+		t.Fatalf("verified item mismatch: have %d accounts, %d slots, %d codes, want 64, 512, 16", report.Accounts, report.Slots, report.Codes)
+	}
+	// Damage the state: drop an account trie node, corrupt a storage trie node
+	// and drop a contract code. The code and storage belong to accounts outside
+	// of the dropped subtrie, which would hide them otherwise.
+	pristine, _ := lemodb.NewMemDatabase()
+	for _, key := range diskdb.Keys() {
+		value, _ := diskdb.Get(key)
+		pristine.Put(key, value)
+	}
+	state, _ := New(root, NewDatabase(diskdb))
+
+	var (
+		accountNode common.Hash
+		accountPath []byte
+	)
+	for it := state.trie.NodeIterator(nil); it.Next(true); {
+		if len(it.Path()) == 1 && it.Hash() != (common.Hash{}) {
+			accountNode, accountPath = it.Hash(), common.CopyBytes(it.Path())
+			break
+		}
+	}
+	var contracts []common.Address
+	for i := byte(0); i < 64; i += 4 {
+		addr := common.BytesToAddress([]byte{i})
+		if crypto.Keccak256(addr.Bytes())[0]>>4 != accountPath[0] {
+			contracts = append(contracts, addr)
+		}
+	}
+	var (
+		storageNode  common.Hash
+		storagePath  []byte
+		storageOwner = crypto.Keccak256Hash(contracts[0].Bytes())
+		code         = crypto.Keccak256Hash(state.GetCode(contracts[1]))
+		codeOwner    = crypto.Keccak256Hash(contracts[1].Bytes())
+	)
+	for it := state.StorageTrie(contracts[0]).NodeIterator(nil); it.Next(true); {
+		if len(it.Path()) == 1 && it.Hash() != (common.Hash{}) {
+			storageNode, storagePath = it.Hash(), common.CopyBytes(it.Path())
+			break
+		}
+	}
+	diskdb.Delete(accountNode[:])
+	diskdb.Put(storageNode[:], []byte{0xc0})
+	diskdb.Delete(code[:])
+
+	report, err = VerifyState(diskdb, root, nil)
+	if err != nil {
+		t.Fatalf("failed to verify damaged state: %v", err)
+	}
+	want := map[common.Hash]*IntegrityFault{
+		accountNode: {Hash: accountNode, Path: accountPath, Missing: true},
+		storageNode: {Hash: storageNode, Owner: storageOwner, Path: storagePath},
+		code:        {Hash: code, Owner: codeOwner, Code: true, Missing: true},
+	}
+	if len(report.Faults) != len(want) {
+		t.Fatalf("fault count mismatch: have %d, want %d", len(report.Faults), len(want))
+	}
+	for _, fault := range report.Faults {
+		expect := want[fault.Hash]
+		if expect == nil {
+			t.Errorf("unexpected fault: %+v", fault)
+			continue
+		}
+		if fault.Owner != expect.Owner || !bytes.Equal(fault.Path, expect.Path) || fault.Code != expect.Code || fault.Missing != expect.Missing {
+			t.Errorf("fault %x mismatch: have %+v, want %+v", fault.Hash, fault, expect)
+		}
+	}
+	// Repair the damaged entries from the pristine copy and verify again
+	sched := NewStateRepair(report.Faults, diskdb)
+	for queue := sched.Missing(0); len(queue) > 0; queue = sched.Missing(0) {
+		results := make([]trie.SyncResult, len(queue))
+		for i, hash := range queue {
+			data, err := pristine.Get(hash[:])
+			if err != nil {
+				t.Fatalf("failed to retrieve node data for %x: %v", hash, err)
+			}
+			results[i] = trie.SyncResult{Hash: hash, Data: data}
+		}
+		if _, index, err := sched.Process(results); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		if index, err := sched.Commit(diskdb); err != nil {
+			t.Fatalf("failed to commit data #%d: %v", index, err)
+		}
+	}
+	if report, err = VerifyState(diskdb, root, nil); err != nil {
+		t.Fatalf("failed to verify repaired state: %v", err)
+	}
+	if len(report.Faults) != 0 {
+		t.Fatalf("repaired state has faults: %+v", report.Faults[0])
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+)
+
+// Tests that the prefetcher executes the transactions of the followup block on
+// the throwaway state, and that it doesn't do anything once interrupted.
+func TestStatePrefetch(t *testing.T) {
+	gspec, db, blocks := parallelTestChain(t, 2)
+
+	blockchain, _ := NewBlockChain(db, nil, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	defer blockchain.Stop()
+
+	if _, err := blockchain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	prefetcher := newStatePrefetcher(gspec.Config, blockchain, blockchain.engine)
+
+	// An interrupted prefetcher must not execute anything
+	interrupt := uint32(1)
+
+	statedb, _ := state.New(blocks[0].Root(), state.NewDatabase(db))
+	prefetcher.Prefetch(blocks[1], statedb, vm.Config{}, &interrupt)
+	if have, want := statedb.GetState(parallelCounter, common.Hash{}).Big(), big.NewInt(2); have.Cmp(want) != 0 {
+		t.Errorf("interrupted prefetch: counter mismatch: have %v, want %v", have, want)
+	}
+	// A running prefetcher must execute the entire block
+	interrupt = 0
+
+	statedb, _ = state.New(blocks[0].Root(), state.NewDatabaseWithCache(db, 16))
+	prefetcher.Prefetch(blocks[1], statedb, vm.Config{}, &interrupt)
+	if have, want := statedb.GetState(parallelCounter, common.Hash{}).Big(), big.NewInt(4); have.Cmp(want) != 0 {
+		t.Errorf("running prefetch: counter mismatch: have %v, want %v", have, want)
+	}
+}
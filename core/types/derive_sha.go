@@ -29,13 +29,31 @@ type DerivableList interface {
 	GetRlp(i int) []byte
 }
 
+// DeriveSha computes the root hash of the trie mapping the RLP encoded index of
+// every list item to the item's RLP encoding.
+//
+// The root is computed with a stack trie, which requires the keys in ascending
+// order. The encoded indices sort as 1..127 (single byte), 0 (0x80) and then the
+// rest (0x81.. prefixed), so index 0 is inserted after the single byte ones.
 func DeriveSha(list DerivableList) common.Hash {
-	keybuf := new(bytes.Buffer)
-	trie := new(trie.Trie)
-	for i := 0; i < list.Len(); i++ {
+	var (
+		keybuf = new(bytes.Buffer)
+		trie   = trie.NewStackTrie(nil)
+		n      = list.Len()
+	)
+	insert := func(i int) {
 		keybuf.Reset()
 		rlp.Encode(keybuf, uint(i))
 		trie.Update(keybuf.Bytes(), list.GetRlp(i))
 	}
+	for i := 1; i < n && i <= 0x7f; i++ {
+		insert(i)
+	}
+	if n > 0 {
+		insert(0)
+	}
+	for i := 0x80; i < n; i++ {
+		insert(i)
+	}
 	return trie.Hash()
 }
@@ -0,0 +1,57 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+	"github.com/LemoFoundationLtd/lemochain-go/trie"
+)
+
+// Tests that the stack trie based DeriveSha produces the same roots as a regular
+// trie, in particular around the list sizes where the key ordering changes.
+func TestDeriveShaEquivalence(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 16, 17, 127, 128, 129, 255, 256, 257, 1000} {
+		txs := make(Transactions, n)
+		for i := range txs {
+			txs[i] = NewTransaction(uint64(i), common.Address{byte(i)}, big.NewInt(int64(i)), 21000, big.NewInt(1), nil)
+		}
+		want := new(trie.Trie)
+		for i := range txs {
+			key, _ := rlp.EncodeToBytes(uint(i))
+			want.Update(key, txs.GetRlp(i))
+		}
+		if have := DeriveSha(txs); have != want.Hash() {
+			t.Errorf("%d transactions: root mismatch: have %x, want %x", n, have, want.Hash())
+		}
+	}
+}
+
+func BenchmarkDeriveSha(b *testing.B) {
+	txs := make(Transactions, 200)
+	for i := range txs {
+		txs[i] = NewTransaction(uint64(i), common.Address{byte(i)}, big.NewInt(int64(i)), 21000, big.NewInt(1), nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DeriveSha(txs)
+	}
+}
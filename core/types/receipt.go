@@ -55,6 +55,7 @@ type Receipt struct {
 	TxHash          common.Hash    `json:"transactionHash" gencodec:"required"`
 	ContractAddress common.Address `json:"contractAddress"`
 	GasUsed         uint64         `json:"gasUsed" gencodec:"required"`
+	RevertReason    []byte         `json:"revertReason,omitempty"`
 }
 
 type receiptMarshaling struct {
@@ -62,6 +63,7 @@ type receiptMarshaling struct {
 	Status            hexutil.Uint
 	CumulativeGasUsed hexutil.Uint64
 	GasUsed           hexutil.Uint64
+	RevertReason      hexutil.Bytes
 }
 
 // receiptRLP is the consensus encoding of a receipt.
@@ -80,6 +82,19 @@ type receiptStorageRLP struct {
 	ContractAddress   common.Address
 	Logs              []*LogForStorage
 	GasUsed           uint64
+	RevertReason      []byte
+}
+
+// legacyReceiptStorageRLP is the storage encoding of a receipt before the revert
+// reason was added to it.
+type legacyReceiptStorageRLP struct {
+	PostStateOrStatus []byte
+	CumulativeGasUsed uint64
+	Bloom             Bloom
+	TxHash            common.Hash
+	ContractAddress   common.Address
+	Logs              []*LogForStorage
+	GasUsed           uint64
 }
 
 // NewReceipt creates a barebone transaction receipt, copying the init fields.
@@ -140,7 +155,7 @@ func (r *Receipt) statusEncoding() []byte {
 // Size returns the approximate memory used by all internal contents. It is used
 // to approximate and limit the memory consumption of various caches.
 func (r *Receipt) Size() common.StorageSize {
-	size := common.StorageSize(unsafe.Sizeof(*r)) + common.StorageSize(len(r.PostState))
+	size := common.StorageSize(unsafe.Sizeof(*r)) + common.StorageSize(len(r.PostState)+len(r.RevertReason))
 
 	size += common.StorageSize(len(r.Logs)) * common.StorageSize(unsafe.Sizeof(Log{}))
 	for _, log := range r.Logs {
@@ -172,6 +187,7 @@ func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 		ContractAddress:   r.ContractAddress,
 		Logs:              make([]*LogForStorage, len(r.Logs)),
 		GasUsed:           r.GasUsed,
+		RevertReason:      r.RevertReason,
 	}
 	for i, log := range r.Logs {
 		enc.Logs[i] = (*LogForStorage)(log)
@@ -180,12 +196,29 @@ func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 }
 
 // DecodeRLP implements rlp.Decoder, and loads both consensus and implementation
-// fields of a receipt from an RLP stream.
+// fields of a receipt from an RLP stream. Receipts stored before the revert reason
+// was tracked are decoded too.
 func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
-	var dec receiptStorageRLP
-	if err := s.Decode(&dec); err != nil {
+	blob, err := s.Raw()
+	if err != nil {
 		return err
 	}
+	var dec receiptStorageRLP
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		var legacy legacyReceiptStorageRLP
+		if rlp.DecodeBytes(blob, &legacy) != nil {
+			return err
+		}
+		dec = receiptStorageRLP{
+			PostStateOrStatus: legacy.PostStateOrStatus,
+			CumulativeGasUsed: legacy.CumulativeGasUsed,
+			Bloom:             legacy.Bloom,
+			TxHash:            legacy.TxHash,
+			ContractAddress:   legacy.ContractAddress,
+			Logs:              legacy.Logs,
+			GasUsed:           legacy.GasUsed,
+		}
+	}
 	if err := (*Receipt)(r).setStatus(dec.PostStateOrStatus); err != nil {
 		return err
 	}
@@ -197,6 +230,9 @@ func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
 	}
 	// Assign the implementation fields
 	r.TxHash, r.ContractAddress, r.GasUsed = dec.TxHash, dec.ContractAddress, dec.GasUsed
+	if len(dec.RevertReason) > 0 {
+		r.RevertReason = dec.RevertReason
+	}
 	return nil
 }
 
@@ -0,0 +1,76 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+)
+
+// Tests that receipts keep their revert reason across a storage round trip.
+func TestReceiptStorageRevertReason(t *testing.T) {
+	receipt := &Receipt{
+		Status:            ReceiptStatusFailed,
+		CumulativeGasUsed: 21000,
+		TxHash:            common.BytesToHash([]byte{0x11}),
+		GasUsed:           21000,
+		Logs:              []*Log{},
+		RevertReason:      []byte{0x08, 0xc3, 0x79, 0xa0},
+	}
+	enc, err := rlp.EncodeToBytes((*ReceiptForStorage)(receipt))
+	if err != nil {
+		t.Fatalf("failed to encode receipt: %v", err)
+	}
+	var dec ReceiptForStorage
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatalf("failed to decode receipt: %v", err)
+	}
+	if !bytes.Equal(dec.RevertReason, receipt.RevertReason) {
+		t.Errorf("revert reason mismatch: have %x, want %x", dec.RevertReason, receipt.RevertReason)
+	}
+	if dec.Status != receipt.Status || dec.GasUsed != receipt.GasUsed || dec.TxHash != receipt.TxHash {
+		t.Errorf("receipt fields mismatch: have %+v, want %+v", dec, *receipt)
+	}
+}
+
+// Tests that receipts stored before the revert reason was added still decode.
+func TestLegacyReceiptStorageDecoding(t *testing.T) {
+	legacy := &legacyReceiptStorageRLP{
+		PostStateOrStatus: receiptStatusSuccessfulRLP,
+		CumulativeGasUsed: 42000,
+		TxHash:            common.BytesToHash([]byte{0x22}),
+		GasUsed:           21000,
+		Logs:              []*LogForStorage{},
+	}
+	enc, err := rlp.EncodeToBytes(legacy)
+	if err != nil {
+		t.Fatalf("failed to encode legacy receipt: %v", err)
+	}
+	var dec ReceiptForStorage
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatalf("failed to decode legacy receipt: %v", err)
+	}
+	if dec.Status != ReceiptStatusSuccessful || dec.CumulativeGasUsed != 42000 || dec.GasUsed != 21000 || dec.TxHash != legacy.TxHash {
+		t.Errorf("receipt fields mismatch: have %+v", dec)
+	}
+	if len(dec.RevertReason) != 0 {
+		t.Errorf("unexpected revert reason %x", dec.RevertReason)
+	}
+}
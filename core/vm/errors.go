@@ -25,4 +25,9 @@ var (
 	ErrTraceLimitReached        = errors.New("the number of logs reached the specified limit")
 	ErrInsufficientBalance      = errors.New("insufficient balance for transfer")
 	ErrContractAddressCollision = errors.New("contract address collision")
+
+	// ErrExecutionReverted is returned if the execution was aborted by the REVERT
+	// opcode. Unlike other errors it leaves the remaining gas to the caller and
+	// comes with the revert data as the return value.
+	ErrExecutionReverted = errors.New("evm: execution reverted")
 )
@@ -17,6 +17,7 @@
 package runtime
 
 import (
+	"bytes"
 	"math/big"
 	"strings"
 	"testing"
@@ -25,7 +26,9 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/common"
 	"github.com/LemoFoundationLtd/lemochain-go/core/state"
 	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
 	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
 )
 
 func TestDefaults(t *testing.T) {
@@ -149,3 +152,183 @@ func BenchmarkCall(b *testing.B) {
 		}
 	}
 }
+
+// constantinopleConfig is a chain config with every fork up to Constantinople
+// activated at genesis.
+var constantinopleConfig = &params.ChainConfig{
+	ChainId:             big.NewInt(1),
+	HomesteadBlock:      new(big.Int),
+	EIP150Block:         new(big.Int),
+	EIP155Block:         new(big.Int),
+	EIP158Block:         new(big.Int),
+	ByzantiumBlock:      new(big.Int),
+	ConstantinopleBlock: new(big.Int),
+}
+
+// create2Init is the init code deployed by create2Factory, returning the single
+// byte runtime code 0xff.
+var create2Init = []byte{
+	byte(vm.PUSH1), 0xff,
+	byte(vm.PUSH1), 0,
+	byte(vm.MSTORE8),
+	byte(vm.PUSH1), 1,
+	byte(vm.PUSH1), 0,
+	byte(vm.RETURN),
+}
+
+// create2Factory deploys create2Init with CREATE2, using the first word of the
+// call data as the salt, and returns the address of the created contract.
+var create2Factory = append(append([]byte{byte(vm.PUSH10)}, create2Init...), []byte{
+	byte(vm.PUSH1), 0,
+	byte(vm.MSTORE),
+	byte(vm.PUSH1), 0,
+	byte(vm.CALLDATALOAD),
+	byte(vm.PUSH1), byte(len(create2Init)),
+	byte(vm.PUSH1), byte(32 - len(create2Init)),
+	byte(vm.PUSH1), 0,
+	byte(vm.CREATE2),
+	byte(vm.PUSH1), 0,
+	byte(vm.MSTORE),
+	byte(vm.PUSH1), 32,
+	byte(vm.PUSH1), 0,
+	byte(vm.RETURN),
+}...)
+
+// Tests that CREATE2 deploys at the address derived from the sender, salt and
+// init code, and that it's only available from Constantinople.
+func TestCreate2(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	factory := common.HexToAddress("0x0a")
+	statedb.SetCode(factory, create2Factory)
+
+	salt := common.Hash{1}
+	ret, _, err := Call(factory, salt[:], &Config{ChainConfig: constantinopleConfig, State: statedb})
+	if err != nil {
+		t.Fatalf("failed to call factory: %v", err)
+	}
+	want := crypto.CreateAddress2(factory, salt, crypto.Keccak256(create2Init))
+	if addr := common.BytesToAddress(ret); addr != want {
+		t.Fatalf("contract address mismatch: have %x, want %x", addr, want)
+	}
+	if code := statedb.GetCode(want); !bytes.Equal(code, []byte{0xff}) {
+		t.Errorf("contract code mismatch: have %x, want ff", code)
+	}
+	if nonce := statedb.GetNonce(factory); nonce != 1 {
+		t.Errorf("factory nonce mismatch: have %d, want 1", nonce)
+	}
+	// Before Constantinople the opcode must be invalid
+	if _, _, err := Call(factory, common.Hash{2}.Bytes(), &Config{State: statedb}); err == nil {
+		t.Errorf("CREATE2 executed before Constantinople")
+	}
+}
+
+// Tests that CREATE2 onto an account with a nonce or code fails, consuming all
+// the gas passed to it (EIP-684), while an account with only a balance is taken
+// over along with its funds.
+func TestCreate2Collision(t *testing.T) {
+	tests := []struct {
+		name    string
+		nonce   uint64
+		code    []byte
+		balance int64
+		collide bool
+	}{
+		{name: "fresh"},
+		{name: "nonce", nonce: 1, collide: true},
+		{name: "code", code: []byte{0x01}, collide: true},
+		{name: "balance", balance: 1000},
+	}
+	for _, tt := range tests {
+		db, _ := lemodb.NewMemDatabase()
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+		factory := common.HexToAddress("0x0a")
+		statedb.SetCode(factory, create2Factory)
+
+		salt := common.Hash{1}
+		target := crypto.CreateAddress2(factory, salt, crypto.Keccak256(create2Init))
+		if tt.nonce != 0 {
+			statedb.SetNonce(target, tt.nonce)
+		}
+		if tt.code != nil {
+			statedb.SetCode(target, tt.code)
+		}
+		if tt.balance != 0 {
+			statedb.SetBalance(target, big.NewInt(tt.balance))
+		}
+		gas := uint64(1000000)
+		ret, left, err := Call(factory, salt[:], &Config{ChainConfig: constantinopleConfig, State: statedb, GasLimit: gas})
+		if err != nil {
+			t.Fatalf("%s: failed to call factory: %v", tt.name, err)
+		}
+		addr := common.BytesToAddress(ret)
+		if tt.collide {
+			if addr != (common.Address{}) {
+				t.Errorf("%s: collision not detected, created %x", tt.name, addr)
+			}
+			// All but the 1/64th withheld by the factory must be consumed
+			if left > gas/64 {
+				t.Errorf("%s: gas not consumed on collision: %d left", tt.name, left)
+			}
+			if nonce := statedb.GetNonce(target); nonce != tt.nonce {
+				t.Errorf("%s: target nonce changed: have %d, want %d", tt.name, nonce, tt.nonce)
+			}
+			if code := statedb.GetCode(target); !bytes.Equal(code, tt.code) {
+				t.Errorf("%s: target code changed: have %x, want %x", tt.name, code, tt.code)
+			}
+			continue
+		}
+		if addr != target {
+			t.Errorf("%s: contract address mismatch: have %x, want %x", tt.name, addr, target)
+		}
+		if left < gas-100000 {
+			t.Errorf("%s: too much gas used: %d", tt.name, gas-left)
+		}
+		if code := statedb.GetCode(target); !bytes.Equal(code, []byte{0xff}) {
+			t.Errorf("%s: contract code mismatch: have %x, want ff", tt.name, code)
+		}
+		if balance := statedb.GetBalance(target); balance.Cmp(big.NewInt(tt.balance)) != 0 {
+			t.Errorf("%s: contract balance mismatch: have %v, want %v", tt.name, balance, tt.balance)
+		}
+	}
+}
+
+// Tests that EXTCODEHASH returns the hash of the code of contracts, the hash of
+// empty code for accounts without code, and zero for non-existent accounts.
+func TestExtCodeHash(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x0b")
+		account  = common.HexToAddress("0x0c")
+		missing  = common.HexToAddress("0x0d")
+	)
+	db, _ := lemodb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	statedb.SetCode(contract, create2Init)
+	statedb.SetBalance(account, big.NewInt(1))
+
+	for _, tt := range []struct {
+		addr common.Address
+		want common.Hash
+	}{
+		{contract, crypto.Keccak256Hash(create2Init)},
+		{account, crypto.Keccak256Hash(nil)},
+		{missing, common.Hash{}},
+	} {
+		code := append([]byte{byte(vm.PUSH20)}, tt.addr.Bytes()...)
+		code = append(code,
+			byte(vm.EXTCODEHASH),
+			byte(vm.PUSH1), 0,
+			byte(vm.MSTORE),
+			byte(vm.PUSH1), 32,
+			byte(vm.PUSH1), 0,
+			byte(vm.RETURN),
+		)
+		ret, _, err := Execute(code, nil, &Config{ChainConfig: constantinopleConfig, State: statedb})
+		if err != nil {
+			t.Fatalf("%x: failed to execute: %v", tt.addr, err)
+		}
+		if hash := common.BytesToHash(ret); hash != tt.want {
+			t.Errorf("%x: code hash mismatch: have %x, want %x", tt.addr, hash, tt.want)
+		}
+	}
+}
@@ -19,10 +19,12 @@ package lemochain
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"math/big"
 
 	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
 	"github.com/LemoFoundationLtd/lemochain-go/core/types"
 )
 
@@ -111,6 +113,39 @@ type ChainSyncReader interface {
 	SyncProgress(ctx context.Context) (*SyncProgress, error)
 }
 
+// ChainReorg describes a reorganisation of the canonical chain. The dropped and
+// added block hashes are ordered from the common ancestor towards the heads.
+type ChainReorg struct {
+	CommonHash   common.Hash   // Hash of the last block shared by both chains
+	CommonNumber uint64        // Number of the last block shared by both chains
+	OldChain     []common.Hash // Hashes of the blocks dropped from the canonical chain
+	NewChain     []common.Hash // Hashes of the blocks added to the canonical chain
+	Depth        uint64        // Number of blocks dropped from the canonical chain
+}
+
+// UnmarshalJSON decodes a chain reorganisation notification of the chainReorg
+// subscription.
+func (r *ChainReorg) UnmarshalJSON(input []byte) error {
+	var dec struct {
+		CommonHash   common.Hash    `json:"commonHash"`
+		CommonNumber hexutil.Uint64 `json:"commonNumber"`
+		OldChain     []common.Hash  `json:"oldChain"`
+		NewChain     []common.Hash  `json:"newChain"`
+		Depth        hexutil.Uint64 `json:"depth"`
+	}
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	*r = ChainReorg{
+		CommonHash:   dec.CommonHash,
+		CommonNumber: uint64(dec.CommonNumber),
+		OldChain:     dec.OldChain,
+		NewChain:     dec.NewChain,
+		Depth:        uint64(dec.Depth),
+	}
+	return nil
+}
+
 // CallMsg contains parameters for contract calls.
 type CallMsg struct {
 	From     common.Address  // the sender of the 'transaction'
@@ -19,6 +19,7 @@ package lemoapi
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math/big"
@@ -26,12 +27,14 @@ import (
 	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go/accounts"
+	"github.com/LemoFoundationLtd/lemochain-go/accounts/abi"
 	"github.com/LemoFoundationLtd/lemochain-go/accounts/keystore"
 	"github.com/LemoFoundationLtd/lemochain-go/common"
 	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
 	"github.com/LemoFoundationLtd/lemochain-go/common/math"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
 	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
 	"github.com/LemoFoundationLtd/lemochain-go/core/types"
 	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
 	"github.com/LemoFoundationLtd/lemochain-go/crypto"
@@ -40,8 +43,6 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/params"
 	"github.com/LemoFoundationLtd/lemochain-go/rlp"
 	"github.com/LemoFoundationLtd/lemochain-go/rpc"
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const (
@@ -611,34 +612,98 @@ type CallArgs struct {
 	Data     hexutil.Bytes   `json:"data"`
 }
 
-func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, vmCfg vm.Config, timeout time.Duration) ([]byte, uint64, bool, error) {
-	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
+// OverrideAccount indicates the overriding fields of account during the execution
+// of a message call. Code, balance and nonce are replaced if set. State replaces
+// the entire storage of the account, while StateDiff only overrides the given
+// slots; the two are mutually exclusive.
+type OverrideAccount struct {
+	Nonce     *hexutil.Uint64              `json:"nonce"`
+	Code      *hexutil.Bytes               `json:"code"`
+	Balance   *hexutil.Big                 `json:"balance"`
+	State     *map[common.Hash]common.Hash `json:"state"`
+	StateDiff *map[common.Hash]common.Hash `json:"stateDiff"`
+}
 
-	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
-	if state == nil || err != nil {
-		return nil, 0, false, err
+// StateOverride is the collection of overridden accounts.
+type StateOverride map[common.Address]OverrideAccount
+
+// Apply overrides the fields of specified accounts into the given state.
+func (diff *StateOverride) Apply(state *state.StateDB) error {
+	if diff == nil {
+		return nil
 	}
-	// Set sender address or use a default if none specified
-	addr := args.From
-	if addr == (common.Address{}) {
-		if wallets := s.b.AccountManager().Wallets(); len(wallets) > 0 {
-			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
-				addr = accounts[0].Address
+	for addr, account := range *diff {
+		if account.Nonce != nil {
+			state.SetNonce(addr, uint64(*account.Nonce))
+		}
+		if account.Code != nil {
+			state.SetCode(addr, *account.Code)
+		}
+		if account.Balance != nil {
+			state.SetBalance(addr, (*big.Int)(account.Balance))
+		}
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+		}
+		if account.State != nil {
+			state.SetStorage(addr, *account.State)
+		}
+		if account.StateDiff != nil {
+			for key, value := range *account.StateDiff {
+				state.SetState(addr, key, value)
 			}
 		}
 	}
-	// Set default gas & gas price if none were set
-	gas, gasPrice := uint64(args.Gas), args.GasPrice.ToInt()
-	if gas == 0 {
-		gas = math.MaxUint64 / 2
+	return nil
+}
+
+// overridesBalance reports whether the balance of the given account is overridden.
+func (diff *StateOverride) overridesBalance(addr common.Address) bool {
+	if diff == nil {
+		return false
 	}
-	if gasPrice.Sign() == 0 {
-		gasPrice = new(big.Int).SetUint64(defaultGasPrice)
+	account, ok := (*diff)[addr]
+	return ok && account.Balance != nil
+}
+
+// BlockOverrides is a set of header fields to override in the block context a
+// message call is executed in.
+type BlockOverrides struct {
+	Number   *hexutil.Big    `json:"number"`
+	Time     *hexutil.Big    `json:"timestamp"`
+	Coinbase *common.Address `json:"coinbase"`
+}
+
+// Apply returns a copy of the given header with the set fields overridden. The
+// header must be overridden before the EVM is created, as the fork rules of the
+// interpreter are chosen based on the block number.
+func (diff *BlockOverrides) Apply(header *types.Header) *types.Header {
+	if diff == nil {
+		return header
 	}
+	header = types.CopyHeader(header)
+	if diff.Number != nil {
+		header.Number = new(big.Int).Set(diff.Number.ToInt())
+	}
+	if diff.Time != nil {
+		header.Time = new(big.Int).Set(diff.Time.ToInt())
+	}
+	if diff.Coinbase != nil {
+		header.Coinbase = *diff.Coinbase
+	}
+	return header
+}
 
-	// Create new call message
-	msg := types.NewMessage(addr, args.To, 0, args.Value.ToInt(), gas, gasPrice, args.Data, false)
+func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, overrides *StateOverride, blockOverrides *BlockOverrides, vmCfg vm.Config, timeout time.Duration) (*core.ExecutionResult, error) {
+	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
 
+	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	if err := overrides.Apply(state); err != nil {
+		return nil, err
+	}
 	// Setup context so it may be cancelled the call has completed
 	// or, in case of unmetered gas, setup a context with a timeout.
 	var cancel context.CancelFunc
@@ -651,38 +716,105 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 	// this makes sure resources are cleaned up.
 	defer cancel()
 
+	// An overridden sender balance must be honoured instead of being topped up
+	keepBalance := overrides.overridesBalance(s.callSender(args.From))
+	return s.applyCall(ctx, args, state, header, blockOverrides, vmCfg, keepBalance)
+}
+
+// callSender returns the sender of a call, defaulting to the first local account
+// if none was specified.
+func (s *PublicBlockChainAPI) callSender(from common.Address) common.Address {
+	if from == (common.Address{}) {
+		if wallets := s.b.AccountManager().Wallets(); len(wallets) > 0 {
+			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
+				return accounts[0].Address
+			}
+		}
+	}
+	return from
+}
+
+// applyCall executes a single call message on top of the given state, which is
+// modified in place. The EVM is aborted once the context is done.
+//
+// The backend credits the sender with an unlimited balance to pay for the call.
+// If keepBalance is set, the sender's real balance is kept instead and the gas
+// price defaults to zero, so that value transfers and balance checks behave as
+// they would on chain.
+func (s *PublicBlockChainAPI) applyCall(ctx context.Context, args CallArgs, state *state.StateDB, header *types.Header, blockOverrides *BlockOverrides, vmCfg vm.Config, keepBalance bool) (*core.ExecutionResult, error) {
+	addr := s.callSender(args.From)
+
+	// Set default gas & gas price if none were set
+	gas, gasPrice := uint64(args.Gas), args.GasPrice.ToInt()
+	if gas == 0 {
+		gas = math.MaxUint64 / 2
+	}
+	if gasPrice.Sign() == 0 && !keepBalance {
+		gasPrice = new(big.Int).SetUint64(defaultGasPrice)
+	}
+
+	// Create new call message
+	msg := types.NewMessage(addr, args.To, 0, args.Value.ToInt(), gas, gasPrice, args.Data, false)
+
 	// Get a new instance of the EVM.
-	evm, vmError, err := s.b.GetEVM(ctx, msg, state, header, vmCfg)
+	balance := new(big.Int).Set(state.GetBalance(addr))
+	evm, vmError, err := s.b.GetEVM(ctx, msg, state, blockOverrides.Apply(header), vmCfg)
 	if err != nil {
-		return nil, 0, false, err
+		return nil, err
 	}
+	if keepBalance {
+		state.SetBalance(addr, balance)
+	}
+	// Some engines derive the block author from the seal instead of the coinbase
+	if blockOverrides != nil && blockOverrides.Coinbase != nil {
+		evm.Coinbase = *blockOverrides.Coinbase
+	}
+
 	// Wait for the context to be done and cancel the evm. Even if the
 	// EVM has finished, cancelling may be done (repeatedly)
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		<-ctx.Done()
-		evm.Cancel()
+		select {
+		case <-ctx.Done():
+			evm.Cancel()
+		case <-done:
+		}
 	}()
 
 	// Setup the gas pool (also for unmetered requests)
 	// and apply the message.
 	gp := new(core.GasPool).AddGas(math.MaxUint64)
-	res, gas, failed, err := core.ApplyMessage(evm, msg, gp)
+	result, err := core.ApplyMessage(evm, msg, gp)
 	if err := vmError(); err != nil {
-		return nil, 0, false, err
+		return nil, err
 	}
-	return res, gas, failed, err
+	return result, err
 }
 
 // Call executes the given transaction on the state for the given block number.
 // It doesn't make and changes in the state/blockchain and is useful to execute and retrieve values.
-func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
-	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{}, 5*time.Second)
-	return (hexutil.Bytes)(result), err
+//
+// If the execution is reverted, an error is returned holding the revert data and
+// the decoded revert reason.
+//
+// Optionally, account fields and storage as well as block context fields can be
+// overridden for the duration of the call.
+func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, overrides *StateOverride, blockOverrides *BlockOverrides) (hexutil.Bytes, error) {
+	result, err := s.doCall(ctx, args, blockNr, overrides, blockOverrides, vm.Config{}, s.b.RPCEVMTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if result.Err == vm.ErrExecutionReverted {
+		return nil, abi.NewRevertError(result.Revert())
+	}
+	return (hexutil.Bytes)(result.ReturnData), nil
 }
 
 // EstimateGas returns an estimate of the amount of gas needed to execute the
-// given transaction against the current pending block.
-func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (hexutil.Uint64, error) {
+// given transaction against the current pending block, optionally with some
+// account and block context fields overridden.
+func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs, overrides *StateOverride, blockOverrides *BlockOverrides) (hexutil.Uint64, error) {
 	// Binary search the gas requirement, as it may be higher than the amount used
 	var (
 		lo  uint64 = params.TxGas - 1
@@ -702,19 +834,19 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	cap = hi
 
 	// Create a helper to check if a gas allowance results in an executable transaction
-	executable := func(gas uint64) bool {
+	executable := func(gas uint64) (bool, *core.ExecutionResult) {
 		args.Gas = hexutil.Uint64(gas)
 
-		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, vm.Config{}, 0)
-		if err != nil || failed {
-			return false
+		result, err := s.doCall(ctx, args, rpc.PendingBlockNumber, overrides, blockOverrides, vm.Config{}, 0)
+		if err != nil || result.Failed() {
+			return false, result
 		}
-		return true
+		return true, result
 	}
 	// Execute the binary search and hone in on an executable gas limit
 	for lo+1 < hi {
 		mid := (hi + lo) / 2
-		if !executable(mid) {
+		if ok, _ := executable(mid); !ok {
 			lo = mid
 		} else {
 			hi = mid
@@ -722,13 +854,109 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	}
 	// Reject the transaction as invalid if it still fails at the highest allowance
 	if hi == cap {
-		if !executable(hi) {
+		if ok, result := executable(hi); !ok {
+			// If the transaction reverted, report the reason instead of the gas
+			if result != nil && result.Err == vm.ErrExecutionReverted {
+				return 0, abi.NewRevertError(result.Revert())
+			}
 			return 0, fmt.Errorf("gas required exceeds allowance or always failing transaction")
 		}
 	}
 	return hexutil.Uint64(hi), nil
 }
 
+// BundleCallResult is the outcome of a single message executed by CallBundle.
+type BundleCallResult struct {
+	ReturnData   hexutil.Bytes  `json:"returnData"`
+	GasUsed      hexutil.Uint64 `json:"gasUsed"`
+	Logs         []*types.Log   `json:"logs"`
+	Error        string         `json:"error,omitempty"`
+	RevertData   hexutil.Bytes  `json:"revertData,omitempty"`
+	RevertReason string         `json:"revertReason,omitempty"`
+}
+
+// CallBundle executes the given list of calls in order on top of the state of
+// the given block number. Unlike Call, the state changes of every message are
+// carried over to the next one, which allows simulating multi step interactions
+// (e.g. approve then transferFrom) without sending any transactions.
+//
+// Senders are not credited with any balance and the gas price defaults to zero,
+// so value transfers and balance checks see the real balances carried through
+// the bundle. A failing or reverting call does not abort the bundle, its error
+// is reported in its result instead. The whole bundle shares the configured
+// timeout of a single call.
+func (s *PublicBlockChainAPI) CallBundle(ctx context.Context, args []CallArgs, blockNr rpc.BlockNumber, overrides *StateOverride, blockOverrides *BlockOverrides) ([]*BundleCallResult, error) {
+	if len(args) == 0 {
+		return nil, errors.New("empty call bundle")
+	}
+	defer func(start time.Time) {
+		log.Debug("Executing EVM call bundle finished", "calls", len(args), "runtime", time.Since(start))
+	}(time.Now())
+
+	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	if err := overrides.Apply(state); err != nil {
+		return nil, err
+	}
+	var (
+		timeout = s.b.RPCEVMTimeout()
+		cancel  context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	results := make([]*BundleCallResult, 0, len(args))
+	for i, call := range args {
+		// Calls have no transaction hash, so collect the logs of the bundle under
+		// the zero hash and slice out the ones emitted by the current call
+		state.Prepare(common.Hash{}, header.Hash(), i)
+		logged := len(state.GetLogs(common.Hash{}))
+		snapshot := state.Snapshot()
+
+		result, callErr := s.applyCall(ctx, call, state, header, blockOverrides, vm.Config{}, true)
+		if err := ctx.Err(); err == context.DeadlineExceeded {
+			return nil, fmt.Errorf("execution aborted (timeout = %v)", timeout)
+		} else if err != nil {
+			return nil, err
+		}
+		if callErr != nil {
+			// The message could not be applied at all, drop any partial change
+			state.RevertToSnapshot(snapshot)
+			results = append(results, &BundleCallResult{Logs: []*types.Log{}, Error: callErr.Error()})
+			continue
+		}
+		logs := state.GetLogs(common.Hash{})[logged:]
+		if logs == nil {
+			logs = []*types.Log{}
+		}
+		res := &BundleCallResult{
+			ReturnData: result.Return(),
+			GasUsed:    hexutil.Uint64(result.UsedGas),
+			Logs:       logs,
+		}
+		if result.Failed() {
+			res.Error = result.Err.Error()
+			if result.Err == vm.ErrExecutionReverted {
+				res.RevertData = result.Revert()
+				if reason, err := abi.UnpackRevert(result.Revert()); err == nil {
+					res.RevertReason = reason
+				}
+			}
+		}
+		results = append(results, res)
+
+		// Seal the changes of the call so that the next one starts from a clean slate
+		state.Finalise(true)
+	}
+	return results, nil
+}
+
 // ExecutionResult groups all structured logs emitted by the EVM
 // while replaying a transaction in debug mode as well as transaction
 // execution status, the amount of gas used and the return value
@@ -1079,9 +1307,96 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, ha
 	if receipt.ContractAddress != (common.Address{}) {
 		fields["contractAddress"] = receipt.ContractAddress
 	}
+	// Attach the revert reason of reverted transactions, if it's known
+	if len(receipt.RevertReason) > 0 {
+		fields["revertReason"] = hexutil.Bytes(receipt.RevertReason)
+	}
 	return fields, nil
 }
 
+const (
+	// defaultAddressTxLimit is the number of transactions returned by a single
+	// GetTransactionsByAddress call if no limit is requested.
+	defaultAddressTxLimit = 100
+
+	// maxAddressTxLimit is the maximum number of transactions returned by a single
+	// GetTransactionsByAddress call.
+	maxAddressTxLimit = 1000
+)
+
+// AddressTransactionsResult is a page of the transactions touching an address. If
+// more transactions are available, Cursor can be passed to the next call to
+// continue the listing.
+type AddressTransactionsResult struct {
+	Transactions []*RPCTransaction `json:"transactions"`
+	Cursor       hexutil.Bytes     `json:"cursor"`
+}
+
+// GetTransactionsByAddress returns the transactions sent from or to an address
+// (or creating it, or emitting logs from it if the node indexes logs too) between
+// two blocks, in chain order. The listing is paginated, the cursor of a previous
+// result continues where it left off. Requires the node to maintain the address
+// transaction index.
+func (s *PublicTransactionPoolAPI) GetTransactionsByAddress(ctx context.Context, address common.Address, fromBlock, toBlock rpc.BlockNumber, cursor *hexutil.Bytes, limit *hexutil.Uint) (*AddressTransactionsResult, error) {
+	head := s.b.CurrentBlock().NumberU64()
+	resolve := func(number rpc.BlockNumber) uint64 {
+		if number < 0 || uint64(number) > head {
+			return head
+		}
+		return uint64(number)
+	}
+	from, to := resolve(fromBlock), resolve(toBlock)
+	if from > to {
+		return nil, fmt.Errorf("invalid block range: %d > %d", from, to)
+	}
+	// Continue from the cursor of a previous page, if given
+	var index uint64
+	if cursor != nil && len(*cursor) > 0 {
+		if len(*cursor) != 16 {
+			return nil, errors.New("invalid cursor")
+		}
+		number := binary.BigEndian.Uint64((*cursor)[:8])
+		if number < from {
+			return nil, errors.New("cursor outside of the block range")
+		}
+		from, index = number, binary.BigEndian.Uint64((*cursor)[8:])
+	}
+	count := defaultAddressTxLimit
+	if limit != nil {
+		count = int(*limit)
+	}
+	if count <= 0 || count > maxAddressTxLimit {
+		return nil, fmt.Errorf("invalid limit %d (1-%d)", count, maxAddressTxLimit)
+	}
+	// Retrieve one posting more than requested to know where the next page starts
+	entries, err := s.b.GetAddressTransactions(ctx, address, from, to, index, count+1)
+	if err != nil {
+		return nil, err
+	}
+	result := &AddressTransactionsResult{Transactions: make([]*RPCTransaction, 0, len(entries))}
+	if len(entries) > count {
+		next := entries[count]
+		result.Cursor = make(hexutil.Bytes, 16)
+		binary.BigEndian.PutUint64(result.Cursor[:8], next.BlockNumber)
+		binary.BigEndian.PutUint64(result.Cursor[8:], next.Index)
+
+		entries = entries[:count]
+	}
+	var block *types.Block
+	for _, entry := range entries {
+		if block == nil || block.NumberU64() != entry.BlockNumber {
+			if block, err = s.b.BlockByNumber(ctx, rpc.BlockNumber(entry.BlockNumber)); err != nil {
+				return nil, err
+			}
+		}
+		if block == nil || entry.Index >= uint64(len(block.Transactions())) || block.Transactions()[entry.Index].Hash() != entry.Hash {
+			return nil, fmt.Errorf("transaction %x reorged during retrieval", entry.Hash)
+		}
+		result.Transactions = append(result.Transactions, newRPCTransactionFromBlockIndex(block, entry.Index))
+	}
+	return result, nil
+}
+
 // sign is a helper function that signs a transaction with the private key of the given address.
 func (s *PublicTransactionPoolAPI) sign(addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
 	// Look up the wallet containing the requested signer
@@ -1412,33 +1727,21 @@ func NewPrivateDebugAPI(b Backend) *PrivateDebugAPI {
 	return &PrivateDebugAPI{b: b}
 }
 
-// ChaindbProperty returns leveldb properties of the chain database.
+// ChaindbProperty returns properties of the chain database. Properties without
+// a namespace are looked up in the leveldb one.
 func (api *PrivateDebugAPI) ChaindbProperty(property string) (string, error) {
-	ldb, ok := api.b.ChainDb().(interface {
-		LDB() *leveldb.DB
-	})
-	if !ok {
-		return "", fmt.Errorf("chaindbProperty does not work for memory databases")
-	}
 	if property == "" {
 		property = "leveldb.stats"
-	} else if !strings.HasPrefix(property, "leveldb.") {
+	} else if !strings.Contains(property, ".") {
 		property = "leveldb." + property
 	}
-	return ldb.LDB().GetProperty(property)
+	return api.b.ChainDb().Stat(property)
 }
 
 func (api *PrivateDebugAPI) ChaindbCompact() error {
-	ldb, ok := api.b.ChainDb().(interface {
-		LDB() *leveldb.DB
-	})
-	if !ok {
-		return fmt.Errorf("chaindbCompact does not work for memory databases")
-	}
 	for b := byte(0); b < 255; b++ {
 		log.Info("Compacting chain database", "range", fmt.Sprintf("0x%0.2X-0x%0.2X", b, b+1))
-		err := ldb.LDB().CompactRange(util.Range{Start: []byte{b}, Limit: []byte{b + 1}})
-		if err != nil {
+		if err := api.b.ChainDb().Compact([]byte{b}, []byte{b + 1}); err != nil {
 			log.Error("Database compaction failed", "err", err)
 			return err
 		}
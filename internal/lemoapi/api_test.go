@@ -0,0 +1,371 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemoapi
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/accounts"
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
+	"github.com/LemoFoundationLtd/lemochain-go/common/math"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/core/state"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+	"github.com/LemoFoundationLtd/lemochain-go/rpc"
+)
+
+// testBackend is an API backend running on top of a real blockchain. Only the
+// methods needed by the call APIs are implemented.
+type testBackend struct {
+	Backend
+
+	chain   *core.BlockChain
+	am      *accounts.Manager
+	timeout time.Duration
+}
+
+// newTestBackend creates a backend with a chain made of the given genesis only.
+// The Constantinople fork is scheduled for block 100.
+func newTestBackend(t *testing.T, alloc core.GenesisAlloc) *testBackend {
+	config := *params.TestChainConfig
+	config.ConstantinopleBlock = big.NewInt(100)
+
+	db, _ := lemodb.NewMemDatabase()
+	gspec := &core.Genesis{Config: &config, GasLimit: 8000000, Alloc: alloc}
+	gspec.MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, nil, &config, lemohash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	return &testBackend{chain: chain, am: accounts.NewManager(), timeout: 5 * time.Second}
+}
+
+func (b *testBackend) AccountManager() *accounts.Manager { return b.am }
+func (b *testBackend) ChainConfig() *params.ChainConfig  { return b.chain.Config() }
+func (b *testBackend) CurrentBlock() *types.Block        { return b.chain.CurrentBlock() }
+func (b *testBackend) RPCEVMTimeout() time.Duration      { return b.timeout }
+
+func (b *testBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Block, error) {
+	if blockNr == rpc.LatestBlockNumber || blockNr == rpc.PendingBlockNumber {
+		return b.chain.CurrentBlock(), nil
+	}
+	return b.chain.GetBlockByNumber(uint64(blockNr)), nil
+}
+
+func (b *testBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	block, _ := b.BlockByNumber(ctx, blockNr)
+	statedb, err := b.chain.StateAt(block.Root())
+	return statedb, block.Header(), err
+}
+
+func (b *testBackend) GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header, vmCfg vm.Config) (*vm.EVM, func() error, error) {
+	state.SetBalance(msg.From(), math.MaxBig256)
+	vmError := func() error { return nil }
+
+	context := core.NewEVMContext(msg, header, b.chain, nil)
+	return vm.NewEVM(context, state, b.chain.Config(), vmCfg), vmError, nil
+}
+
+var (
+	testSender   = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	testContract = common.HexToAddress("0x2000000000000000000000000000000000000002")
+
+	// sloadCode returns the storage slot given as the first 32 bytes of calldata
+	sloadCode = common.FromHex("6000355460005260206000f3")
+	// balanceCode returns the balance of the caller
+	balanceCode = common.FromHex("333160005260206000f3")
+	// createCode deploys an empty contract and returns its address
+	createCode = common.FromHex("600060006000f060005260206000f3")
+	// create2Code deploys an empty contract with CREATE2 and returns its address
+	create2Code = common.FromHex("6000600060006000f560005260206000f3")
+	// blockCode returns the block number, timestamp and coinbase
+	blockCode = common.FromHex("43600052426020524160405260606000f3")
+	// guardCode reverts unless storage slot 0 is set
+	guardCode = common.FromHex("600054600a57600080fd5b00")
+	// counterCode increments storage slot 0, logs and returns the new value. If
+	// any calldata is given, it reverts with the new value after logging it.
+	counterCode = common.FromHex("6000546001018060005560005260206000a036601b5760206000f35b60206000fd")
+	// loopCode spins until it runs out of gas
+	loopCode = common.FromHex("5b600056")
+	// revertCode reverts with the reason "boom"
+	revertCode = common.FromHex("7f08c379a000000000000000000000000000000000000000000000000000000000600052602060045260046024527f626f6f6d0000000000000000000000000000000000000000000000000000000060445260646000fd")
+)
+
+func TestCallStateOverrides(t *testing.T) {
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testSender: {Balance: big.NewInt(1000000000)},
+		testContract: {Code: sloadCode, Balance: new(big.Int), Storage: map[common.Hash]common.Hash{
+			common.BigToHash(big.NewInt(1)): common.BigToHash(big.NewInt(0xaa)),
+			common.BigToHash(big.NewInt(2)): common.BigToHash(big.NewInt(0xbb)),
+		}},
+	})
+	api := NewPublicBlockChainAPI(backend)
+
+	slot := func(n int64) hexutil.Bytes { return common.BigToHash(big.NewInt(n)).Bytes() }
+	storage := map[common.Hash]common.Hash{common.BigToHash(big.NewInt(1)): common.BigToHash(big.NewInt(0xcc))}
+	balance := (*hexutil.Big)(big.NewInt(12345))
+	nonce := hexutil.Uint64(7)
+	code := hexutil.Bytes(balanceCode)
+	factory := hexutil.Bytes(createCode)
+
+	tests := []struct {
+		name      string
+		to        common.Address
+		data      hexutil.Bytes
+		overrides StateOverride
+		want      common.Hash
+	}{
+		{"no override", testContract, slot(2), nil, common.BigToHash(big.NewInt(0xbb))},
+		{"state replaced", testContract, slot(1), StateOverride{testContract: {State: &storage}}, common.BigToHash(big.NewInt(0xcc))},
+		{"state dropped", testContract, slot(2), StateOverride{testContract: {State: &storage}}, common.Hash{}},
+		{"state diff", testContract, slot(1), StateOverride{testContract: {StateDiff: &storage}}, common.BigToHash(big.NewInt(0xcc))},
+		{"state diff kept", testContract, slot(2), StateOverride{testContract: {StateDiff: &storage}}, common.BigToHash(big.NewInt(0xbb))},
+		{"sender balance", testContract, nil, StateOverride{testContract: {Code: &code}, testSender: {Balance: balance}}, common.BigToHash(balance.ToInt())},
+		{"nonce", testContract, nil, StateOverride{testContract: {Code: &factory, Nonce: &nonce}}, crypto.CreateAddress(testContract, 7).Hash()},
+	}
+	for _, tt := range tests {
+		overrides := tt.overrides
+		to := tt.to
+		out, err := api.Call(context.Background(), CallArgs{From: testSender, To: &to, Data: tt.data}, rpc.LatestBlockNumber, &overrides, nil)
+		if err != nil {
+			t.Errorf("%s: call failed: %v", tt.name, err)
+			continue
+		}
+		if have := common.BytesToHash(out); have != tt.want {
+			t.Errorf("%s: result mismatch: have %x, want %x", tt.name, have, tt.want)
+		}
+	}
+	// Overrides must not leak into the chain state
+	out, err := api.Call(context.Background(), CallArgs{From: testSender, To: &testContract, Data: slot(1)}, rpc.LatestBlockNumber, nil, nil)
+	if err != nil || common.BytesToHash(out) != common.BigToHash(big.NewInt(0xaa)) {
+		t.Errorf("state modified by overrides: have %x, %v", out, err)
+	}
+	// Replacing and diffing the storage of the same account is contradictory
+	overrides := StateOverride{testContract: {State: &storage, StateDiff: &storage}}
+	if _, err := api.Call(context.Background(), CallArgs{From: testSender, To: &testContract}, rpc.LatestBlockNumber, &overrides, nil); err == nil {
+		t.Errorf("expected error for conflicting state and stateDiff")
+	}
+}
+
+func TestCallBlockOverrides(t *testing.T) {
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testContract: {Code: blockCode, Balance: new(big.Int)},
+	})
+	api := NewPublicBlockChainAPI(backend)
+
+	coinbase := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	overrides := &BlockOverrides{
+		Number:   (*hexutil.Big)(big.NewInt(42)),
+		Time:     (*hexutil.Big)(big.NewInt(1234567)),
+		Coinbase: &coinbase,
+	}
+	out, err := api.Call(context.Background(), CallArgs{From: testSender, To: &testContract}, rpc.LatestBlockNumber, nil, overrides)
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if len(out) != 96 {
+		t.Fatalf("result length mismatch: have %d, want 96", len(out))
+	}
+	if number := new(big.Int).SetBytes(out[:32]); number.Int64() != 42 {
+		t.Errorf("number mismatch: have %v, want 42", number)
+	}
+	if time := new(big.Int).SetBytes(out[32:64]); time.Int64() != 1234567 {
+		t.Errorf("timestamp mismatch: have %v, want 1234567", time)
+	}
+	if have := common.BytesToAddress(out[64:]); have != coinbase {
+		t.Errorf("coinbase mismatch: have %x, want %x", have, coinbase)
+	}
+}
+
+// Tests that overriding the block number also switches the fork rules of the
+// interpreter, not only the NUMBER opcode.
+func TestCallBlockOverridesForkRules(t *testing.T) {
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testContract: {Code: create2Code, Balance: new(big.Int)},
+	})
+	api := NewPublicBlockChainAPI(backend)
+
+	result, err := api.doCall(context.Background(), CallArgs{From: testSender, To: &testContract}, rpc.LatestBlockNumber, nil, nil, vm.Config{}, 0)
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if !result.Failed() {
+		t.Errorf("CREATE2 succeeded before Constantinople")
+	}
+	overrides := &BlockOverrides{Number: (*hexutil.Big)(big.NewInt(100))}
+	result, err = api.doCall(context.Background(), CallArgs{From: testSender, To: &testContract}, rpc.LatestBlockNumber, nil, overrides, vm.Config{}, 0)
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if result.Failed() {
+		t.Fatalf("CREATE2 failed after Constantinople: %v", result.Err)
+	}
+	if want := crypto.CreateAddress2(testContract, [32]byte{}, crypto.Keccak256(nil)); common.BytesToAddress(result.ReturnData) != want {
+		t.Errorf("created address mismatch: have %x, want %x", result.ReturnData, want)
+	}
+}
+
+func TestEstimateGasOverrides(t *testing.T) {
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testContract: {Code: guardCode, Balance: new(big.Int)},
+	})
+	api := NewPublicBlockChainAPI(backend)
+
+	if _, err := api.EstimateGas(context.Background(), CallArgs{From: testSender, To: &testContract}, nil, nil); err == nil {
+		t.Fatalf("expected estimation to fail without overrides")
+	}
+	diff := map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(1))}
+	overrides := StateOverride{testContract: {StateDiff: &diff}}
+	gas, err := api.EstimateGas(context.Background(), CallArgs{From: testSender, To: &testContract}, &overrides, nil)
+	if err != nil {
+		t.Fatalf("estimation failed: %v", err)
+	}
+	if uint64(gas) <= params.TxGas {
+		t.Errorf("estimated gas too low: %d", gas)
+	}
+}
+
+func TestCallBundle(t *testing.T) {
+	var (
+		counter   = common.HexToAddress("0x4000000000000000000000000000000000000004")
+		recipient = common.HexToAddress("0x5000000000000000000000000000000000000005")
+	)
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testSender:   {Balance: big.NewInt(1000)},
+		testContract: {Code: balanceCode, Balance: new(big.Int)},
+		counter:      {Code: counterCode, Balance: new(big.Int)},
+	})
+	api := NewPublicBlockChainAPI(backend)
+
+	value := func(n int64) hexutil.Big { return hexutil.Big(*big.NewInt(n)) }
+	results, err := api.CallBundle(context.Background(), []CallArgs{
+		{From: testSender, To: &counter},
+		{From: testSender, To: &counter, Data: hexutil.Bytes{0x01}},
+		{From: testSender, To: &counter},
+		{From: testSender, To: &recipient, Value: value(600)},
+		{From: testSender, To: &recipient, Value: value(600)},
+		{From: testSender, To: &testContract},
+	}, rpc.LatestBlockNumber, nil, nil)
+	if err != nil {
+		t.Fatalf("bundle failed: %v", err)
+	}
+	if len(results) != 6 {
+		t.Fatalf("result count mismatch: have %d, want 6", len(results))
+	}
+	one, two := common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(2))
+
+	// The first call increments the counter and logs once
+	if have := common.BytesToHash(results[0].ReturnData); have != one || results[0].Error != "" {
+		t.Errorf("call 0: result mismatch: have %x, %q", have, results[0].Error)
+	}
+	if len(results[0].Logs) != 1 || common.BytesToHash(results[0].Logs[0].Data) != one || results[0].Logs[0].TxIndex != 0 {
+		t.Errorf("call 0: logs mismatch: have %v", results[0].Logs)
+	}
+	// The second call reverts, dropping its increment and log
+	if results[1].Error != vm.ErrExecutionReverted.Error() || common.BytesToHash(results[1].RevertData) != two {
+		t.Errorf("call 1: revert mismatch: have %q, %x", results[1].Error, results[1].RevertData)
+	}
+	if len(results[1].Logs) != 0 || results[1].GasUsed == 0 {
+		t.Errorf("call 1: have %d logs, %d gas", len(results[1].Logs), results[1].GasUsed)
+	}
+	// The third call sees the state of the first one only
+	if have := common.BytesToHash(results[2].ReturnData); have != two {
+		t.Errorf("call 2: result mismatch: have %x, want %x", have, two)
+	}
+	if len(results[2].Logs) != 1 || common.BytesToHash(results[2].Logs[0].Data) != two || results[2].Logs[0].TxIndex != 2 || results[2].Logs[0].Index != 1 {
+		t.Errorf("call 2: logs mismatch: have %v", results[2].Logs)
+	}
+	// Transfers are paid from the real balance, the second one can't be afforded
+	// but doesn't abort the bundle
+	if results[3].Error != "" {
+		t.Errorf("call 3: unexpected error: %v", results[3].Error)
+	}
+	if results[4].Error == "" {
+		t.Errorf("call 4: expected insufficient balance error")
+	}
+	if have := new(big.Int).SetBytes(results[5].ReturnData); have.Int64() != 400 {
+		t.Errorf("call 5: sender balance mismatch: have %v, want 400", have)
+	}
+	if _, err := api.CallBundle(context.Background(), nil, rpc.LatestBlockNumber, nil, nil); err == nil {
+		t.Errorf("expected error for empty bundle")
+	}
+}
+
+func TestCallBundleTimeout(t *testing.T) {
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testContract: {Code: loopCode, Balance: new(big.Int)},
+	})
+	backend.timeout = 50 * time.Millisecond
+	api := NewPublicBlockChainAPI(backend)
+
+	_, err := api.CallBundle(context.Background(), []CallArgs{
+		{From: testSender, To: &testContract},
+		{From: testSender, To: &testContract},
+	}, rpc.LatestBlockNumber, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "execution aborted") {
+		t.Fatalf("expected timeout error, have %v", err)
+	}
+}
+
+// Tests that reverting calls and gas estimations return an error holding both
+// the decoded revert reason and the raw revert data.
+func TestCallRevert(t *testing.T) {
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testContract: {Code: revertCode, Balance: new(big.Int)},
+	})
+	api := NewPublicBlockChainAPI(backend)
+
+	revertData := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"626f6f6d00000000000000000000000000000000000000000000000000000000"
+
+	_, callErr := api.Call(context.Background(), CallArgs{From: testSender, To: &testContract}, rpc.LatestBlockNumber, nil, nil)
+	_, estimateErr := api.EstimateGas(context.Background(), CallArgs{From: testSender, To: &testContract}, nil, nil)
+
+	for name, err := range map[string]error{"call": callErr, "estimate": estimateErr} {
+		if err == nil {
+			t.Errorf("%s: expected revert error", name)
+			continue
+		}
+		if err.Error() != "execution reverted: boom" {
+			t.Errorf("%s: error message mismatch: have %q", name, err.Error())
+		}
+		if code := err.(rpc.Error).ErrorCode(); code != 3 {
+			t.Errorf("%s: error code mismatch: have %d, want 3", name, code)
+		}
+		derr, ok := err.(rpc.DataError)
+		if !ok {
+			t.Errorf("%s: error has no data: %T", name, err)
+			continue
+		}
+		if data := derr.ErrorData(); data != revertData {
+			t.Errorf("%s: error data mismatch: have %v, want %v", name, data, revertData)
+		}
+	}
+}
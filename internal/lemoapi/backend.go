@@ -20,6 +20,7 @@ package lemoapi
 import (
 	"context"
 	"math/big"
+	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go/accounts"
 	"github.com/LemoFoundationLtd/lemochain-go/common"
@@ -53,6 +54,7 @@ type Backend interface {
 	GetBlock(ctx context.Context, blockHash common.Hash) (*types.Block, error)
 	GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error)
 	GetTd(blockHash common.Hash) *big.Int
+	GetAddressTransactions(ctx context.Context, address common.Address, from, to, index uint64, limit int) ([]core.AddressTxEntry, error)
 	GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header, vmCfg vm.Config) (*vm.EVM, func() error, error)
 	SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription
 	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
@@ -69,6 +71,7 @@ type Backend interface {
 
 	ChainConfig() *params.ChainConfig
 	CurrentBlock() *types.Block
+	RPCEVMTimeout() time.Duration // global timeout for lemo_call and lemo_callBundle
 }
 
 func GetAPIs(apiBackend Backend) []rpc.API {
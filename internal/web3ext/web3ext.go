@@ -22,6 +22,7 @@ var Modules = map[string]string{
 	"chequebook": Chequebook_JS,
 	"clique":     Clique_JS,
 	"debug":      Debug_JS,
+	"dpovp":      Dpovp_JS,
 	"lemo":        Lemo_JS,
 	"miner":      Miner_JS,
 	"net":        Net_JS,
@@ -109,6 +110,64 @@ web3._extend({
 });
 `
 
+const Dpovp_JS = `
+web3._extend({
+	property: 'dpovp',
+	methods: [
+		new web3._extend.Method({
+			name: 'getSnapshot',
+			call: 'dpovp_getSnapshot',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'getSnapshotAtHash',
+			call: 'dpovp_getSnapshotAtHash',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getDeputies',
+			call: 'dpovp_getDeputies',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'getDeputiesAtHash',
+			call: 'dpovp_getDeputiesAtHash',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getConfirmations',
+			call: 'dpovp_getConfirmations',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'getFinalized',
+			call: 'dpovp_getFinalized',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'propose',
+			call: 'dpovp_propose',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'discard',
+			call: 'dpovp_discard',
+			params: 1
+		}),
+	],
+	properties: [
+		new web3._extend.Property({
+			name: 'proposals',
+			getter: 'dpovp_proposals'
+		}),
+	]
+});
+`
+
 const Admin_JS = `
 web3._extend({
 	property: 'admin',
@@ -134,6 +193,11 @@ web3._extend({
 			call: 'admin_importChain',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'backupDatabase',
+			call: 'admin_backupDatabase',
+			params: 1
+		}),
 		new web3._extend.Method({
 			name: 'sleepBlocks',
 			call: 'admin_sleepBlocks',
@@ -423,6 +487,12 @@ web3._extend({
 			call: 'lemo_getRawTransactionByHash',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'callBundle',
+			call: 'lemo_callBundle',
+			params: 4,
+			inputFormatter: [null, web3._extend.formatters.inputDefaultBlockNumberFormatter, null, null]
+		}),
 		new web3._extend.Method({
 			name: 'getRawTransactionFromBlock',
 			call: function(args) {
@@ -431,6 +501,12 @@ web3._extend({
 			params: 2,
 			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, web3._extend.utils.toHex]
 		}),
+		new web3._extend.Method({
+			name: 'getTransactionsByAddress',
+			call: 'lemo_getTransactionsByAddress',
+			params: 5,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputBlockNumberFormatter, web3._extend.formatters.inputBlockNumberFormatter, null, null]
+		}),
 	],
 	properties: [
 		new web3._extend.Property({
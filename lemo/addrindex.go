@@ -0,0 +1,256 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+const (
+	// addrIndexSectionSize is the number of blocks in a single section of the
+	// address transaction index.
+	addrIndexSectionSize = 4096
+
+	// addrIndexConfirms is the number of confirmation blocks before a section of
+	// the address transaction index is considered final and gets indexed.
+	addrIndexConfirms = 256
+
+	// addrIndexThrottling is the time to wait between processing two consecutive
+	// index sections, preventing disk overload while indexing an existing chain.
+	addrIndexThrottling = 100 * time.Millisecond
+)
+
+var (
+	// errAddressIndexDisabled is returned if the address transactions are queried
+	// from a node not maintaining the index.
+	errAddressIndexDisabled = errors.New("address transaction index disabled")
+
+	// errAddressIndexSyncing is returned if the address transactions are queried
+	// over a chain range not indexed yet.
+	errAddressIndexSyncing = errors.New("address transaction index is still being generated")
+)
+
+// AddressIndexer implements a core.ChainIndexer, building up an index of all the
+// transactions touching an address (as sender, recipient, created contract and
+// optionally as log emitter) for every section of the canonical chain.
+type AddressIndexer struct {
+	db     lemodb.Database     // database instance to write index data and metadata into
+	config *params.ChainConfig // chain configuration to recover the transaction senders
+	logs   bool                // whether to index the addresses of the emitted logs too
+
+	section  uint64                                   // Section is the section number being processed currently
+	head     common.Hash                              // Head is the hash of the last header processed
+	postings map[common.Address][]core.AddressTxEntry // Transaction postings of the current section
+}
+
+// NewAddressIndexer returns a chain indexer that generates the address transaction
+// index for the canonical chain.
+func NewAddressIndexer(db lemodb.Database, config *params.ChainConfig, size, confirms uint64, logs bool) *core.ChainIndexer {
+	backend := &AddressIndexer{
+		db:     db,
+		config: config,
+		logs:   logs,
+	}
+	table := lemodb.NewTable(db, string(core.AddressIndexPrefix))
+
+	return core.NewChainIndexer(db, table, backend, size, confirms, addrIndexThrottling, "addrindex")
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new address index section.
+func (a *AddressIndexer) Reset(section uint64, lastSectionHead common.Hash) error {
+	a.section, a.head = section, common.Hash{}
+	a.postings = make(map[common.Address][]core.AddressTxEntry)
+	return nil
+}
+
+// Process implements core.ChainIndexerBackend, adding the transactions of a new
+// block into the index.
+func (a *AddressIndexer) Process(header *types.Header) {
+	hash, number := header.Hash(), header.Number.Uint64()
+	a.head = hash
+
+	body := core.GetBody(a.db, hash, number)
+	if body == nil {
+		log.Error("Missing body of indexed block", "number", number, "hash", hash)
+		return
+	}
+	var receipts types.Receipts
+	if a.logs {
+		receipts = core.GetBlockReceipts(a.db, hash, number)
+	}
+	block := types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles)
+	for address, entries := range addressTxEntries(block, receipts, types.MakeSigner(a.config, header.Number)) {
+		a.postings[address] = append(a.postings[address], entries...)
+	}
+}
+
+// Commit implements core.ChainIndexerBackend, writing the postings of the section
+// out into the database.
+func (a *AddressIndexer) Commit() error {
+	batch := a.db.NewBatch()
+
+	addresses := make([]common.Address, 0, len(a.postings))
+	for address, entries := range a.postings {
+		if err := core.WriteAddressTxEntries(batch, address, a.section, a.head, entries); err != nil {
+			return err
+		}
+		addresses = append(addresses, address)
+		if batch.ValueSize() >= lemodb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := core.WriteAddressIndexSection(batch, a.section, a.head, addresses); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// Rollback implements core.ChainIndexerRollbacker, removing the postings of a
+// section reorged out of the canonical chain.
+func (a *AddressIndexer) Rollback(section uint64, head common.Hash) error {
+	return core.DeleteAddressIndexSection(a.db, section, head)
+}
+
+// addressTxEntries collects the transaction postings of a block for every address
+// touched by its transactions: senders, recipients, created contracts and, if the
+// receipts are given, the addresses of the emitted logs.
+func addressTxEntries(block *types.Block, receipts types.Receipts, signer types.Signer) map[common.Address][]core.AddressTxEntry {
+	postings := make(map[common.Address][]core.AddressTxEntry)
+	for i, tx := range block.Transactions() {
+		touched := make(map[common.Address]struct{})
+
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			log.Error("Failed to recover indexed transaction sender", "hash", tx.Hash(), "err", err)
+			continue
+		}
+		touched[from] = struct{}{}
+		if to := tx.To(); to != nil {
+			touched[*to] = struct{}{}
+		} else {
+			touched[crypto.CreateAddress(from, tx.Nonce())] = struct{}{}
+		}
+		if i < len(receipts) {
+			for _, l := range receipts[i].Logs {
+				touched[l.Address] = struct{}{}
+			}
+		}
+		entry := core.AddressTxEntry{BlockNumber: block.NumberU64(), Index: uint64(i), Hash: tx.Hash()}
+		for address := range touched {
+			postings[address] = append(postings[address], entry)
+		}
+	}
+	return postings
+}
+
+// addressIndex serves the address transaction queries, reading the sections
+// already processed by an AddressIndexer and scanning the unindexed chain tail.
+type addressIndex struct {
+	indexer  *core.ChainIndexer  // indexer generating the address transaction index
+	db       lemodb.Database     // database instance to read the index and the blocks from
+	config   *params.ChainConfig // chain configuration to recover the transaction senders
+	size     uint64              // number of blocks in a single index section
+	confirms uint64              // number of confirmations before a section is indexed
+	logs     bool                // whether the addresses of the emitted logs are indexed too
+}
+
+// newAddressIndex creates an address transaction index over the given database.
+func newAddressIndex(db lemodb.Database, config *params.ChainConfig, size, confirms uint64, logs bool) *addressIndex {
+	return &addressIndex{
+		indexer:  NewAddressIndexer(db, config, size, confirms, logs),
+		db:       db,
+		config:   config,
+		size:     size,
+		confirms: confirms,
+		logs:     logs,
+	}
+}
+
+// transactions retrieves the postings of the transactions touching an address
+// between two blocks (both inclusive), starting at the given transaction index of
+// the first block. At most limit postings are returned. Sections already indexed
+// are served from the database, the rest of the range is scanned.
+func (idx *addressIndex) transactions(ctx context.Context, address common.Address, from, to, index uint64, limit int) ([]core.AddressTxEntry, error) {
+	var entries []core.AddressTxEntry
+	sections, _, _ := idx.indexer.Sections()
+
+	// include appends the postings within the requested range, reporting whether
+	// the result set is complete.
+	include := func(postings []core.AddressTxEntry) bool {
+		for _, entry := range postings {
+			if entry.BlockNumber < from || (entry.BlockNumber == from && entry.Index < index) || entry.BlockNumber > to {
+				continue
+			}
+			entries = append(entries, entry)
+			if len(entries) >= limit {
+				return true
+			}
+		}
+		return false
+	}
+	// Serve the indexed sections from the database
+	for section := from / idx.size; section < sections && section*idx.size <= to; section++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		head := core.GetCanonicalHash(idx.db, (section+1)*idx.size-1)
+		if include(core.GetAddressTxEntries(idx.db, address, section, head)) {
+			return entries, nil
+		}
+	}
+	// Scan the blocks of the unindexed tail of the range
+	start := sections * idx.size
+	if start < from {
+		start = from
+	}
+	if start <= to && to-start >= idx.size+idx.confirms {
+		return nil, fmt.Errorf("%v (%d sections done)", errAddressIndexSyncing, sections)
+	}
+	for number := start; number <= to; number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hash := core.GetCanonicalHash(idx.db, number)
+		block := core.GetBlock(idx.db, hash, number)
+		if block == nil {
+			break
+		}
+		var receipts types.Receipts
+		if idx.logs {
+			receipts = core.GetBlockReceipts(idx.db, hash, number)
+		}
+		postings := addressTxEntries(block, receipts, types.MakeSigner(idx.config, block.Number()))
+		if include(postings[address]) {
+			break
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemo
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/core/vm"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/params"
+)
+
+// waitAddressIndex waits until the address index processed the given number of
+// sections, the last one ending at the given canonical block.
+func waitAddressIndex(t *testing.T, index *addressIndex, sections uint64, head common.Hash) {
+	for i := 0; i < 100; i++ {
+		if have, _, shead := index.indexer.Sections(); have == sections && shead == head {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	have, _, _ := index.indexer.Sections()
+	t.Fatalf("address index not generated: have %d sections, want %d", have, sections)
+}
+
+// Tests that the address index lists all the transactions touching an address,
+// both from indexed sections and the unindexed chain tail, and that reorged
+// sections are rolled back and reindexed.
+func TestAddressIndex(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender  = crypto.PubkeyToAddress(key.PublicKey)
+		target  = common.Address{0x02}
+		forked  = common.Address{0x03}
+		signer  = types.HomesteadSigner{}
+		db, _   = lemodb.NewMemDatabase()
+		gspec   = &core.Genesis{Config: params.TestChainConfig, Alloc: core.GenesisAlloc{sender: {Balance: big.NewInt(1000000000000000000)}}}
+		genesis = gspec.MustCommit(db)
+
+		want    []common.Hash
+		created common.Address
+	)
+	// Generate a chain sending a transaction to the target every third block
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, lemohash.NewFaker(), db, 22, func(i int, gen *core.BlockGen) {
+		if i%3 == 0 {
+			tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(sender), target, big.NewInt(1), params.TxGas, nil, nil), signer, key)
+			gen.AddTx(tx)
+			want = append(want, tx.Hash())
+		}
+		if i == 4 {
+			created = crypto.CreateAddress(sender, gen.TxNonce(sender))
+			tx, _ := types.SignTx(types.NewContractCreation(gen.TxNonce(sender), big.NewInt(0), 100000, nil, []byte{0x00}), signer, key)
+			gen.AddTx(tx)
+		}
+	})
+	chain, _ := core.NewBlockChain(db, nil, gspec.Config, lemohash.NewFaker(), vm.Config{})
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	index := newAddressIndex(db, gspec.Config, 4, 2, false)
+	index.indexer.Start(chain)
+	defer index.indexer.Close()
+
+	// Blocks 0-19 are indexed, the rest is in the unindexed tail
+	waitAddressIndex(t, index, 5, blocks[18].Hash())
+
+	entries, err := index.transactions(context.Background(), target, 0, 22, 0, 100)
+	if err != nil {
+		t.Fatalf("failed to retrieve transactions: %v", err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("transaction count mismatch: have %d, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Hash != want[i] {
+			t.Errorf("transaction %d: hash mismatch: have %x, want %x", i, entry.Hash, want[i])
+		}
+	}
+	if entries, _ := index.transactions(context.Background(), created, 0, 22, 0, 100); len(entries) != 1 {
+		t.Errorf("contract creation count mismatch: have %d, want 1", len(entries))
+	}
+	// Page through the transactions of the sender and ensure nothing is skipped
+	all, _ := index.transactions(context.Background(), sender, 0, 22, 0, 100)
+	if len(all) != len(want)+1 {
+		t.Fatalf("sender transaction count mismatch: have %d, want %d", len(all), len(want)+1)
+	}
+	var (
+		paged       []core.AddressTxEntry
+		from, start uint64
+	)
+	for {
+		page, err := index.transactions(context.Background(), sender, from, 22, start, 3)
+		if err != nil {
+			t.Fatalf("failed to retrieve page: %v", err)
+		}
+		paged = append(paged, page...)
+		if len(page) < 3 {
+			break
+		}
+		last := page[len(page)-1]
+		from, start = last.BlockNumber, last.Index+1
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("paged transaction count mismatch: have %d, want %d", len(paged), len(all))
+	}
+	// Reorg the chain from block 10 and ensure the stale sections are replaced
+	stale := blocks[10].Hash()
+	if addresses := core.GetAddressIndexSection(db, 2, stale); len(addresses) == 0 {
+		t.Fatalf("section 2 not indexed")
+	}
+	fork, _ := core.GenerateChain(gspec.Config, blocks[9], lemohash.NewFaker(), db, 15, func(i int, gen *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(sender), forked, big.NewInt(1), params.TxGas, nil, nil), signer, key)
+		gen.AddTx(tx)
+	})
+	if _, err := chain.InsertChain(fork); err != nil {
+		t.Fatalf("failed to insert fork: %v", err)
+	}
+	waitAddressIndex(t, index, 6, fork[12].Hash())
+
+	if addresses := core.GetAddressIndexSection(db, 2, stale); addresses != nil {
+		t.Errorf("reorged section not rolled back: %v", addresses)
+	}
+	if entries, _ := index.transactions(context.Background(), target, 0, 25, 0, 100); len(entries) != 4 {
+		t.Errorf("reorged transaction count mismatch: have %d, want 4", len(entries))
+	}
+	if entries, _ := index.transactions(context.Background(), forked, 0, 25, 0, 100); len(entries) != len(fork) {
+		t.Errorf("forked transaction count mismatch: have %d, want %d", len(entries), len(fork))
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/core"
 	"github.com/LemoFoundationLtd/lemochain-go/core/state"
 	"github.com/LemoFoundationLtd/lemochain-go/core/types"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
 	"github.com/LemoFoundationLtd/lemochain-go/log"
 	"github.com/LemoFoundationLtd/lemochain-go/miner"
 	"github.com/LemoFoundationLtd/lemochain-go/params"
@@ -291,6 +292,24 @@ func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
 	return true, nil
 }
 
+// BackupDatabase streams a consistent snapshot of the live chain database into
+// a local directory, or a tar archive if the path ends in .tar, .tar.gz or .tgz.
+func (api *PrivateAdminAPI) BackupDatabase(path string) (*core.BackupMeta, error) {
+	sink, err := lemodb.CreateBackup(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := api.lemo.BlockChain().Backup(sink)
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Chain database backed up", "path", path, "number", meta.Number, "hash", meta.Head, "entries", meta.Entries, "ancients", meta.Ancients)
+	return meta, nil
+}
+
 // PublicDebugAPI is the collection of Lemochain full node APIs exposed
 // over the public debugging endpoint.
 type PublicDebugAPI struct {
@@ -19,6 +19,7 @@ package lemo
 import (
 	"context"
 	"math/big"
+	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go/accounts"
 	"github.com/LemoFoundationLtd/lemochain-go/common"
@@ -50,6 +51,10 @@ func (b *LemoApiBackend) CurrentBlock() *types.Block {
 	return b.lemo.blockchain.CurrentBlock()
 }
 
+func (b *LemoApiBackend) RPCEVMTimeout() time.Duration {
+	return b.lemo.config.RPCEVMTimeout
+}
+
 func (b *LemoApiBackend) SetHead(number uint64) {
 	b.lemo.protocolManager.downloader.Cancel()
 	b.lemo.blockchain.SetHead(number)
@@ -78,7 +83,11 @@ func (b *LemoApiBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNum
 	if blockNr == rpc.LatestBlockNumber {
 		return b.lemo.blockchain.CurrentBlock(), nil
 	}
-	return b.lemo.blockchain.GetBlockByNumber(uint64(blockNr)), nil
+	block := b.lemo.blockchain.GetBlockByNumber(uint64(blockNr))
+	if block == nil && b.lemo.blockchain.HistoryPruned(uint64(blockNr)) {
+		return nil, core.ErrHistoryPruned
+	}
+	return block, nil
 }
 
 func (b *LemoApiBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
@@ -97,17 +106,25 @@ func (b *LemoApiBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc
 }
 
 func (b *LemoApiBackend) GetBlock(ctx context.Context, blockHash common.Hash) (*types.Block, error) {
-	return b.lemo.blockchain.GetBlockByHash(blockHash), nil
+	block := b.lemo.blockchain.GetBlockByHash(blockHash)
+	if block == nil && b.historyPruned(blockHash) {
+		return nil, core.ErrHistoryPruned
+	}
+	return block, nil
 }
 
 func (b *LemoApiBackend) GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error) {
-	return core.GetBlockReceipts(b.lemo.chainDb, blockHash, core.GetBlockNumber(b.lemo.chainDb, blockHash)), nil
+	receipts := core.GetBlockReceipts(b.lemo.chainDb, blockHash, core.GetBlockNumber(b.lemo.chainDb, blockHash))
+	if receipts == nil && b.historyPruned(blockHash) {
+		return nil, core.ErrHistoryPruned
+	}
+	return receipts, nil
 }
 
 func (b *LemoApiBackend) GetLogs(ctx context.Context, blockHash common.Hash) ([][]*types.Log, error) {
-	receipts := core.GetBlockReceipts(b.lemo.chainDb, blockHash, core.GetBlockNumber(b.lemo.chainDb, blockHash))
+	receipts, err := b.GetReceipts(ctx, blockHash)
 	if receipts == nil {
-		return nil, nil
+		return nil, err
 	}
 	logs := make([][]*types.Log, len(receipts))
 	for i, receipt := range receipts {
@@ -116,6 +133,13 @@ func (b *LemoApiBackend) GetLogs(ctx context.Context, blockHash common.Hash) ([]
 	return logs, nil
 }
 
+// historyPruned reports whether the block with the given hash is known, but its
+// history was pruned.
+func (b *LemoApiBackend) historyPruned(blockHash common.Hash) bool {
+	header := b.lemo.blockchain.GetHeaderByHash(blockHash)
+	return header != nil && b.lemo.blockchain.HistoryPruned(header.Number.Uint64())
+}
+
 func (b *LemoApiBackend) GetTd(blockHash common.Hash) *big.Int {
 	return b.lemo.blockchain.GetTdByHash(blockHash)
 }
@@ -128,6 +152,10 @@ func (b *LemoApiBackend) GetEVM(ctx context.Context, msg core.Message, state *st
 	return vm.NewEVM(context, state, b.lemo.chainConfig, vmCfg), vmError, nil
 }
 
+func (b *LemoApiBackend) SubscribeChainReorgEvent(ch chan<- core.ChainReorgEvent) event.Subscription {
+	return b.lemo.BlockChain().SubscribeChainReorgEvent(ch)
+}
+
 func (b *LemoApiBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
 	return b.lemo.BlockChain().SubscribeRemovedLogsEvent(ch)
 }
@@ -208,6 +236,13 @@ func (b *LemoApiBackend) AccountManager() *accounts.Manager {
 	return b.lemo.AccountManager()
 }
 
+func (b *LemoApiBackend) GetAddressTransactions(ctx context.Context, address common.Address, from, to, index uint64, limit int) ([]core.AddressTxEntry, error) {
+	if b.lemo.addrIndex == nil {
+		return nil, errAddressIndexDisabled
+	}
+	return b.lemo.addrIndex.transactions(ctx, address, from, to, index, limit)
+}
+
 func (b *LemoApiBackend) BloomStatus() (uint64, uint64) {
 	sections, _, _ := b.lemo.bloomIndexer.Sections()
 	return params.BloomBitsBlocks, sections
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -30,6 +31,7 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus/clique"
+	"github.com/LemoFoundationLtd/lemochain-go/consensus/dpovp"
 	"github.com/LemoFoundationLtd/lemochain-go/consensus/lemohash"
 	"github.com/LemoFoundationLtd/lemochain-go/core"
 	"github.com/LemoFoundationLtd/lemochain-go/core/bloombits"
@@ -63,8 +65,9 @@ type Lemochain struct {
 	chainConfig *params.ChainConfig
 
 	// Channel for shutting down the service
-	shutdownChan  chan bool    // Channel for shutting down the lemochain
-	stopDbUpgrade func() error // stop chain db sequential key upgrade
+	shutdownChan   chan bool    // Channel for shutting down the lemochain
+	stopDbUpgrade  func() error // stop chain db schema migrations
+	stopRecompress func()       // stop chain data recompression
 
 	// Handlers
 	txPool          *core.TxPool
@@ -81,6 +84,7 @@ type Lemochain struct {
 
 	bloomRequests chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 	bloomIndexer  *core.ChainIndexer             // Bloom indexer operating during block imports
+	addrIndex     *addressIndex                  // Address transaction index, nil if disabled
 
 	ApiBackend *LemoApiBackend
 
@@ -108,11 +112,23 @@ func New(ctx *node.ServiceContext, config *Config) (*Lemochain, error) {
 	if !config.SyncMode.IsValid() {
 		return nil, fmt.Errorf("invalid sync mode %d", config.SyncMode)
 	}
-	chainDb, err := CreateDB(ctx, config, "chaindata")
+	codec, err := core.ParseStorageCodec(config.DatabaseCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	freezer := config.DatabaseFreezer
+	if freezer == "" {
+		freezer = filepath.Join("chaindata", "ancient")
+	}
+	chainDb, err := CreateDB(ctx, config, "chaindata", freezer)
+	if err != nil {
+		return nil, err
+	}
+	stopDbUpgrade, err := upgradeDatabase(chainDb, ctx.DBReadOnly())
 	if err != nil {
 		return nil, err
 	}
-	stopDbUpgrade := upgradeDeduplicateData(chainDb)
 	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlock(chainDb, config.Genesis)
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
 		return nil, genesisErr
@@ -134,6 +150,9 @@ func New(ctx *node.ServiceContext, config *Config) (*Lemochain, error) {
 		bloomRequests:  make(chan chan *bloombits.Retrieval),
 		bloomIndexer:   NewBloomIndexer(chainDb, params.BloomBitsBlocks),
 	}
+	if config.AddressIndex {
+		lemo.addrIndex = newAddressIndex(chainDb, chainConfig, addrIndexSectionSize, addrIndexConfirms, config.AddressIndexLogs)
+	}
 
 	log.Info("Initialising Lemochain protocol", "versions", ProtocolVersions, "network", config.NetworkId)
 
@@ -145,8 +164,8 @@ func New(ctx *node.ServiceContext, config *Config) (*Lemochain, error) {
 		core.WriteBlockChainVersion(chainDb, core.BlockChainVersion)
 	}
 	var (
-		vmConfig    = vm.Config{EnablePreimageRecording: config.EnablePreimageRecording}
-		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout}
+		vmConfig    = vm.Config{EnablePreimageRecording: config.EnablePreimageRecording, ParallelExecution: config.ParallelExecution}
+		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout, FreezerThreshold: config.FreezerThreshold, HistoryRetain: config.HistoryRetain, NoSnapshot: config.NoSnapshot, TrieCleanLimit: config.TrieCleanCache, NoPrefetch: config.NoPrefetch, StorageCodec: codec, ReadOnly: ctx.DBReadOnly()}
 	)
 	lemo.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, lemo.chainConfig, lemo.engine, vmConfig)
 	if err != nil {
@@ -159,6 +178,17 @@ func New(ctx *node.ServiceContext, config *Config) (*Lemochain, error) {
 		core.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
 	lemo.bloomIndexer.Start(lemo.blockchain)
+	if lemo.addrIndex != nil {
+		lemo.addrIndex.indexer.Start(lemo.blockchain)
+	}
+
+	if config.DatabaseRecompress {
+		if ctx.DBReadOnly() {
+			log.Warn("Skipping chain data recompression of read only database")
+		} else {
+			lemo.stopRecompress = recompressChainData(lemo.blockchain)
+		}
+	}
 
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
@@ -198,9 +228,18 @@ func makeExtraData(extra []byte) []byte {
 	return extra
 }
 
-// CreateDB creates the chain database.
-func CreateDB(ctx *node.ServiceContext, config *Config, name string) (lemodb.Database, error) {
-	db, err := ctx.OpenDatabase(name, config.DatabaseCache, config.DatabaseHandles)
+// CreateDB creates the chain database. If a freezer directory is specified, an
+// ancient store holding immutable chain segments is attached to the database.
+func CreateDB(ctx *node.ServiceContext, config *Config, name string, freezer string) (lemodb.Database, error) {
+	var (
+		db  lemodb.Database
+		err error
+	)
+	if freezer == "" {
+		db, err = ctx.OpenDatabase(name, config.DatabaseCache, config.DatabaseHandles)
+	} else {
+		db, err = ctx.OpenDatabaseWithFreezer(name, config.DatabaseCache, config.DatabaseHandles, freezer, "lemo/db/chaindata/")
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -216,6 +255,10 @@ func CreateConsensusEngine(ctx *node.ServiceContext, config *lemohash.Config, ch
 	if chainConfig.Clique != nil {
 		return clique.New(chainConfig.Clique, db)
 	}
+	// If delegated deputy nodes are requested, set them up
+	if chainConfig.Dpovp != nil {
+		return dpovp.New(chainConfig.Dpovp, db)
+	}
 	// Otherwise assume proof-of-work
 	switch {
 	case config.PowMode == lemohash.ModeFake:
@@ -348,6 +391,14 @@ func (s *Lemochain) StartMining(local bool) error {
 		}
 		clique.Authorize(eb, wallet.SignHash)
 	}
+	if dpovp, ok := s.engine.(*dpovp.Dpovp); ok {
+		wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
+		if wallet == nil || err != nil {
+			log.Error("Lemobase account unavailable locally", "err", err)
+			return fmt.Errorf("deputy missing: %v", err)
+		}
+		dpovp.Authorize(eb, wallet.SignHash)
+	}
 	if local {
 		// If local (CPU) mining is started, we can disable the transaction rejection
 		// mechanism introduced to speed sync times. CPU mining on mainnet is ludicrous
@@ -414,7 +465,13 @@ func (s *Lemochain) Stop() error {
 	if s.stopDbUpgrade != nil {
 		s.stopDbUpgrade()
 	}
+	if s.stopRecompress != nil {
+		s.stopRecompress()
+	}
 	s.bloomIndexer.Close()
+	if s.addrIndex != nil {
+		s.addrIndex.indexer.Close()
+	}
 	s.blockchain.Stop()
 	s.protocolManager.Stop()
 	if s.lesServer != nil {
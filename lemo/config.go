@@ -43,12 +43,14 @@ var DefaultConfig = Config{
 		DatasetsInMem:  1,
 		DatasetsOnDisk: 2,
 	},
-	NetworkId:     1,
-	LightPeers:    100,
-	DatabaseCache: 768,
-	TrieCache:     256,
-	TrieTimeout:   5 * time.Minute,
-	GasPrice:      big.NewInt(18 * params.Shannon),
+	NetworkId:      1,
+	LightPeers:     100,
+	DatabaseCache:  768,
+	TrieCache:      256,
+	TrieCleanCache: 102,
+	TrieTimeout:    5 * time.Minute,
+	GasPrice:       big.NewInt(18 * params.Shannon),
+	RPCEVMTimeout:  5 * time.Second,
 
 	TxPool: core.DefaultTxPoolConfig,
 	GPO: gasprice.Config{
@@ -80,19 +82,31 @@ type Config struct {
 
 	// Protocol options
 	NetworkId uint64 // Network ID to use for selecting peers to connect to
-	SyncMode  downloader.SyncMode
-	NoPruning bool
+	SyncMode   downloader.SyncMode
+	NoPruning  bool
+	NoSnapshot bool
+
+	// Indexing options
+	AddressIndex     bool // Whether to index the transactions of every address
+	AddressIndexLogs bool // Whether to index the transactions by their log addresses too
 
 	// Light client options
 	LightServ  int `toml:",omitempty"` // Maximum percentage of time allowed for serving LES requests
 	LightPeers int `toml:",omitempty"` // Maximum number of LES client peers
 
 	// Database options
-	SkipBcVersionCheck bool `toml:"-"`
-	DatabaseHandles    int  `toml:"-"`
-	DatabaseCache      int
-	TrieCache          int
-	TrieTimeout        time.Duration
+	SkipBcVersionCheck  bool `toml:"-"`
+	DatabaseHandles     int  `toml:"-"`
+	DatabaseCache       int
+	DatabaseFreezer     string // Directory of the ancient store (default = inside the chaindata)
+	DatabaseCompression string // Codec of the stored block bodies and receipts (none, rle or snappy)
+	DatabaseRecompress  bool   // Whether to recompress the existing chain data with the codec in the background
+	FreezerThreshold    uint64 // Distance from the head after which blocks are frozen (0 = default)
+	HistoryRetain       uint64 // Number of recent blocks whose bodies and receipts are retained (0 = all)
+	TrieCache           int
+	TrieCleanCache      int  // Memory allowance (MB) for caching clean trie nodes
+	NoPrefetch          bool // Whether to disable prefetching the state of the next block during import
+	TrieTimeout         time.Duration
 
 	// Mining-related options
 	Lemobase    common.Address `toml:",omitempty"`
@@ -112,6 +126,12 @@ type Config struct {
 	// Enables tracking of SHA3 preimages in the VM
 	EnablePreimageRecording bool
 
+	// Enables optimistic parallel execution of block transactions
+	ParallelExecution bool
+
+	// RPCEVMTimeout is the global timeout for lemo_call and lemo_callBundle
+	RPCEVMTimeout time.Duration
+
 	// Miscellaneous options
 	DocRoot string `toml:"-"`
 }
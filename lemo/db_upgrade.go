@@ -19,6 +19,8 @@ package lemo
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go/common"
@@ -28,108 +30,234 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/rlp"
 )
 
-var deduplicateData = []byte("dbUpgrade_20170714deduplicateData")
+// errMigrationInterrupted is returned if a schema migration is stopped before
+// it could finish. The migration resumes from its last checkpoint on next run.
+var errMigrationInterrupted = errors.New("migration interrupted")
 
-// upgradeDeduplicateData checks the chain database version and
-// starts a background process to make upgrades if necessary.
-// Returns a stop function that blocks until the process has
-// been safely stopped.
-func upgradeDeduplicateData(db lemodb.Database) func() error {
-	// If the database is already converted or empty, bail out
-	data, _ := db.Get(deduplicateData)
-	if len(data) > 0 && data[0] == 42 {
-		return nil
+// Migration is a single numbered step of the database schema upgrades. Steps are
+// run in order, each of them must be idempotent and resumable from the markers
+// it checkpoints, as the node may be stopped at any point.
+type Migration struct {
+	Version uint64 // Schema version of the database after the migration
+	Name    string // Human readable description of the schema change
+
+	// migrate runs the migration starting from the given marker (nil if fresh).
+	// Progress is persisted through checkpoint, and the migration must abort with
+	// errMigrationInterrupted as soon as quit is closed.
+	migrate func(db lemodb.Database, marker []byte, checkpoint func(marker []byte) error, quit <-chan struct{}) error
+}
+
+// migrations is the registry of all the database schema migrations, ordered by
+// their versions. New schema changes must be appended with the next version.
+var migrations = []*Migration{
+	{Version: 1, Name: "Deduplicate transaction and receipt data", migrate: migrateDeduplicateData},
+}
+
+// LatestSchemaVersion returns the database schema version this node creates
+// and understands.
+func LatestSchemaVersion() uint64 {
+	if len(migrations) == 0 {
+		return 0
 	}
-	if data, _ := db.Get([]byte("LastHeader")); len(data) == 0 {
-		db.Put(deduplicateData, []byte{42})
-		return nil
+	return migrations[len(migrations)-1].Version
+}
+
+// PendingMigrations returns the schema version of the database and the migrations
+// needed to bring it up to the latest version. Databases with a newer schema
+// than supported are rejected. Fresh databases are at the latest version.
+func PendingMigrations(db lemodb.Database) (uint64, []*Migration, error) {
+	version, ok := core.GetSchemaVersion(db)
+	if !ok && core.GetHeadHeaderHash(db) == (common.Hash{}) {
+		return LatestSchemaVersion(), nil, nil
+	}
+	if latest := LatestSchemaVersion(); version > latest {
+		return version, nil, fmt.Errorf("database schema version %d is newer than the supported %d, please upgrade glemo", version, latest)
 	}
-	// Start the deduplication upgrade on a new goroutine
-	log.Warn("Upgrading database to use lookup entries")
-	stop := make(chan chan error)
+	var pending []*Migration
+	for _, migration := range migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return version, pending, nil
+}
 
-	go func() {
-		// Create an iterator to read the entire database and covert old lookup entires
-		it := db.(*lemodb.LDBDatabase).NewIterator()
-		defer func() {
-			if it != nil {
-				it.Release()
-			}
-		}()
-
-		var (
-			converted uint64
-			failed    error
-		)
-		for failed == nil && it.Next() {
-			// Skip any entries that don't look like old transaction meta entires (<hash>0x01)
-			key := it.Key()
-			if len(key) != common.HashLength+1 || key[common.HashLength] != 0x01 {
-				continue
-			}
-			// Skip any entries that don't contain metadata (name clash between <hash>0x01 and <some-prefix><hash>)
-			var meta struct {
-				BlockHash  common.Hash
-				BlockIndex uint64
-				Index      uint64
-			}
-			if err := rlp.DecodeBytes(it.Value(), &meta); err != nil {
-				continue
-			}
-			// Skip any already upgraded entries (clash due to <hash> ending with 0x01 (old suffix))
-			hash := key[:common.HashLength]
-
-			if hash[0] == byte('l') {
-				// Potential clash, the "old" `hash` must point to a live transaction.
-				if tx, _, _, _ := core.GetTransaction(db, common.BytesToHash(hash)); tx == nil || !bytes.Equal(tx.Hash().Bytes(), hash) {
-					continue
-				}
-			}
-			// Convert the old metadata to a new lookup entry, delete duplicate data
-			if failed = db.Put(append([]byte("l"), hash...), it.Value()); failed == nil { // Write the new looku entry
-				if failed = db.Delete(hash); failed == nil { // Delete the duplicate transaction data
-					if failed = db.Delete(append([]byte("receipts-"), hash...)); failed == nil { // Delete the duplicate receipt data
-						if failed = db.Delete(key); failed != nil { // Delete the old transaction metadata
-							break
-						}
-					}
-				}
-			}
-			// Bump the conversion counter, and recreate the iterator occasionally to
-			// avoid too high memory consumption.
-			converted++
-			if converted%100000 == 0 {
-				it.Release()
-				it = db.(*lemodb.LDBDatabase).NewIterator()
-				it.Seek(key)
-
-				log.Info("Deduplicating database entries", "deduped", converted)
-			}
-			// Check for termination, or continue after a bit of a timeout
-			select {
-			case errc := <-stop:
-				errc <- nil
-				return
-			case <-time.After(time.Microsecond * 100):
+// MigrateDatabase runs all the pending schema migrations of the database in order,
+// persisting the new schema version after each of them. Closing quit interrupts
+// the running migration, which is resumed from its last checkpoint on next run.
+func MigrateDatabase(db lemodb.Database, quit <-chan struct{}) error {
+	version, pending, err := PendingMigrations(db)
+	if err != nil {
+		return err
+	}
+	if _, ok := core.GetSchemaVersion(db); !ok && len(pending) == 0 {
+		// Fresh database, stamp it with the latest version
+		return core.WriteSchemaVersion(db, version)
+	}
+	for _, migration := range pending {
+		var marker []byte
+		if target, progress := core.GetSchemaMigration(db); target == migration.Version {
+			marker = progress
+		}
+		checkpoint := func(marker []byte) error {
+			return core.WriteSchemaMigration(db, migration.Version, marker)
+		}
+		log.Info("Migrating database schema", "version", migration.Version, "name", migration.Name, "resumed", marker != nil)
+
+		start := time.Now()
+		if err := migration.migrate(db, marker, checkpoint, quit); err != nil {
+			if err == errMigrationInterrupted {
+				log.Info("Database schema migration interrupted", "version", migration.Version, "elapsed", common.PrettyDuration(time.Since(start)))
+			} else {
+				log.Error("Database schema migration failed", "version", migration.Version, "err", err)
 			}
+			return err
 		}
-		// Upgrade finished, mark a such and terminate
-		if failed == nil {
-			log.Info("Database deduplication successful", "deduped", converted)
-			db.Put(deduplicateData, []byte{42})
-		} else {
-			log.Error("Database deduplication failed", "deduped", converted, "err", failed)
+		if err := core.WriteSchemaVersion(db, migration.Version); err != nil {
+			return err
 		}
-		it.Release()
-		it = nil
+		core.DeleteSchemaMigration(db)
+		log.Info("Database schema migration done", "version", migration.Version, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return nil
+}
 
-		errc := <-stop
-		errc <- failed
+// upgradeDatabase checks the chain database schema version, refusing databases
+// newer than supported, and starts a background process to run any pending
+// migrations. Returns a stop function that blocks until the process has been
+// safely stopped.
+//
+// Read only databases can't be migrated, so they are refused if outdated.
+func upgradeDatabase(db lemodb.Database, readonly bool) (func() error, error) {
+	version, pending, err := PendingMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if readonly {
+		if len(pending) > 0 {
+			return nil, fmt.Errorf("database schema version %d is outdated (%d migrations pending), open it with write access to upgrade", version, len(pending))
+		}
+		return nil, nil
+	}
+	if len(pending) == 0 {
+		// Nothing to migrate, but make sure fresh databases get their version
+		return nil, MigrateDatabase(db, nil)
+	}
+	var (
+		quit = make(chan struct{})
+		done = make(chan error, 1)
+	)
+	go func() {
+		done <- MigrateDatabase(db, quit)
 	}()
 	// Assembly the cancellation callback
 	return func() error {
-		errc := make(chan error)
-		stop <- errc
-		return <-errc
+		close(quit)
+		if err := <-done; err != nil && err != errMigrationInterrupted {
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// recompressChainData starts rewriting the stored block bodies and receipts with
+// the configured storage codec of the chain in the background, returning a
+// callback to abort and wait for it.
+func recompressChainData(chain *core.BlockChain) func() {
+	var (
+		quit = make(chan struct{})
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		if err := chain.RecompressChainData(quit); err != nil {
+			log.Error("Failed to recompress chain data", "err", err)
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// deduplicateData is the marker of the original ad hoc deduplication upgrade,
+// predating the schema versions.
+var deduplicateData = []byte("dbUpgrade_20170714deduplicateData")
+
+// migrateDeduplicateData converts the old transaction metadata entries into
+// lookup entries, deleting the transaction and receipt data duplicated outside
+// of the block bodies and receipts. The marker is the key to resume from.
+func migrateDeduplicateData(db lemodb.Database, marker []byte, checkpoint func([]byte) error, quit <-chan struct{}) error {
+	// If the database was converted by the legacy upgrade, bail out
+	if data, _ := db.Get(deduplicateData); len(data) > 0 && data[0] == 42 {
+		return nil
+	}
+	// Create an iterator to read the entire database and covert old lookup entires
+	it := db.NewIteratorWithStart(marker)
+	defer func() {
+		it.Release()
+	}()
+
+	var converted uint64
+	for it.Next() {
+		// Skip any entries that don't look like old transaction meta entires (<hash>0x01)
+		key := common.CopyBytes(it.Key())
+		if len(key) != common.HashLength+1 || key[common.HashLength] != 0x01 {
+			continue
+		}
+		// Skip any entries that don't contain metadata (name clash between <hash>0x01 and <some-prefix><hash>)
+		var meta struct {
+			BlockHash  common.Hash
+			BlockIndex uint64
+			Index      uint64
+		}
+		if err := rlp.DecodeBytes(it.Value(), &meta); err != nil {
+			continue
+		}
+		// Skip any already upgraded entries (clash due to <hash> ending with 0x01 (old suffix))
+		hash := key[:common.HashLength]
+
+		if hash[0] == byte('l') {
+			// Potential clash, the "old" `hash` must point to a live transaction.
+			if tx, _, _, _ := core.GetTransaction(db, common.BytesToHash(hash)); tx == nil || !bytes.Equal(tx.Hash().Bytes(), hash) {
+				continue
+			}
+		}
+		// Convert the old metadata to a new lookup entry, delete duplicate data
+		if err := db.Put(append([]byte("l"), hash...), it.Value()); err != nil { // Write the new looku entry
+			return err
+		}
+		if err := db.Delete(hash); err != nil { // Delete the duplicate transaction data
+			return err
+		}
+		if err := db.Delete(append([]byte("receipts-"), hash...)); err != nil { // Delete the duplicate receipt data
+			return err
+		}
+		if err := db.Delete(key); err != nil { // Delete the old transaction metadata
+			return err
+		}
+		// Bump the conversion counter, and recreate the iterator occasionally to
+		// avoid too high memory consumption.
+		converted++
+		if converted%100000 == 0 {
+			if err := checkpoint(key); err != nil {
+				return err
+			}
+			it.Release()
+			it = db.NewIteratorWithStart(key)
+
+			log.Info("Deduplicating database entries", "deduped", converted)
+		}
+		// Check for termination
+		select {
+		case <-quit:
+			return errMigrationInterrupted
+		default:
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
 	}
+	log.Info("Database deduplication successful", "deduped", converted)
+	return nil
 }
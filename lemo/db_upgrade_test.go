@@ -0,0 +1,159 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+)
+
+// Tests that fresh databases are stamped with the latest schema version, while
+// legacy ones without a version have all the migrations pending.
+func TestSchemaVersionStamping(t *testing.T) {
+	// Fresh databases have nothing to migrate
+	db, _ := lemodb.NewMemDatabase()
+	if _, err := upgradeDatabase(db, false); err != nil {
+		t.Fatalf("failed to upgrade fresh database: %v", err)
+	}
+	if version, ok := core.GetSchemaVersion(db); !ok || version != LatestSchemaVersion() {
+		t.Fatalf("fresh database version mismatch: have %d (%v), want %d", version, ok, LatestSchemaVersion())
+	}
+	// Legacy databases need all the migrations
+	db, _ = lemodb.NewMemDatabase()
+	core.WriteHeadHeaderHash(db, common.Hash{0x01})
+
+	version, pending, err := PendingMigrations(db)
+	if err != nil {
+		t.Fatalf("failed to check legacy database: %v", err)
+	}
+	if version != 0 || len(pending) != len(migrations) {
+		t.Fatalf("legacy database mismatch: have version %d and %d pending, want 0 and %d", version, len(pending), len(migrations))
+	}
+	if err := MigrateDatabase(db, nil); err != nil {
+		t.Fatalf("failed to migrate legacy database: %v", err)
+	}
+	if version, _ := core.GetSchemaVersion(db); version != LatestSchemaVersion() {
+		t.Fatalf("migrated database version mismatch: have %d, want %d", version, LatestSchemaVersion())
+	}
+}
+
+// Tests that databases with a newer schema than supported are refused.
+func TestSchemaVersionTooNew(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+	core.WriteSchemaVersion(db, LatestSchemaVersion()+1)
+
+	if _, err := upgradeDatabase(db, false); err == nil {
+		t.Fatalf("newer database accepted")
+	}
+	if err := MigrateDatabase(db, nil); err == nil {
+		t.Fatalf("newer database migrated")
+	}
+}
+
+// Tests that interrupted migrations resume from their last checkpoint and that
+// the schema version is only bumped once a migration finishes.
+func TestSchemaMigrationResume(t *testing.T) {
+	// Replace the registry with a test migration counting keys
+	defer func(old []*Migration) { migrations = old }(migrations)
+
+	var interrupt bool
+	migrations = []*Migration{
+		{Version: 1, Name: "noop", migrate: func(db lemodb.Database, marker []byte, checkpoint func([]byte) error, quit <-chan struct{}) error {
+			return nil
+		}},
+		{Version: 2, Name: "counter", migrate: func(db lemodb.Database, marker []byte, checkpoint func([]byte) error, quit <-chan struct{}) error {
+			var next int
+			if marker != nil {
+				fmt.Sscan(string(marker), &next)
+			}
+			for i := next; i < 10; i++ {
+				if interrupt && i == 5 {
+					return errMigrationInterrupted
+				}
+				db.Put([]byte(fmt.Sprintf("counter-%d", i)), []byte{byte(i)})
+				if err := checkpoint([]byte(fmt.Sprint(i + 1))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+	db, _ := lemodb.NewMemDatabase()
+	core.WriteHeadHeaderHash(db, common.Hash{0x01})
+
+	// Run the migrations with an interruption half way through
+	interrupt = true
+	if err := MigrateDatabase(db, nil); err != errMigrationInterrupted {
+		t.Fatalf("interrupted migration error mismatch: have %v, want %v", err, errMigrationInterrupted)
+	}
+	if version, _ := core.GetSchemaVersion(db); version != 1 {
+		t.Fatalf("interrupted version mismatch: have %d, want 1", version)
+	}
+	if target, marker := core.GetSchemaMigration(db); target != 2 || string(marker) != "5" {
+		t.Fatalf("interrupted progress mismatch: have %d/%q, want 2/\"5\"", target, marker)
+	}
+	// Resume the migration and ensure it finishes
+	interrupt = false
+	if err := MigrateDatabase(db, nil); err != nil {
+		t.Fatalf("failed to resume migration: %v", err)
+	}
+	if version, _ := core.GetSchemaVersion(db); version != 2 {
+		t.Fatalf("migrated version mismatch: have %d, want 2", version)
+	}
+	if target, _ := core.GetSchemaMigration(db); target != 0 {
+		t.Fatalf("migration progress not cleaned up: %d", target)
+	}
+	for i := 0; i < 10; i++ {
+		if ok, _ := db.Has([]byte(fmt.Sprintf("counter-%d", i))); !ok {
+			t.Errorf("migrated key %d missing", i)
+		}
+	}
+}
+
+// Tests that read only databases are never migrated, and refused if outdated.
+func TestSchemaUpgradeReadOnly(t *testing.T) {
+	// Fresh databases are left untouched
+	db, _ := lemodb.NewMemDatabase()
+	if _, err := upgradeDatabase(db, true); err != nil {
+		t.Fatalf("failed to open fresh database: %v", err)
+	}
+	if _, ok := core.GetSchemaVersion(db); ok {
+		t.Fatalf("read only database stamped with schema version")
+	}
+	// Up to date databases are accepted
+	core.WriteSchemaVersion(db, LatestSchemaVersion())
+	if _, err := upgradeDatabase(db, true); err != nil {
+		t.Fatalf("failed to open up to date database: %v", err)
+	}
+	// Outdated databases are refused without migrating them
+	db, _ = lemodb.NewMemDatabase()
+	core.WriteHeadHeaderHash(db, common.Hash{0x01})
+
+	if stop, err := upgradeDatabase(db, true); err == nil {
+		if stop != nil {
+			stop()
+		}
+		t.Fatalf("outdated read only database accepted")
+	}
+	if _, ok := core.GetSchemaVersion(db); ok {
+		t.Fatalf("read only database migrated")
+	}
+}
@@ -59,7 +59,19 @@ type stateSyncStats struct {
 
 // syncState starts downloading state with the given root hash.
 func (d *Downloader) syncState(root common.Hash) *stateSync {
-	s := newStateSync(d, root)
+	return d.startStateSync(newStateSync(d, state.NewStateSync(root, d.stateDB), d.cancelCh))
+}
+
+// RepairState downloads the state entries scheduled by the given trie sync (e.g.
+// the damaged entries of a local state) from the connected peers, blocking until
+// all of them are retrieved. The download is independent from chain syncs, but
+// it is aborted if a chain sync starts downloading a state of its own.
+func (d *Downloader) RepairState(sched *trie.TrieSync) error {
+	return d.startStateSync(newStateSync(d, sched, nil)).Wait()
+}
+
+// startStateSync hands a state download over to the state fetcher.
+func (d *Downloader) startStateSync(s *stateSync) *stateSync {
 	select {
 	case d.stateSyncStart <- s:
 	case <-d.quitCh:
@@ -215,6 +227,7 @@ type stateSync struct {
 	d *Downloader // Downloader instance to access and manage current peerset
 
 	sched  *trie.TrieSync             // State trie sync scheduler defining the tasks
+	abort  <-chan struct{}            // Cancellation channel of the chain sync owning the download (nil if standalone)
 	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
 	tasks  map[common.Hash]*stateTask // Set of tasks currently queued for retrieval
 
@@ -236,10 +249,11 @@ type stateTask struct {
 
 // newStateSync creates a new state trie download scheduler. This method does not
 // yet start the sync. The user needs to call run to initiate.
-func newStateSync(d *Downloader, root common.Hash) *stateSync {
+func newStateSync(d *Downloader, sched *trie.TrieSync, abort <-chan struct{}) *stateSync {
 	return &stateSync{
 		d:       d,
-		sched:   state.NewStateSync(root, d.stateDB),
+		sched:   sched,
+		abort:   abort,
 		keccak:  sha3.NewKeccak256(),
 		tasks:   make(map[common.Hash]*stateTask),
 		deliver: make(chan *stateReq),
@@ -294,7 +308,7 @@ func (s *stateSync) loop() error {
 		case <-s.cancel:
 			return errCancelStateFetch
 
-		case <-s.d.cancelCh:
+		case <-s.abort:
 			return errCancelStateFetch
 
 		case req := <-s.deliver:
@@ -351,7 +365,7 @@ func (s *stateSync) assignTasks() {
 			case s.d.trackStateReq <- req:
 				req.peer.FetchNodeData(req.items)
 			case <-s.cancel:
-			case <-s.d.cancelCh:
+			case <-s.abort:
 			}
 		}
 	}
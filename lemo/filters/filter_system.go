@@ -51,6 +51,8 @@ const (
 	PendingTransactionsSubscription
 	// BlocksSubscription queries hashes for blocks that are imported
 	BlocksSubscription
+	// ChainReorgSubscription queries the reorganisations of the canonical chain
+	ChainReorgSubscription
 	// LastSubscription keeps track of the last index
 	LastIndexSubscription
 )
@@ -66,6 +68,8 @@ const (
 	logsChanSize = 10
 	// chainEvChanSize is the size of channel listening to ChainEvent.
 	chainEvChanSize = 10
+	// reorgChanSize is the size of channel listening to ChainReorgEvent.
+	reorgChanSize = 10
 )
 
 var (
@@ -80,6 +84,7 @@ type subscription struct {
 	logs      chan []*types.Log
 	hashes    chan common.Hash
 	headers   chan *types.Header
+	reorgs    chan core.ChainReorgEvent
 	installed chan struct{} // closed when the filter is installed
 	err       chan error    // closed when the filter is uninstalled
 }
@@ -143,6 +148,7 @@ func (sub *Subscription) Unsubscribe() {
 			case <-sub.f.logs:
 			case <-sub.f.hashes:
 			case <-sub.f.headers:
+			case <-sub.f.reorgs:
 			}
 		}
 
@@ -210,6 +216,7 @@ func (es *EventSystem) subscribeMinedPendingLogs(crit lemochain.FilterQuery, log
 		logs:      logs,
 		hashes:    make(chan common.Hash),
 		headers:   make(chan *types.Header),
+		reorgs:    make(chan core.ChainReorgEvent),
 		installed: make(chan struct{}),
 		err:       make(chan error),
 	}
@@ -227,6 +234,7 @@ func (es *EventSystem) subscribeLogs(crit lemochain.FilterQuery, logs chan []*ty
 		logs:      logs,
 		hashes:    make(chan common.Hash),
 		headers:   make(chan *types.Header),
+		reorgs:    make(chan core.ChainReorgEvent),
 		installed: make(chan struct{}),
 		err:       make(chan error),
 	}
@@ -244,6 +252,7 @@ func (es *EventSystem) subscribePendingLogs(crit lemochain.FilterQuery, logs cha
 		logs:      logs,
 		hashes:    make(chan common.Hash),
 		headers:   make(chan *types.Header),
+		reorgs:    make(chan core.ChainReorgEvent),
 		installed: make(chan struct{}),
 		err:       make(chan error),
 	}
@@ -260,6 +269,7 @@ func (es *EventSystem) SubscribeNewHeads(headers chan *types.Header) *Subscripti
 		logs:      make(chan []*types.Log),
 		hashes:    make(chan common.Hash),
 		headers:   headers,
+		reorgs:    make(chan core.ChainReorgEvent),
 		installed: make(chan struct{}),
 		err:       make(chan error),
 	}
@@ -276,6 +286,24 @@ func (es *EventSystem) SubscribePendingTxEvents(hashes chan common.Hash) *Subscr
 		logs:      make(chan []*types.Log),
 		hashes:    hashes,
 		headers:   make(chan *types.Header),
+		reorgs:    make(chan core.ChainReorgEvent),
+		installed: make(chan struct{}),
+		err:       make(chan error),
+	}
+	return es.subscribe(sub)
+}
+
+// SubscribeChainReorgs creates a subscription that writes the reorganisations of
+// the canonical chain.
+func (es *EventSystem) SubscribeChainReorgs(reorgs chan core.ChainReorgEvent) *Subscription {
+	sub := &subscription{
+		id:        rpc.NewID(),
+		typ:       ChainReorgSubscription,
+		created:   time.Now(),
+		logs:      make(chan []*types.Log),
+		hashes:    make(chan common.Hash),
+		headers:   make(chan *types.Header),
+		reorgs:    reorgs,
 		installed: make(chan struct{}),
 		err:       make(chan error),
 	}
@@ -320,6 +348,10 @@ func (es *EventSystem) broadcast(filters filterIndex, ev interface{}) {
 		for _, f := range filters[PendingTransactionsSubscription] {
 			f.hashes <- e.Tx.Hash()
 		}
+	case core.ChainReorgEvent:
+		for _, f := range filters[ChainReorgSubscription] {
+			f.reorgs <- e
+		}
 	case core.ChainEvent:
 		for _, f := range filters[BlocksSubscription] {
 			f.headers <- e.Block.Header()
@@ -426,6 +458,9 @@ func (es *EventSystem) eventLoop() {
 		// Subscribe ChainEvent
 		chainEvCh  = make(chan core.ChainEvent, chainEvChanSize)
 		chainEvSub = es.backend.SubscribeChainEvent(chainEvCh)
+		// Subscribe ChainReorgEvent
+		reorgCh  = make(chan core.ChainReorgEvent, reorgChanSize)
+		reorgSub = es.backend.SubscribeChainReorgEvent(reorgCh)
 	)
 
 	// Unsubscribe all events
@@ -434,6 +469,7 @@ func (es *EventSystem) eventLoop() {
 	defer rmLogsSub.Unsubscribe()
 	defer logsSub.Unsubscribe()
 	defer chainEvSub.Unsubscribe()
+	defer reorgSub.Unsubscribe()
 
 	for i := UnknownSubscription; i < LastIndexSubscription; i++ {
 		index[i] = make(map[rpc.ID]*subscription)
@@ -456,6 +492,8 @@ func (es *EventSystem) eventLoop() {
 			es.broadcast(index, ev)
 		case ev := <-chainEvCh:
 			es.broadcast(index, ev)
+		case ev := <-reorgCh:
+			es.broadcast(index, ev)
 
 		case f := <-es.install:
 			if f.typ == MinedAndPendingLogsSubscription {
@@ -485,6 +523,8 @@ func (es *EventSystem) eventLoop() {
 			return
 		case <-chainEvSub.Err():
 			return
+		case <-reorgSub.Err():
+			return
 		}
 	}
 }
@@ -4,6 +4,7 @@ package lemo
 
 import (
 	"math/big"
+	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go/common"
 	"github.com/LemoFoundationLtd/lemochain-go/common/hexutil"
@@ -20,11 +21,21 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		Genesis                 *core.Genesis `toml:",omitempty"`
 		NetworkId               uint64
 		SyncMode                downloader.SyncMode
+		NoSnapshot              bool
+		AddressIndex            bool
+		AddressIndexLogs        bool
 		LightServ               int  `toml:",omitempty"`
 		LightPeers              int  `toml:",omitempty"`
 		SkipBcVersionCheck      bool `toml:"-"`
 		DatabaseHandles         int  `toml:"-"`
 		DatabaseCache           int
+		TrieCleanCache          int
+		NoPrefetch              bool
+		DatabaseFreezer         string
+		DatabaseCompression     string
+		DatabaseRecompress      bool
+		FreezerThreshold        uint64
+		HistoryRetain           uint64
 		Lemobase               common.Address `toml:",omitempty"`
 		MinerThreads            int            `toml:",omitempty"`
 		ExtraData               hexutil.Bytes  `toml:",omitempty"`
@@ -33,17 +44,29 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TxPool                  core.TxPoolConfig
 		GPO                     gasprice.Config
 		EnablePreimageRecording bool
+		ParallelExecution       bool
+		RPCEVMTimeout           time.Duration
 		DocRoot                 string `toml:"-"`
 	}
 	var enc Config
 	enc.Genesis = c.Genesis
 	enc.NetworkId = c.NetworkId
 	enc.SyncMode = c.SyncMode
+	enc.NoSnapshot = c.NoSnapshot
+	enc.AddressIndex = c.AddressIndex
+	enc.AddressIndexLogs = c.AddressIndexLogs
 	enc.LightServ = c.LightServ
 	enc.LightPeers = c.LightPeers
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
+	enc.TrieCleanCache = c.TrieCleanCache
+	enc.NoPrefetch = c.NoPrefetch
+	enc.DatabaseFreezer = c.DatabaseFreezer
+	enc.DatabaseCompression = c.DatabaseCompression
+	enc.DatabaseRecompress = c.DatabaseRecompress
+	enc.FreezerThreshold = c.FreezerThreshold
+	enc.HistoryRetain = c.HistoryRetain
 	enc.Lemobase = c.Lemobase
 	enc.MinerThreads = c.MinerThreads
 	enc.ExtraData = c.ExtraData
@@ -52,6 +75,8 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
+	enc.ParallelExecution = c.ParallelExecution
+	enc.RPCEVMTimeout = c.RPCEVMTimeout
 	enc.DocRoot = c.DocRoot
 	return &enc, nil
 }
@@ -61,11 +86,21 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		Genesis                 *core.Genesis `toml:",omitempty"`
 		NetworkId               *uint64
 		SyncMode                *downloader.SyncMode
+		NoSnapshot              *bool
+		AddressIndex            *bool
+		AddressIndexLogs        *bool
 		LightServ               *int  `toml:",omitempty"`
 		LightPeers              *int  `toml:",omitempty"`
 		SkipBcVersionCheck      *bool `toml:"-"`
 		DatabaseHandles         *int  `toml:"-"`
 		DatabaseCache           *int
+		TrieCleanCache          *int
+		NoPrefetch              *bool
+		DatabaseFreezer         *string
+		DatabaseCompression     *string
+		DatabaseRecompress      *bool
+		FreezerThreshold        *uint64
+		HistoryRetain           *uint64
 		Lemobase               *common.Address `toml:",omitempty"`
 		MinerThreads            *int            `toml:",omitempty"`
 		ExtraData               *hexutil.Bytes  `toml:",omitempty"`
@@ -74,6 +109,8 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TxPool                  *core.TxPoolConfig
 		GPO                     *gasprice.Config
 		EnablePreimageRecording *bool
+		ParallelExecution       *bool
+		RPCEVMTimeout           *time.Duration
 		DocRoot                 *string `toml:"-"`
 	}
 	var dec Config
@@ -89,6 +126,15 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.SyncMode != nil {
 		c.SyncMode = *dec.SyncMode
 	}
+	if dec.NoSnapshot != nil {
+		c.NoSnapshot = *dec.NoSnapshot
+	}
+	if dec.AddressIndex != nil {
+		c.AddressIndex = *dec.AddressIndex
+	}
+	if dec.AddressIndexLogs != nil {
+		c.AddressIndexLogs = *dec.AddressIndexLogs
+	}
 	if dec.LightServ != nil {
 		c.LightServ = *dec.LightServ
 	}
@@ -104,6 +150,27 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.DatabaseCache != nil {
 		c.DatabaseCache = *dec.DatabaseCache
 	}
+	if dec.TrieCleanCache != nil {
+		c.TrieCleanCache = *dec.TrieCleanCache
+	}
+	if dec.NoPrefetch != nil {
+		c.NoPrefetch = *dec.NoPrefetch
+	}
+	if dec.DatabaseFreezer != nil {
+		c.DatabaseFreezer = *dec.DatabaseFreezer
+	}
+	if dec.DatabaseCompression != nil {
+		c.DatabaseCompression = *dec.DatabaseCompression
+	}
+	if dec.DatabaseRecompress != nil {
+		c.DatabaseRecompress = *dec.DatabaseRecompress
+	}
+	if dec.FreezerThreshold != nil {
+		c.FreezerThreshold = *dec.FreezerThreshold
+	}
+	if dec.HistoryRetain != nil {
+		c.HistoryRetain = *dec.HistoryRetain
+	}
 	if dec.Lemobase != nil {
 		c.Lemobase = *dec.Lemobase
 	}
@@ -128,6 +195,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.EnablePreimageRecording != nil {
 		c.EnablePreimageRecording = *dec.EnablePreimageRecording
 	}
+	if dec.ParallelExecution != nil {
+		c.ParallelExecution = *dec.ParallelExecution
+	}
+	if dec.RPCEVMTimeout != nil {
+		c.RPCEVMTimeout = *dec.RPCEVMTimeout
+	}
 	if dec.DocRoot != nil {
 		c.DocRoot = *dec.DocRoot
 	}
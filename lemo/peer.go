@@ -48,6 +48,8 @@ type PeerInfo struct {
 	Version    int      `json:"version"`    // Lemochain protocol version negotiated
 	Difficulty *big.Int `json:"difficulty"` // Total difficulty of the peer's blockchain
 	Head       string   `json:"head"`       // SHA3 hash of the peer's best owned block
+
+	HistoryTail uint64 `json:"historyTail,omitempty"` // First block whose history the peer serves
 }
 
 type peer struct {
@@ -63,6 +65,8 @@ type peer struct {
 	td   *big.Int
 	lock sync.RWMutex
 
+	historyTail uint64 // First block whose bodies and receipts the peer can serve
+
 	knownTxs    *set.Set // Set of transaction hashes known to be known by this peer
 	knownBlocks *set.Set // Set of block hashes known to be known by this peer
 }
@@ -88,9 +92,17 @@ func (p *peer) Info() *PeerInfo {
 		Version:    p.version,
 		Difficulty: td,
 		Head:       hash.Hex(),
+
+		HistoryTail: p.historyTail,
 	}
 }
 
+// HistoryTail retrieves the number of the first block whose bodies and receipts
+// the peer can serve, as advertised during the handshake.
+func (p *peer) HistoryTail() uint64 {
+	return p.historyTail
+}
+
 // Head retrieves a copy of the current head hash and total difficulty of the
 // peer.
 func (p *peer) Head() (hash common.Hash, td *big.Int) {
@@ -229,13 +241,25 @@ func (p *peer) RequestReceipts(hashes []common.Hash) error {
 }
 
 // Handshake executes the lemo protocol handshake, negotiating version number,
-// network IDs, difficulties, head and genesis blocks.
-func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
+// network IDs, difficulties, head and genesis blocks, and from lemo/64 on the
+// first blocks whose history is served.
+func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash, tail uint64) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
-	var status statusData // safe to read after two values have been received from errc
+	var status statusData64 // safe to read after two values have been received from errc
 
 	go func() {
+		if p.version >= lemo64 {
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData64{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       network,
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+				HistoryTail:     tail,
+			})
+			return
+		}
 		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
 			ProtocolVersion: uint32(p.version),
 			NetworkId:       network,
@@ -259,11 +283,11 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 			return p2p.DiscReadTimeout
 		}
 	}
-	p.td, p.head = status.TD, status.CurrentBlock
+	p.td, p.head, p.historyTail = status.TD, status.CurrentBlock, status.HistoryTail
 	return nil
 }
 
-func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
+func (p *peer) readStatus(network uint64, status *statusData64, genesis common.Hash) (err error) {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
 		return err
@@ -275,7 +299,16 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
 	}
 	// Decode the handshake and make sure everything matches
-	if err := msg.Decode(&status); err != nil {
+	if p.version >= lemo64 {
+		err = msg.Decode(status)
+	} else {
+		var legacy statusData
+		if err = msg.Decode(&legacy); err == nil {
+			status.ProtocolVersion, status.NetworkId, status.TD = legacy.ProtocolVersion, legacy.NetworkId, legacy.TD
+			status.CurrentBlock, status.GenesisBlock = legacy.CurrentBlock, legacy.GenesisBlock
+		}
+	}
+	if err != nil {
 		return errResp(ErrDecode, "msg %v: %v", msg, err)
 	}
 	if status.GenesisBlock != genesis {
@@ -32,16 +32,17 @@ import (
 const (
 	lemo62 = 62
 	lemo63 = 63
+	lemo64 = 64
 )
 
 // Official short name of the protocol used during capability negotiation.
 var ProtocolName = "lemo"
 
 // Supported versions of the lemo protocol (first is primary).
-var ProtocolVersions = []uint{lemo63, lemo62}
+var ProtocolVersions = []uint{lemo64, lemo63, lemo62}
 
 // Number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{17, 8}
+var ProtocolLengths = []uint64{17, 17, 8}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -108,7 +109,7 @@ type txPool interface {
 	SubscribeTxPreEvent(chan<- core.TxPreEvent) event.Subscription
 }
 
-// statusData is the network packet for the status message.
+// statusData is the network packet for the status message of lemo/62 and lemo/63.
 type statusData struct {
 	ProtocolVersion uint32
 	NetworkId       uint64
@@ -117,6 +118,17 @@ type statusData struct {
 	GenesisBlock    common.Hash
 }
 
+// statusData64 is the network packet for the status message of lemo/64, also
+// advertising the first block whose bodies and receipts the node can serve.
+type statusData64 struct {
+	ProtocolVersion uint32
+	NetworkId       uint64
+	TD              *big.Int
+	CurrentBlock    common.Hash
+	GenesisBlock    common.Hash
+	HistoryTail     uint64
+}
+
 // newBlockHashesData is the network packet for the block announcements.
 type newBlockHashesData []struct {
 	Hash   common.Hash // Hash of one particular block being announced
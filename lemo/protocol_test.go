@@ -92,6 +92,41 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that lemo/64 peers exchange the first blocks whose history they serve
+// during the handshake.
+func TestHistoryTailHandshake64(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	var (
+		genesis = pm.blockchain.Genesis()
+		head    = pm.blockchain.CurrentHeader()
+		td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
+	)
+	defer pm.Stop()
+
+	p, _ := newTestPeer("peer", lemo64, pm, false)
+	defer p.close()
+
+	local := &statusData64{uint32(lemo64), DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), pm.blockchain.HistoryTail()}
+	if err := p2p.ExpectMsg(p.app, StatusMsg, local); err != nil {
+		t.Fatalf("status recv: %v", err)
+	}
+	remote := &statusData64{uint32(lemo64), DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), 1000}
+	if err := p2p.Send(p.app, StatusMsg, remote); err != nil {
+		t.Fatalf("status send: %v", err)
+	}
+	// Wait for the peer to be registered and check its advertised history
+	for i := 0; i < 100; i++ {
+		if peer := pm.peers.Peer(p.id); peer != nil {
+			if tail := peer.HistoryTail(); tail != 1000 {
+				t.Fatalf("history tail mismatch: have %d, want 1000", tail)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("peer not registered")
+}
+
 // This test checks that received transactions are added to the local pool.
 func TestRecvTransactions62(t *testing.T) { testRecvTransactions(t, 62) }
 func TestRecvTransactions63(t *testing.T) { testRecvTransactions(t, 63) }
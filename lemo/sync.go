@@ -189,11 +189,18 @@ func (pm *ProtocolManager) synchronise(peer *peer) {
 		mode = downloader.FastSync
 	}
 
+	origin := currentBlock.NumberU64()
 	if mode == downloader.FastSync {
 		// Make sure the peer's total difficulty we are synchronizing is higher.
 		if pm.blockchain.GetTdByHash(pm.blockchain.CurrentFastBlock().Hash()).Cmp(pTd) >= 0 {
 			return
 		}
+		origin = pm.blockchain.CurrentFastBlock().NumberU64()
+	}
+	// Make sure the peer didn't prune the history we're missing
+	if tail := peer.HistoryTail(); tail > origin+1 {
+		peer.Log().Debug("Peer history pruned, skipping sync", "tail", tail, "origin", origin)
+		return
 	}
 
 	// Run the sync cycle, and disable fast sync if we've went past the pivot block
@@ -299,6 +299,12 @@ func (ec *Client) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header)
 	return ec.c.LemoSubscribe(ctx, ch, "newHeads", map[string]struct{}{})
 }
 
+// SubscribeChainReorgEvent subscribes to notifications about the reorganisations
+// of the canonical chain on the given channel.
+func (ec *Client) SubscribeChainReorgEvent(ctx context.Context, ch chan<- lemochain.ChainReorg) (lemochain.Subscription, error) {
+	return ec.c.LemoSubscribe(ctx, ch, "chainReorg")
+}
+
 // State Access
 
 // NetworkID returns the network ID (also known as the chain ID) for this chain.
@@ -421,6 +427,9 @@ func (ec *Client) PendingTransactionCount(ctx context.Context) (uint, error) {
 // blockNumber selects the block height at which the call runs. It can be nil, in which
 // case the code is taken from the latest known block. Note that state from very old
 // blocks might not be available.
+//
+// If the call reverts, the returned error holds the decoded revert reason in its
+// message and implements rpc.DataError, returning the hex encoded revert data.
 func (ec *Client) CallContract(ctx context.Context, msg lemochain.CallMsg, blockNumber *big.Int) ([]byte, error) {
 	var hex hexutil.Bytes
 	err := ec.c.CallContext(ctx, &hex, "lemo_call", toCallArg(msg), toBlockNumArg(blockNumber))
@@ -455,6 +464,8 @@ func (ec *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
 // the current pending state of the backend blockchain. There is no guarantee that this is
 // the true gas limit requirement as other transactions may be added or removed by miners,
 // but it should provide a basis for setting a reasonable default.
+//
+// As with CallContract, a reverting transaction results in an rpc.DataError.
 func (ec *Client) EstimateGas(ctx context.Context, msg lemochain.CallMsg) (uint64, error) {
 	var hex hexutil.Uint64
 	err := ec.c.CallContext(ctx, &hex, "lemo_estimateGas", toCallArg(msg))
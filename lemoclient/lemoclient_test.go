@@ -16,7 +16,18 @@
 
 package lemoclient
 
-import "github.com/LemoFoundationLtd/lemochain-go"
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go"
+	"github.com/LemoFoundationLtd/lemochain-go/accounts/abi"
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/core"
+	"github.com/LemoFoundationLtd/lemochain-go/rpc"
+)
 
 // Verify that Client implements the lemochain interfaces.
 var (
@@ -32,3 +43,90 @@ var (
 	// _ = lemochain.PendingStateEventer(&Client{})
 	_ = lemochain.PendingContractCaller(&Client{})
 )
+
+// RevertService is a lemo API service whose calls always revert.
+type RevertService struct {
+	data []byte
+}
+
+func (s *RevertService) Call(args map[string]interface{}, block string) (string, error) {
+	return "", abi.NewRevertError(s.data)
+}
+
+func (s *RevertService) EstimateGas(args map[string]interface{}) (string, error) {
+	return "", abi.NewRevertError(s.data)
+}
+
+// Tests that callers can read the revert reason and data of failed calls.
+func TestRevertErrorData(t *testing.T) {
+	data := common.FromHex("0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"626f6f6d00000000000000000000000000000000000000000000000000000000")
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("lemo", &RevertService{data: data}); err != nil {
+		t.Fatalf("failed to register service: %v", err)
+	}
+	defer server.Stop()
+	rpcClient := rpc.DialInProc(server)
+	defer rpcClient.Close()
+	client := NewClient(rpcClient)
+
+	to := common.HexToAddress("0x1000000000000000000000000000000000000001")
+	msg := lemochain.CallMsg{To: &to}
+
+	_, callErr := client.CallContract(context.Background(), msg, nil)
+	_, estimateErr := client.EstimateGas(context.Background(), msg)
+
+	for name, err := range map[string]error{"call": callErr, "estimate": estimateErr} {
+		if err == nil {
+			t.Errorf("%s: expected revert error", name)
+			continue
+		}
+		if err.Error() != "execution reverted: boom" {
+			t.Errorf("%s: error message mismatch: have %q", name, err.Error())
+		}
+		if code := err.(rpc.Error).ErrorCode(); code != 3 {
+			t.Errorf("%s: error code mismatch: have %d, want 3", name, code)
+		}
+		derr, ok := err.(rpc.DataError)
+		if !ok {
+			t.Errorf("%s: error has no data: %T", name, err)
+			continue
+		}
+		if have := derr.ErrorData(); have != common.ToHex(data) {
+			t.Errorf("%s: error data mismatch: have %v, want %x", name, have, data)
+		}
+	}
+}
+
+// Tests that the reorg notifications of the chainReorg subscription decode into
+// the client side type.
+func TestChainReorgDecoding(t *testing.T) {
+	event := core.ChainReorgEvent{
+		CommonHash:   common.Hash{0x01},
+		CommonNumber: 10,
+		OldChain:     []common.Hash{{0x02}, {0x03}},
+		NewChain:     []common.Hash{{0x04}, {0x05}, {0x06}},
+		Depth:        2,
+	}
+	blob, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("failed to encode event: %v", err)
+	}
+	var reorg lemochain.ChainReorg
+	if err := json.Unmarshal(blob, &reorg); err != nil {
+		t.Fatalf("failed to decode event: %v", err)
+	}
+	want := lemochain.ChainReorg{
+		CommonHash:   event.CommonHash,
+		CommonNumber: event.CommonNumber,
+		OldChain:     event.OldChain,
+		NewChain:     event.NewChain,
+		Depth:        event.Depth,
+	}
+	if !reflect.DeepEqual(reorg, want) {
+		t.Fatalf("reorg mismatch: have %+v, want %+v", reorg, want)
+	}
+}
@@ -0,0 +1,488 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemodb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/rlp"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// backupChunkSize is the amount of data collected into a single backup file
+	// before it's flushed into the sink.
+	backupChunkSize = 64 * 1024 * 1024
+
+	// backupEntriesPrefix is the name prefix of the backup files holding the
+	// key-value entries of the database.
+	backupEntriesPrefix = "chaindata-"
+
+	// backupAncientsPrefix is the name prefix of the backup files holding the
+	// items of the ancient store.
+	backupAncientsPrefix = "ancient-"
+)
+
+// Snapshot is a consistent read-only view of a database at a point in time,
+// unaffected by any write happening after it was taken.
+type Snapshot interface {
+	Get(key []byte) ([]byte, error)
+	Has(key []byte) (bool, error)
+
+	// NewIterator creates an iterator over the entire key space contained within
+	// the snapshot.
+	NewIterator() Iterator
+
+	// Release releases the resources held by the snapshot. The snapshot must not
+	// be used afterwards.
+	Release()
+}
+
+// Snapshotter wraps the snapshot method of a database.
+type Snapshotter interface {
+	// NewSnapshot takes a consistent snapshot of the current database content.
+	NewSnapshot() (Snapshot, error)
+}
+
+// NewSnapshot takes a consistent snapshot of the current database content.
+func (db *LDBDatabase) NewSnapshot() (Snapshot, error) {
+	snap, err := db.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &ldbSnapshot{snap: snap}, nil
+}
+
+// ldbSnapshot is a Snapshot backed by a leveldb snapshot.
+type ldbSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+func (s *ldbSnapshot) Get(key []byte) ([]byte, error) {
+	return s.snap.Get(key, nil)
+}
+
+func (s *ldbSnapshot) Has(key []byte) (bool, error) {
+	return s.snap.Has(key, nil)
+}
+
+func (s *ldbSnapshot) NewIterator() Iterator {
+	return s.snap.NewIterator(nil, nil)
+}
+
+func (s *ldbSnapshot) Release() {
+	s.snap.Release()
+}
+
+// BackupSink is the destination a backup is streamed into, as a sequence of
+// named files.
+type BackupSink interface {
+	WriteFile(name string, data []byte) error
+	Close() error
+}
+
+// BackupSource is the origin a backup is restored from, yielding the files in
+// the order they were written. NextFile returns io.EOF after the last file.
+type BackupSource interface {
+	NextFile() (string, []byte, error)
+	Close() error
+}
+
+// isTarBackup reports whether a backup path denotes a tar archive rather than
+// a directory.
+func isTarBackup(path string) bool {
+	return strings.HasSuffix(path, ".tar") || isGzipBackup(path)
+}
+
+// isGzipBackup reports whether a backup path denotes a gzipped tar archive.
+func isGzipBackup(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// CreateBackup creates a sink streaming a backup to the given path. Paths ending
+// in .tar, .tar.gz or .tgz are written as a (gzipped) tar archive, anything else
+// as a directory. Existing archives and non-empty directories are never touched.
+func CreateBackup(path string) (BackupSink, error) {
+	if isTarBackup(path) {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return nil, err
+		}
+		sink := &tarSink{file: file}
+		if isGzipBackup(path) {
+			sink.gz = gzip.NewWriter(file)
+			sink.tw = tar.NewWriter(sink.gz)
+		} else {
+			sink.tw = tar.NewWriter(file)
+		}
+		return sink, nil
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		return nil, fmt.Errorf("backup directory %s is not empty", path)
+	}
+	return &dirSink{dir: path}, nil
+}
+
+// OpenBackup opens a backup created by CreateBackup for reading.
+func OpenBackup(path string) (BackupSource, error) {
+	if isTarBackup(path) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		source := &tarSource{file: file}
+		if isGzipBackup(path) {
+			if source.gz, err = gzip.NewReader(file); err != nil {
+				file.Close()
+				return nil, err
+			}
+			source.tr = tar.NewReader(source.gz)
+		} else {
+			source.tr = tar.NewReader(file)
+		}
+		return source, nil
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	source := &dirSource{dir: path}
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			source.names = append(source.names, file.Name())
+		}
+	}
+	return source, nil
+}
+
+// dirSink writes the backup files into a directory.
+type dirSink struct {
+	dir string
+}
+
+func (s *dirSink) WriteFile(name string, data []byte) error {
+	return ioutil.WriteFile(filepath.Join(s.dir, name), data, 0644)
+}
+
+func (s *dirSink) Close() error {
+	return nil
+}
+
+// dirSource reads the backup files from a directory, in name order.
+type dirSource struct {
+	dir   string
+	names []string
+}
+
+func (s *dirSource) NextFile() (string, []byte, error) {
+	if len(s.names) == 0 {
+		return "", nil, io.EOF
+	}
+	name := s.names[0]
+	s.names = s.names[1:]
+
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	return name, data, err
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
+
+// tarSink writes the backup files into an optionally gzipped tar archive.
+type tarSink struct {
+	file *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+}
+
+func (s *tarSink) WriteFile(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := s.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := s.tw.Write(data)
+	return err
+}
+
+func (s *tarSink) Close() error {
+	err := s.tw.Close()
+	if s.gz != nil {
+		if gzerr := s.gz.Close(); err == nil {
+			err = gzerr
+		}
+	}
+	if ferr := s.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// tarSource reads the backup files from an optionally gzipped tar archive.
+type tarSource struct {
+	file *os.File
+	gz   *gzip.Reader
+	tr   *tar.Reader
+}
+
+func (s *tarSource) NextFile() (string, []byte, error) {
+	for {
+		header, err := s.tr.Next()
+		if err != nil {
+			return "", nil, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		data, err := ioutil.ReadAll(s.tr)
+		return header.Name, data, err
+	}
+}
+
+func (s *tarSource) Close() error {
+	if s.gz != nil {
+		s.gz.Close()
+	}
+	return s.file.Close()
+}
+
+// BackupStats contains the number of items contained in a backup.
+type BackupStats struct {
+	Entries  uint64 // Number of key-value entries
+	Ancients uint64 // Number of blocks in the ancient store
+	Bytes    uint64 // Total size of the backup files
+}
+
+// backupEntry is a key-value entry of a backup file.
+type backupEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// backupAncient is an ancient store item of a backup file.
+type backupAncient struct {
+	Number   uint64
+	Hash     []byte
+	Header   []byte
+	Body     []byte
+	Receipts []byte
+	Diff     []byte
+}
+
+// chunkWriter collects RLP encoded items into numbered backup files.
+type chunkWriter struct {
+	sink   BackupSink
+	prefix string
+	stats  *BackupStats
+	index  int
+	buf    bytes.Buffer
+}
+
+// write appends an item to the current backup file, flushing it if full.
+func (w *chunkWriter) write(item interface{}) error {
+	if err := rlp.Encode(&w.buf, item); err != nil {
+		return err
+	}
+	if w.buf.Len() >= backupChunkSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the current backup file into the sink, if not empty.
+func (w *chunkWriter) flush() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	if err := w.sink.WriteFile(fmt.Sprintf("%s%06d.rlp", w.prefix, w.index), w.buf.Bytes()); err != nil {
+		return err
+	}
+	w.stats.Bytes += uint64(w.buf.Len())
+	w.index++
+	w.buf.Reset()
+	return nil
+}
+
+// WriteBackup streams the entire content of a database snapshot, along with the
+// first frozen blocks of its ancient store, into a backup sink. The ancient store
+// may be nil if the database has none attached.
+//
+// Blocks are only ever deleted from the key-value store after they've been moved
+// into the ancient store, so the snapshot must be taken before the number of
+// frozen blocks is read, otherwise blocks frozen in between would be missing.
+func WriteBackup(sink BackupSink, snap Snapshot, ancients AncientReader, frozen uint64) (*BackupStats, error) {
+	var (
+		stats  = new(BackupStats)
+		start  = time.Now()
+		logged = time.Now()
+	)
+	entries := &chunkWriter{sink: sink, prefix: backupEntriesPrefix, stats: stats}
+
+	it := snap.NewIterator()
+	defer it.Release()
+
+	for it.Next() {
+		if err := entries.write(&backupEntry{Key: it.Key(), Value: it.Value()}); err != nil {
+			return nil, err
+		}
+		stats.Entries++
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Backing up database", "entries", stats.Entries, "size", stats.Bytes, "elapsed", time.Since(start))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	if err := entries.flush(); err != nil {
+		return nil, err
+	}
+	items := &chunkWriter{sink: sink, prefix: backupAncientsPrefix, stats: stats}
+	for number := uint64(0); number < frozen; number++ {
+		item := &backupAncient{Number: number}
+		for _, field := range []struct {
+			kind string
+			blob *[]byte
+		}{
+			{AncientHashes, &item.Hash},
+			{AncientHeaders, &item.Header},
+			{AncientBodies, &item.Body},
+			{AncientReceipts, &item.Receipts},
+			{AncientDiffs, &item.Diff},
+		} {
+			blob, err := ancients.Ancient(field.kind, number)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ancient %s #%d: %v", field.kind, number, err)
+			}
+			*field.blob = blob
+		}
+		if err := items.write(item); err != nil {
+			return nil, err
+		}
+		stats.Ancients++
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Backing up ancients", "blocks", stats.Ancients, "total", frozen, "size", stats.Bytes, "elapsed", time.Since(start))
+			logged = time.Now()
+		}
+	}
+	if err := items.flush(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// RestoreBackup writes the content of a backup into an empty database and its
+// ancient store, which may be nil if the backup contains no ancient blocks. Any
+// additional file of the backup is returned to the caller, keyed by name.
+func RestoreBackup(source BackupSource, db Database, ancients AncientWriter) (map[string][]byte, *BackupStats, error) {
+	var (
+		stats  = new(BackupStats)
+		extras = make(map[string][]byte)
+		batch  = db.NewBatch()
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for {
+		name, data, err := source.NextFile()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		stats.Bytes += uint64(len(data))
+
+		switch {
+		case strings.HasPrefix(name, backupEntriesPrefix):
+			stream := rlp.NewStream(bytes.NewReader(data), uint64(len(data)))
+			for {
+				var entry backupEntry
+				if err := stream.Decode(&entry); err == io.EOF {
+					break
+				} else if err != nil {
+					return nil, nil, fmt.Errorf("invalid backup file %s: %v", name, err)
+				}
+				if err := batch.Put(entry.Key, entry.Value); err != nil {
+					return nil, nil, err
+				}
+				if batch.ValueSize() >= IdealBatchSize {
+					if err := batch.Write(); err != nil {
+						return nil, nil, err
+					}
+					batch.Reset()
+				}
+				stats.Entries++
+			}
+		case strings.HasPrefix(name, backupAncientsPrefix):
+			stream := rlp.NewStream(bytes.NewReader(data), uint64(len(data)))
+			for {
+				var item backupAncient
+				if err := stream.Decode(&item); err == io.EOF {
+					break
+				} else if err != nil {
+					return nil, nil, fmt.Errorf("invalid backup file %s: %v", name, err)
+				}
+				if ancients == nil {
+					return nil, nil, errNotSupported
+				}
+				if err := ancients.AppendAncient(item.Number, item.Hash, item.Header, item.Body, item.Receipts, item.Diff); err != nil {
+					return nil, nil, err
+				}
+				stats.Ancients++
+			}
+		default:
+			extras[name] = data
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Restoring database", "entries", stats.Entries, "ancients", stats.Ancients, "size", stats.Bytes, "elapsed", time.Since(start))
+			logged = time.Now()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return nil, nil, err
+	}
+	if stats.Ancients > 0 {
+		if err := ancients.Sync(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return extras, stats, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemodb_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+)
+
+// Tests that a database snapshot along with its ancient store can be streamed
+// into directory and tar backups and restored from them, without any of the
+// writes done after the snapshot was taken.
+func TestBackupRestoreDir(t *testing.T)   { testBackupRestore(t, "backup") }
+func TestBackupRestoreTar(t *testing.T)   { testBackupRestore(t, "backup.tar") }
+func TestBackupRestoreTarGz(t *testing.T) { testBackupRestore(t, "backup.tar.gz") }
+
+func testBackupRestore(t *testing.T, target string) {
+	dir, err := ioutil.TempDir("", "lemodb-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := lemodb.NewLDBDatabaseWithFreezer(filepath.Join(dir, "source"), 0, 0, filepath.Join(dir, "source", "ancient"), "")
+	if err != nil {
+		t.Fatalf("failed to create source database: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	appendTestAncients(t, db, 0, 10)
+
+	snap, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+	defer snap.Release()
+
+	// Modify the database after the snapshot, none of it may be backed up
+	db.Put([]byte("key-0000"), []byte("modified"))
+	db.Put([]byte("key-late"), []byte("late"))
+	db.Delete([]byte("key-0001"))
+
+	path := filepath.Join(dir, target)
+	sink, err := lemodb.CreateBackup(path)
+	if err != nil {
+		t.Fatalf("failed to create backup: %v", err)
+	}
+	stats, err := lemodb.WriteBackup(sink, snap, db, 10)
+	if err != nil {
+		t.Fatalf("failed to write backup: %v", err)
+	}
+	if stats.Entries != 1000 || stats.Ancients != 10 {
+		t.Fatalf("backup stats mismatch: have %d entries, %d ancients, want 1000, 10", stats.Entries, stats.Ancients)
+	}
+	if err := sink.WriteFile("extra", []byte("metadata")); err != nil {
+		t.Fatalf("failed to write extra file: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close backup: %v", err)
+	}
+	if _, err := lemodb.CreateBackup(path); err == nil {
+		t.Fatalf("overwrote existing backup")
+	}
+	// Restore the backup into a fresh database and check its content
+	restored, err := lemodb.NewLDBDatabaseWithFreezer(filepath.Join(dir, "restored"), 0, 0, filepath.Join(dir, "restored", "ancient"), "")
+	if err != nil {
+		t.Fatalf("failed to create restored database: %v", err)
+	}
+	defer restored.Close()
+
+	source, err := lemodb.OpenBackup(path)
+	if err != nil {
+		t.Fatalf("failed to open backup: %v", err)
+	}
+	defer source.Close()
+
+	extras, stats, err := lemodb.RestoreBackup(source, restored, restored)
+	if err != nil {
+		t.Fatalf("failed to restore backup: %v", err)
+	}
+	if stats.Entries != 1000 || stats.Ancients != 10 {
+		t.Fatalf("restore stats mismatch: have %d entries, %d ancients, want 1000, 10", stats.Entries, stats.Ancients)
+	}
+	if len(extras) != 1 || !bytes.Equal(extras["extra"], []byte("metadata")) {
+		t.Fatalf("extra files mismatch: have %q", extras)
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		if value, err := restored.Get(key); err != nil || !bytes.Equal(value, []byte(fmt.Sprintf("value-%d", i))) {
+			t.Fatalf("key %q: have %q, %v, want %q", key, value, err, fmt.Sprintf("value-%d", i))
+		}
+	}
+	if ok, _ := restored.Has([]byte("key-late")); ok {
+		t.Fatalf("entry written after the snapshot restored")
+	}
+	checkTestAncients(t, restored, 10)
+}
@@ -0,0 +1,401 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemodb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// boltFile is the name of the data file inside a bolt database directory.
+	boltFile = "bolt.db"
+
+	// boltIteratorChunk is the number of entries an iterator reads in a single
+	// transaction. Long lived read transactions would block the database from
+	// growing, so iterators release them after every chunk.
+	boltIteratorChunk = 1024
+)
+
+var (
+	// boltBucket is the single bucket all the key-value pairs are stored in.
+	boltBucket = []byte("lemodb")
+
+	// errBoltNotFound is returned if a requested key is not in the bolt database.
+	errBoltNotFound = errors.New("not found")
+)
+
+// boltKey converts a database key into the key stored in bolt. Bolt doesn't allow
+// empty keys, so all of them are stored with a constant single byte prefix.
+func boltKey(key []byte) []byte {
+	return append([]byte{0x00}, key...)
+}
+
+// BoltDatabase is a database backed by bolt, a pure Go key-value store keeping
+// all its data in a single memory mapped file. The file is locked while open, so
+// read-only instances can be shared by multiple readers, but not with a writer.
+type BoltDatabase struct {
+	fn string   // filename for reporting
+	db *bolt.DB // Bolt instance
+
+	ancients *Freezer // Optional ancient store holding immutable chain segments
+
+	log log.Logger // Contextual logger tracking the database path
+}
+
+// NewBoltDatabase returns a bolt wrapped object, storing its data file in the
+// given directory. Read-only databases must already exist.
+func NewBoltDatabase(file string, readonly bool) (*BoltDatabase, error) {
+	logger := log.New("database", file)
+
+	if !readonly {
+		if err := os.MkdirAll(file, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(filepath.Join(file, boltFile), 0644, &bolt.Options{Timeout: time.Second, ReadOnly: readonly})
+	if err != nil {
+		return nil, err
+	}
+	if !readonly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltBucket)
+			return err
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	logger.Info("Opened bolt database", "readonly", readonly)
+
+	return &BoltDatabase{
+		fn:  file,
+		db:  db,
+		log: logger,
+	}, nil
+}
+
+// Path returns the path to the database directory.
+func (db *BoltDatabase) Path() string {
+	return db.fn
+}
+
+// Put inserts the given key / value pair into the database.
+func (db *BoltDatabase) Put(key []byte, value []byte) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(boltKey(key), value)
+	})
+}
+
+// Has retrieves whether the key is present in the database.
+func (db *BoltDatabase) Has(key []byte) (bool, error) {
+	var exists bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(boltBucket); bucket != nil {
+			exists = bucket.Get(boltKey(key)) != nil
+		}
+		return nil
+	})
+	return exists, err
+}
+
+// Get returns the given key if it's present.
+func (db *BoltDatabase) Get(key []byte) ([]byte, error) {
+	var dat []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		if bucket == nil {
+			return errBoltNotFound
+		}
+		// Values are only valid during the transaction, copy them out
+		value := bucket.Get(boltKey(key))
+		if value == nil {
+			return errBoltNotFound
+		}
+		dat = common.CopyBytes(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dat, nil
+}
+
+// Delete deletes the key from the database.
+func (db *BoltDatabase) Delete(key []byte) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(boltKey(key))
+	})
+}
+
+// NewIterator returns an iterator over the entire database content.
+func (db *BoltDatabase) NewIterator() Iterator {
+	return db.newIterator(nil, nil)
+}
+
+// NewIteratorWithStart returns an iterator over the database content starting
+// at a particular initial key (or after, if it does not exist).
+func (db *BoltDatabase) NewIteratorWithStart(start []byte) Iterator {
+	return db.newIterator(start, nil)
+}
+
+// NewIteratorWithPrefix returns an iterator over the database content with a
+// particular key prefix.
+func (db *BoltDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return db.newIterator(prefix, prefix)
+}
+
+func (db *BoltDatabase) newIterator(start []byte, prefix []byte) Iterator {
+	return &boltIterator{
+		db:     db.db,
+		next:   boltKey(start),
+		prefix: boltKey(prefix),
+	}
+}
+
+// Stat returns a particular internal stat of the database. The only supported
+// property is "bolt.stats", reporting the transaction and page statistics.
+func (db *BoltDatabase) Stat(property string) (string, error) {
+	if property != "bolt.stats" {
+		return "", errors.New("unknown property")
+	}
+	stats := db.db.Stats()
+	return fmt.Sprintf("Free pages: %d, Pending pages: %d, Free allocation: %d bytes\n"+
+		"Read transactions: %d (%d open)\n"+
+		"Page allocations: %d (%d bytes), Node splits: %d, Rebalances: %d, Spills: %d, Writes: %d",
+		stats.FreePageN, stats.PendingPageN, stats.FreeAlloc, stats.TxN, stats.OpenTxN,
+		stats.TxStats.PageCount, stats.TxStats.PageAlloc, stats.TxStats.Split,
+		stats.TxStats.Rebalance, stats.TxStats.Spill, stats.TxStats.Write), nil
+}
+
+// Compact is a no-op, bolt reuses the pages of deleted data without compaction.
+func (db *BoltDatabase) Compact(start []byte, limit []byte) error {
+	return nil
+}
+
+// Close closes the attached ancient store and the database itself.
+func (db *BoltDatabase) Close() {
+	if db.ancients != nil {
+		if err := db.ancients.Close(); err != nil {
+			db.log.Error("Failed to close ancient database", "err", err)
+		}
+	}
+	err := db.db.Close()
+	if err == nil {
+		db.log.Info("Database closed")
+	} else {
+		db.log.Error("Failed to close database", "err", err)
+	}
+}
+
+// attachFreezer sets the ancient store to serve the immutable chain segments from.
+func (db *BoltDatabase) attachFreezer(ancients *Freezer) {
+	db.ancients = ancients
+}
+
+// HasAncient returns an indicator whether the specified data exists in the
+// attached ancient store.
+func (db *BoltDatabase) HasAncient(kind string, number uint64) (bool, error) {
+	if db.ancients == nil {
+		return false, errNotSupported
+	}
+	return db.ancients.HasAncient(kind, number)
+}
+
+// Ancient retrieves an ancient binary blob from the attached ancient store.
+func (db *BoltDatabase) Ancient(kind string, number uint64) ([]byte, error) {
+	if db.ancients == nil {
+		return nil, errNotSupported
+	}
+	return db.ancients.Ancient(kind, number)
+}
+
+// Ancients returns the number of items frozen into the attached ancient store.
+func (db *BoltDatabase) Ancients() (uint64, error) {
+	if db.ancients == nil {
+		return 0, errNotSupported
+	}
+	return db.ancients.Ancients()
+}
+
+// AppendAncient injects all binary blobs belonging to a block at the end of the
+// attached ancient store.
+func (db *BoltDatabase) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	if db.ancients == nil {
+		return errNotSupported
+	}
+	return db.ancients.AppendAncient(number, hash, header, body, receipts, td)
+}
+
+// TruncateAncients discards all but the first n items of the attached ancient store.
+func (db *BoltDatabase) TruncateAncients(n uint64) error {
+	if db.ancients == nil {
+		return errNotSupported
+	}
+	return db.ancients.TruncateAncients(n)
+}
+
+// Sync flushes the attached ancient store to disk.
+func (db *BoltDatabase) Sync() error {
+	if db.ancients == nil {
+		return errNotSupported
+	}
+	return db.ancients.Sync()
+}
+
+// NewBatch creates a write-only batch, committing all its content in a single
+// bolt transaction.
+func (db *BoltDatabase) NewBatch() Batch {
+	return &boltBatch{db: db.db}
+}
+
+// boltOp is a single insertion or deletion queued up in a bolt batch.
+type boltOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+type boltBatch struct {
+	db   *bolt.DB
+	ops  []boltOp
+	size int
+}
+
+func (b *boltBatch) Put(key, value []byte) error {
+	b.ops = append(b.ops, boltOp{key: boltKey(key), value: common.CopyBytes(value)})
+	b.size += len(value)
+	return nil
+}
+
+func (b *boltBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, boltOp{key: boltKey(key), delete: true})
+	b.size++
+	return nil
+}
+
+func (b *boltBatch) Write() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, op := range b.ops {
+			var err error
+			if op.delete {
+				err = bucket.Delete(op.key)
+			} else {
+				err = bucket.Put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltBatch) ValueSize() int {
+	return b.size
+}
+
+func (b *boltBatch) Reset() {
+	b.ops = b.ops[:0]
+	b.size = 0
+}
+
+// boltIterator iterates over the content of a bolt database, reading it in chunks
+// of short lived read transactions.
+type boltIterator struct {
+	db     *bolt.DB
+	next   []byte // Key to continue reading the next chunk from (inclusive)
+	prefix []byte // Key prefix all the iterated keys need to start with
+
+	keys   [][]byte // Keys of the current chunk, stripped of the bolt prefix
+	values [][]byte // Values of the current chunk
+	index  int      // Position within the current chunk
+	done   bool     // Flag whether the last chunk was read
+	err    error
+}
+
+// Next moves the iterator to the next key/value pair, reading a new chunk from
+// the database if the current one was exhausted.
+func (it *boltIterator) Next() bool {
+	if it.index+1 < len(it.keys) {
+		it.index++
+		return true
+	}
+	if it.done || it.err != nil {
+		it.keys, it.values, it.index = nil, nil, 0
+		return false
+	}
+	it.keys, it.values, it.index = it.keys[:0], it.values[:0], 0
+
+	it.err = it.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		if bucket == nil {
+			it.done = true
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for key, value := cursor.Seek(it.next); ; key, value = cursor.Next() {
+			if key == nil || !bytes.HasPrefix(key, it.prefix) {
+				it.done = true
+				return nil
+			}
+			if len(it.keys) == boltIteratorChunk {
+				it.next = common.CopyBytes(key)
+				return nil
+			}
+			it.keys = append(it.keys, common.CopyBytes(key[1:]))
+			it.values = append(it.values, common.CopyBytes(value))
+		}
+	})
+	if it.err != nil || len(it.keys) == 0 {
+		it.keys, it.values = nil, nil
+		return false
+	}
+	return true
+}
+
+func (it *boltIterator) Error() error {
+	return it.err
+}
+
+func (it *boltIterator) Key() []byte {
+	if it.index >= len(it.keys) {
+		return nil
+	}
+	return it.keys[it.index]
+}
+
+func (it *boltIterator) Value() []byte {
+	if it.index >= len(it.keys) {
+		return nil
+	}
+	return it.values[it.index]
+}
+
+func (it *boltIterator) Release() {
+	it.keys, it.values, it.done = nil, nil, true
+}
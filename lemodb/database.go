@@ -17,6 +17,7 @@
 package lemodb
 
 import (
+	"bytes"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,7 +28,6 @@ import (
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/filter"
-	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
@@ -47,11 +47,19 @@ type LDBDatabase struct {
 	quitLock sync.Mutex      // Mutex protecting the quit channel access
 	quitChan chan chan error // Quit channel to stop the metrics collection before closing the database
 
+	ancients *Freezer // Optional ancient store holding immutable chain segments
+
 	log log.Logger // Contextual logger tracking the database path
 }
 
 // NewLDBDatabase returns a LevelDB wrapped object.
 func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
+	return newLDBDatabase(file, cache, handles, false)
+}
+
+// newLDBDatabase returns a LevelDB wrapped object, optionally opening an existing
+// database without write access.
+func newLDBDatabase(file string, cache int, handles int, readonly bool) (*LDBDatabase, error) {
 	logger := log.New("database", file)
 
 	// Ensure we have some minimal caching and file guarantees
@@ -69,8 +77,9 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 		BlockCacheCapacity:     cache / 2 * opt.MiB,
 		WriteBuffer:            cache / 4 * opt.MiB, // Two of these are used internally
 		Filter:                 filter.NewBloomFilter(10),
+		ReadOnly:               readonly,
 	})
-	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
+	if _, corrupted := err.(*errors.ErrCorrupted); corrupted && !readonly {
 		db, err = leveldb.RecoverFile(file, nil)
 	}
 	// (Re)check for errors and abort if opening of the db failed
@@ -84,6 +93,28 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 	}, nil
 }
 
+// NewLDBDatabaseWithFreezer returns a LevelDB wrapped object with an attached
+// ancient store, which moves immutable chain segments out of the key-value
+// store into append-only flat files in the freezer directory.
+func NewLDBDatabaseWithFreezer(file string, cache int, handles int, freezer string, namespace string) (*LDBDatabase, error) {
+	db, err := NewLDBDatabase(file, cache, handles)
+	if err != nil {
+		return nil, err
+	}
+	ancients, err := NewFreezer(freezer, namespace, false)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	db.attachFreezer(ancients)
+	return db, nil
+}
+
+// attachFreezer sets the ancient store to serve the immutable chain segments from.
+func (db *LDBDatabase) attachFreezer(ancients *Freezer) {
+	db.ancients = ancients
+}
+
 // Path returns the path to the database directory.
 func (db *LDBDatabase) Path() string {
 	return db.fn
@@ -118,15 +149,33 @@ func (db *LDBDatabase) Delete(key []byte) error {
 	return db.db.Delete(key, nil)
 }
 
-func (db *LDBDatabase) NewIterator() iterator.Iterator {
+// NewIterator returns an iterator over the entire database content.
+func (db *LDBDatabase) NewIterator() Iterator {
 	return db.db.NewIterator(nil, nil)
 }
 
+// NewIteratorWithStart returns an iterator over the database content starting
+// at a particular initial key (or after, if it does not exist).
+func (db *LDBDatabase) NewIteratorWithStart(start []byte) Iterator {
+	return db.db.NewIterator(&util.Range{Start: start}, nil)
+}
+
 // NewIteratorWithPrefix returns a iterator to iterate over subset of database content with a particular prefix.
-func (db *LDBDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+func (db *LDBDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
 	return db.db.NewIterator(util.BytesPrefix(prefix), nil)
 }
 
+// Stat returns a particular internal stat of the database, e.g. "leveldb.stats".
+func (db *LDBDatabase) Stat(property string) (string, error) {
+	return db.db.GetProperty(property)
+}
+
+// Compact flattens the underlying data store for the given key range. A nil
+// start and limit compacts the entire database.
+func (db *LDBDatabase) Compact(start []byte, limit []byte) error {
+	return db.db.CompactRange(util.Range{Start: start, Limit: limit})
+}
+
 func (db *LDBDatabase) Close() {
 	// Stop the metrics collection to avoid internal database races
 	db.quitLock.Lock()
@@ -139,6 +188,11 @@ func (db *LDBDatabase) Close() {
 			db.log.Error("Metrics collection failed", "err", err)
 		}
 	}
+	if db.ancients != nil {
+		if err := db.ancients.Close(); err != nil {
+			db.log.Error("Failed to close ancient database", "err", err)
+		}
+	}
 	err := db.db.Close()
 	if err == nil {
 		db.log.Info("Database closed")
@@ -151,6 +205,56 @@ func (db *LDBDatabase) LDB() *leveldb.DB {
 	return db.db
 }
 
+// HasAncient returns an indicator whether the specified data exists in the
+// attached ancient store.
+func (db *LDBDatabase) HasAncient(kind string, number uint64) (bool, error) {
+	if db.ancients == nil {
+		return false, errNotSupported
+	}
+	return db.ancients.HasAncient(kind, number)
+}
+
+// Ancient retrieves an ancient binary blob from the attached ancient store.
+func (db *LDBDatabase) Ancient(kind string, number uint64) ([]byte, error) {
+	if db.ancients == nil {
+		return nil, errNotSupported
+	}
+	return db.ancients.Ancient(kind, number)
+}
+
+// Ancients returns the number of items frozen into the attached ancient store.
+func (db *LDBDatabase) Ancients() (uint64, error) {
+	if db.ancients == nil {
+		return 0, errNotSupported
+	}
+	return db.ancients.Ancients()
+}
+
+// AppendAncient injects all binary blobs belonging to a block at the end of the
+// attached ancient store.
+func (db *LDBDatabase) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	if db.ancients == nil {
+		return errNotSupported
+	}
+	return db.ancients.AppendAncient(number, hash, header, body, receipts, td)
+}
+
+// TruncateAncients discards all but the first n items of the attached ancient store.
+func (db *LDBDatabase) TruncateAncients(n uint64) error {
+	if db.ancients == nil {
+		return errNotSupported
+	}
+	return db.ancients.TruncateAncients(n)
+}
+
+// Sync flushes the attached ancient store to disk.
+func (db *LDBDatabase) Sync() error {
+	if db.ancients == nil {
+		return errNotSupported
+	}
+	return db.ancients.Sync()
+}
+
 // Meter configures the database metrics collectors and
 func (db *LDBDatabase) Meter(prefix string) {
 	// Short circuit metering if the metrics system is disabled
@@ -311,6 +415,12 @@ func (b *ldbBatch) Put(key, value []byte) error {
 	return nil
 }
 
+func (b *ldbBatch) Delete(key []byte) error {
+	b.b.Delete(key)
+	b.size += 1
+	return nil
+}
+
 func (b *ldbBatch) Write() error {
 	return b.db.Write(b.b, nil)
 }
@@ -354,10 +464,93 @@ func (dt *table) Delete(key []byte) error {
 	return dt.db.Delete(append([]byte(dt.prefix), key...))
 }
 
+// NewIterator returns an iterator over all the keys of the table, stripped of
+// the table prefix.
+func (dt *table) NewIterator() Iterator {
+	return dt.NewIteratorWithPrefix(nil)
+}
+
+// NewIteratorWithStart returns an iterator over the keys of the table starting
+// at a particular initial key (or after, if it does not exist).
+func (dt *table) NewIteratorWithStart(start []byte) Iterator {
+	return &tableIterator{
+		it:     dt.db.NewIteratorWithStart(append([]byte(dt.prefix), start...)),
+		prefix: []byte(dt.prefix),
+	}
+}
+
+// NewIteratorWithPrefix returns an iterator over the keys of the table with a
+// particular prefix.
+func (dt *table) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return &tableIterator{
+		it:     dt.db.NewIteratorWithPrefix(append([]byte(dt.prefix), prefix...)),
+		prefix: []byte(dt.prefix),
+	}
+}
+
+// Stat returns a particular internal stat of the underlying database.
+func (dt *table) Stat(property string) (string, error) {
+	return dt.db.Stat(property)
+}
+
+// Compact flattens the given key range of the table in the underlying database.
+// A nil start and limit compacts the entire table.
+func (dt *table) Compact(start []byte, limit []byte) error {
+	// Without an upper bound, compact up to the end of the table prefix
+	if limit == nil {
+		limit = util.BytesPrefix([]byte(dt.prefix)).Limit
+	} else {
+		limit = append([]byte(dt.prefix), limit...)
+	}
+	return dt.db.Compact(append([]byte(dt.prefix), start...), limit)
+}
+
 func (dt *table) Close() {
 	// Do nothing; don't close the underlying DB.
 }
 
+// tableIterator wraps an iterator of the underlying database, stopping at the
+// end of the table and stripping the table prefix from the keys.
+type tableIterator struct {
+	it     Iterator
+	prefix []byte
+	done   bool
+}
+
+func (it *tableIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if !it.it.Next() || !bytes.HasPrefix(it.it.Key(), it.prefix) {
+		it.done = true
+		return false
+	}
+	return true
+}
+
+func (it *tableIterator) Error() error {
+	return it.it.Error()
+}
+
+func (it *tableIterator) Key() []byte {
+	key := it.it.Key()
+	if it.done || key == nil {
+		return nil
+	}
+	return key[len(it.prefix):]
+}
+
+func (it *tableIterator) Value() []byte {
+	if it.done {
+		return nil
+	}
+	return it.it.Value()
+}
+
+func (it *tableIterator) Release() {
+	it.it.Release()
+}
+
 type tableBatch struct {
 	batch  Batch
 	prefix string
@@ -376,6 +569,10 @@ func (tb *tableBatch) Put(key, value []byte) error {
 	return tb.batch.Put(append([]byte(tb.prefix), key...), value)
 }
 
+func (tb *tableBatch) Delete(key []byte) error {
+	return tb.batch.Delete(append([]byte(tb.prefix), key...))
+}
+
 func (tb *tableBatch) Write() error {
 	return tb.batch.Write()
 }
@@ -44,6 +44,22 @@ func newTestLDB() (*lemodb.LDBDatabase, func()) {
 	}
 }
 
+func newTestBolt() (*lemodb.BoltDatabase, func()) {
+	dirname, err := ioutil.TempDir(os.TempDir(), "lemodb_test_")
+	if err != nil {
+		panic("failed to create test file: " + err.Error())
+	}
+	db, err := lemodb.NewBoltDatabase(dirname, false)
+	if err != nil {
+		panic("failed to create test database: " + err.Error())
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dirname)
+	}
+}
+
 var test_values = []string{"", "a", "1251", "\x00123\x00"}
 
 func TestLDB_PutGet(t *testing.T) {
@@ -52,6 +68,12 @@ func TestLDB_PutGet(t *testing.T) {
 	testPutGet(db, t)
 }
 
+func TestBolt_PutGet(t *testing.T) {
+	db, remove := newTestBolt()
+	defer remove()
+	testPutGet(db, t)
+}
+
 func TestMemoryDB_PutGet(t *testing.T) {
 	db, _ := lemodb.NewMemDatabase()
 	testPutGet(db, t)
@@ -130,6 +152,12 @@ func TestLDB_ParallelPutGet(t *testing.T) {
 	testParallelPutGet(db, t)
 }
 
+func TestBolt_ParallelPutGet(t *testing.T) {
+	db, remove := newTestBolt()
+	defer remove()
+	testParallelPutGet(db, t)
+}
+
 func TestMemoryDB_ParallelPutGet(t *testing.T) {
 	db, _ := lemodb.NewMemDatabase()
 	testParallelPutGet(db, t)
@@ -190,3 +218,114 @@ func testParallelPutGet(db lemodb.Database, t *testing.T) {
 	}
 	pending.Wait()
 }
+
+func TestLDB_Iterator(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testIterator(db, t)
+}
+
+func TestBolt_Iterator(t *testing.T) {
+	db, remove := newTestBolt()
+	defer remove()
+	testIterator(db, t)
+}
+
+func TestMemoryDB_Iterator(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+	testIterator(db, t)
+}
+
+func TestTable_Iterator(t *testing.T) {
+	db, _ := lemodb.NewMemDatabase()
+
+	// Surround the table with unrelated entries to ensure they are not leaked
+	db.Put([]byte("tablA"), []byte("before"))
+	db.Put([]byte("tablf"), []byte("after"))
+
+	testIterator(lemodb.NewTable(db, "table"), t)
+}
+
+func testIterator(db lemodb.Database, t *testing.T) {
+	keys := []string{"", "a", "aa", "ab", "b", "ba", "c"}
+	for _, key := range keys {
+		if err := db.Put([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	tests := []struct {
+		start, prefix string
+		want          []string
+	}{
+		{"", "", keys},
+		{"a", "", keys[1:]},
+		{"ac", "", keys[4:]},
+		{"d", "", nil},
+		{"", "a", keys[1:4]},
+		{"", "b", keys[4:6]},
+		{"", "d", nil},
+	}
+	for i, tt := range tests {
+		var it lemodb.Iterator
+		switch {
+		case tt.prefix != "":
+			it = db.NewIteratorWithPrefix([]byte(tt.prefix))
+		case tt.start != "":
+			it = db.NewIteratorWithStart([]byte(tt.start))
+		default:
+			it = db.NewIterator()
+		}
+		var have []string
+		for it.Next() {
+			if want := "v" + string(it.Key()); string(it.Value()) != want {
+				t.Errorf("test %d: value mismatch for key %q: have %q, want %q", i, it.Key(), it.Value(), want)
+			}
+			have = append(have, string(it.Key()))
+		}
+		if err := it.Error(); err != nil {
+			t.Errorf("test %d: iteration failed: %v", i, err)
+		}
+		it.Release()
+
+		if fmt.Sprint(have) != fmt.Sprint(tt.want) {
+			t.Errorf("test %d: keys mismatch: have %q, want %q", i, have, tt.want)
+		}
+	}
+	// Compaction must not lose any data
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	for _, key := range keys {
+		if data, err := db.Get([]byte(key)); err != nil || string(data) != "v"+key {
+			t.Errorf("key %q: post-compaction value mismatch: have %q, %v, want %q", key, data, err, "v"+key)
+		}
+	}
+}
+
+// Tests that bolt iterators spanning multiple read transactions don't skip or
+// duplicate any entries.
+func TestBolt_IteratorChunks(t *testing.T) {
+	db, remove := newTestBolt()
+	defer remove()
+
+	batch := db.NewBatch()
+	for i := 0; i < 3000; i++ {
+		batch.Put([]byte(fmt.Sprintf("%05d", i)), []byte{byte(i)})
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatalf("batch write failed: %v", err)
+	}
+	it := db.NewIterator()
+	defer it.Release()
+
+	count := 0
+	for it.Next() {
+		if want := fmt.Sprintf("%05d", count); string(it.Key()) != want {
+			t.Fatalf("key mismatch: have %q, want %q", it.Key(), want)
+		}
+		count++
+	}
+	if count != 3000 {
+		t.Errorf("iterated entries mismatch: have %d, want %d", count, 3000)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemodb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// LevelDBEngine is the name of the goleveldb backed database engine.
+	LevelDBEngine = "leveldb"
+
+	// BoltEngine is the name of the bolt backed database engine.
+	BoltEngine = "bolt"
+
+	// DefaultEngine is the engine used to create new databases if none is requested.
+	DefaultEngine = LevelDBEngine
+)
+
+// engineMarker is the name of the file inside the database directory recording
+// the engine which created the database.
+const engineMarker = "ENGINE"
+
+// errUnknownEngine is returned if a database is requested with an engine that
+// was never registered.
+var errUnknownEngine = errors.New("unknown database engine")
+
+// Options contains the settings to open a database with, independent of the
+// engine backing it. Engines are free to ignore any setting they have no use for.
+type Options struct {
+	Cache    int  // Megabytes of memory allocated to internal caching
+	Handles  int  // Number of files allowed to be kept open
+	ReadOnly bool // Whether to open an existing database without write access
+}
+
+// Opener opens or creates a database of a particular engine at the given path.
+type Opener func(file string, opts Options) (Database, error)
+
+var (
+	engines     = make(map[string]Opener)
+	enginesLock sync.RWMutex
+)
+
+func init() {
+	Register(LevelDBEngine, func(file string, opts Options) (Database, error) {
+		return newLDBDatabase(file, opts.Cache, opts.Handles, opts.ReadOnly)
+	})
+	Register(BoltEngine, func(file string, opts Options) (Database, error) {
+		return NewBoltDatabase(file, opts.ReadOnly)
+	})
+}
+
+// Register makes a database engine available by the provided name. If Register
+// is called twice with the same name, the latter opener replaces the former one.
+func Register(engine string, opener Opener) {
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+
+	engines[engine] = opener
+}
+
+// Engines returns the names of all the registered database engines.
+func Engines() []string {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the database at the given path with the requested engine, creating
+// it if none exists yet. If no engine is requested, the one which created the
+// existing database is used, or the default one for new databases. Opening an
+// existing database with a different engine than it was created with fails.
+func Open(engine string, file string, opts Options) (Database, error) {
+	recorded, err := ReadEngine(file)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case engine == "" && recorded != "":
+		engine = recorded
+	case engine == "":
+		engine = DefaultEngine
+	case recorded != "" && engine != recorded:
+		return nil, fmt.Errorf("database %s was created by engine %q, refusing to open with %q", file, recorded, engine)
+	}
+	enginesLock.RLock()
+	opener, ok := engines[engine]
+	enginesLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%v: %q (available: %s)", errUnknownEngine, engine, strings.Join(Engines(), ", "))
+	}
+	db, err := opener(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Record the engine of freshly created and legacy databases alike
+	if !opts.ReadOnly {
+		if marker, _ := ioutil.ReadFile(filepath.Join(file, engineMarker)); string(marker) != engine {
+			if err := ioutil.WriteFile(filepath.Join(file, engineMarker), []byte(engine), 0644); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+	return db, nil
+}
+
+// OpenWithFreezer opens the database at the given path the same way as Open does,
+// also attaching an ancient store to it. Only engines supporting ancient stores
+// can be used. The ancient store of a read-only database is read-only too.
+func OpenWithFreezer(engine string, file string, opts Options, freezer string, namespace string) (Database, error) {
+	db, err := Open(engine, file, opts)
+	if err != nil {
+		return nil, err
+	}
+	host, ok := db.(interface {
+		attachFreezer(freezer *Freezer)
+	})
+	if !ok {
+		db.Close()
+		return nil, errNotSupported
+	}
+	ancients, err := NewFreezer(freezer, namespace, opts.ReadOnly)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	host.attachFreezer(ancients)
+	return db, nil
+}
+
+// ReadEngine returns the engine which created the database at the given path,
+// or an empty string if there's no database there yet. Databases predating the
+// engine records are detected based on the files of the engines.
+func ReadEngine(file string) (string, error) {
+	marker, err := ioutil.ReadFile(filepath.Join(file, engineMarker))
+	switch {
+	case err == nil:
+		return strings.TrimSpace(string(marker)), nil
+	case !os.IsNotExist(err):
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(file, "CURRENT")); err == nil {
+		return LevelDBEngine, nil
+	}
+	if _, err := os.Stat(filepath.Join(file, boltFile)); err == nil {
+		return BoltEngine, nil
+	}
+	return "", nil
+}
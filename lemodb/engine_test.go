@@ -0,0 +1,85 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemodb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Tests that databases remember the engine which created them and refuse to be
+// opened by any other one.
+func TestEngineRecording(t *testing.T) {
+	for _, engine := range []string{LevelDBEngine, BoltEngine} {
+		dir, err := ioutil.TempDir("", "lemodb-engine-")
+		if err != nil {
+			t.Fatalf("failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		db, err := Open(engine, dir, Options{})
+		if err != nil {
+			t.Fatalf("%s: failed to create database: %v", engine, err)
+		}
+		if err := db.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("%s: failed to insert data: %v", engine, err)
+		}
+		db.Close()
+
+		if recorded, err := ReadEngine(dir); err != nil || recorded != engine {
+			t.Fatalf("%s: recorded engine mismatch: have %q, %v, want %q", engine, recorded, err, engine)
+		}
+		// Opening with any other engine must fail
+		for _, other := range []string{LevelDBEngine, BoltEngine} {
+			if other == engine {
+				continue
+			}
+			if db, err := Open(other, dir, Options{}); err == nil {
+				db.Close()
+				t.Fatalf("%s: opened with mismatching engine %s", engine, other)
+			}
+		}
+		// Opening without an engine must reuse the recorded one
+		db, err = Open("", dir, Options{ReadOnly: true})
+		if err != nil {
+			t.Fatalf("%s: failed to reopen database: %v", engine, err)
+		}
+		if value, err := db.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+			t.Errorf("%s: value mismatch: have %x, %v, want %x", engine, value, err, []byte("value"))
+		}
+		if err := db.Put([]byte("key"), []byte("other")); err == nil {
+			t.Errorf("%s: write succeeded on read-only database", engine)
+		}
+		db.Close()
+	}
+}
+
+// Tests that unknown engines are rejected.
+func TestUnknownEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lemodb-engine-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if db, err := Open("nosuchengine", dir, Options{}); err == nil {
+		db.Close()
+		t.Fatalf("opened database with unknown engine")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemodb
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/metrics"
+)
+
+// The list of table names of the chain freezer.
+const (
+	AncientHeaders  = "headers"  // Table of the RLP encoded block headers
+	AncientHashes   = "hashes"   // Table of the canonical block hashes
+	AncientBodies   = "bodies"   // Table of the RLP encoded block bodies
+	AncientReceipts = "receipts" // Table of the RLP encoded block receipts
+	AncientDiffs    = "diffs"    // Table of the RLP encoded total difficulties
+)
+
+// ancientTables is the set of tables every freezer maintains.
+var ancientTables = []string{AncientHeaders, AncientHashes, AncientBodies, AncientReceipts, AncientDiffs}
+
+var (
+	// errNotSupported is returned if the database doesn't support the required operation.
+	errNotSupported = errors.New("this operation is not supported")
+
+	// errUnknownTable is returned if the user attempts to read from a table that is
+	// not tracked by the freezer.
+	errUnknownTable = errors.New("unknown table")
+)
+
+// Freezer is an append-only database to store immutable chain data
+// into flat files:
+//
+// - The append only nature ensures that disk writes are minimized.
+// - The flat files allow the chain data to be placed on a different (slower)
+//   disk than the key-value store holding the recent chain and the state.
+type Freezer struct {
+	frozen   uint64 // Number of blocks already frozen (atomic)
+	readonly bool   // Whether the freezer is opened without write access
+
+	tables map[string]*freezerTable // Data tables for storing everything
+	log    log.Logger               // Contextual logger tracking the freezer path
+}
+
+// NewFreezer creates a chain freezer that moves ancient chain data into
+// append-only flat file containers. A read-only freezer must already exist and
+// never modifies its files, nor accepts new data.
+func NewFreezer(datadir string, namespace string, readonly bool) (*Freezer, error) {
+	var (
+		readMeter  = metrics.NewRegisteredMeter(namespace+"ancient/read", nil)
+		writeMeter = metrics.NewRegisteredMeter(namespace+"ancient/write", nil)
+	)
+	freezer := &Freezer{
+		readonly: readonly,
+		tables:   make(map[string]*freezerTable),
+		log:      log.New("database", datadir),
+	}
+	for _, name := range ancientTables {
+		table, err := newTable(datadir, name, readMeter, writeMeter, readonly)
+		if err != nil {
+			for _, table := range freezer.tables {
+				table.Close()
+			}
+			return nil, err
+		}
+		freezer.tables[name] = table
+	}
+	if err := freezer.repair(); err != nil {
+		freezer.Close()
+		return nil, err
+	}
+	freezer.log.Info("Opened ancient database", "frozen", freezer.frozen, "readonly", readonly)
+	return freezer, nil
+}
+
+// repair truncates all data tables to the same length, discarding any partial
+// block that might have been written before a crash. Read-only freezers only
+// hide the partial block.
+func (f *Freezer) repair() error {
+	min := ^uint64(0)
+	for _, table := range f.tables {
+		if items := table.Items(); items < min {
+			min = items
+		}
+	}
+	if f.readonly {
+		atomic.StoreUint64(&f.frozen, min)
+		return nil
+	}
+	for _, table := range f.tables {
+		if err := table.truncate(min); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, min)
+	return nil
+}
+
+// Close terminates the chain freezer, closing all the data files.
+func (f *Freezer) Close() error {
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// HasAncient returns an indicator whether the specified ancient data exists
+// in the freezer.
+func (f *Freezer) HasAncient(kind string, number uint64) (bool, error) {
+	if table := f.tables[kind]; table != nil {
+		return number < atomic.LoadUint64(&f.frozen), nil
+	}
+	return false, errUnknownTable
+}
+
+// Ancient retrieves an ancient binary blob from the append-only immutable files.
+func (f *Freezer) Ancient(kind string, number uint64) ([]byte, error) {
+	if table := f.tables[kind]; table != nil {
+		if number >= atomic.LoadUint64(&f.frozen) {
+			return nil, errOutOfBounds
+		}
+		return table.Retrieve(number)
+	}
+	return nil, errUnknownTable
+}
+
+// Ancients returns the length of the frozen items.
+func (f *Freezer) Ancients() (uint64, error) {
+	return atomic.LoadUint64(&f.frozen), nil
+}
+
+// AppendAncient injects all binary blobs belonging to a block at the end of the
+// append-only immutable table files.
+//
+// Notably, this function is lock free but kind of thread-safe. All out-of-order
+// injection will be rejected. But if two injections with same number happen at
+// the same time, we can get into the trouble.
+func (f *Freezer) AppendAncient(number uint64, hash, header, body, receipts, td []byte) (err error) {
+	if f.readonly {
+		return errReadOnly
+	}
+	// Ensure the binary blobs we are appending is continuous with freezer.
+	if atomic.LoadUint64(&f.frozen) != number {
+		return errOutOrderInsertion
+	}
+	// Rollback all inserted data if any insertion below failed to ensure
+	// the tables won't out of sync.
+	defer func() {
+		if err != nil {
+			rerr := f.repair()
+			if rerr != nil {
+				log.Crit("Failed to repair freezer", "err", rerr)
+			}
+			log.Info("Append ancient failed", "number", number, "err", err)
+		}
+	}()
+	blobs := map[string][]byte{
+		AncientHashes:   hash,
+		AncientHeaders:  header,
+		AncientBodies:   body,
+		AncientReceipts: receipts,
+		AncientDiffs:    td,
+	}
+	for _, name := range ancientTables {
+		if err := f.tables[name].Append(number, blobs[name]); err != nil {
+			log.Error("Failed to append ancient item", "table", name, "number", number, "err", err)
+			return err
+		}
+	}
+	atomic.AddUint64(&f.frozen, 1) // Only modify atomically
+	return nil
+}
+
+// TruncateAncients discards any recent data above the provided threshold number.
+func (f *Freezer) TruncateAncients(items uint64) error {
+	if atomic.LoadUint64(&f.frozen) <= items {
+		return nil
+	}
+	if f.readonly {
+		return errReadOnly
+	}
+	for _, table := range f.tables {
+		if err := table.truncate(items); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, items)
+	return nil
+}
+
+// Sync flushes all data tables to disk.
+func (f *Freezer) Sync() error {
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemodb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/metrics"
+)
+
+var (
+	// errClosed is returned if an operation attempts to read from or write to the
+	// freezer table after it has already been closed.
+	errClosed = errors.New("closed")
+
+	// errOutOfBounds is returned if the item requested is not contained within the
+	// freezer table.
+	errOutOfBounds = errors.New("out of bounds")
+
+	// errOutOrderInsertion is returned if the user attempts to inject out-of-order
+	// binary blobs into the freezer.
+	errOutOrderInsertion = errors.New("the append operation is out-order")
+
+	// errReadOnly is returned if the user attempts to modify a freezer which was
+	// opened in read-only mode.
+	errReadOnly = errors.New("read only freezer")
+)
+
+// indexEntrySize is the size of a single entry in the index file, namely the
+// big endian encoded end offset of the item within the data file.
+const indexEntrySize = 8
+
+// freezerTable represents a single chained data table within the freezer (e.g.
+// blocks). It consists of an append-only data file containing the raw blobs and
+// an index file containing the end offsets of every blob in the data file.
+type freezerTable struct {
+	items uint64 // Number of items stored in the table (atomic)
+
+	name  string   // Name of the table, used for file names and logging
+	index *os.File // File descriptor for the index file of the table
+	data  *os.File // File descriptor for the data file of the table
+	head  uint64   // Number of bytes stored in the data file
+
+	readonly bool // Whether the table files are opened without write access
+
+	readMeter  metrics.Meter // Meter for measuring the effective amount of data read
+	writeMeter metrics.Meter // Meter for measuring the effective amount of data written
+
+	log  log.Logger   // Logger with database path and table name embedded
+	lock sync.RWMutex // Mutex protecting the data file descriptors
+}
+
+// newTable opens a freezer table with the given name in the given directory,
+// creating the data and index files if they do not exist yet. Read-only tables
+// must already exist.
+func newTable(path string, name string, readMeter metrics.Meter, writeMeter metrics.Meter, readonly bool) (*freezerTable, error) {
+	flag := os.O_RDONLY
+	if !readonly {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+		flag = os.O_RDWR | os.O_CREATE
+	}
+	index, err := os.OpenFile(filepath.Join(path, name+".idx"), flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.OpenFile(filepath.Join(path, name+".dat"), flag, 0644)
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	tab := &freezerTable{
+		name:       name,
+		index:      index,
+		data:       data,
+		readonly:   readonly,
+		readMeter:  readMeter,
+		writeMeter: writeMeter,
+		log:        log.New("database", path, "table", name),
+	}
+	if err := tab.repair(); err != nil {
+		tab.Close()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// repair cross checks the index and data files, truncating them to be in sync
+// with each other after a potential crash or partial write. Read-only tables are
+// never truncated, any partial trailing data is only ignored.
+func (t *freezerTable) repair() error {
+	stat, err := t.index.Stat()
+	if err != nil {
+		return err
+	}
+	// Drop any trailing partial index entry
+	size := stat.Size()
+	if overflow := size % indexEntrySize; overflow != 0 {
+		size -= overflow
+		if !t.readonly {
+			if err := t.index.Truncate(size); err != nil {
+				return err
+			}
+		}
+	}
+	if stat, err = t.data.Stat(); err != nil {
+		return err
+	}
+	dataSize := uint64(stat.Size())
+
+	// Drop any index entries pointing past the end of the data file
+	items := uint64(size / indexEntrySize)
+	for items > 0 {
+		offset, err := t.offset(items)
+		if err != nil {
+			return err
+		}
+		if offset <= dataSize {
+			dataSize = offset
+			break
+		}
+		items--
+	}
+	if items == 0 {
+		dataSize = 0
+	}
+	if !t.readonly {
+		if err := t.index.Truncate(int64(items * indexEntrySize)); err != nil {
+			return err
+		}
+		if err := t.data.Truncate(int64(dataSize)); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&t.items, items)
+	t.head = dataSize
+
+	t.log.Debug("Opened freezer table", "items", items, "size", dataSize)
+	return nil
+}
+
+// offset retrieves the end offset of the item-th (1 based) entry in the data
+// file. Item zero is the beginning of the data file.
+func (t *freezerTable) offset(item uint64) (uint64, error) {
+	if item == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, indexEntrySize)
+	if _, err := t.index.ReadAt(buf, int64((item-1)*indexEntrySize)); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+// Items returns the number of items stored in the table.
+func (t *freezerTable) Items() uint64 {
+	return atomic.LoadUint64(&t.items)
+}
+
+// Append injects a binary blob at the end of the freezer table. The item number
+// is a precautionary parameter to ensure data correctness, but the table will
+// reject already existing data.
+func (t *freezerTable) Append(item uint64, blob []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil || t.data == nil {
+		return errClosed
+	}
+	if t.readonly {
+		return errReadOnly
+	}
+	if atomic.LoadUint64(&t.items) != item {
+		return errOutOrderInsertion
+	}
+	if _, err := t.data.WriteAt(blob, int64(t.head)); err != nil {
+		return err
+	}
+	end := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint64(end, t.head+uint64(len(blob)))
+	if _, err := t.index.WriteAt(end, int64(item*indexEntrySize)); err != nil {
+		return err
+	}
+	t.head += uint64(len(blob))
+	t.writeMeter.Mark(int64(len(blob) + indexEntrySize))
+	atomic.AddUint64(&t.items, 1)
+	return nil
+}
+
+// Retrieve looks up the data offset of an item with the given number and
+// retrieves the raw binary blob from the data file.
+func (t *freezerTable) Retrieve(item uint64) ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil || t.data == nil {
+		return nil, errClosed
+	}
+	if atomic.LoadUint64(&t.items) <= item {
+		return nil, errOutOfBounds
+	}
+	start, err := t.offset(item)
+	if err != nil {
+		return nil, err
+	}
+	end, err := t.offset(item + 1)
+	if err != nil {
+		return nil, err
+	}
+	if end < start {
+		return nil, fmt.Errorf("corrupted index entry %d in %s", item, t.name)
+	}
+	blob := make([]byte, end-start)
+	if _, err := t.data.ReadAt(blob, int64(start)); err != nil {
+		return nil, err
+	}
+	t.readMeter.Mark(int64(len(blob) + 2*indexEntrySize))
+	return blob, nil
+}
+
+// truncate discards any recent data above the provided threshold number.
+func (t *freezerTable) truncate(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if atomic.LoadUint64(&t.items) <= items {
+		return nil
+	}
+	if t.readonly {
+		return errReadOnly
+	}
+	t.log.Warn("Truncating freezer table", "items", atomic.LoadUint64(&t.items), "limit", items)
+
+	end, err := t.offset(items)
+	if err != nil {
+		return err
+	}
+	if err := t.index.Truncate(int64(items * indexEntrySize)); err != nil {
+		return err
+	}
+	if err := t.data.Truncate(int64(end)); err != nil {
+		return err
+	}
+	t.head = end
+	atomic.StoreUint64(&t.items, items)
+	return nil
+}
+
+// Sync pushes any pending data from memory out to disk. This is an expensive
+// operation, so use it with care.
+func (t *freezerTable) Sync() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil || t.data == nil {
+		return errClosed
+	}
+	if t.readonly {
+		return nil
+	}
+	if err := t.data.Sync(); err != nil {
+		return err
+	}
+	return t.index.Sync()
+}
+
+// Close closes all opened files.
+func (t *freezerTable) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var errs []error
+	if t.index != nil {
+		if err := t.index.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		t.index = nil
+	}
+	if t.data != nil {
+		if err := t.data.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		t.data = nil
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package lemodb_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+)
+
+// testAncientItem generates a deterministic blob for a table and item number.
+func testAncientItem(kind string, number uint64) []byte {
+	return []byte(fmt.Sprintf("%s-%d", kind, number))
+}
+
+func appendTestAncients(t *testing.T, f lemodb.AncientStore, from, to uint64) {
+	for i := from; i < to; i++ {
+		err := f.AppendAncient(i,
+			testAncientItem(lemodb.AncientHashes, i),
+			testAncientItem(lemodb.AncientHeaders, i),
+			testAncientItem(lemodb.AncientBodies, i),
+			testAncientItem(lemodb.AncientReceipts, i),
+			testAncientItem(lemodb.AncientDiffs, i),
+		)
+		if err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+}
+
+func checkTestAncients(t *testing.T, f lemodb.AncientStore, items uint64) {
+	if frozen, _ := f.Ancients(); frozen != items {
+		t.Fatalf("frozen item count mismatch: have %d, want %d", frozen, items)
+	}
+	for i := uint64(0); i < items; i++ {
+		for _, kind := range []string{lemodb.AncientHashes, lemodb.AncientHeaders, lemodb.AncientBodies, lemodb.AncientReceipts, lemodb.AncientDiffs} {
+			blob, err := f.Ancient(kind, i)
+			if err != nil {
+				t.Fatalf("failed to retrieve %s #%d: %v", kind, i, err)
+			}
+			if want := testAncientItem(kind, i); !bytes.Equal(blob, want) {
+				t.Fatalf("%s #%d mismatch: have %q, want %q", kind, i, blob, want)
+			}
+		}
+	}
+	if _, err := f.Ancient(lemodb.AncientHeaders, items); err == nil {
+		t.Fatalf("retrieved item above the frozen limit")
+	}
+}
+
+// Tests that items can be appended to the freezer, retrieved and that they
+// survive a restart.
+func TestFreezerAppendRetrieve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := lemodb.NewFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	appendTestAncients(t, f, 0, 64)
+	checkTestAncients(t, f, 64)
+
+	// Out of order insertions must be rejected
+	if err := f.AppendAncient(100, nil, nil, nil, nil, nil); err == nil {
+		t.Fatalf("out of order append succeeded")
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatalf("failed to sync freezer: %v", err)
+	}
+	f.Close()
+
+	// Reopen the freezer and ensure everything is still there
+	if f, err = lemodb.NewFreezer(dir, "", false); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer f.Close()
+	checkTestAncients(t, f, 64)
+}
+
+// Tests that truncating the freezer discards the items above the limit and that
+// new items can be appended afterwards.
+func TestFreezerTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := lemodb.NewFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	defer f.Close()
+
+	appendTestAncients(t, f, 0, 32)
+	if err := f.TruncateAncients(10); err != nil {
+		t.Fatalf("failed to truncate freezer: %v", err)
+	}
+	checkTestAncients(t, f, 10)
+
+	appendTestAncients(t, f, 10, 20)
+	checkTestAncients(t, f, 20)
+}
+
+// Tests that a freezer whose tables went out of sync (e.g. crash during an
+// append) is repaired to the last complete item when opened.
+func TestFreezerRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := lemodb.NewFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	appendTestAncients(t, f, 0, 16)
+	f.Close()
+
+	// Chop a few bytes off the end of the bodies, simulating a partial write
+	data := filepath.Join(dir, lemodb.AncientBodies+".dat")
+	stat, err := os.Stat(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(data, stat.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = lemodb.NewFreezer(dir, "", false); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer f.Close()
+	checkTestAncients(t, f, 15)
+}
+
+// Tests that a read-only freezer never modifies its files, hiding partial writes
+// instead of repairing them, and rejects any modification.
+func TestFreezerReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Read-only freezers must not be created from scratch
+	if _, err := lemodb.NewFreezer(filepath.Join(dir, "missing"), "", true); err == nil {
+		t.Fatalf("opened missing freezer in read-only mode")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("read-only open created the freezer directory: %v", err)
+	}
+	f, err := lemodb.NewFreezer(dir, "", false)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	appendTestAncients(t, f, 0, 16)
+	f.Close()
+
+	// Chop a few bytes off the end of the bodies, simulating a partial write
+	data := filepath.Join(dir, lemodb.AncientBodies+".dat")
+	stat, err := os.Stat(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(data, stat.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	index := filepath.Join(dir, lemodb.AncientHeaders+".idx")
+	before, err := os.Stat(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err = lemodb.NewFreezer(dir, "", true); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer f.Close()
+	checkTestAncients(t, f, 15)
+
+	if err := f.AppendAncient(15, nil, nil, nil, nil, nil); err == nil {
+		t.Fatalf("appended to read-only freezer")
+	}
+	if err := f.TruncateAncients(10); err == nil {
+		t.Fatalf("truncated read-only freezer")
+	}
+	checkTestAncients(t, f, 15)
+
+	if after, err := os.Stat(index); err != nil || after.Size() != before.Size() {
+		t.Fatalf("read-only freezer modified the index: have %v, want %d", after, before.Size())
+	}
+}
@@ -18,6 +18,9 @@ package lemodb
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/LemoFoundationLtd/lemochain-go/common"
@@ -88,6 +91,63 @@ func (db *MemDatabase) Delete(key []byte) error {
 	return nil
 }
 
+// NewIterator creates an iterator over the entire content of the database. The
+// iterator works on a snapshot of the data taken at creation time.
+func (db *MemDatabase) NewIterator() Iterator {
+	return db.newIterator(nil, nil)
+}
+
+func (db *MemDatabase) NewIteratorWithStart(start []byte) Iterator {
+	return db.newIterator(start, nil)
+}
+
+func (db *MemDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return db.newIterator(nil, prefix)
+}
+
+func (db *MemDatabase) newIterator(start []byte, prefix []byte) Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var (
+		pr     = string(prefix)
+		st     = string(start)
+		keys   = make([]string, 0, len(db.db))
+		values = make([][]byte, 0, len(db.db))
+	)
+	for key := range db.db {
+		if strings.HasPrefix(key, pr) && key >= st {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values = append(values, db.db[key])
+	}
+	return &memIterator{keys: keys, values: values, index: -1}
+}
+
+// Stat returns a particular internal stat of the database. The only supported
+// property is "memdb.stats", reporting the number and size of the entries.
+func (db *MemDatabase) Stat(property string) (string, error) {
+	if property != "memdb.stats" {
+		return "", errors.New("unknown property")
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var size int
+	for key, value := range db.db {
+		size += len(key) + len(value)
+	}
+	return fmt.Sprintf("Entries: %d, Size: %d bytes", len(db.db), size), nil
+}
+
+// Compact is a no-op, there is nothing to flatten in a memory database.
+func (db *MemDatabase) Compact(start []byte, limit []byte) error {
+	return nil
+}
+
 func (db *MemDatabase) Close() {}
 
 func (db *MemDatabase) NewBatch() Batch {
@@ -96,7 +156,10 @@ func (db *MemDatabase) NewBatch() Batch {
 
 func (db *MemDatabase) Len() int { return len(db.db) }
 
-type kv struct{ k, v []byte }
+type kv struct {
+	k, v []byte
+	del  bool
+}
 
 type memBatch struct {
 	db     *MemDatabase
@@ -105,16 +168,26 @@ type memBatch struct {
 }
 
 func (b *memBatch) Put(key, value []byte) error {
-	b.writes = append(b.writes, kv{common.CopyBytes(key), common.CopyBytes(value)})
+	b.writes = append(b.writes, kv{common.CopyBytes(key), common.CopyBytes(value), false})
 	b.size += len(value)
 	return nil
 }
 
+func (b *memBatch) Delete(key []byte) error {
+	b.writes = append(b.writes, kv{common.CopyBytes(key), nil, true})
+	b.size += 1
+	return nil
+}
+
 func (b *memBatch) Write() error {
 	b.db.lock.Lock()
 	defer b.db.lock.Unlock()
 
 	for _, kv := range b.writes {
+		if kv.del {
+			delete(b.db.db, string(kv.k))
+			continue
+		}
 		b.db.db[string(kv.k)] = kv.v
 	}
 	return nil
@@ -128,3 +201,40 @@ func (b *memBatch) Reset() {
 	b.writes = b.writes[:0]
 	b.size = 0
 }
+
+// memIterator iterates over a sorted snapshot of the memory database content.
+type memIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+}
+
+func (it *memIterator) Next() bool {
+	if it.index >= len(it.keys) {
+		return false
+	}
+	it.index++
+	return it.index < len(it.keys)
+}
+
+func (it *memIterator) Error() error {
+	return nil
+}
+
+func (it *memIterator) Key() []byte {
+	if it.index < 0 || it.index >= len(it.keys) {
+		return nil
+	}
+	return []byte(it.keys[it.index])
+}
+
+func (it *memIterator) Value() []byte {
+	if it.index < 0 || it.index >= len(it.keys) {
+		return nil
+	}
+	return it.values[it.index]
+}
+
+func (it *memIterator) Release() {
+	it.keys, it.values, it.index = nil, nil, 0
+}
@@ -18,7 +18,9 @@ package les
 
 import (
 	"context"
+	"errors"
 	"math/big"
+	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go/accounts"
 	"github.com/LemoFoundationLtd/lemochain-go/common"
@@ -50,6 +52,10 @@ func (b *LesApiBackend) CurrentBlock() *types.Block {
 	return types.NewBlockWithHeader(b.lemo.BlockChain().CurrentHeader())
 }
 
+func (b *LesApiBackend) RPCEVMTimeout() time.Duration {
+	return b.lemo.config.RPCEVMTimeout
+}
+
 func (b *LesApiBackend) SetHead(number uint64) {
 	b.lemo.protocolManager.downloader.Cancel()
 	b.lemo.blockchain.SetHead(number)
@@ -95,6 +101,10 @@ func (b *LesApiBackend) GetTd(blockHash common.Hash) *big.Int {
 	return b.lemo.blockchain.GetTdByHash(blockHash)
 }
 
+func (b *LesApiBackend) GetAddressTransactions(ctx context.Context, address common.Address, from, to, index uint64, limit int) ([]core.AddressTxEntry, error) {
+	return nil, errors.New("address transaction index not available in light mode")
+}
+
 func (b *LesApiBackend) GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header, vmCfg vm.Config) (*vm.EVM, func() error, error) {
 	state.SetBalance(msg.From(), math.MaxBig256)
 	context := core.NewEVMContext(msg, header, b.lemo.blockchain, nil)
@@ -149,6 +159,10 @@ func (b *LesApiBackend) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscri
 	return b.lemo.blockchain.SubscribeLogsEvent(ch)
 }
 
+func (b *LesApiBackend) SubscribeChainReorgEvent(ch chan<- core.ChainReorgEvent) event.Subscription {
+	return b.lemo.blockchain.SubscribeChainReorgEvent(ch)
+}
+
 func (b *LesApiBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
 	return b.lemo.blockchain.SubscribeRemovedLogsEvent(ch)
 }
@@ -80,7 +80,7 @@ type LightLemochain struct {
 }
 
 func New(ctx *node.ServiceContext, config *lemo.Config) (*LightLemochain, error) {
-	chainDb, err := lemo.CreateDB(ctx, config, "lightchaindata")
+	chainDb, err := lemo.CreateDB(ctx, config, "lightchaindata", "")
 	if err != nil {
 		return nil, err
 	}
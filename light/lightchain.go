@@ -494,6 +494,12 @@ func (self *LightChain) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscri
 	return self.scope.Track(new(event.Feed).Subscribe(ch))
 }
 
+// SubscribeChainReorgEvent implements the interface of filters.Backend
+// LightChain does not send core.ChainReorgEvent, so return an empty subscription.
+func (self *LightChain) SubscribeChainReorgEvent(ch chan<- core.ChainReorgEvent) event.Subscription {
+	return self.scope.Track(new(event.Feed).Subscribe(ch))
+}
+
 // SubscribeRemovedLogsEvent implements the interface of filters.Backend
 // LightChain does not send core.RemovedLogsEvent, so return an empty subscription.
 func (self *LightChain) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
@@ -271,7 +271,7 @@ func (self *worker) update() {
 				self.currentMu.Unlock()
 			} else {
 				// If we're mining, but nothing is being processed, wake on new transactions
-				if self.config.Clique != nil && self.config.Clique.Period == 0 {
+				if (self.config.Clique != nil && self.config.Clique.Period == 0) || (self.config.Dpovp != nil && self.config.Dpovp.Period == 0) {
 					self.commitNewWork()
 				}
 			}
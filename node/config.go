@@ -30,6 +30,7 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/accounts/usbwallet"
 	"github.com/LemoFoundationLtd/lemochain-go/common"
 	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
 	"github.com/LemoFoundationLtd/lemochain-go/log"
 	"github.com/LemoFoundationLtd/lemochain-go/p2p"
 	"github.com/LemoFoundationLtd/lemochain-go/p2p/discover"
@@ -66,6 +67,15 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// DBEngine is the key-value store backing the databases of the node. If empty,
+	// existing databases are opened with the engine that created them and new ones
+	// with the default engine.
+	DBEngine string `toml:",omitempty"`
+
+	// DBReadOnly opens all the databases of the node without write access, e.g. to
+	// serve an analytics replica. The databases must already exist.
+	DBReadOnly bool `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -262,6 +272,12 @@ var isOldGlemoResource = map[string]bool{
 	"trusted-nodes.json": true,
 }
 
+// databaseOptions assembles the engine independent settings to open the
+// databases of the node with.
+func (c *Config) databaseOptions(cache int, handles int) lemodb.Options {
+	return lemodb.Options{Cache: cache, Handles: handles, ReadOnly: c.DBReadOnly}
+}
+
 // resolvePath resolves path in the instance directory.
 func (c *Config) resolvePath(path string) string {
 	if filepath.IsAbs(path) {
@@ -645,7 +645,18 @@ func (n *Node) OpenDatabase(name string, cache, handles int) (lemodb.Database, e
 	if n.config.DataDir == "" {
 		return lemodb.NewMemDatabase()
 	}
-	return lemodb.NewLDBDatabase(n.config.resolvePath(name), cache, handles)
+	return lemodb.Open(n.config.DBEngine, n.config.resolvePath(name), n.config.databaseOptions(cache, handles))
+}
+
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching an ancient store to it. If the node is an ephemeral one, a memory
+// database without an ancient store is returned.
+func (n *Node) OpenDatabaseWithFreezer(name string, cache, handles int, freezer string, namespace string) (lemodb.Database, error) {
+	if n.config.DataDir == "" {
+		return lemodb.NewMemDatabase()
+	}
+	return lemodb.OpenWithFreezer(n.config.DBEngine, n.config.resolvePath(name), n.config.databaseOptions(cache, handles), n.config.resolvePath(freezer), namespace)
 }
 
 // ResolvePath returns the absolute path of a resource in the instance directory.
@@ -43,7 +43,24 @@ func (ctx *ServiceContext) OpenDatabase(name string, cache int, handles int) (le
 	if ctx.config.DataDir == "" {
 		return lemodb.NewMemDatabase()
 	}
-	db, err := lemodb.NewLDBDatabase(ctx.config.resolvePath(name), cache, handles)
+	db, err := lemodb.Open(ctx.config.DBEngine, ctx.config.resolvePath(name), ctx.config.databaseOptions(cache, handles))
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching an ancient store to it. A relative freezer path is resolved into
+// the node's data directory, an absolute one allows placing the ancient store on
+// a different disk. If the node is an ephemeral one, a memory database without
+// an ancient store is returned.
+func (ctx *ServiceContext) OpenDatabaseWithFreezer(name string, cache int, handles int, freezer string, namespace string) (lemodb.Database, error) {
+	if ctx.config.DataDir == "" {
+		return lemodb.NewMemDatabase()
+	}
+	db, err := lemodb.OpenWithFreezer(ctx.config.DBEngine, ctx.config.resolvePath(name), ctx.config.databaseOptions(cache, handles), ctx.config.resolvePath(freezer), namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -57,6 +74,12 @@ func (ctx *ServiceContext) ResolvePath(path string) string {
 	return ctx.config.resolvePath(path)
 }
 
+// DBReadOnly reports whether the databases of the node are opened without write
+// access.
+func (ctx *ServiceContext) DBReadOnly() bool {
+	return ctx.config.DBReadOnly
+}
+
 // Service retrieves a currently running service registered of a specific type.
 func (ctx *ServiceContext) Service(service interface{}) error {
 	element := reflect.ValueOf(service).Elem()
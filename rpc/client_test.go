@@ -49,6 +49,33 @@ func TestClientRequest(t *testing.T) {
 	}
 }
 
+// Tests that the code and data of errors returned by callbacks reach the client.
+func TestClientErrorData(t *testing.T) {
+	server := newTestServer("service", new(Service))
+	defer server.Stop()
+	client := DialInProc(server)
+	defer client.Close()
+
+	var resp interface{}
+	err := client.Call(&resp, "service_returnError")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if err.Error() != "test error" {
+		t.Errorf("wrong error message %q", err.Error())
+	}
+	if code := err.(Error).ErrorCode(); code != 444 {
+		t.Errorf("wrong error code %d", code)
+	}
+	derr, ok := err.(DataError)
+	if !ok {
+		t.Fatalf("error does not implement DataError: %T", err)
+	}
+	if data := derr.ErrorData(); data != "test data" {
+		t.Errorf("wrong error data %#v", data)
+	}
+}
+
 func TestClientBatchRequest(t *testing.T) {
 	server := newTestServer("service", new(Service))
 	defer server.Stop()
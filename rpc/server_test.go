@@ -59,6 +59,17 @@ func (s *Service) Rets() (string, error) {
 	return "", nil
 }
 
+// testError is an error carrying a custom code and extra data.
+type testError struct{}
+
+func (e *testError) Error() string          { return "test error" }
+func (e *testError) ErrorCode() int         { return 444 }
+func (e *testError) ErrorData() interface{} { return "test data" }
+
+func (s *Service) ReturnError() (string, error) {
+	return "", &testError{}
+}
+
 func (s *Service) InvalidRets1() (error, string) {
 	return nil, ""
 }
@@ -92,8 +103,8 @@ func TestServerRegisterName(t *testing.T) {
 		t.Fatalf("Expected service calc to be registered")
 	}
 
-	if len(svc.callbacks) != 5 {
-		t.Errorf("Expected 5 callbacks for service 'calc', got %d", len(svc.callbacks))
+	if len(svc.callbacks) != 6 {
+		t.Errorf("Expected 6 callbacks for service 'calc', got %d", len(svc.callbacks))
 	}
 
 	if len(svc.subscriptions) != 1 {
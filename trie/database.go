@@ -18,11 +18,21 @@ package trie
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/LemoFoundationLtd/lemochain-go/common"
 	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
 	"github.com/LemoFoundationLtd/lemochain-go/log"
+	"github.com/LemoFoundationLtd/lemochain-go/metrics"
+	"github.com/hashicorp/golang-lru"
+)
+
+var (
+	memcacheCleanHitMeter   = metrics.NewRegisteredMeter("trie/memcache/clean/hit", nil)
+	memcacheCleanMissMeter  = metrics.NewRegisteredMeter("trie/memcache/clean/miss", nil)
+	memcacheCleanReadMeter  = metrics.NewRegisteredMeter("trie/memcache/clean/read", nil)
+	memcacheCleanWriteMeter = metrics.NewRegisteredMeter("trie/memcache/clean/write", nil)
 )
 
 // secureKeyPrefix is the database key prefix used to store trie node preimages.
@@ -46,6 +56,10 @@ type DatabaseReader interface {
 type Database struct {
 	diskdb lemodb.Database // Persistent storage for matured trie nodes
 
+	cleans      *lru.Cache // LRU cache of clean nodes read from or written to disk
+	cleansSize  int64      // Storage size of the clean cache (accessed atomically)
+	cleansLimit int64      // Memory allowance of the clean cache
+
 	nodes     map[common.Hash]*cachedNode // Data and references relationships of a node
 	preimages map[common.Hash][]byte      // Preimages of nodes from the secure trie
 	seckeybuf [secureKeyLength]byte       // Ephemeral buffer for calculating preimage keys
@@ -69,15 +83,48 @@ type cachedNode struct {
 }
 
 // NewDatabase creates a new trie database to store ephemeral trie content before
-// its written out to disk or garbage collected.
+// its written out to disk or garbage collected. No read cache is created, so all
+// data retrievals will hit the underlying disk database.
 func NewDatabase(diskdb lemodb.Database) *Database {
-	return &Database{
+	return NewDatabaseWithCache(diskdb, 0)
+}
+
+// NewDatabaseWithCache creates a new trie database to store ephemeral trie content
+// before its written out to disk or garbage collected. It also acts as a read cache
+// for nodes loaded from disk, using at most cache megabytes of memory.
+func NewDatabaseWithCache(diskdb lemodb.Database, cache int) *Database {
+	db := &Database{
 		diskdb: diskdb,
 		nodes: map[common.Hash]*cachedNode{
 			{}: {children: make(map[common.Hash]int)},
 		},
 		preimages: make(map[common.Hash][]byte),
 	}
+	if cache > 0 {
+		db.cleansLimit = int64(cache) * 1024 * 1024
+		db.cleans, _ = lru.NewWithEvict(int(db.cleansLimit/common.HashLength), func(key, value interface{}) {
+			atomic.AddInt64(&db.cleansSize, -int64(common.HashLength+len(value.([]byte))))
+		})
+	}
+	return db
+}
+
+// cacheClean inserts a node loaded from or flushed to disk into the clean cache,
+// evicting the least recently used ones if the memory allowance is exceeded.
+func (db *Database) cacheClean(hash common.Hash, blob []byte) {
+	if db.cleans == nil {
+		return
+	}
+	if ok, _ := db.cleans.ContainsOrAdd(hash, blob); ok {
+		return
+	}
+	memcacheCleanWriteMeter.Mark(int64(len(blob)))
+
+	size := atomic.AddInt64(&db.cleansSize, int64(common.HashLength+len(blob)))
+	for size > db.cleansLimit && db.cleans.Len() > 0 {
+		db.cleans.RemoveOldest()
+		size = atomic.LoadInt64(&db.cleansSize)
+	}
 }
 
 // DiskDB retrieves the persistent storage backing the trie database.
@@ -129,8 +176,21 @@ func (db *Database) Node(hash common.Hash) ([]byte, error) {
 	if node != nil {
 		return node.blob, nil
 	}
+	// Retrieve the node from the clean cache if available
+	if db.cleans != nil {
+		if blob, ok := db.cleans.Get(hash); ok {
+			memcacheCleanHitMeter.Mark(1)
+			memcacheCleanReadMeter.Mark(int64(len(blob.([]byte))))
+			return blob.([]byte), nil
+		}
+		memcacheCleanMissMeter.Mark(1)
+	}
 	// Content unavailable in memory, attempt to retrieve from disk
-	return db.diskdb.Get(hash[:])
+	blob, err := db.diskdb.Get(hash[:])
+	if err == nil && len(blob) > 0 {
+		db.cacheClean(hash, blob)
+	}
+	return blob, err
 }
 
 // preimage retrieves a cached trie node pre-image from memory. If it cannot be
@@ -343,6 +403,9 @@ func (db *Database) uncache(hash common.Hash) {
 	}
 	delete(db.nodes, hash)
 	db.nodesSize -= common.StorageSize(common.HashLength + len(node.blob))
+
+	// The node was just persisted, keep it around as a clean one
+	db.cacheClean(hash, node.blob)
 }
 
 // Size returns the current storage size of the memory cache in front of the
@@ -0,0 +1,80 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+)
+
+// Tests that nodes flushed to or loaded from disk are retained in the clean cache
+// and served from memory afterwards.
+func TestDatabaseCleanCache(t *testing.T) {
+	diskdb, _ := lemodb.NewMemDatabase()
+
+	// Create a trie and flush it to disk through a caching database
+	triedb := NewDatabaseWithCache(diskdb, 1)
+	trie, _ := New(common.Hash{}, triedb)
+	for i := 0; i < 64; i++ {
+		updateString(trie, fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d-qwerqwerqwerqwerqwerqwer", i))
+	}
+	root, _ := trie.Commit(nil)
+	if err := triedb.Commit(root, false); err != nil {
+		t.Fatalf("failed to flush trie: %v", err)
+	}
+	// Load the trie through a second caching database to pull the nodes from disk
+	loaddb := NewDatabaseWithCache(diskdb, 1)
+	if err := checkCachedTrie(loaddb, root, 64); err != nil {
+		t.Fatalf("failed to load trie from disk: %v", err)
+	}
+	// Wipe the disk, all the nodes must be served from memory
+	for _, key := range diskdb.Keys() {
+		diskdb.Delete(key)
+	}
+	if err := checkCachedTrie(triedb, root, 64); err != nil {
+		t.Errorf("flushed nodes not cached: %v", err)
+	}
+	if err := checkCachedTrie(loaddb, root, 64); err != nil {
+		t.Errorf("loaded nodes not cached: %v", err)
+	}
+	if err := checkCachedTrie(NewDatabase(diskdb), root, 64); err == nil {
+		t.Errorf("non-caching database served wiped nodes")
+	}
+}
+
+// checkCachedTrie opens a fresh trie at root and retrieves all the entries
+// inserted by TestDatabaseCleanCache.
+func checkCachedTrie(triedb *Database, root common.Hash, n int) error {
+	trie, err := New(root, triedb)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		want := fmt.Sprintf("value-%d-qwerqwerqwerqwerqwerqwer", i)
+		have, err := trie.TryGet([]byte(fmt.Sprintf("key-%d", i)))
+		if err != nil {
+			return err
+		}
+		if string(have) != want {
+			return fmt.Errorf("entry %d: value mismatch: have %q, want %q", i, have, want)
+		}
+	}
+	return nil
+}
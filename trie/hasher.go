@@ -26,12 +26,30 @@ import (
 	"github.com/LemoFoundationLtd/lemochain-go/rlp"
 )
 
+// parallelHashThreshold is the estimated number of changed nodes in a subtrie
+// above which the children of its topmost full node are hashed concurrently.
+const parallelHashThreshold = 100
+
+// parallelHashHook, if set, is called whenever the children of a full node are
+// hashed concurrently. It's used by tests.
+var parallelHashHook func()
+
 type hasher struct {
 	tmp        *bytes.Buffer
 	sha        hash.Hash
 	cachegen   uint16
 	cachelimit uint16
 	onleaf     LeafCallback
+	unhashed   int    // Estimated number of nodes to hash or store in the subtrie being hashed
+	deferred   bool   // Whether leaf callbacks are collected instead of being run
+	leaves     []leaf // Leaf callbacks collected while hashing on a separate goroutine
+}
+
+// leaf is a leaf callback deferred until the concurrent hashing of a subtrie is
+// joined, so that callbacks are always run in order from a single goroutine.
+type leaf struct {
+	blob   []byte
+	parent common.Hash
 }
 
 // hashers live in a global db.
@@ -44,6 +62,7 @@ var hasherPool = sync.Pool{
 func newHasher(cachegen, cachelimit uint16, onleaf LeafCallback) *hasher {
 	h := hasherPool.Get().(*hasher)
 	h.cachegen, h.cachelimit, h.onleaf = cachegen, cachelimit, onleaf
+	h.unhashed, h.deferred, h.leaves = 0, false, h.leaves[:0]
 	return h
 }
 
@@ -125,6 +144,22 @@ func (h *hasher) hashChildren(original node, db *Database) (node, node, error) {
 		// Hash the full node's children, caching the newly hashed subtrees
 		collapsed, cached := n.copy(), n.copy()
 
+		if h.unhashed >= parallelHashThreshold {
+			// Spread the work across goroutines if it's shared by multiple children.
+			// Otherwise the only changed child inherits the whole estimate.
+			work := 0
+			for i := 0; i < 16; i++ {
+				if needsHashing(n.Children[i], db) {
+					work++
+				}
+			}
+			if work > 1 {
+				if err := h.hashChildrenParallel(n, collapsed, cached, db, work); err != nil {
+					return original, original, err
+				}
+				return collapsed, cached, nil
+			}
+		}
 		for i := 0; i < 16; i++ {
 			if n.Children[i] != nil {
 				collapsed.Children[i], cached.Children[i], err = h.hash(n.Children[i], db, false)
@@ -147,6 +182,76 @@ func (h *hasher) hashChildren(original node, db *Database) (node, node, error) {
 	}
 }
 
+// needsHashing reports whether a child node has to be hashed, or in commit mode
+// stored, as opposed to having its result cached already.
+func needsHashing(n node, db *Database) bool {
+	switch n := n.(type) {
+	case *shortNode, *fullNode:
+		hash, dirty := n.cache()
+		return hash == nil || (db != nil && dirty)
+	default:
+		return false
+	}
+}
+
+// hashChildrenParallel hashes the children of a full node, the changed ones each
+// on its own goroutine with its own hasher, filling in the collapsed and cached
+// copies of the node. The estimated work is split evenly among the changed
+// children, so large subtries fan out further down. Every child subtrie is hashed
+// exactly as it would be sequentially, so the resulting hashes are identical, and
+// the leaf callbacks collected by the goroutines are run in the sequential order
+// after joining them.
+func (h *hasher) hashChildrenParallel(n, collapsed, cached *fullNode, db *Database, work int) error {
+	if parallelHashHook != nil {
+		parallelHashHook()
+	}
+	var (
+		wg      sync.WaitGroup
+		hashers [16]*hasher
+		errs    [16]error
+	)
+	for i := 0; i < 16; i++ {
+		if n.Children[i] == nil {
+			collapsed.Children[i] = valueNode(nil) // Ensure that nil children are encoded as empty strings.
+			continue
+		}
+		if !needsHashing(n.Children[i], db) {
+			collapsed.Children[i], cached.Children[i], errs[i] = h.hash(n.Children[i], db, false)
+			continue
+		}
+		hashers[i] = newHasher(h.cachegen, h.cachelimit, h.onleaf)
+		hashers[i].unhashed, hashers[i].deferred = h.unhashed/work, h.onleaf != nil
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			collapsed.Children[i], cached.Children[i], errs[i] = hashers[i].hash(n.Children[i], db, false)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, hasher := range hashers {
+		if hasher == nil {
+			continue
+		}
+		for _, leaf := range hasher.leaves {
+			h.leaf(leaf.blob, leaf.parent)
+		}
+		returnHasherToPool(hasher)
+		hashers[i] = nil
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	cached.Children[16] = n.Children[16]
+	if collapsed.Children[16] == nil {
+		collapsed.Children[16] = valueNode(nil)
+	}
+	return nil
+}
+
 // store hashes the node n and if we have a storage layer specified, it writes
 // the key/value pair to it and tracks any node->child references as well as any
 // node->external trie references.
@@ -197,12 +302,12 @@ func (h *hasher) store(n node, db *Database, force bool) (node, error) {
 			switch n := n.(type) {
 			case *shortNode:
 				if child, ok := n.Val.(valueNode); ok {
-					h.onleaf(child, hash)
+					h.leaf(child, hash)
 				}
 			case *fullNode:
 				for i := 0; i < 16; i++ {
 					if child, ok := n.Children[i].(valueNode); ok {
-						h.onleaf(child, hash)
+						h.leaf(child, hash)
 					}
 				}
 			}
@@ -210,3 +315,13 @@ func (h *hasher) store(n node, db *Database, force bool) (node, error) {
 	}
 	return hash, nil
 }
+
+// leaf runs the leaf callback for a value referenced by a stored node, or defers
+// it if the hasher is working on a separate goroutine.
+func (h *hasher) leaf(blob []byte, parent common.Hash) {
+	if h.deferred {
+		h.leaves = append(h.leaves, leaf{blob, parent})
+		return
+	}
+	h.onleaf(blob, parent)
+}
@@ -0,0 +1,273 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+	"github.com/LemoFoundationLtd/lemochain-go/log"
+)
+
+var (
+	// ErrUnsortedKey is returned if a key is inserted into a stack trie out of order.
+	ErrUnsortedKey = errors.New("stack trie keys must be inserted in ascending order")
+
+	// ErrPrefixKey is returned if a key inserted into a stack trie is prefixed by
+	// the previous one. Values are only stored in leaves.
+	ErrPrefixKey = errors.New("stack trie keys must not be prefixes of each other")
+)
+
+// StackTrie is a trie implementation that expects its keys to be inserted in
+// ascending order, none of them being the prefix of another (such as hashes or
+// RLP encoded integers). Since no key may come before the last inserted one, every
+// subtrie to the left of the insertion path is final and is collapsed into its
+// hash (or its encoding, if shorter than a hash) as soon as the path moves past
+// it. Only the nodes along the current path are kept in memory, so the root of
+// an arbitrary number of entries is computed in memory proportional to the depth
+// of the trie, without any database.
+//
+// The produced root is identical to the one of a Trie holding the same entries.
+type StackTrie struct {
+	db   lemodb.Putter // Optional database the hashed nodes are written into
+	root *stNode       // Node at the root of the trie
+	last []byte        // Last inserted key, to enforce the insertion order
+	err  error         // First database write error encountered
+}
+
+// Kinds of stack trie nodes.
+const (
+	emptyNode  = iota // Node without any content yet
+	branchNode        // Node with up to 16 children
+	extNode           // Node with a shared key segment and a single branch child
+	leafNode          // Node with the remaining key segment and a value
+	hashedNode        // Final node collapsed into its hash or embedded encoding
+)
+
+// stNode is a single node of a stack trie.
+type stNode struct {
+	kind     int
+	key      []byte      // Key segment of extension and leaf nodes, hex encoded without terminator
+	val      []byte      // Value of leaf nodes
+	offset   int         // Number of key nibbles consumed by the ancestors of the node
+	children [16]*stNode // Children of branch nodes, the branch of extension nodes is the first one
+	hashed   node        // Collapsed form of hashed nodes, either a hashNode or an embedded node
+}
+
+// NewStackTrie creates an empty stack trie. If a database is given, every node
+// which is referenced by hash is written into it as soon as it's final.
+func NewStackTrie(db lemodb.Putter) *StackTrie {
+	return &StackTrie{db: db, root: new(stNode)}
+}
+
+// Reset empties the trie so that it can be reused.
+func (t *StackTrie) Reset() {
+	t.root, t.last, t.err = new(stNode), nil, nil
+}
+
+// Update inserts the given key into the trie. Keys must be inserted in strictly
+// ascending order. Empty values are ignored, as the trie doesn't hold them.
+func (t *StackTrie) Update(key, value []byte) {
+	if err := t.TryUpdate(key, value); err != nil {
+		log.Error(fmt.Sprintf("Unhandled trie error: %v", err))
+	}
+}
+
+// TryUpdate inserts the given key into the trie. Keys must be inserted in
+// strictly ascending order, otherwise ErrUnsortedKey is returned, and must not be
+// prefixed by the previous key, otherwise ErrPrefixKey is returned. Empty values
+// are ignored, as the trie doesn't hold them.
+//
+// The value bytes must not be modified by the caller until the trie is hashed.
+func (t *StackTrie) TryUpdate(key, value []byte) error {
+	if t.err != nil {
+		return t.err
+	}
+	if t.last != nil && bytes.Compare(key, t.last) <= 0 {
+		return ErrUnsortedKey
+	}
+	if t.last != nil && bytes.HasPrefix(key, t.last) {
+		return ErrPrefixKey
+	}
+	if len(value) == 0 {
+		return nil
+	}
+	t.last = common.CopyBytes(key)
+
+	k := keybytesToHex(key)
+	t.root.insert(t, k[:len(k)-1], value)
+	return t.err
+}
+
+// Hash returns the root hash of the trie. No more keys may be inserted after it
+// has been called, unless the trie is Reset.
+func (t *StackTrie) Hash() common.Hash {
+	hash, _ := t.hashRoot()
+	return hash
+}
+
+// Commit hashes the trie, writing all remaining nodes and the root node into the
+// database of the trie. No more keys may be inserted after it has been called,
+// unless the trie is Reset.
+func (t *StackTrie) Commit() (common.Hash, error) {
+	if t.db == nil {
+		panic("commit called on stack trie with nil database")
+	}
+	return t.hashRoot()
+}
+
+// hashRoot collapses the whole trie and hashes its root node, which is written
+// into the database even if its encoding is shorter than a hash.
+func (t *StackTrie) hashRoot() (common.Hash, error) {
+	if t.root.kind == emptyNode {
+		return emptyRoot, nil
+	}
+	if t.root.kind != hashedNode {
+		t.root.hash(t, true)
+	}
+	if t.err != nil {
+		return common.Hash{}, t.err
+	}
+	return common.BytesToHash(t.root.hashed.(hashNode)), nil
+}
+
+// insert adds the given hex key, without terminator, into the subtrie rooted at
+// the node. The nibbles before the offset of the node are already consumed.
+func (n *stNode) insert(t *StackTrie, key, value []byte) {
+	switch n.kind {
+	case emptyNode:
+		n.kind, n.key, n.val = leafNode, common.CopyBytes(key[n.offset:]), value
+
+	case branchNode:
+		idx := key[n.offset]
+
+		// The left siblings of the insertion path are final, collapse them
+		for i := int(idx) - 1; i >= 0; i-- {
+			if n.children[i] != nil {
+				if n.children[i].kind != hashedNode {
+					n.children[i].hash(t, false)
+				}
+				break
+			}
+		}
+		if n.children[idx] == nil {
+			n.children[idx] = &stNode{offset: n.offset + 1}
+		}
+		n.children[idx].insert(t, key, value)
+
+	case extNode:
+		diff := prefixLen(n.key, key[n.offset:])
+		if diff == len(n.key) {
+			n.children[0].insert(t, key, value)
+			return
+		}
+		// The key diverges within the extension, everything below the split
+		// point is final. Reuse the branch child if the split is on the last
+		// nibble, otherwise keep the rest of the extension above it.
+		orig := n.children[0]
+		if diff < len(n.key)-1 {
+			orig = &stNode{kind: extNode, key: n.key[diff+1:], offset: n.offset + diff + 1}
+			orig.children[0] = n.children[0]
+		}
+		orig.hash(t, false)
+
+		branch := n.split(diff)
+		branch.children[n.key[diff]] = orig
+		branch.children[key[n.offset+diff]] = &stNode{kind: leafNode, key: common.CopyBytes(key[n.offset+diff+1:]), val: value, offset: n.offset + diff + 1}
+		n.key = n.key[:diff]
+
+	case leafNode:
+		diff := prefixLen(n.key, key[n.offset:])
+		if diff == len(n.key) {
+			panic("stack trie key prefixed by a leaf") // Prevented by the order check
+		}
+		// Split the leaf into a branch at the first differing nibble, with an
+		// extension above it for the shared prefix. The old leaf is final.
+		orig := &stNode{kind: leafNode, key: n.key[diff+1:], val: n.val, offset: n.offset + diff + 1}
+		orig.hash(t, false)
+
+		origIdx := n.key[diff]
+		branch := n.split(diff)
+		branch.children[origIdx] = orig
+		branch.children[key[n.offset+diff]] = &stNode{kind: leafNode, key: common.CopyBytes(key[n.offset+diff+1:]), val: value, offset: n.offset + diff + 1}
+		n.key, n.val = n.key[:diff], nil
+
+	case hashedNode:
+		panic("stack trie insert into hashed node")
+	}
+}
+
+// split turns an extension or leaf node into the parent of a branch node at the
+// given key position: the node itself if there's no shared prefix, or a new one
+// hanging off the node turned into an extension otherwise. The branch is returned.
+func (n *stNode) split(diff int) *stNode {
+	if diff == 0 {
+		n.kind, n.children = branchNode, [16]*stNode{}
+		return n
+	}
+	branch := &stNode{kind: branchNode, offset: n.offset + diff}
+	n.kind, n.children = extNode, [16]*stNode{branch}
+	return n.children[0]
+}
+
+// hash collapses the subtrie rooted at the node, turning the node into a hashed
+// node and dropping its descendants. Nodes encoded in less than 32 bytes are
+// embedded into their parent instead of hashed, unless forced.
+func (n *stNode) hash(t *StackTrie, force bool) {
+	var collapsed node
+	switch n.kind {
+	case branchNode:
+		full := new(fullNode)
+		for i, child := range n.children {
+			if child == nil {
+				full.Children[i] = valueNode(nil)
+				continue
+			}
+			if child.kind != hashedNode {
+				child.hash(t, false)
+			}
+			full.Children[i] = child.hashed
+		}
+		full.Children[16] = valueNode(nil)
+		collapsed = full
+
+	case extNode:
+		child := n.children[0]
+		if child.kind != hashedNode {
+			child.hash(t, false)
+		}
+		collapsed = &shortNode{Key: hexToCompact(n.key), Val: child.hashed}
+
+	case leafNode:
+		collapsed = &shortNode{Key: hexToCompact(append(common.CopyBytes(n.key), 16)), Val: valueNode(n.val)}
+
+	default:
+		panic(fmt.Sprintf("stack trie hash of invalid node kind %d", n.kind))
+	}
+	h := newHasher(0, 0, nil)
+	defer returnHasherToPool(h)
+
+	hashed, _ := h.store(collapsed, nil, force)
+	if hash, ok := hashed.(hashNode); ok && t.db != nil && t.err == nil {
+		t.err = t.db.Put(hash, common.CopyBytes(h.tmp.Bytes()))
+	}
+	n.kind, n.hashed = hashedNode, hashed
+	n.key, n.val, n.children = nil, nil, [16]*stNode{}
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 The lemochain-go Authors
+// This file is part of the lemochain-go library.
+//
+// The lemochain-go library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The lemochain-go library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the lemochain-go library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/LemoFoundationLtd/lemochain-go/common"
+	"github.com/LemoFoundationLtd/lemochain-go/crypto"
+	"github.com/LemoFoundationLtd/lemochain-go/lemodb"
+)
+
+// stackTrieEntry is a key/value pair inserted into the tries under test.
+type stackTrieEntry struct {
+	key, value []byte
+}
+
+// testStackTrieEquivalence inserts the given entries into both a stack trie and
+// a regular one, checking that the roots are identical.
+func testStackTrieEquivalence(t *testing.T, entries []stackTrieEntry) {
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+
+	trie := newEmpty()
+	stack := NewStackTrie(nil)
+	for _, entry := range entries {
+		trie.Update(entry.key, entry.value)
+		if err := stack.TryUpdate(entry.key, entry.value); err != nil {
+			t.Fatalf("failed to insert %x: %v", entry.key, err)
+		}
+	}
+	if have, want := stack.Hash(), trie.Hash(); have != want {
+		t.Fatalf("root mismatch for %d entries: have %x, want %x", len(entries), have, want)
+	}
+}
+
+func TestStackTrieEmpty(t *testing.T) {
+	if root := NewStackTrie(nil).Hash(); root != emptyRoot {
+		t.Errorf("empty root mismatch: have %x, want %x", root, emptyRoot)
+	}
+}
+
+func TestStackTrieHashedKeys(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 16, 17, 100, 1000, 5000} {
+		entries := make([]stackTrieEntry, n)
+		for i := range entries {
+			entries[i].key = crypto.Keccak256(big32(i))
+			entries[i].value = bytes.Repeat([]byte{byte(i)}, 1+i%70)
+		}
+		testStackTrieEquivalence(t, entries)
+	}
+}
+
+// Tests tries with small keys and values, whose nodes are embedded into their
+// parents instead of hashed.
+func TestStackTrieEmbeddedNodes(t *testing.T) {
+	for _, n := range []int{1, 2, 15, 16, 17, 255, 256, 257, 4096} {
+		entries := make([]stackTrieEntry, n)
+		for i := range entries {
+			entries[i].key = big32(i)[2:]
+			entries[i].value = []byte{byte(i % 7)}
+		}
+		testStackTrieEquivalence(t, entries)
+	}
+}
+
+func TestStackTrieRandom(t *testing.T) {
+	src := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		var (
+			keylen  = 1 + src.Intn(8)
+			entries []stackTrieEntry
+			seen    = make(map[string]bool)
+		)
+		for i := src.Intn(300); i >= 0; i-- {
+			key := make([]byte, keylen)
+			src.Read(key)
+			if seen[string(key)] {
+				continue
+			}
+			seen[string(key)] = true
+
+			value := make([]byte, 1+src.Intn(40))
+			src.Read(value)
+			entries = append(entries, stackTrieEntry{key, value})
+		}
+		testStackTrieEquivalence(t, entries)
+	}
+}
+
+func TestStackTrieCommit(t *testing.T) {
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = crypto.Keccak256(big32(i))
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	diskdb, _ := lemodb.NewMemDatabase()
+	stack := NewStackTrie(diskdb)
+	for _, key := range keys {
+		stack.Update(key, key[:8])
+	}
+	root, err := stack.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit stack trie: %v", err)
+	}
+	// The committed nodes must be enough to open and read the trie
+	trie, err := New(root, NewDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("failed to open committed trie: %v", err)
+	}
+	for _, key := range keys {
+		if value := trie.Get(key); !bytes.Equal(value, key[:8]) {
+			t.Fatalf("value %x mismatch: have %x, want %x", key, value, key[:8])
+		}
+	}
+	if hash := trie.Hash(); hash != root {
+		t.Fatalf("root mismatch: have %x, want %x", hash, root)
+	}
+}
+
+func TestStackTrieInsertOrder(t *testing.T) {
+	stack := NewStackTrie(nil)
+	if err := stack.TryUpdate([]byte{0x10, 0x20}, []byte{1}); err != nil {
+		t.Fatalf("failed to insert first key: %v", err)
+	}
+	if err := stack.TryUpdate([]byte{0x10, 0x20}, []byte{2}); err != ErrUnsortedKey {
+		t.Errorf("duplicate key error mismatch: have %v, want %v", err, ErrUnsortedKey)
+	}
+	if err := stack.TryUpdate([]byte{0x10, 0x10}, []byte{2}); err != ErrUnsortedKey {
+		t.Errorf("unsorted key error mismatch: have %v, want %v", err, ErrUnsortedKey)
+	}
+	if err := stack.TryUpdate([]byte{0x10, 0x20, 0x30}, []byte{2}); err != ErrPrefixKey {
+		t.Errorf("prefixed key error mismatch: have %v, want %v", err, ErrPrefixKey)
+	}
+	if err := stack.TryUpdate([]byte{0x10, 0x21}, []byte{2}); err != nil {
+		t.Errorf("failed to insert sorted key: %v", err)
+	}
+}
+
+func BenchmarkStackTrieHash(b *testing.B) {
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = crypto.Keccak256(big32(i))
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stack := NewStackTrie(nil)
+		for _, key := range keys {
+			stack.Update(key, key)
+		}
+		stack.Hash()
+	}
+}
+
+// big32 returns the 4 byte big endian encoding of an integer.
+func big32(i int) []byte {
+	var blob [4]byte
+	binary.BigEndian.PutUint32(blob[:], uint32(i))
+	return common.CopyBytes(blob[:])
+}
@@ -74,6 +74,11 @@ type Trie struct {
 	// new nodes are tagged with the current generation and unloaded
 	// when their generation is older than than cachegen-cachelimit.
 	cachegen, cachelimit uint16
+
+	// unhashed and uncommitted count the nodes created or modified since the
+	// last hashing and commit, deciding whether it's worth spreading the next
+	// one across goroutines.
+	unhashed, uncommitted int
 }
 
 // SetCacheLimit sets the number of 'cache generations' to keep.
@@ -84,6 +89,8 @@ func (t *Trie) SetCacheLimit(l uint16) {
 
 // newFlag returns the cache flag value for a newly created node.
 func (t *Trie) newFlag() nodeFlag {
+	t.unhashed++
+	t.uncommitted++
 	return nodeFlag{dirty: true, gen: t.cachegen}
 }
 
@@ -472,7 +479,22 @@ func (t *Trie) hashRoot(db *Database, onleaf LeafCallback) (node, node, error) {
 	if t.root == nil {
 		return hashNode(emptyRoot.Bytes()), nil, nil
 	}
+	// Subtries are hashed concurrently if enough of them changed. Committing
+	// also stores the nodes hashed since the last commit, so it's estimated by
+	// the changes since then.
 	h := newHasher(t.cachegen, t.cachelimit, onleaf)
+	h.unhashed = t.unhashed
+	if db != nil {
+		h.unhashed = t.uncommitted
+	}
 	defer returnHasherToPool(h)
-	return h.hash(t.root, db, true)
+
+	hashed, cached, err := h.hash(t.root, db, true)
+	if err == nil {
+		t.unhashed = 0
+		if db != nil {
+			t.uncommitted = 0
+		}
+	}
+	return hashed, cached, err
 }
@@ -26,6 +26,7 @@ import (
 	"math/rand"
 	"os"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"testing/quick"
 
@@ -558,11 +559,133 @@ func benchUpdate(b *testing.B, e binary.ByteOrder) *Trie {
 	return trie
 }
 
+// Tests that hashing and committing the children of the root concurrently gives
+// the same root and the same database content as doing it sequentially.
+func TestParallelHash(t *testing.T) {
+	random := rand.New(rand.NewSource(0))
+
+	for _, n := range []int{parallelHashThreshold / 4, parallelHashThreshold, 2000} {
+		var (
+			seqdb, _ = lemodb.NewMemDatabase()
+			pardb, _ = lemodb.NewMemDatabase()
+			seq, _   = New(common.Hash{}, NewDatabase(seqdb))
+			par, _   = New(common.Hash{}, NewDatabase(pardb))
+		)
+		for i := 0; i < n; i++ {
+			key, value := make([]byte, 32), make([]byte, 1+random.Intn(64))
+			random.Read(key)
+			random.Read(value)
+
+			seq.Update(key, value)
+			par.Update(key, value)
+		}
+		seq.unhashed = 0 // Force sequential hashing
+		if seqRoot, parRoot := seq.Hash(), par.Hash(); seqRoot != parRoot {
+			t.Fatalf("%d entries: hash mismatch: sequential %x, parallel %x", n, seqRoot, parRoot)
+		}
+		// Modify some entries and commit the tries without prior hashing
+		for i := 0; i < n/2; i++ {
+			key := make([]byte, 32)
+			random.Read(key)
+
+			seq.Update(key, key)
+			par.Update(key, key)
+		}
+		seq.uncommitted = 0 // Force sequential committing
+		seqRoot, _ := seq.Commit(nil)
+		parRoot, _ := par.Commit(nil)
+		if seqRoot != parRoot {
+			t.Fatalf("%d entries: commit root mismatch: sequential %x, parallel %x", n, seqRoot, parRoot)
+		}
+		seq.db.Commit(seqRoot, false)
+		par.db.Commit(parRoot, false)
+
+		if seqLen, parLen := len(seqdb.Keys()), len(pardb.Keys()); seqLen != parLen {
+			t.Fatalf("%d entries: committed node count mismatch: sequential %d, parallel %d", n, seqLen, parLen)
+		}
+		for _, key := range seqdb.Keys() {
+			want, _ := seqdb.Get(key)
+			if have, _ := pardb.Get(key); !bytes.Equal(have, want) {
+				t.Fatalf("%d entries: node %x mismatch: sequential %x, parallel %x", n, key, want, have)
+			}
+		}
+	}
+}
+
+// Tests that committing a trie after hashing it still stores its nodes concurrently,
+// including leaf callbacks, and that changed subtries deep down are spread across
+// goroutines even if the rest of the trie is unchanged.
+func TestParallelCommit(t *testing.T) {
+	var fanouts int32
+	parallelHashHook = func() { atomic.AddInt32(&fanouts, 1) }
+	defer func() { parallelHashHook = nil }()
+
+	var (
+		random   = rand.New(rand.NewSource(0))
+		seqdb, _ = lemodb.NewMemDatabase()
+		pardb, _ = lemodb.NewMemDatabase()
+		seq, _   = New(common.Hash{}, NewDatabase(seqdb))
+		par, _   = New(common.Hash{}, NewDatabase(pardb))
+	)
+	// commit hashes and then commits a trie, counting the nodes whose children
+	// were hashed concurrently during the commit only
+	commit := func(trie *Trie, sequential bool) (common.Hash, []string, int32) {
+		var leaves []string
+		onleaf := func(leaf []byte, parent common.Hash) error {
+			leaves = append(leaves, fmt.Sprintf("%x:%x", leaf, parent))
+			return nil
+		}
+		trie.Hash()
+		if sequential {
+			trie.uncommitted = 0
+		}
+		atomic.StoreInt32(&fanouts, 0)
+		root, err := trie.Commit(onleaf)
+		if err != nil {
+			t.Fatalf("failed to commit trie: %v", err)
+		}
+		return root, leaves, atomic.LoadInt32(&fanouts)
+	}
+	for i, prefix := range []int{-1, 0} {
+		// Update random keys, all in the same subtrie if a prefix is given
+		for j := 0; j < 1000; j++ {
+			key, value := make([]byte, 32), make([]byte, 1+random.Intn(64))
+			random.Read(key)
+			random.Read(value)
+			if prefix >= 0 {
+				key[0] = byte(prefix)
+			}
+			seq.Update(key, value)
+			par.Update(key, value)
+		}
+		seqRoot, seqLeaves, n := commit(seq, true)
+		if n != 0 {
+			t.Fatalf("round %d: sequential commit hashed %d nodes concurrently", i, n)
+		}
+		parRoot, parLeaves, n := commit(par, false)
+		if n == 0 {
+			t.Fatalf("round %d: commit not hashed concurrently", i)
+		}
+		if seqRoot != parRoot {
+			t.Fatalf("round %d: commit root mismatch: sequential %x, parallel %x", i, seqRoot, parRoot)
+		}
+		if !reflect.DeepEqual(seqLeaves, parLeaves) {
+			t.Fatalf("round %d: leaf callback mismatch: sequential %d, parallel %d", i, len(seqLeaves), len(parLeaves))
+		}
+	}
+}
+
 // Benchmarks the trie hashing. Since the trie caches the result of any operation,
 // we cannot use b.N as the number of hashing rouns, since all rounds apart from
 // the first one will be NOOP. As such, we'll use b.N as the number of account to
 // insert into the trie before measuring the hashing.
-func BenchmarkHash(b *testing.B) {
+func BenchmarkHash(b *testing.B) { benchHash(b, false) }
+
+// Benchmarks the trie hashing with the children of the root hashed concurrently,
+// to be compared against BenchmarkHash.
+func BenchmarkHashParallel(b *testing.B) { benchHash(b, true) }
+
+func benchHash(b *testing.B, parallel bool) {
 	// Make the random benchmark deterministic
 	random := rand.New(rand.NewSource(0))
 
@@ -588,6 +711,9 @@ func BenchmarkHash(b *testing.B) {
 	for i := 0; i < len(addresses); i++ {
 		trie.Update(crypto.Keccak256(addresses[i][:]), accounts[i])
 	}
+	if !parallel {
+		trie.unhashed = 0
+	}
 	b.ResetTimer()
 	b.ReportAllocs()
 	trie.Hash()